@@ -30,7 +30,9 @@ def demo():
     MODELS_DIR = os.path.join(os.getcwd(),"models")
     model = os.path.join(MODELS_DIR, model)
     id = request.json.get('id')
-    run_demo(id, convertImage(image), model)
+    request_id = request.headers.get('X-Request-ID')
+    trace_context = {name: request.headers[name] for name in ('traceparent', 'tracestate') if name in request.headers}
+    run_demo(id, convertImage(image), model, request_id, trace_context)
     return json.dumps({'id':id}), 200, {'ContentType':'application/json'}
 
 
@@ -11,7 +11,7 @@ def fire_and_forget(f):
     return wrapped
 
 @fire_and_forget
-def run_demo(id, image, model):
+def run_demo(id, image, model, request_id=None, trace_context=None):
     try:
         ROOT_DIR = os.getcwd()
         MODEL_DIR = os.path.join(ROOT_DIR, "logs")
@@ -67,4 +67,4 @@ def run_demo(id, image, model):
         results = "error"
     finally:
         K.clear_session()
-        update(id, results)
+        update(id, results, request_id, trace_context)
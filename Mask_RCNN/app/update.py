@@ -3,7 +3,12 @@ import json
 
 address = 'http://server:8081'
 
-def update(id, results):
+def update(id, results, request_id=None, trace_context=None):
     results = json.dumps(results)
     data = json.dumps({'id': id, 'content': results})
-    requests.put(address + '/v1/simulation-results', data=data, headers={'Content-Type': 'application/json'})
\ No newline at end of file
+    headers = {'Content-Type': 'application/json'}
+    if request_id:
+        headers['X-Request-ID'] = request_id
+    if trace_context:
+        headers.update(trace_context)
+    requests.put(address + '/v1/simulation-results', data=data, headers=headers)
\ No newline at end of file
@@ -0,0 +1,75 @@
+// Command algorithm-stub is a reference algorithm container which speaks the
+// gRPC contract from proto/algorithm/v1. It stores uploaded models in
+// MODEL_DIR and finds no objects; detector implementations replace
+// stubDetector with their model.
+package main
+
+import (
+	"backend/pkg/algorithmpb"
+	"backend/pkg/algorithmserver"
+	"context"
+	"google.golang.org/grpc"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+)
+
+func getEnv(key, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return value
+	}
+	return fallback
+}
+
+type stubDetector struct {
+	dir string
+}
+
+func (d stubDetector) LoadModel(name string, model io.Reader) error {
+	file, err := os.Create(filepath.Join(d.dir, filepath.Base(name)))
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(file, model); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func (d stubDetector) Detect(ctx context.Context, operation, model string, image []byte) (*algorithmpb.Detections, error) {
+	log.Printf("%s with %s on %d bytes", operation, model, len(image))
+	return &algorithmpb.Detections{}, nil
+}
+
+func main() {
+	dir := getEnv("MODEL_DIR", filepath.Join(os.TempDir(), "models"))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		log.Fatalf("failed to create model directory: %v", err)
+	}
+	listener, err := net.Listen("tcp", ":"+getEnv("PORT", "50051"))
+	if err != nil {
+		log.Fatalf("failed to listen: %v", err)
+	}
+
+	impl := algorithmserver.New(stubDetector{dir: dir}, 1, 16)
+	server := grpc.NewServer()
+	algorithmpb.RegisterAlgorithmServer(server, impl)
+	go func() {
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+		defer stop()
+		<-ctx.Done()
+		// watchers of results are disconnected by Stop, so GracefulStop
+		// does not wait for them
+		impl.Stop()
+		server.GracefulStop()
+	}()
+	log.Print("listening on " + listener.Addr().String())
+	if err = server.Serve(listener); err != nil {
+		log.Fatalf("failed to serve: %v", err)
+	}
+}
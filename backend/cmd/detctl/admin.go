@@ -0,0 +1,103 @@
+package main
+
+import (
+	"backend/internal/structure"
+	"github.com/pkg/errors"
+	"net/url"
+	"os"
+	"strconv"
+)
+
+// detctl backup
+func downloadBackup(a *app, args []string) error {
+	flags := newFlagSet("backup", "backup [flags]")
+	output := flags.String("o", "", "file to write, standard output when empty")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 0 {
+		return errors.New("expected backup [-o FILE]")
+	}
+	return a.download("/v1/admin/backup", *output)
+}
+
+// restoreBackup uploads the archive and prints what was restored of images,
+// models and results.
+func restoreBackup(a *app, args []string) error {
+	flags := newFlagSet("restore", "restore [flags] FILE")
+	conflict := flags.String("conflict", "skip", "how to restore records which exist, skip, overwrite or rename")
+	dryRun := flags.Bool("dry-run", false, "report what would be restored without writing anything")
+	format := formatFlag(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if err := checkFormat(*format); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("expected restore FILE")
+	}
+	file, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	query := url.Values{"conflict": {*conflict}}
+	if *dryRun {
+		query.Set("dryRun", "true")
+	}
+	var report structure.RestoreReport
+	if err = a.client.do(a.ctx, "POST", "/v1/admin/restore?"+query.Encode(), file, "application/gzip", &report); err != nil {
+		return err
+	}
+	var rows [][]string
+	for _, kind := range []struct {
+		name   string
+		counts structure.RestoreCounts
+	}{{"images", report.Images}, {"models", report.Models}, {"results", report.Results}} {
+		rows = append(rows, []string{
+			kind.name,
+			strconv.Itoa(kind.counts.Added),
+			strconv.Itoa(kind.counts.Skipped),
+			strconv.Itoa(kind.counts.Overwritten),
+			strconv.Itoa(kind.counts.Renamed),
+		})
+	}
+	return write(a.stdout, *format, report, []string{"KIND", "ADDED", "SKIPPED", "OVERWRITTEN", "RENAMED"}, rows)
+}
+
+// showStats prints jobs of each window as a table, the other stats are only
+// part of the JSON output.
+func showStats(a *app, args []string) error {
+	flags := newFlagSet("stats", "stats [flags]")
+	windows := flags.String("windows", "", "comma separated windows of jobs, e.g. 1h,24h,all")
+	format := formatFlag(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if err := checkFormat(*format); err != nil {
+		return err
+	}
+	path := "/v1/stats"
+	if *windows != "" {
+		path += "?" + url.Values{"windows": {*windows}}.Encode()
+	}
+	var stats structure.Stats
+	if err := a.client.getJSON(a.ctx, path, &stats); err != nil {
+		return err
+	}
+	var rows [][]string
+	for _, jobs := range stats.Jobs {
+		rows = append(rows, []string{
+			jobs.Window,
+			strconv.Itoa(jobs.Total),
+			strconv.Itoa(jobs.Statuses["queued"]),
+			strconv.Itoa(jobs.Statuses["in-progress"]),
+			strconv.Itoa(jobs.Statuses["finished"]),
+			strconv.Itoa(jobs.Statuses["error"]),
+			strconv.FormatFloat(jobs.CallbackErrorRate, 'f', 2, 64),
+		})
+	}
+	return write(a.stdout, *format, stats, []string{"WINDOW", "JOBS", "QUEUED", "IN-PROGRESS", "FINISHED", "ERROR", "CALLBACK-ERRORS"}, rows)
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"backend/internal/structure"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// imageExtensions are the files picked up from directories by images upload.
+var imageExtensions = map[string]bool{".jpg": true, ".jpeg": true, ".png": true}
+
+type algorithmSummary struct {
+	ID      string `json:"id"`
+	Default string `json:"default"`
+	Models  int    `json:"models"`
+	Workers bool   `json:"workers"`
+}
+
+type modelSummary struct {
+	structure.Model
+	Versions int  `json:"versions"`
+	Default  bool `json:"default"`
+}
+
+type uploadedImage struct {
+	Path string `json:"path"`
+	ID   string `json:"id"`
+}
+
+// detctl algorithms
+func listAlgorithms(a *app, args []string) error {
+	flags := newFlagSet("algorithms", "algorithms [flags]")
+	format := formatFlag(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if err := checkFormat(*format); err != nil {
+		return err
+	}
+	var algorithms []algorithmSummary
+	if err := a.client.getJSON(a.ctx, "/v1/algorithms", &algorithms); err != nil {
+		return err
+	}
+	var rows [][]string
+	for _, alg := range algorithms {
+		rows = append(rows, []string{alg.ID, alg.Default, strconv.Itoa(alg.Models), strconv.FormatBool(alg.Workers)})
+	}
+	return write(a.stdout, *format, algorithms, []string{"ID", "DEFAULT", "MODELS", "WORKERS"}, rows)
+}
+
+// detctl models list|upload
+func modelsCommand(a *app, args []string) error {
+	name, err := subcommand("models", args, "list", "upload")
+	if err != nil {
+		return err
+	}
+	if name == "list" {
+		return listModels(a, args[1:])
+	}
+	return uploadModel(a, args[1:])
+}
+
+func listModels(a *app, args []string) error {
+	flags := newFlagSet("models list", "models list [flags] ALG")
+	format := formatFlag(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if err := checkFormat(*format); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("expected models list ALG")
+	}
+	var models []modelSummary
+	if err := a.client.getJSON(a.ctx, "/v1/models/"+url.PathEscape(flags.Arg(0)), &models); err != nil {
+		return err
+	}
+	var rows [][]string
+	for _, model := range models {
+		isDefault := ""
+		if model.Default {
+			isDefault = "*"
+		}
+		rows = append(rows, []string{isDefault, model.Name, strconv.Itoa(model.Version), strconv.Itoa(model.Versions), model.Status, model.UploadedAt})
+	}
+	return write(a.stdout, *format, models, []string{"DEFAULT", "NAME", "VERSION", "VERSIONS", "STATUS", "UPLOADED"}, rows)
+}
+
+// uploadModel streams the model file to the backend. The checksum is sent
+// with it, so the backend rejects files which were corrupted on the way.
+func uploadModel(a *app, args []string) error {
+	flags := newFlagSet("models upload", "models upload -alg ALG [flags] FILE")
+	alg := flags.String("alg", "", "algorithm of the model (required)")
+	name := flags.String("name", "", "name of the model, the file name when empty")
+	description := flags.String("description", "", "description of the model")
+	classes := flags.String("classes", "", "comma separated classes detected by the model")
+	uploader := flags.String("uploader", os.Getenv("USER"), "who uploads the model")
+	setDefault := flags.Bool("default", false, "make the model the default of the algorithm")
+	format := formatFlag(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if err := checkFormat(*format); err != nil {
+		return err
+	}
+	if *alg == "" || flags.NArg() != 1 {
+		return errors.New("expected models upload -alg ALG FILE")
+	}
+	path := flags.Arg(0)
+	if *name == "" {
+		*name = filepath.Base(path)
+	}
+	checksum, size, err := fileChecksum(path)
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	fields := [][2]string{
+		{"id", *alg},
+		{"name", *name},
+		{"sha256", checksum},
+		{"size", strconv.FormatInt(size, 10)},
+		{"uploader", *uploader},
+		{"description", *description},
+		{"classes", *classes},
+		{"default", strconv.FormatBool(*setDefault)},
+	}
+	body, pipe := io.Pipe()
+	writer := multipart.NewWriter(pipe)
+	go func() {
+		// form values have to be sent before the model
+		for _, field := range fields {
+			if err := writer.WriteField(field[0], field[1]); err != nil {
+				pipe.CloseWithError(err)
+				return
+			}
+		}
+		part, err := writer.CreateFormFile("model", *name)
+		if err != nil {
+			pipe.CloseWithError(err)
+			return
+		}
+		if _, err = io.Copy(part, file); err != nil {
+			pipe.CloseWithError(err)
+			return
+		}
+		pipe.CloseWithError(writer.Close())
+	}()
+	defer body.Close()
+
+	var model structure.Model
+	if err = a.client.do(a.ctx, "PUT", "/v1/models", body, writer.FormDataContentType(), &model); err != nil {
+		return err
+	}
+	row := []string{model.Name, strconv.Itoa(model.Version), model.File, strconv.FormatInt(model.Size, 10), model.Status}
+	return write(a.stdout, *format, model, []string{"NAME", "VERSION", "FILE", "SIZE", "STATUS"}, [][]string{row})
+}
+
+// detctl images list|upload
+func imagesCommand(a *app, args []string) error {
+	name, err := subcommand("images", args, "list", "upload")
+	if err != nil {
+		return err
+	}
+	if name == "list" {
+		return listImages(a, args[1:])
+	}
+	return uploadImages(a, args[1:])
+}
+
+func listImages(a *app, args []string) error {
+	flags := newFlagSet("images list", "images list [flags]")
+	format := formatFlag(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if err := checkFormat(*format); err != nil {
+		return err
+	}
+	var images structure.ImageList
+	if err := a.client.getJSON(a.ctx, "/v1/images", &images); err != nil {
+		return err
+	}
+	var rows [][]string
+	for _, image := range images.Images {
+		rows = append(rows, []string{image.ID, image.URL})
+	}
+	return write(a.stdout, *format, images, []string{"ID", "URL"}, rows)
+}
+
+func uploadImages(a *app, args []string) error {
+	flags := newFlagSet("images upload", "images upload [flags] FILE|DIR...")
+	format := formatFlag(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if err := checkFormat(*format); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		return errors.New("expected images upload FILE|DIR...")
+	}
+	paths, err := imagePaths(flags.Args())
+	if err != nil {
+		return err
+	}
+
+	uploaded := []uploadedImage{}
+	var rows [][]string
+	for _, path := range paths {
+		content, err := imageContent(path)
+		if err != nil {
+			return err
+		}
+		var image structure.Image
+		if err = a.client.sendJSON(a.ctx, "PUT", "/v1/images", structure.Data{Content: content}, &image); err != nil {
+			return errors.Wrap(err, "failed to upload "+path)
+		}
+		uploaded = append(uploaded, uploadedImage{Path: path, ID: image.ID})
+		rows = append(rows, []string{path, image.ID})
+	}
+	return write(a.stdout, *format, uploaded, []string{"PATH", "ID"}, rows)
+}
+
+// imagePaths returns the given files and the images found in the given
+// directories.
+func imagePaths(args []string) ([]string, error) {
+	var paths []string
+	for _, arg := range args {
+		info, err := os.Stat(arg)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			paths = append(paths, arg)
+			continue
+		}
+		err = filepath.Walk(arg, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() && imageExtensions[strings.ToLower(filepath.Ext(path))] {
+				paths = append(paths, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return paths, nil
+}
+
+// imageContent returns the image as a data URL, the format in which the
+// frontend uploads images.
+func imageContent(path string) (string, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return "data:" + http.DetectContentType(content) + ";base64," + base64.StdEncoding.EncodeToString(content), nil
+}
+
+func fileChecksum(path string) (string, int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), size, nil
+}
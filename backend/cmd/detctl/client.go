@@ -0,0 +1,115 @@
+package main
+
+import (
+	"backend/internal/structure"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// client calls the backend API. Failed requests return apiError with the
+// message of the error response. The token is sent to admin endpoints, which
+// require the ADMIN_TOKEN of the backend, and the actor is recorded in the
+// audit log of the backend.
+type client struct {
+	baseURL string
+	token   string
+	actor   string
+	http    *http.Client
+}
+
+func newClient(baseURL, token, actor string) *client {
+	return &client{baseURL: strings.TrimRight(baseURL, "/"), token: token, actor: actor, http: &http.Client{}}
+}
+
+type apiError struct {
+	status   int
+	response structure.Error
+}
+
+func (e apiError) Error() string {
+	message := e.response.Message
+	if message == "" {
+		message = http.StatusText(e.status)
+	}
+	if e.response.Details != "" {
+		message += ": " + e.response.Details
+	}
+	return fmt.Sprintf("%s (%d)", message, e.status)
+}
+
+// do sends the request and decodes the JSON response into out, unless out is
+// nil.
+func (c *client) do(ctx context.Context, method, path string, body io.Reader, contentType string, out interface{}) error {
+	resp, err := c.send(ctx, method, path, body, contentType)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		_, err = io.Copy(ioutil.Discard, resp.Body)
+		return err
+	}
+	if err = json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to read response of %s %s: %v", method, path, err)
+	}
+	return nil
+}
+
+func (c *client) getJSON(ctx context.Context, path string, out interface{}) error {
+	return c.do(ctx, "GET", path, nil, "", out)
+}
+
+func (c *client) sendJSON(ctx context.Context, method, path string, in, out interface{}) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	return c.do(ctx, method, path, bytes.NewReader(body), "application/json", out)
+}
+
+// download copies the response body to w as it arrives.
+func (c *client) download(ctx context.Context, path string, w io.Writer) error {
+	resp, err := c.send(ctx, "GET", path, nil, "")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+func (c *client) send(ctx context.Context, method, path string, body io.Reader, contentType string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	if c.actor != "" {
+		req.Header.Set("X-Actor", c.actor)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	failure := apiError{status: resp.StatusCode}
+	content, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if json.Unmarshal(content, &failure.response) != nil {
+		failure.response.Message = strings.TrimSpace(string(content))
+	}
+	return nil, failure
+}
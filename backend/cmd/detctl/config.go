@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// defaultURL is used when no profile is configured, it matches the default
+// PORT of the server.
+const defaultURL = "http://localhost:8081"
+
+// config holds profiles with backend URLs, e.g. of a local and a shared
+// server. Current is used unless a command selects another profile.
+type config struct {
+	Current  string             `json:"current"`
+	Profiles map[string]profile `json:"profiles"`
+}
+
+type profile struct {
+	URL string `json:"url"`
+}
+
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ".detctl.json"
+	}
+	return filepath.Join(dir, "detctl", "config.json")
+}
+
+// loadConfig reads the config file. A missing file is an empty config.
+func loadConfig(path string) (config, error) {
+	cfg := config{Profiles: map[string]profile{}}
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return cfg, nil
+	}
+	if err != nil {
+		return config{}, err
+	}
+	if err = json.Unmarshal(content, &cfg); err != nil {
+		return config{}, errors.Wrap(err, "failed to read config "+path)
+	}
+	if cfg.Profiles == nil {
+		cfg.Profiles = map[string]profile{}
+	}
+	return cfg, nil
+}
+
+func (c config) save(path string) error {
+	content, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(content, '\n'), 0600)
+}
+
+// url returns the backend URL: override when it is set, otherwise the URL of
+// the named profile, or of the current one when name is empty.
+func (c config) url(name, override string) (string, error) {
+	if override != "" {
+		return override, nil
+	}
+	if name == "" {
+		name = c.Current
+	}
+	if name == "" {
+		return defaultURL, nil
+	}
+	p, ok := c.Profiles[name]
+	if !ok {
+		return "", errors.New("profile " + name + " does not exist")
+	}
+	return p.URL, nil
+}
+
+func (c config) names() []string {
+	var names []string
+	for name := range c.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,172 @@
+// Command detctl is a command-line client for the backend API. It uploads
+// images and models, runs simulations and fetches or exports their results:
+//
+//	detctl profile set local http://localhost:8081
+//	detctl images upload photos/
+//	detctl models upload -alg alg1 -default mask_rcnn_coco.h5
+//	detctl run -alg alg1 -wait photos/street.jpg
+//	detctl export -alg alg1 -format coco -o results.json
+//	detctl -token secret backup -o backup.tar.gz
+//
+// The backend URL is taken from -url, from the profile selected with
+// -profile, or from the current profile of the config file. Admin commands
+// send the token of -token or DETCTL_TOKEN.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+	"os/signal"
+	"time"
+)
+
+const usage = `Usage: detctl [-config FILE] [-profile NAME] [-url URL] [-token TOKEN] [-actor NAME] COMMAND [ARGS]
+
+Commands:
+  profile list|set NAME URL|use NAME|remove NAME
+                  manage backend URLs
+  algorithms      list algorithms served by the backend
+  models list ALG
+  models upload -alg ALG [-name NAME] [-default] FILE
+                  list or upload models
+  images list
+  images upload FILE|DIR...
+                  list or upload images, directories are searched for
+                  JPEG and PNG files
+  run -alg ALG [-wait] FILE|-image-id ID
+                  run a simulation, optionally waiting for its results
+  job [-wait] ID  show a simulation
+  results -alg ALG
+                  list results of an algorithm
+  export -alg ALG [-format csv|jsonl|coco] [-o FILE]
+                  export results of an algorithm
+  backup [-o FILE]
+                  download a backup of images, models and results
+  restore [-conflict skip|overwrite|rename] [-dry-run] FILE
+                  restore a backup, dry run reports what would change
+  stats [-windows 1h,24h,all]
+                  show jobs of the windows, -format json shows all stats
+
+Run "detctl COMMAND -h" for the flags of a command.
+`
+
+// pollInterval is how often commands which wait for a simulation check its
+// status.
+var pollInterval = time.Second
+
+// app is the state shared by commands.
+type app struct {
+	ctx        context.Context
+	config     config
+	configPath string
+	client     *client
+	stdout     io.Writer
+}
+
+var commands = map[string]func(a *app, args []string) error{
+	"profile":    profileCommand,
+	"algorithms": listAlgorithms,
+	"models":     modelsCommand,
+	"images":     imagesCommand,
+	"run":        runSimulation,
+	"job":        showJob,
+	"results":    listResults,
+	"export":     exportResults,
+	"backup":     downloadBackup,
+	"restore":    restoreBackup,
+	"stats":      showStats,
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if err := run(ctx, os.Args[1:], os.Stdout, os.Stderr); err != nil {
+		if err != flag.ErrHelp {
+			fmt.Fprintln(os.Stderr, "detctl: "+err.Error())
+		}
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	flags := flag.NewFlagSet("detctl", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() { fmt.Fprint(stderr, usage) }
+	configPath := flags.String("config", defaultConfigPath(), "config file with profiles")
+	profileName := flags.String("profile", os.Getenv("DETCTL_PROFILE"), "profile to use instead of the current one")
+	url := flags.String("url", os.Getenv("DETCTL_URL"), "backend URL, overrides profiles")
+	token := flags.String("token", os.Getenv("DETCTL_TOKEN"), "admin token of the backend")
+	actor := flags.String("actor", os.Getenv("USER"), "who sends the requests, recorded in the audit log of the backend")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return errors.New("command is required")
+	}
+	command, ok := commands[flags.Arg(0)]
+	if !ok {
+		return errors.New("unknown command " + flags.Arg(0))
+	}
+
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	a := &app{ctx: ctx, config: cfg, configPath: *configPath, stdout: stdout}
+	// profiles are managed before they point to a backend
+	if flags.Arg(0) != "profile" {
+		baseURL, err := cfg.url(*profileName, *url)
+		if err != nil {
+			return err
+		}
+		a.client = newClient(baseURL, *token, *actor)
+	}
+	return command(a, flags.Args()[1:])
+}
+
+// newFlagSet creates flags of a command, which report errors instead of
+// exiting.
+func newFlagSet(name, synopsis string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: detctl %s\n", synopsis)
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+// subcommand returns the first argument of commands with subcommands, e.g.
+// "list" of "models list".
+func subcommand(command string, args []string, names ...string) (string, error) {
+	if len(args) > 0 {
+		for _, name := range names {
+			if args[0] == name {
+				return name, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("expected %s %v", command, names)
+}
+
+// download writes the response to the output file, or to standard output when
+// it is empty. The file is removed when the download fails.
+func (a *app) download(path, output string) error {
+	if output == "" {
+		return a.client.download(a.ctx, path, a.stdout)
+	}
+	file, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	if err = a.client.download(a.ctx, path, file); err != nil {
+		file.Close()
+		os.Remove(output)
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"backend/internal/structure"
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var pngHeader = []byte("\x89PNG\r\n\x1a\n")
+
+// detctl runs the command against the backend at url with a config file in
+// dir and returns its output.
+func detctl(t *testing.T, dir, url string, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	global := []string{"-config", filepath.Join(dir, "config.json")}
+	if url != "" {
+		global = append(global, "-url", url)
+	}
+	err := run(context.Background(), append(global, args...), &stdout, &stderr)
+	return stdout.String(), err
+}
+
+func TestConfig_URL(t *testing.T) {
+	cfg := config{Current: "local", Profiles: map[string]profile{
+		"local":  {URL: "http://localhost:8081"},
+		"shared": {URL: "http://backend:8081"},
+	}}
+	tests := []struct {
+		testName string
+		config   config
+		name     string
+		override string
+		url      string
+		err      string
+	}{
+		{testName: "should use current profile", config: cfg, url: "http://localhost:8081"},
+		{testName: "should use selected profile", config: cfg, name: "shared", url: "http://backend:8081"},
+		{testName: "should prefer url over profiles", config: cfg, name: "shared", override: "http://other", url: "http://other"},
+		{testName: "should use default url without profiles", url: defaultURL},
+		{testName: "should fail when profile does not exist", config: cfg, name: "other", err: "profile other does not exist"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			//when
+			url, err := tt.config.url(tt.name, tt.override)
+
+			//then
+			if tt.err != "" {
+				assert.EqualError(t, err, tt.err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.url, url)
+		})
+	}
+}
+
+func TestProfiles(t *testing.T) {
+	//given
+	dir := t.TempDir()
+
+	//when
+	_, err := detctl(t, dir, "", "profile", "set", "local", "http://localhost:8081")
+	assert.NoError(t, err)
+	_, err = detctl(t, dir, "", "profile", "set", "shared", "http://backend:8081")
+	assert.NoError(t, err)
+	output, err := detctl(t, dir, "", "profile", "use", "shared")
+	assert.NoError(t, err)
+	list, err := detctl(t, dir, "", "profile", "list")
+	assert.NoError(t, err)
+
+	//then
+	assert.Equal(t, "using http://backend:8081\n", output)
+	assert.Equal(t, "CURRENT  NAME    URL\n         local   http://localhost:8081\n*        shared  http://backend:8081\n", list)
+	cfg, err := loadConfig(filepath.Join(dir, "config.json"))
+	assert.NoError(t, err)
+	assert.Equal(t, "shared", cfg.Current)
+}
+
+func TestImagesUpload(t *testing.T) {
+	//given
+	dir := t.TempDir()
+	assert.NoError(t, os.Mkdir(filepath.Join(dir, "photos"), 0755))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "photos", "a.png"), pngHeader, 0644))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "photos", "notes.txt"), []byte("notes"), 0644))
+	var contents []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "PUT /v1/images", r.Method+" "+r.URL.Path)
+		var data structure.Data
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&data))
+		contents = append(contents, data.Content)
+		w.Write([]byte(`{"id":"image1"}`))
+	}))
+	defer server.Close()
+
+	//when
+	output, err := detctl(t, dir, server.URL, "images", "upload", "-format", "json", filepath.Join(dir, "photos"))
+
+	//then
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"data:image/png;base64,iVBORw0KGgo="}, contents)
+	assert.JSONEq(t, `[{"path":"`+filepath.Join(dir, "photos", "a.png")+`","id":"image1"}]`, output)
+}
+
+func TestRun(t *testing.T) {
+	pollInterval = time.Millisecond
+	jobID := "2009-11-10T20:34:58Zalg1demo"
+	jobColumn := "JOB" + strings.Repeat(" ", len(jobID)-1)
+	tests := []struct {
+		testName string
+		args     []string
+		statuses []string
+		output   string
+		err      string
+	}{
+		{
+			testName: "should print queued job",
+			args:     []string{"run", "-alg", "alg1", "-image-id", "image1"},
+			output:   jobColumn + "STATUS  POSITION\n" + jobID + "  queued  2\n",
+		},
+		{
+			testName: "should wait for results",
+			args:     []string{"run", "-alg", "alg1", "-image-id", "image1", "-wait"},
+			statuses: []string{"queued", "in-progress", "finished"},
+			output:   jobColumn + "STATUS    MODEL    VERSION  DETECTIONS  ERROR\n" + jobID + "  finished  default  1        1           \n",
+		},
+		{
+			testName: "should report failed job",
+			args:     []string{"run", "-alg", "alg1", "-image-id", "image1", "-wait", "-format", "json"},
+			statuses: []string{"error"},
+			err:      "job " + jobID + " failed: dispatch failed",
+		},
+		{
+			testName: "should require an image",
+			args:     []string{"run", "-alg", "alg1"},
+			err:      "expected run -alg ALG FILE or run -alg ALG -image-id ID",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			//given
+			statuses := tt.statuses
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.Method + " " + r.URL.Path {
+				case "POST /v1/simulation-results/demo":
+					var body structure.Body
+					assert.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+					assert.Equal(t, structure.Body{ID: "alg1", ImageID: "image1"}, body)
+					w.Write([]byte(`{"id":"` + jobID + `","status":"queued","position":2}`))
+				case "GET /v1/jobs/" + jobID:
+					job := structure.Results{Algorithm: "alg1", Operation: "demo", Model: "default", ModelVersion: 1, TimeStamp: "2009-11-10T20:34:58Z", Status: statuses[0]}
+					if job.Status == "finished" {
+						job.Result = `{"names":["cat"],"scores":[0.9],"bbox":[[1,2,3,4]]}`
+					}
+					if job.Status == "error" {
+						job.Error = "dispatch failed"
+					}
+					statuses = statuses[1:]
+					json.NewEncoder(w).Encode(job)
+				default:
+					t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+				}
+			}))
+			defer server.Close()
+
+			//when
+			output, err := detctl(t, t.TempDir(), server.URL, tt.args...)
+
+			//then
+			if tt.err != "" {
+				assert.EqualError(t, err, tt.err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.output, output)
+			assert.Empty(t, statuses)
+		})
+	}
+}
+
+func TestExport(t *testing.T) {
+	t.Run("should write export to file", func(t *testing.T) {
+		//given
+		dir := t.TempDir()
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "/v1/simulation-results/demo/alg1/export", r.URL.Path)
+			assert.Equal(t, "format=coco&score=0.5", r.URL.RawQuery)
+			w.Write([]byte(`[]`))
+		}))
+		defer server.Close()
+
+		//when
+		_, err := detctl(t, dir, server.URL, "export", "-alg", "alg1", "-format", "coco", "-score", "0.5", "-o", filepath.Join(dir, "results.json"))
+
+		//then
+		assert.NoError(t, err)
+		content, err := ioutil.ReadFile(filepath.Join(dir, "results.json"))
+		assert.NoError(t, err)
+		assert.Equal(t, "[]", string(content))
+	})
+	t.Run("should report errors of backend and remove file", func(t *testing.T) {
+		//given
+		dir := t.TempDir()
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"code":"bad_request","message":"unsupported export format xml"}`))
+		}))
+		defer server.Close()
+
+		//when
+		_, err := detctl(t, dir, server.URL, "export", "-alg", "alg1", "-format", "xml", "-o", filepath.Join(dir, "results.xml"))
+
+		//then
+		assert.EqualError(t, err, "unsupported export format xml (400)")
+		_, err = os.Stat(filepath.Join(dir, "results.xml"))
+		assert.True(t, os.IsNotExist(err))
+	})
+}
+
+func TestAPIError(t *testing.T) {
+	err := apiError{status: http.StatusInternalServerError, response: structure.Error{Message: "failed to read models", Details: "connection refused"}}
+	assert.Equal(t, "failed to read models: connection refused (500)", err.Error())
+	assert.True(t, strings.HasPrefix(apiError{status: http.StatusBadGateway}.Error(), "Bad Gateway"))
+}
+
+func TestBackup(t *testing.T) {
+	t.Run("should send admin token and actor and write backup to file", func(t *testing.T) {
+		//given
+		dir := t.TempDir()
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "/v1/admin/backup", r.URL.Path)
+			assert.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+			assert.Equal(t, "anna", r.Header.Get("X-Actor"))
+			w.Write([]byte("archive"))
+		}))
+		defer server.Close()
+
+		//when
+		_, err := detctl(t, dir, server.URL, "-token", "secret", "-actor", "anna", "backup", "-o", filepath.Join(dir, "backup.tar.gz"))
+
+		//then
+		assert.NoError(t, err)
+		content, err := ioutil.ReadFile(filepath.Join(dir, "backup.tar.gz"))
+		assert.NoError(t, err)
+		assert.Equal(t, "archive", string(content))
+	})
+	t.Run("should report invalid admin token", func(t *testing.T) {
+		//given
+		dir := t.TempDir()
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Empty(t, r.Header.Get("Authorization"))
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"code":"unauthorized","message":"invalid admin token"}`))
+		}))
+		defer server.Close()
+
+		//when
+		_, err := detctl(t, dir, server.URL, "backup", "-o", filepath.Join(dir, "backup.tar.gz"))
+
+		//then
+		assert.EqualError(t, err, "invalid admin token (401)")
+	})
+}
+
+func TestRestore(t *testing.T) {
+	//given
+	dir := t.TempDir()
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "backup.tar.gz"), []byte("archive"), 0600))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/admin/restore", r.URL.Path)
+		assert.Equal(t, "conflict=rename&dryRun=true", r.URL.RawQuery)
+		body, err := ioutil.ReadAll(r.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, "archive", string(body))
+		json.NewEncoder(w).Encode(structure.RestoreReport{
+			DryRun:        true,
+			Conflict:      "rename",
+			SchemaVersion: 4,
+			Images:        structure.RestoreCounts{Added: 2, Skipped: 1},
+			Models:        structure.RestoreCounts{Renamed: 1},
+		})
+	}))
+	defer server.Close()
+
+	//when
+	output, err := detctl(t, dir, server.URL, "restore", "-conflict", "rename", "-dry-run", filepath.Join(dir, "backup.tar.gz"))
+
+	//then
+	assert.NoError(t, err)
+	assert.Equal(t, "KIND     ADDED  SKIPPED  OVERWRITTEN  RENAMED\n"+
+		"images   2      1        0            0\n"+
+		"models   0      0        0            1\n"+
+		"results  0      0        0            0\n", output)
+}
+
+func TestStats(t *testing.T) {
+	//given
+	dir := t.TempDir()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/stats", r.URL.Path)
+		assert.Equal(t, "windows=1h%2Call", r.URL.RawQuery)
+		json.NewEncoder(w).Encode(structure.Stats{Jobs: []structure.JobStats{
+			{Window: "1h", Total: 3, Statuses: map[string]int{"finished": 1, "error": 1, "in-progress": 1}, CallbackErrorRate: 0.5},
+			{Window: "all", Total: 4, Statuses: map[string]int{"finished": 2, "error": 1, "queued": 1}, CallbackErrorRate: 1.0 / 3},
+		}})
+	}))
+	defer server.Close()
+
+	//when
+	output, err := detctl(t, dir, server.URL, "stats", "-windows", "1h,all")
+
+	//then
+	assert.NoError(t, err)
+	assert.Equal(t, "WINDOW  JOBS  QUEUED  IN-PROGRESS  FINISHED  ERROR  CALLBACK-ERRORS\n"+
+		"1h      3     0       1            1         1      0.50\n"+
+		"all     4     1       0            2         1      0.33\n", output)
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+func formatFlag(flags *flag.FlagSet) *string {
+	return flags.String("format", "table", "output format, table or json")
+}
+
+func checkFormat(format string) error {
+	if format != "table" && format != "json" {
+		return errors.New("unsupported format " + format + ", expected table or json")
+	}
+	return nil
+}
+
+// write prints rows as an aligned table, or value as indented JSON. value
+// holds the responses of the backend, so JSON output can be piped to other
+// tools.
+func write(w io.Writer, format string, value interface{}, header []string, rows [][]string) error {
+	if format == "json" {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(value)
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+)
+
+// detctl profile list|set|use|remove
+func profileCommand(a *app, args []string) error {
+	name, err := subcommand("profile", args, "list", "set", "use", "remove")
+	if err != nil {
+		return err
+	}
+	args = args[1:]
+	switch name {
+	case "list":
+		var rows [][]string
+		for _, name := range a.config.names() {
+			current := ""
+			if name == a.config.Current {
+				current = "*"
+			}
+			rows = append(rows, []string{current, name, a.config.Profiles[name].URL})
+		}
+		return write(a.stdout, "table", nil, []string{"CURRENT", "NAME", "URL"}, rows)
+	case "set":
+		if len(args) != 2 {
+			return errors.New("expected profile set NAME URL")
+		}
+		a.config.Profiles[args[0]] = profile{URL: args[1]}
+		// the first profile is used right away
+		if a.config.Current == "" {
+			a.config.Current = args[0]
+		}
+	case "use":
+		if len(args) != 1 {
+			return errors.New("expected profile use NAME")
+		}
+		if _, ok := a.config.Profiles[args[0]]; !ok {
+			return errors.New("profile " + args[0] + " does not exist")
+		}
+		a.config.Current = args[0]
+	case "remove":
+		if len(args) != 1 {
+			return errors.New("expected profile remove NAME")
+		}
+		if _, ok := a.config.Profiles[args[0]]; !ok {
+			return errors.New("profile " + args[0] + " does not exist")
+		}
+		delete(a.config.Profiles, args[0])
+		if a.config.Current == args[0] {
+			a.config.Current = ""
+		}
+	}
+	if err = a.config.save(a.configPath); err != nil {
+		return err
+	}
+	url, err := a.config.url("", "")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(a.stdout, "using "+url)
+	return err
+}
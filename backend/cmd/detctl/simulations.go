@@ -0,0 +1,198 @@
+package main
+
+import (
+	"backend/internal/structure"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// detctl run
+func runSimulation(a *app, args []string) error {
+	flags := newFlagSet("run", "run -alg ALG [flags] FILE|-image-id ID")
+	alg := flags.String("alg", "", "algorithm to run (required)")
+	operation := flags.String("op", "demo", "operation of the algorithm")
+	model := flags.String("model", "", "model, the default model of the algorithm when empty")
+	version := flags.Int("version", 0, "version of the model, the latest ready one when 0")
+	priority := flags.Int("priority", 0, "priority from 0 to 9, higher runs first")
+	imageID := flags.String("image-id", "", "id of an uploaded image to use instead of a file")
+	wait := flags.Bool("wait", false, "wait until the simulation finished and show its results")
+	timeout := flags.Duration("timeout", 30*time.Minute, "how long to wait for results")
+	format := formatFlag(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if err := checkFormat(*format); err != nil {
+		return err
+	}
+	if *alg == "" || (*imageID == "") == (flags.NArg() == 0) || flags.NArg() > 1 {
+		return errors.New("expected run -alg ALG FILE or run -alg ALG -image-id ID")
+	}
+
+	body := structure.Body{ID: *alg, Model: *model, Version: *version, ImageID: *imageID, Priority: *priority}
+	if *imageID == "" {
+		content, err := imageContent(flags.Arg(0))
+		if err != nil {
+			return err
+		}
+		body.Image = content
+	}
+	var status structure.JobStatus
+	if err := a.client.sendJSON(a.ctx, "POST", "/v1/simulation-results/"+url.PathEscape(*operation), body, &status); err != nil {
+		return err
+	}
+	if !*wait {
+		position := ""
+		if status.Position > 0 {
+			position = strconv.Itoa(status.Position)
+		}
+		return write(a.stdout, *format, status, []string{"JOB", "STATUS", "POSITION"}, [][]string{{status.ID, status.Status, position}})
+	}
+	return a.waitForJob(status.ID, *timeout, *format)
+}
+
+// detctl job
+func showJob(a *app, args []string) error {
+	flags := newFlagSet("job", "job [flags] ID")
+	wait := flags.Bool("wait", false, "wait until the simulation finished")
+	timeout := flags.Duration("timeout", 30*time.Minute, "how long to wait for results")
+	format := formatFlag(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if err := checkFormat(*format); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("expected job ID")
+	}
+	if *wait {
+		return a.waitForJob(flags.Arg(0), *timeout, *format)
+	}
+	var job structure.Results
+	if err := a.client.getJSON(a.ctx, "/v1/jobs/"+url.PathEscape(flags.Arg(0)), &job); err != nil {
+		return err
+	}
+	return writeJobs(a.stdout, *format, job, []structure.Results{job})
+}
+
+// waitForJob polls the job until it finished or failed. Failed jobs are
+// shown and reported as error.
+func (a *app) waitForJob(id string, timeout time.Duration, format string) error {
+	ctx, cancel := context.WithTimeout(a.ctx, timeout)
+	defer cancel()
+	for {
+		var job structure.Results
+		if err := a.client.getJSON(ctx, "/v1/jobs/"+url.PathEscape(id), &job); err != nil {
+			if ctx.Err() == context.DeadlineExceeded {
+				return errors.New("job " + id + " did not finish in " + timeout.String())
+			}
+			return err
+		}
+		switch job.Status {
+		case "finished":
+			return writeJobs(a.stdout, format, job, []structure.Results{job})
+		case "error":
+			if err := writeJobs(a.stdout, format, job, []structure.Results{job}); err != nil {
+				return err
+			}
+			if job.Error != "" {
+				return errors.New("job " + id + " failed: " + job.Error)
+			}
+			return errors.New("job " + id + " failed")
+		}
+		select {
+		case <-ctx.Done():
+			if ctx.Err() == context.DeadlineExceeded {
+				return errors.New("job " + id + " did not finish in " + timeout.String())
+			}
+			return ctx.Err()
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// detctl results
+func listResults(a *app, args []string) error {
+	flags := newFlagSet("results", "results -alg ALG [flags]")
+	alg := flags.String("alg", "", "algorithm (required)")
+	operation := flags.String("op", "demo", "operation of the algorithm")
+	format := formatFlag(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if err := checkFormat(*format); err != nil {
+		return err
+	}
+	if *alg == "" {
+		return errors.New("expected results -alg ALG")
+	}
+	var results []structure.Results
+	path := fmt.Sprintf("/v1/simulation-results/%s/%s", url.PathEscape(*operation), url.PathEscape(*alg))
+	if err := a.client.getJSON(a.ctx, path, &results); err != nil {
+		return err
+	}
+	if results == nil {
+		results = []structure.Results{}
+	}
+	return writeJobs(a.stdout, *format, results, results)
+}
+
+// detctl export
+func exportResults(a *app, args []string) error {
+	flags := newFlagSet("export", "export -alg ALG [flags]")
+	alg := flags.String("alg", "", "algorithm (required)")
+	operation := flags.String("op", "demo", "operation of the algorithm")
+	format := flags.String("format", "csv", "export format, csv, jsonl or coco")
+	status := flags.String("status", "", "only results with the status, e.g. finished")
+	model := flags.String("model", "", "only results of the model")
+	from := flags.String("from", "", "only results started at or after the RFC3339 time")
+	to := flags.String("to", "", "only results started at or before the RFC3339 time")
+	score := flags.String("score", "", "only detections with at least the score")
+	output := flags.String("o", "", "file to write, standard output when empty")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *alg == "" {
+		return errors.New("expected export -alg ALG")
+	}
+
+	query := url.Values{}
+	for key, value := range map[string]string{"format": *format, "status": *status, "model": *model, "from": *from, "to": *to, "score": *score} {
+		if value != "" {
+			query.Set(key, value)
+		}
+	}
+	path := fmt.Sprintf("/v1/simulation-results/%s/%s/export?%s", url.PathEscape(*operation), url.PathEscape(*alg), query.Encode())
+	return a.download(path, *output)
+}
+
+// writeJobs prints value as JSON, or the jobs as a table. Job ids are not
+// stored with results, they are derived like the backend does.
+func writeJobs(w io.Writer, format string, value interface{}, jobs []structure.Results) error {
+	var rows [][]string
+	for _, job := range jobs {
+		version := ""
+		if job.ModelVersion > 0 {
+			version = strconv.Itoa(job.ModelVersion)
+		}
+		rows = append(rows, []string{job.TimeStamp + job.Algorithm + job.Operation, job.Status, job.Model, version, detectionCount(job), job.Error})
+	}
+	return write(w, format, value, []string{"JOB", "STATUS", "MODEL", "VERSION", "DETECTIONS", "ERROR"}, rows)
+}
+
+func detectionCount(job structure.Results) string {
+	if job.Status != "finished" {
+		return ""
+	}
+	var detections structure.Detections
+	if err := json.Unmarshal([]byte(job.Result), &detections); err != nil {
+		return ""
+	}
+	return strconv.Itoa(len(detections.Names))
+}
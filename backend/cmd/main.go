@@ -3,53 +3,314 @@ package main
 import (
 	"backend/internal/algorithm"
 	"backend/internal/api"
+	"backend/internal/audit"
 	db "backend/internal/database"
+	"backend/internal/logging"
+	"backend/internal/metrics"
+	"backend/internal/queue"
+	"backend/internal/retention"
+	"backend/internal/tracing"
+	"backend/internal/worker"
+	"context"
 	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
 	"github.com/rs/cors"
+	"go.uber.org/zap"
+	"io"
 	"log"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 )
 
-func initializeHandler() (api.Handler, error) {
-	log.Print("Started!")
+func getEnv(key, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return value
+	}
+	return fallback
+}
 
-	database := db.NewDBConnection("redis:6379", "")
-	err := database.Connect()
-	if err != nil {
-		return api.Handler{}, err
+// connectDatabase selects the database with DB_DRIVER. "redis" (default)
+// connects to DB_ADDRESS, "memory" keeps everything in process memory and
+// optionally persists it to the DB_SNAPSHOT file, "sqlite" and "postgres"
+// connect to DB_DSN. Redis expires results older than the max age of policy
+// by itself.
+func connectDatabase(policy retention.Policy) (api.IDatabase, error) {
+	switch driver := getEnv("DB_DRIVER", "redis"); driver {
+	case "redis":
+		database := db.NewDBConnection(getEnv("DB_ADDRESS", "redis:6379"), getEnv("DB_PASSWORD", "")).
+			WithExpiration(policy.Expiration)
+		if err := database.Connect(context.Background()); err != nil {
+			return nil, err
+		}
+		return database, nil
+	case "memory":
+		return db.NewMemory(getEnv("DB_SNAPSHOT", ""))
+	case "sqlite":
+		return db.NewSQL(db.SQLite, getEnv("DB_DSN", "backend.db"))
+	case "postgres":
+		return db.NewSQL(db.Postgres, getEnv("DB_DSN", ""))
+	default:
+		return nil, errors.New("unknown database driver " + driver)
+	}
+}
+
+// parseJobLimits reads MAX_CONCURRENT_JOBS, a comma separated list with the
+// default limit and limits of single algorithms, e.g. "1,alg2=4".
+func parseJobLimits(value string) (int, map[string]int, error) {
+	limit := 1
+	limits := map[string]int{}
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		name, number := "", item
+		if i := strings.Index(item, "="); i >= 0 {
+			name, number = item[:i], item[i+1:]
+		}
+		n, err := strconv.Atoi(number)
+		if err != nil || n < 1 {
+			return 0, nil, errors.New("invalid job limit " + item + ", expected a positive number")
+		}
+		if name == "" {
+			limit = n
+		} else {
+			limits[name] = n
+		}
+	}
+	return limit, limits, nil
+}
+
+// retentionPolicy reads RESULT_MAX_AGE, RESULT_MAX_COUNT and
+// RESULT_KEEP_FINISHED_ONLY. Results are kept forever when none of them is
+// set.
+func retentionPolicy(maxAge, maxCount, finishedOnly string) (retention.Policy, error) {
+	var policy retention.Policy
+	var err error
+	if maxAge != "" {
+		if policy.MaxAge, err = time.ParseDuration(maxAge); err != nil || policy.MaxAge < 0 {
+			return retention.Policy{}, errors.New("invalid max age " + maxAge + ", expected a duration")
+		}
 	}
-	log.Print("connected to db")
+	if maxCount != "" {
+		if policy.MaxCount, err = strconv.Atoi(maxCount); err != nil || policy.MaxCount < 0 {
+			return retention.Policy{}, errors.New("invalid max count " + maxCount + ", expected a number")
+		}
+	}
+	if finishedOnly != "" {
+		if policy.FinishedOnly, err = strconv.ParseBool(finishedOnly); err != nil {
+			return retention.Policy{}, errors.New("invalid keep finished only " + finishedOnly + ", expected true or false")
+		}
+	}
+	return policy, nil
+}
+
+// workerPools creates a pool for each algorithm in WORKER_ALGORITHMS, a comma
+// separated list of algorithms whose simulations are leased by workers.
+func workerPools(value string, ttl time.Duration) []*worker.Pool {
+	var pools []*worker.Pool
+	for _, id := range strings.Split(value, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			pools = append(pools, worker.NewPool(id, ttl))
+		}
+	}
+	return pools
+}
+
+// newAlgorithm connects to the algorithm container at url. Containers with a
+// grpc:// url are reached over the gRPC contract, others over HTTP.
+func newAlgorithm(id, url string) (api.IAlgorithm, error) {
+	if target := strings.TrimPrefix(url, "grpc://"); target != url {
+		return algorithm.NewGRPCAlgorithm(id, target)
+	}
+	return algorithm.NewAlgorithm(id, url), nil
+}
 
-	alg1 := algorithm.NewAlgorithm("alg1", "http://algorithm:80")
+func initializeHandler(database api.IDatabase, jobs *queue.Queue, alg1 api.IAlgorithm, pools []*worker.Pool) (api.Handler, error) {
 	algorithms := []api.IAlgorithm{alg1}
-	apiHandler := api.NewHandler(database, algorithms)
-	if err := apiHandler.Config(); err != nil {
+	for _, pool := range pools {
+		algorithms = append(algorithms, pool)
+	}
+	// the audit log is set before Config, which records registered algorithms
+	apiHandler := api.NewHandler(database, algorithms).WithQueue(jobs).WithAuditLog(audit.New(database))
+	if err := apiHandler.Config(context.Background()); err != nil {
+		return api.Handler{}, err
+	}
+	if err := apiHandler.ResumeJobs(context.Background()); err != nil {
 		return api.Handler{}, err
 	}
 	return apiHandler, nil
 }
 
 func main() {
+	logger, err := zap.NewProduction()
+	if err != nil {
+		log.Fatalf("Failed to create logger: %v", err)
+	}
+	defer logger.Sync()
+
+	shutdownTracing, err := tracing.Setup(context.Background(), "backend", getEnv("OTEL_TRACES_EXPORTER", tracing.ExporterNone))
+	if err != nil {
+		logger.Error("failed to set up tracing", zap.Error(err))
+		return
+	}
+	defer shutdownTracing(context.Background())
+
+	shutdownTimeout, err := time.ParseDuration(getEnv("SHUTDOWN_TIMEOUT", "30s"))
+	if err != nil {
+		logger.Error("invalid shutdown timeout", zap.Error(err))
+		return
+	}
+	jobLimit, jobLimits, err := parseJobLimits(getEnv("MAX_CONCURRENT_JOBS", "1"))
+	if err != nil {
+		logger.Error("invalid job limits", zap.Error(err))
+		return
+	}
+	jobTimeout, err := time.ParseDuration(getEnv("JOB_TIMEOUT", "30m"))
+	if err != nil {
+		logger.Error("invalid job timeout", zap.Error(err))
+		return
+	}
+	leaseTimeout, err := time.ParseDuration(getEnv("WORKER_LEASE_TIMEOUT", "1m"))
+	if err != nil {
+		logger.Error("invalid worker lease timeout", zap.Error(err))
+		return
+	}
+
+	policy, err := retentionPolicy(getEnv("RESULT_MAX_AGE", ""), getEnv("RESULT_MAX_COUNT", ""), getEnv("RESULT_KEEP_FINISHED_ONLY", ""))
+	if err != nil {
+		logger.Error("invalid retention policy", zap.Error(err))
+		return
+	}
+	retentionInterval, err := time.ParseDuration(getEnv("RETENTION_INTERVAL", "1h"))
+	if err != nil || retentionInterval <= 0 {
+		logger.Error("invalid retention interval", zap.Error(err))
+		return
+	}
+	uploadTTL, err := time.ParseDuration(getEnv("UPLOAD_TTL", "24h"))
+	if err != nil || uploadTTL <= 0 {
+		logger.Error("invalid upload ttl", zap.Error(err))
+		return
+	}
+	maxRestoreSize, err := strconv.ParseInt(getEnv("RESTORE_MAX_SIZE_MB", "1024"), 10, 64)
+	if err != nil || maxRestoreSize <= 0 {
+		logger.Error("invalid restore size limit", zap.Error(err))
+		return
+	}
+
+	logger.Info("started")
+	database, err := connectDatabase(policy)
+	if err != nil {
+		logger.Error("failed to connect to db", zap.Error(err))
+		return
+	}
+	defer closeDatabase(logger, database)
+	logger.Info("connected to db", zap.String("driver", getEnv("DB_DRIVER", "redis")))
+
+	// closed after the queue stopped, as jobs which are being dispatched
+	// still use the connection
+	alg1, err := newAlgorithm("alg1", getEnv("ALGORITHM_URL", "http://algorithm:80"))
+	if err != nil {
+		logger.Error("failed to connect to algorithm", zap.Error(err))
+		return
+	}
+	if closer, ok := alg1.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	// stopped before the database is closed, as jobs which are being
+	// dispatched still record their status
+	jobs := queue.New(jobLimit, jobLimits, jobTimeout)
+	defer jobs.Stop()
+	// stopped before the queue, which waits for jobs offered to workers
+	pools := workerPools(getEnv("WORKER_ALGORITHMS", ""), leaseTimeout)
+	defer func() {
+		for _, pool := range pools {
+			pool.Stop()
+		}
+	}()
+	workerToken := getEnv("WORKER_TOKEN", "")
+	if len(pools) > 0 && workerToken == "" {
+		logger.Error("worker token is required for worker algorithms")
+		return
+	}
+	adminToken := getEnv("ADMIN_TOKEN", "")
+	if adminToken == "" {
+		logger.Warn("admin token is not set, admin endpoints reject all requests")
+	}
+
 	router := mux.NewRouter()
+	router.Use(metrics.Middleware, tracing.Middleware)
 
-	apiHandler, err := initializeHandler()
+	apiHandler, err := initializeHandler(database, jobs, alg1, pools)
 	if err != nil {
-		log.Print(err)
+		logger.Error("failed to initialize handler", zap.Error(err))
 		return
 	}
+	apiHandler = apiHandler.WithRetention(policy).
+		WithAdminToken(adminToken).
+		WithWorkerToken(workerToken).
+		WithMaxRestoreSize(maxRestoreSize << 20).
+		WithUploadTTL(uploadTTL)
 	apiHandler.InitializeEndpoints(router)
+	watchCtx, stopWatching := context.WithCancel(context.Background())
+	defer stopWatching()
+	apiHandler.WatchResults(watchCtx)
+	apiHandler.EnforceRetention(watchCtx, retentionInterval)
+	// expired uploads are looked for at least every hour, so that they are
+	// not kept much longer than the TTL
+	uploadInterval := time.Hour
+	if uploadTTL < uploadInterval {
+		uploadInterval = uploadTTL
+	}
+	apiHandler.ExpireUploads(watchCtx, uploadInterval)
 
 	c := cors.New(cors.Options{
 		AllowedOrigins:   []string{"*"},
 		AllowCredentials: true,
 		AllowedMethods:   []string{"POST", "PUT", "GET"},
+		AllowedHeaders:   []string{"Origin", "Accept", "Authorization", "Content-Type", "X-Requested-With", logging.RequestIDHeader, api.ActorHeader, "traceparent", "tracestate"},
+		ExposedHeaders:   []string{logging.RequestIDHeader},
 	})
 
-	handler := c.Handler(router)
-	port := "8081"
-	log.Printf("Listening on port %s!", port)
-	err = http.ListenAndServe(":"+port, handler)
+	handler := logging.Middleware(logger)(c.Handler(router))
+	port := getEnv("PORT", "8081")
+	listener, err := net.Listen("tcp", ":"+port)
 	if err != nil {
-		log.Fatalf("Failed to listen")
+		logger.Error("failed to listen", zap.Error(err))
+		return
+	}
+	logger.Info("listening", zap.String("port", port))
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+	server := &http.Server{Handler: handler}
+	// workers waiting for jobs are released right away, so that they do not
+	// delay the shutdown
+	for _, pool := range pools {
+		server.RegisterOnShutdown(pool.Stop)
+	}
+	err = serve(ctx, server, listener, shutdownTimeout)
+	if err != nil && err != http.ErrServerClosed {
+		logger.Error("failed to serve", zap.Error(err))
+		return
+	}
+	logger.Info("stopped")
+}
+
+// closeDatabase closes the connection to the database, if it holds one.
+func closeDatabase(logger *zap.Logger, database api.IDatabase) {
+	closer, ok := database.(io.Closer)
+	if !ok {
+		return
+	}
+	if err := closer.Close(); err != nil {
+		logger.Error("failed to close db", zap.Error(err))
 	}
 }
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"backend/internal/algorithm"
+	"backend/internal/retention"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestParseJobLimits(t *testing.T) {
+	tests := []struct {
+		testName string
+		value    string
+		limit    int
+		limits   map[string]int
+		err      string
+	}{
+		{
+			testName: "should use default limit when value is empty",
+			value:    "",
+			limit:    1,
+			limits:   map[string]int{},
+		},
+		{
+			testName: "should read default limit and limits of algorithms",
+			value:    "2, alg1=4,alg2=1",
+			limit:    2,
+			limits:   map[string]int{"alg1": 4, "alg2": 1},
+		},
+		{
+			testName: "should return error when limit is not positive",
+			value:    "alg1=0",
+			err:      "invalid job limit alg1=0, expected a positive number",
+		},
+		{
+			testName: "should return error when limit is not a number",
+			value:    "many",
+			err:      "invalid job limit many, expected a positive number",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			//when
+			limit, limits, err := parseJobLimits(tt.value)
+
+			//then
+			if tt.err != "" {
+				assert.EqualError(t, err, tt.err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.limit, limit)
+			assert.Equal(t, tt.limits, limits)
+		})
+	}
+}
+
+func TestRetentionPolicy(t *testing.T) {
+	tests := []struct {
+		testName     string
+		maxAge       string
+		maxCount     string
+		finishedOnly string
+		policy       retention.Policy
+		err          string
+	}{
+		{
+			testName: "should keep results forever by default",
+		},
+		{
+			testName:     "should read limits",
+			maxAge:       "720h",
+			maxCount:     "1000",
+			finishedOnly: "true",
+			policy:       retention.Policy{MaxAge: 720 * time.Hour, MaxCount: 1000, FinishedOnly: true},
+		},
+		{
+			testName: "should return error when max age is not a duration",
+			maxAge:   "30",
+			err:      "invalid max age 30, expected a duration",
+		},
+		{
+			testName: "should return error when max count is negative",
+			maxCount: "-1",
+			err:      "invalid max count -1, expected a number",
+		},
+		{
+			testName:     "should return error when keep finished only is not a bool",
+			finishedOnly: "yes",
+			err:          "invalid keep finished only yes, expected true or false",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			//when
+			policy, err := retentionPolicy(tt.maxAge, tt.maxCount, tt.finishedOnly)
+
+			//then
+			if tt.err != "" {
+				assert.EqualError(t, err, tt.err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.policy, policy)
+		})
+	}
+}
+
+func TestWorkerPools(t *testing.T) {
+	//when
+	pools := workerPools(" alg2,,alg3 ", time.Minute)
+
+	//then
+	assert.Len(t, pools, 2)
+	assert.Equal(t, "alg2", pools[0].GetID())
+	assert.Equal(t, "alg3", pools[1].GetID())
+}
+
+func TestNewAlgorithm(t *testing.T) {
+	t.Run("should reach algorithm over http", func(t *testing.T) {
+		alg, err := newAlgorithm("alg1", "http://algorithm:80")
+		assert.NoError(t, err)
+		assert.Equal(t, algorithm.NewAlgorithm("alg1", "http://algorithm:80"), alg)
+	})
+	t.Run("should reach algorithm over grpc", func(t *testing.T) {
+		alg, err := newAlgorithm("alg1", "grpc://algorithm:50051")
+		assert.NoError(t, err)
+		assert.IsType(t, &algorithm.GRPCAlgorithm{}, alg)
+		assert.Equal(t, "alg1", alg.GetID())
+		assert.NoError(t, alg.(*algorithm.GRPCAlgorithm).Close())
+	})
+}
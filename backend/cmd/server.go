@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// abortTimeout is how long requests cancelled during shutdown have to record
+// their outcome, e.g. to mark an interrupted model upload as failed.
+const abortTimeout = 5 * time.Second
+
+// serve handles requests on listener until ctx is done. Then it stops
+// accepting new connections and waits up to timeout for requests in flight.
+// Requests still running after the timeout have their context cancelled and
+// their connections are closed once they return or abortTimeout passes.
+func serve(ctx context.Context, server *http.Server, listener net.Listener, timeout time.Duration) error {
+	base, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	server.BaseContext = func(net.Listener) context.Context { return base }
+
+	inFlight := &sync.WaitGroup{}
+	handler := server.Handler
+	server.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		inFlight.Add(1)
+		defer inFlight.Done()
+		handler.ServeHTTP(w, r)
+	})
+
+	served := make(chan error, 1)
+	go func() {
+		served <- server.Serve(listener)
+	}()
+	select {
+	case err := <-served:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), timeout)
+	defer cancelShutdown()
+	err := server.Shutdown(shutdownCtx)
+	if err == nil {
+		return nil
+	}
+
+	cancel()
+	done := make(chan struct{})
+	go func() {
+		inFlight.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(abortTimeout):
+	}
+	server.Close()
+	return errors.Wrap(err, "requests did not finish before shutdown timeout")
+}
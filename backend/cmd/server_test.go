@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestServe(t *testing.T) {
+	t.Run("should finish requests in flight before returning", func(t *testing.T) {
+		//given
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		assert.NoError(t, err)
+		started := make(chan struct{})
+		server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			close(started)
+			time.Sleep(50 * time.Millisecond)
+			w.Write([]byte("done"))
+		})}
+		ctx, stop := context.WithCancel(context.Background())
+		served := make(chan error, 1)
+		go func() {
+			served <- serve(ctx, server, listener, time.Second)
+		}()
+		responses := make(chan string, 1)
+		go func() {
+			resp, err := http.Get("http://" + listener.Addr().String())
+			if err != nil {
+				responses <- err.Error()
+				return
+			}
+			defer resp.Body.Close()
+			body, _ := ioutil.ReadAll(resp.Body)
+			responses <- string(body)
+		}()
+		<-started
+
+		//when
+		stop()
+
+		//then
+		assert.NoError(t, <-served)
+		assert.Equal(t, "done", <-responses)
+		_, err = http.Get("http://" + listener.Addr().String())
+		assert.Error(t, err)
+	})
+	t.Run("should cancel requests which do not finish before timeout", func(t *testing.T) {
+		//given
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		assert.NoError(t, err)
+		started := make(chan struct{})
+		cancelled := make(chan error, 1)
+		server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			close(started)
+			<-r.Context().Done()
+			cancelled <- r.Context().Err()
+		})}
+		ctx, stop := context.WithCancel(context.Background())
+		served := make(chan error, 1)
+		go func() {
+			served <- serve(ctx, server, listener, 50*time.Millisecond)
+		}()
+		go http.Get("http://" + listener.Addr().String())
+		<-started
+
+		//when
+		stop()
+
+		//then
+		assert.Error(t, <-served)
+		assert.Equal(t, context.Canceled, <-cancelled)
+	})
+}
@@ -1,71 +1,152 @@
 package algorithm
 
 import (
+	"backend/internal/logging"
+	"backend/internal/metrics"
+	"backend/internal/tracing"
 	"bytes"
+	"context"
 	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.7.0"
+	"go.opentelemetry.io/otel/trace"
 	"io"
 	"io/ioutil"
 	"mime/multipart"
 	"net/http"
+	"time"
 )
 
+// defaultTimeout limits starting a simulation. Simulations run asynchronously,
+// so the algorithm only has to accept the request in this time.
+const defaultTimeout = 30 * time.Second
+
 type Algorithm struct {
-	ID  string
-	URL string
+	ID      string
+	URL     string
+	Timeout time.Duration
 }
 
 func NewAlgorithm(id string, url string) Algorithm {
 	return Algorithm{
-		ID:  id,
-		URL: url,
+		ID:      id,
+		URL:     url,
+		Timeout: defaultTimeout,
 	}
 }
 
-func (a Algorithm) UploadModel(modelFile multipart.File, modelHeader *multipart.FileHeader) error {
-	body := &bytes.Buffer{}
-	writer := multipart.NewWriter(body)
-	fw, err := writer.CreateFormFile("model", modelHeader.Filename)
-	if err != nil {
-		return errors.New("CreateFormFile" + err.Error())
-	}
-	if _, err = io.Copy(fw, modelFile); err != nil {
-		return errors.New("ioCopy" + err.Error())
-	}
-	writer.Close()
+// UploadModel streams the model to the algorithm container without buffering
+// it, so weights of any size can be uploaded. It is not limited by Timeout,
+// only by ctx. It returns once model is no longer read, even when the
+// algorithm replied before reading the whole body.
+func (a Algorithm) UploadModel(ctx context.Context, name string, model io.Reader) error {
+	body, pipe := io.Pipe()
+	writer := multipart.NewWriter(pipe)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		fw, err := writer.CreateFormFile("model", name)
+		if err != nil {
+			pipe.CloseWithError(errors.New("CreateFormFile" + err.Error()))
+			return
+		}
+		if _, err = io.Copy(fw, model); err != nil {
+			pipe.CloseWithError(errors.New("ioCopy" + err.Error()))
+			return
+		}
+		pipe.CloseWithError(writer.Close())
+	}()
 
-	req, err := http.NewRequest("POST", a.URL+"/upload_model", bytes.NewReader(body.Bytes()))
+	ctx, span := startSpan(ctx, a.ID, "algorithm upload_model")
+	status, err := a.uploadModel(ctx, body, writer.FormDataContentType())
+	endSpan(span, status, err)
+	// the writer fails on its next write to the closed pipe
+	body.Close()
+	<-done
+	return err
+}
+
+func (a Algorithm) uploadModel(ctx context.Context, body io.Reader, contentType string) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", a.URL+"/upload_model", body)
 	if err != nil {
-		return err
+		return 0, err
 	}
-	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.Header.Set("Content-Type", contentType)
+	setRequestID(ctx, req)
 
 	client := &http.Client{}
 	resp, err := client.Do(req)
 	if err != nil {
-		return err
+		return 0, err
 	}
 	defer resp.Body.Close()
 	if _, err = ioutil.ReadAll(resp.Body); err != nil {
-		return err
+		return resp.StatusCode, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return resp.StatusCode, errors.Errorf("algorithm responded with status %d", resp.StatusCode)
 	}
-	return nil
+	return resp.StatusCode, nil
 }
 
-func (a Algorithm) RunSimulation(opType string, data []byte) (int, error) {
-	req, err := http.NewRequest("POST", a.URL+"/"+opType, bytes.NewReader(data))
+func (a Algorithm) RunSimulation(ctx context.Context, opType string, data []byte) (int, error) {
+	if a.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, a.Timeout)
+		defer cancel()
+	}
+	ctx, span := startSpan(ctx, a.ID, "algorithm "+opType)
+	req, err := http.NewRequestWithContext(ctx, "POST", a.URL+"/"+opType, bytes.NewReader(data))
 	if err != nil {
-		return 0, errors.New("NewRequest" + err.Error())
+		err = errors.New("NewRequest" + err.Error())
+		endSpan(span, 0, err)
+		return 0, err
 	}
 	req.Header.Set("Content-Type", "application/json")
+	setRequestID(ctx, req)
 	client := &http.Client{}
+	start := time.Now()
 	resp, err := client.Do(req)
 	if err != nil {
-		return 0, errors.New("DoRequest" + err.Error())
+		metrics.ObserveDispatch(a.ID, opType, start, true)
+		err = errors.New("DoRequest" + err.Error())
+		endSpan(span, 0, err)
+		return 0, err
 	}
 	defer resp.Body.Close()
+	metrics.ObserveDispatch(a.ID, opType, start, resp.StatusCode != http.StatusOK)
+	endSpan(span, resp.StatusCode, nil)
 	return resp.StatusCode, nil
 }
 
+// setRequestID forwards the id of the request being handled, so that logs of
+// the algorithm and of its callbacks can be correlated with it. The trace
+// context is forwarded too, so the algorithm can send it back in callbacks.
+func setRequestID(ctx context.Context, req *http.Request) {
+	if id := logging.RequestID(ctx); id != "" {
+		req.Header.Set(logging.RequestIDHeader, id)
+	}
+	tracing.Inject(ctx, req.Header)
+}
+
+func startSpan(ctx context.Context, id, name string) (context.Context, trace.Span) {
+	return tracing.Tracer().Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("algorithm.id", id)))
+}
+
+func endSpan(span trace.Span, status int, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	} else if status != 0 {
+		span.SetAttributes(semconv.HTTPAttributesFromHTTPStatusCode(status)...)
+		span.SetStatus(semconv.SpanStatusFromHTTPStatusCodeAndSpanKind(status, trace.SpanKindClient))
+	}
+	span.End()
+}
+
 func (a Algorithm) GetID() string {
 	return a.ID
 }
@@ -1,12 +1,22 @@
 package algorithm
 
 import (
+	"backend/internal/logging"
 	"backend/internal/structure"
+	"context"
 	"encoding/json"
 	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestAlgorithm_RunSimulation(t *testing.T) {
@@ -21,8 +31,123 @@ func TestAlgorithm_RunSimulation(t *testing.T) {
 		testServer := httptest.NewServer(http.HandlerFunc(handler))
 		defer testServer.Close()
 		alg := NewAlgorithm("id", testServer.URL)
-		status, err := alg.RunSimulation("demo", jsonSendData)
+		status, err := alg.RunSimulation(context.Background(), "demo", jsonSendData)
 		assert.NoError(t, err)
 		assert.Equal(t, status, http.StatusOK)
 	})
+	t.Run("should forward request id to algorithm", func(t *testing.T) {
+		var requestID string
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			requestID = r.Header.Get(logging.RequestIDHeader)
+			w.WriteHeader(http.StatusOK)
+		}
+		testServer := httptest.NewServer(http.HandlerFunc(handler))
+		defer testServer.Close()
+		alg := NewAlgorithm("id", testServer.URL)
+		_, err := alg.RunSimulation(logging.WithRequestID(context.Background(), "requestID"), "demo", nil)
+		assert.NoError(t, err)
+		assert.Equal(t, "requestID", requestID)
+	})
+	t.Run("should forward trace context to algorithm", func(t *testing.T) {
+		recorder := tracetest.NewSpanRecorder()
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+		otel.SetTextMapPropagator(propagation.TraceContext{})
+		var traceparent string
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			traceparent = r.Header.Get("traceparent")
+			w.WriteHeader(http.StatusOK)
+		}
+		testServer := httptest.NewServer(http.HandlerFunc(handler))
+		defer testServer.Close()
+		alg := NewAlgorithm("id", testServer.URL)
+		_, err := alg.RunSimulation(context.Background(), "demo", nil)
+		assert.NoError(t, err)
+		spans := recorder.Ended()
+		assert.Len(t, spans, 1)
+		assert.Equal(t, "algorithm demo", spans[0].Name())
+		spanContext := spans[0].SpanContext()
+		assert.Equal(t, "00-"+spanContext.TraceID().String()+"-"+spanContext.SpanID().String()+"-01", traceparent)
+	})
+	t.Run("should return error when algorithm does not respond before timeout", func(t *testing.T) {
+		done := make(chan struct{})
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			<-done
+		}
+		testServer := httptest.NewServer(http.HandlerFunc(handler))
+		defer testServer.Close()
+		defer close(done)
+		alg := NewAlgorithm("id", testServer.URL)
+		alg.Timeout = 10 * time.Millisecond
+		_, err := alg.RunSimulation(context.Background(), "demo", nil)
+		assert.Error(t, err)
+	})
+	t.Run("should return error when context is cancelled", func(t *testing.T) {
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}
+		testServer := httptest.NewServer(http.HandlerFunc(handler))
+		defer testServer.Close()
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		alg := NewAlgorithm("id", testServer.URL)
+		_, err := alg.RunSimulation(ctx, "demo", nil)
+		assert.Error(t, err)
+	})
+}
+
+func TestAlgorithm_UploadModel(t *testing.T) {
+	t.Run("should stream model to algorithm", func(t *testing.T) {
+		var name, content string
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			file, header, err := r.FormFile("model")
+			assert.NoError(t, err)
+			body, err := ioutil.ReadAll(file)
+			assert.NoError(t, err)
+			name, content = header.Filename, string(body)
+			w.WriteHeader(http.StatusOK)
+		}
+		testServer := httptest.NewServer(http.HandlerFunc(handler))
+		defer testServer.Close()
+		alg := NewAlgorithm("id", testServer.URL)
+		err := alg.UploadModel(context.Background(), "model.h5", strings.NewReader("weights"))
+		assert.NoError(t, err)
+		assert.Equal(t, "model.h5", name)
+		assert.Equal(t, "weights", content)
+	})
+	t.Run("should return error when algorithm rejects model", func(t *testing.T) {
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		testServer := httptest.NewServer(http.HandlerFunc(handler))
+		defer testServer.Close()
+		alg := NewAlgorithm("id", testServer.URL)
+		err := alg.UploadModel(context.Background(), "model.h5", strings.NewReader("weights"))
+		assert.EqualError(t, err, "algorithm responded with status 500")
+	})
+	t.Run("should stop reading model before returning", func(t *testing.T) {
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		testServer := httptest.NewServer(http.HandlerFunc(handler))
+		defer testServer.Close()
+		alg := NewAlgorithm("id", testServer.URL)
+		model := &endlessReader{}
+		err := alg.UploadModel(context.Background(), "model.h5", model)
+		read := atomic.LoadInt64(&model.n)
+		time.Sleep(10 * time.Millisecond)
+		assert.Error(t, err)
+		assert.Equal(t, read, atomic.LoadInt64(&model.n))
+	})
+}
+
+// endlessReader counts the bytes read from it and never ends. Reads are slow,
+// like reads of a large file.
+type endlessReader struct {
+	n int64
+}
+
+func (r *endlessReader) Read(p []byte) (int, error) {
+	time.Sleep(5 * time.Millisecond)
+	atomic.AddInt64(&r.n, int64(len(p)))
+	return len(p), nil
 }
@@ -0,0 +1,226 @@
+package algorithm
+
+import (
+	"backend/internal/logging"
+	"backend/internal/metrics"
+	"backend/internal/render"
+	"backend/internal/structure"
+	"backend/internal/tracing"
+	"backend/pkg/algorithmpb"
+	"context"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+// uploadChunkSize is the size of model chunks sent by UploadModel, well below
+// the default message limit of gRPC servers.
+const uploadChunkSize = 256 * 1024
+
+// GRPCAlgorithm is an algorithm container which speaks the gRPC contract from
+// proto/algorithm/v1. Results are not sent to the backend, the backend
+// receives them with WatchResults.
+type GRPCAlgorithm struct {
+	ID      string
+	Timeout time.Duration
+	conn    *grpc.ClientConn
+	client  algorithmpb.AlgorithmClient
+}
+
+// NewGRPCAlgorithm connects to the algorithm at target, e.g. algorithm:50051.
+// The connection is established lazily, so the algorithm does not have to be
+// up yet.
+func NewGRPCAlgorithm(id string, target string, opts ...grpc.DialOption) (*GRPCAlgorithm, error) {
+	opts = append([]grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}, opts...)
+	conn, err := grpc.Dial(target, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &GRPCAlgorithm{
+		ID:      id,
+		Timeout: defaultTimeout,
+		conn:    conn,
+		client:  algorithmpb.NewAlgorithmClient(conn),
+	}, nil
+}
+
+func (a *GRPCAlgorithm) GetID() string {
+	return a.ID
+}
+
+func (a *GRPCAlgorithm) Close() error {
+	return a.conn.Close()
+}
+
+// RunSimulation sends the job with its decoded image to the algorithm. The
+// status is http.StatusOK once the algorithm accepted it.
+func (a *GRPCAlgorithm) RunSimulation(ctx context.Context, opType string, data []byte) (int, error) {
+	var body structure.Body
+	if err := json.Unmarshal(data, &body); err != nil {
+		return 0, errors.Wrap(err, "failed to read job")
+	}
+	image, err := render.DecodeBase64(body.Image)
+	if err != nil {
+		return 0, err
+	}
+	if a.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, a.Timeout)
+		defer cancel()
+	}
+	ctx, span := startSpan(ctx, a.ID, "algorithm "+opType)
+	start := time.Now()
+	_, err = a.client.RunSimulation(outgoingContext(ctx), &algorithmpb.RunSimulationRequest{
+		Id:        body.ID,
+		Operation: opType,
+		Model:     body.Model,
+		Image:     image,
+	})
+	metrics.ObserveDispatch(a.ID, opType, start, err != nil)
+	endSpan(span, 0, err)
+	if err != nil {
+		return 0, err
+	}
+	return http.StatusOK, nil
+}
+
+// GetStatus reports whether the algorithm received the simulation with id, so
+// that it is queued, running or finished there. Simulations the algorithm
+// does not know were never accepted.
+func (a *GRPCAlgorithm) GetStatus(ctx context.Context, id string) (bool, error) {
+	if a.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, a.Timeout)
+		defer cancel()
+	}
+	_, err := a.client.GetStatus(outgoingContext(ctx), &algorithmpb.GetStatusRequest{Id: id})
+	if status.Code(err) == codes.NotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// UploadModel streams the model to the algorithm in chunks. Like the HTTP
+// upload it is not limited by Timeout, only by ctx.
+func (a *GRPCAlgorithm) UploadModel(ctx context.Context, name string, model io.Reader) error {
+	ctx, span := startSpan(ctx, a.ID, "algorithm upload_model")
+	err := a.uploadModel(outgoingContext(ctx), name, model)
+	endSpan(span, 0, err)
+	return err
+}
+
+func (a *GRPCAlgorithm) uploadModel(ctx context.Context, name string, model io.Reader) error {
+	// cancelling aborts the stream when the model can not be read
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := a.client.UploadModel(ctx)
+	if err != nil {
+		return err
+	}
+	send := func(req *algorithmpb.UploadModelRequest) error {
+		if err := stream.Send(req); err != io.EOF {
+			return err
+		}
+		// the algorithm ended the stream, its status tells why
+		_, err := stream.CloseAndRecv()
+		return err
+	}
+	if err = send(&algorithmpb.UploadModelRequest{Data: &algorithmpb.UploadModelRequest_Name{Name: name}}); err != nil {
+		return err
+	}
+	chunk := make([]byte, uploadChunkSize)
+	for {
+		n, err := model.Read(chunk)
+		if n > 0 {
+			if err := send(&algorithmpb.UploadModelRequest{Data: &algorithmpb.UploadModelRequest_Chunk{Chunk: chunk[:n]}}); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	_, err = stream.CloseAndRecv()
+	return err
+}
+
+// WatchResults receives results of simulations until ctx is done or the
+// stream breaks. report gets them as the content sent to PUT
+// /v1/simulation-results by HTTP algorithms: detections as JSON, or "error"
+// for failed simulations.
+func (a *GRPCAlgorithm) WatchResults(ctx context.Context, report func(id, content string)) error {
+	stream, err := a.client.WatchResults(outgoingContext(ctx), &algorithmpb.WatchResultsRequest{})
+	if err != nil {
+		return err
+	}
+	for {
+		result, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		if result.GetDetections() == nil {
+			log.Print("simulation " + result.Id + " failed: " + result.GetError())
+			report(result.Id, `"error"`)
+			continue
+		}
+		report(result.Id, resultContent(result.GetDetections()))
+	}
+}
+
+// resultContent converts detections to the layout of structure.Detections.
+func resultContent(detections *algorithmpb.Detections) string {
+	converted := structure.Detections{Names: []string{}, Scores: []float64{}, BBox: [][]float64{}}
+	withMasks := false
+	for _, detection := range detections.Detections {
+		converted.Names = append(converted.Names, detection.Name)
+		converted.Scores = append(converted.Scores, detection.Score)
+		converted.BBox = append(converted.BBox, detection.Bbox)
+		withMasks = withMasks || detection.Mask != nil
+	}
+	if withMasks {
+		// masks are matched with detections by index, so detections without
+		// a mask get an empty one
+		for _, detection := range detections.Detections {
+			converted.Masks = append(converted.Masks, structure.RLE{
+				Size:   ints(detection.GetMask().GetSize()),
+				Counts: ints(detection.GetMask().GetCounts()),
+			})
+		}
+	}
+	content, _ := json.Marshal(converted)
+	return string(content)
+}
+
+func ints(values []int32) []int {
+	converted := make([]int, len(values))
+	for i, value := range values {
+		converted[i] = int(value)
+	}
+	return converted
+}
+
+// outgoingContext forwards the request id and the trace context as metadata,
+// like setRequestID does with headers.
+func outgoingContext(ctx context.Context) context.Context {
+	header := http.Header{}
+	if id := logging.RequestID(ctx); id != "" {
+		header.Set(logging.RequestIDHeader, id)
+	}
+	tracing.Inject(ctx, header)
+	md := metadata.MD{}
+	for key, values := range header {
+		md.Append(key, values...)
+	}
+	return metadata.NewOutgoingContext(ctx, md)
+}
@@ -0,0 +1,207 @@
+package algorithm
+
+import (
+	"backend/internal/logging"
+	"backend/internal/structure"
+	"backend/pkg/algorithmpb"
+	"backend/pkg/algorithmserver"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/test/bufconn"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+type testDetector struct {
+	models     map[string][]byte
+	images     chan []byte
+	detections *algorithmpb.Detections
+	err        error
+}
+
+func (d *testDetector) LoadModel(name string, model io.Reader) error {
+	content, err := ioutil.ReadAll(model)
+	d.models[name] = content
+	return err
+}
+
+func (d *testDetector) Detect(ctx context.Context, operation, model string, image []byte) (*algorithmpb.Detections, error) {
+	d.images <- image
+	return d.detections, d.err
+}
+
+// startGRPCAlgorithm serves the detector with the reference server and
+// returns a client connected to it.
+func startGRPCAlgorithm(t *testing.T, detector *testDetector, opts ...grpc.ServerOption) *GRPCAlgorithm {
+	listener := bufconn.Listen(1024 * 1024)
+	impl := algorithmserver.New(detector, 1, 1)
+	server := grpc.NewServer(opts...)
+	algorithmpb.RegisterAlgorithmServer(server, impl)
+	go server.Serve(listener)
+	t.Cleanup(func() {
+		server.Stop()
+		impl.Stop()
+	})
+
+	alg, err := NewGRPCAlgorithm("alg1", "bufnet", grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+		return listener.Dial()
+	}))
+	assert.NoError(t, err)
+	t.Cleanup(func() { alg.Close() })
+	return alg
+}
+
+// watchOne returns the first result reported by WatchResults.
+func watchOne(t *testing.T, alg *GRPCAlgorithm) (string, string) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	var id, content string
+	alg.WatchResults(ctx, func(reportedID, reportedContent string) {
+		id, content = reportedID, reportedContent
+		cancel()
+	})
+	return id, content
+}
+
+func TestGRPCAlgorithm_RunSimulation(t *testing.T) {
+	image := []byte("image")
+	jsonSendData, err := json.Marshal(structure.Body{ID: "job1", Model: "model.h5", Image: "data:image/png;base64," + base64.StdEncoding.EncodeToString(image)})
+	assert.NoError(t, err)
+	tests := []struct {
+		testName   string
+		detections *algorithmpb.Detections
+		err        error
+		content    string
+	}{
+		{
+			testName: "should stream detections as results",
+			detections: &algorithmpb.Detections{Detections: []*algorithmpb.Detection{
+				{Name: "cat", Score: 0.9, Bbox: []float64{1, 2, 3, 4}},
+			}},
+			content: `{"names":["cat"],"scores":[0.9],"bbox":[[1,2,3,4]]}`,
+		},
+		{
+			testName: "should stream masks of detections",
+			detections: &algorithmpb.Detections{Detections: []*algorithmpb.Detection{
+				{Name: "cat", Score: 0.9, Bbox: []float64{1, 2, 3, 4}, Mask: &algorithmpb.Mask{Size: []int32{2, 2}, Counts: []int32{1, 3}}},
+				{Name: "dog", Score: 0.5, Bbox: []float64{5, 6, 7, 8}},
+			}},
+			content: `{"names":["cat","dog"],"scores":[0.9,0.5],"bbox":[[1,2,3,4],[5,6,7,8]],"masks":[{"size":[2,2],"counts":[1,3]},{"size":[],"counts":[]}]}`,
+		},
+		{
+			testName: "should report failed simulation as error",
+			err:      errors.New("out of memory"),
+			content:  `"error"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			//given
+			detector := &testDetector{images: make(chan []byte, 1), detections: tt.detections, err: tt.err}
+			alg := startGRPCAlgorithm(t, detector)
+
+			//when
+			status, err := alg.RunSimulation(context.Background(), "demo", jsonSendData)
+			id, content := watchOne(t, alg)
+
+			//then
+			assert.NoError(t, err)
+			assert.Equal(t, http.StatusOK, status)
+			assert.Equal(t, image, <-detector.images)
+			assert.Equal(t, "job1", id)
+			assert.Equal(t, tt.content, content)
+		})
+	}
+	t.Run("should return error when image is not base64", func(t *testing.T) {
+		alg := startGRPCAlgorithm(t, &testDetector{})
+		jsonInvalid, err := json.Marshal(structure.Body{ID: "job1", Model: "model.h5", Image: "%%%"})
+		assert.NoError(t, err)
+		status, err := alg.RunSimulation(context.Background(), "demo", jsonInvalid)
+		assert.EqualError(t, err, "image is not valid base64")
+		assert.Equal(t, 0, status)
+	})
+	t.Run("should forward request id to algorithm", func(t *testing.T) {
+		var requestID []string
+		interceptor := func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			md, _ := metadata.FromIncomingContext(ctx)
+			requestID = md.Get(logging.RequestIDHeader)
+			return handler(ctx, req)
+		}
+		alg := startGRPCAlgorithm(t, &testDetector{images: make(chan []byte, 1)}, grpc.UnaryInterceptor(interceptor))
+		_, err := alg.RunSimulation(logging.WithRequestID(context.Background(), "requestID"), "demo", jsonSendData)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"requestID"}, requestID)
+	})
+}
+
+func TestGRPCAlgorithm_GetStatus(t *testing.T) {
+	t.Run("should report whether algorithm received simulation", func(t *testing.T) {
+		//given
+		detector := &testDetector{images: make(chan []byte, 1), detections: &algorithmpb.Detections{}}
+		alg := startGRPCAlgorithm(t, detector)
+		jsonSendData, err := json.Marshal(structure.Body{ID: "job1", Image: base64.StdEncoding.EncodeToString([]byte("image"))})
+		assert.NoError(t, err)
+		_, err = alg.RunSimulation(context.Background(), "demo", jsonSendData)
+		assert.NoError(t, err)
+
+		//when
+		received, err := alg.GetStatus(context.Background(), "job1")
+		unknown, unknownErr := alg.GetStatus(context.Background(), "job2")
+
+		//then
+		assert.NoError(t, err)
+		assert.True(t, received)
+		assert.NoError(t, unknownErr)
+		assert.False(t, unknown)
+	})
+	t.Run("should return error when algorithm can not be reached", func(t *testing.T) {
+		//given
+		alg, err := NewGRPCAlgorithm("alg1", "bufnet", grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+			return nil, errors.New("connection refused")
+		}))
+		assert.NoError(t, err)
+		defer alg.Close()
+		alg.Timeout = 100 * time.Millisecond
+
+		//when
+		received, err := alg.GetStatus(context.Background(), "job1")
+
+		//then
+		assert.Error(t, err)
+		assert.False(t, received)
+	})
+}
+
+func TestGRPCAlgorithm_UploadModel(t *testing.T) {
+	t.Run("should stream model in chunks", func(t *testing.T) {
+		detector := &testDetector{models: map[string][]byte{}}
+		alg := startGRPCAlgorithm(t, detector)
+		model := bytes.Repeat([]byte("weights"), uploadChunkSize)
+		err := alg.UploadModel(context.Background(), "model.h5", bytes.NewReader(model))
+		assert.NoError(t, err)
+		assert.Equal(t, model, detector.models["model.h5"])
+	})
+	t.Run("should abort upload when model can not be read", func(t *testing.T) {
+		detector := &testDetector{models: map[string][]byte{}}
+		alg := startGRPCAlgorithm(t, detector)
+		model := io.MultiReader(bytes.NewReader([]byte("weights")), &failingReader{})
+		err := alg.UploadModel(context.Background(), "model.h5", model)
+		assert.EqualError(t, err, "read failed")
+	})
+}
+
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) {
+	return 0, errors.New("read failed")
+}
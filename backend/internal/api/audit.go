@@ -0,0 +1,141 @@
+package api
+
+import (
+	"backend/internal/audit"
+	"backend/internal/logging"
+	"backend/internal/structure"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// ActorHeader names who sends a request, e.g. a user of the frontend or a
+// script. It is recorded in the audit log, but not verified.
+const ActorHeader = "X-Actor"
+
+// Actions recorded in the audit log.
+const (
+	actionAddImage     = "image.add"
+	actionUploadModel  = "model.upload"
+	actionDefaultModel = "model.default"
+	actionRunJob       = "simulation.run"
+	actionJobResults   = "simulation.results"
+	actionPurge        = "results.purge"
+	actionRestore      = "backup.restore"
+	actionAddAlgorithm = "algorithm.register"
+)
+
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+var actorRegexp = regexp.MustCompile(`^[A-Za-z0-9._:@+-]{1,128}$`)
+
+// WithAuditLog sets the log which records mutating operations. By default
+// nothing is recorded.
+func (h Handler) WithAuditLog(auditLog IAuditLog) Handler {
+	h.iAuditLog = auditLog
+	return h
+}
+
+// auditEntry starts an entry of the audit log for the request. Requests with
+// the admin token are made by "admin", other requests by the sender named in
+// ActorHeader.
+func (h Handler) auditEntry(r *http.Request, action, resource string) structure.AuditEntry {
+	entry := structure.AuditEntry{
+		SourceIP:  r.RemoteAddr,
+		RequestID: logging.RequestID(r.Context()),
+		Action:    action,
+		Resource:  resource,
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		entry.SourceIP = host
+	}
+	if h.isAdmin(r) {
+		entry.Actor = "admin"
+	} else if actor := r.Header.Get(ActorHeader); actorRegexp.MatchString(actor) {
+		entry.Actor = actor
+	}
+	return entry
+}
+
+// audit records the entry. The operation already happened, so failures are
+// only logged.
+func (h Handler) audit(ctx context.Context, entry structure.AuditEntry) {
+	if h.iAuditLog == nil {
+		return
+	}
+	if entry.Actor == "" {
+		entry.Actor = "anonymous"
+	}
+	if err := h.iAuditLog.Record(detach(ctx), entry); err != nil {
+		log.Print("failed to record " + entry.Action + " of " + entry.Resource + ": " + err.Error())
+	}
+}
+
+//GET /v1/admin/audit
+func (h Handler) GetAuditLog(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != h.auditEndpoint {
+		notFound(w, r)
+		return
+	}
+	if !h.authorizeAdmin(w, r) {
+		return
+	}
+	filter, err := auditFilter(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	auditLog := structure.AuditLog{Entries: []structure.AuditEntry{}}
+	if h.iAuditLog != nil {
+		if auditLog.Entries, err = h.iAuditLog.Query(r.Context(), filter); err != nil {
+			writeError(w, r, http.StatusInternalServerError, "failed to read audit log", err)
+			return
+		}
+	}
+	jsonLog, err := json.Marshal(auditLog)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "failed to marshal audit log", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if _, err = fmt.Fprint(w, string(jsonLog)); err != nil {
+		log.Print("failed to write response: " + err.Error())
+	}
+}
+
+func auditFilter(r *http.Request) (audit.Filter, error) {
+	query := r.URL.Query()
+	filter := audit.Filter{
+		Actor:     query.Get("actor"),
+		Action:    query.Get("action"),
+		Resource:  query.Get("resource"),
+		RequestID: query.Get("requestId"),
+		Limit:     defaultAuditLimit,
+	}
+	var err error
+	for name, value := range map[string]*time.Time{"from": &filter.From, "to": &filter.To} {
+		if query.Get(name) == "" {
+			continue
+		}
+		if *value, err = time.Parse(time.RFC3339, query.Get(name)); err != nil {
+			return audit.Filter{}, fmt.Errorf("invalid %s, expected RFC3339 time", name)
+		}
+	}
+	if query.Get("limit") != "" {
+		filter.Limit, err = strconv.Atoi(query.Get("limit"))
+		if err != nil || filter.Limit < 1 || filter.Limit > maxAuditLimit {
+			return audit.Filter{}, fmt.Errorf("invalid limit, expected number between 1 and %d", maxAuditLimit)
+		}
+	}
+	return filter, nil
+}
@@ -0,0 +1,198 @@
+package api
+
+import (
+	"backend/internal/api/mocks"
+	"backend/internal/audit"
+	"backend/internal/logging"
+	"backend/internal/structure"
+	"errors"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandler_Audit(t *testing.T) {
+	jobKey := "2009-11-10T20:34:58Zalg1demo"
+	image := testImage(t, "png", 4, 3)
+	records := map[string]interface{}{
+		"models": testRegistry("alg1",
+			structure.Model{Name: "coco", Version: 1, File: "coco.h5", Status: modelReady},
+			structure.Model{Name: "balloon", Version: 1, File: "balloon.h5", Status: modelReady}),
+		jobKey: structure.Results{Algorithm: "alg1", Operation: "demo", TimeStamp: "2009-11-10T20:34:58Z", Status: "in-progress"},
+	}
+	tests := []struct {
+		testName string
+		request  func() *http.Request
+		serve    func(h Handler, w http.ResponseWriter, r *http.Request)
+		entry    structure.AuditEntry
+	}{
+		{
+			testName: "should record added image with actor",
+			request: func() *http.Request {
+				r := httptest.NewRequest("PUT", "/v1/images", strings.NewReader(`{"content":"`+image+`"}`))
+				r.Header.Set("Content-Type", "application/json")
+				r.Header.Set(ActorHeader, "anna")
+				return r
+			},
+			serve: Handler.AddImage,
+			entry: structure.AuditEntry{
+				Actor:     "anna",
+				SourceIP:  "192.0.2.1",
+				RequestID: "request-1",
+				Action:    actionAddImage,
+				Resource:  imageID(image),
+				After:     map[string]string{"id": imageID(image), "bytes": strconv.Itoa(len(image))},
+			},
+		},
+		{
+			testName: "should record default model of admin",
+			request: func() *http.Request {
+				r := httptest.NewRequest("PUT", "/v1/models/alg1/balloon/default", nil)
+				r.Header.Set("Authorization", "Bearer secret")
+				r.Header.Set(ActorHeader, "anna")
+				return mux.SetURLVars(r, map[string]string{"alg": "alg1", "name": "balloon"})
+			},
+			serve: Handler.SetDefaultModel,
+			entry: structure.AuditEntry{
+				Actor:     "admin",
+				SourceIP:  "192.0.2.1",
+				RequestID: "request-1",
+				Action:    actionDefaultModel,
+				Resource:  "alg1",
+				Before:    map[string]string{"default": "default"},
+				After:     map[string]string{"default": "balloon"},
+			},
+		},
+		{
+			testName: "should record results of algorithm",
+			request: func() *http.Request {
+				r := httptest.NewRequest("PUT", "/v1/simulation-results", strings.NewReader(`{"id":"`+jobKey+`","content":"{}"}`))
+				r.Header.Set("Content-Type", "application/json")
+				r.Header.Set(ActorHeader, "invalid actor")
+				return r
+			},
+			serve: Handler.UpdateResults,
+			entry: structure.AuditEntry{
+				Actor:     "algorithm:alg1",
+				SourceIP:  "192.0.2.1",
+				RequestID: "request-1",
+				Action:    actionJobResults,
+				Resource:  jobKey,
+				Before:    map[string]string{"status": "in-progress"},
+				After:     map[string]string{"status": "finished"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			//given
+			h, _ := memoryHandler(t, records)
+			iAuditLogMock := mocks.IAuditLog{}
+			iAuditLogMock.On("Record", mock.Anything, mock.Anything).Return(errors.New("connection refused"))
+			h = h.WithAuditLog(&iAuditLogMock).WithAdminToken("secret")
+			r := tt.request()
+			r = r.WithContext(logging.WithRequestID(r.Context(), "request-1"))
+			w := httptest.NewRecorder()
+
+			//when
+			tt.serve(h, w, r)
+
+			//then
+			assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+			iAuditLogMock.AssertCalled(t, "Record", mock.Anything, tt.entry)
+			iAuditLogMock.AssertNumberOfCalls(t, "Record", 1)
+		})
+	}
+}
+
+func TestHandler_GetAuditLog(t *testing.T) {
+	entries := []structure.AuditEntry{{ID: "2009-11-10T20:34:58.000000000Z-00000000", Time: "2009-11-10T20:34:58Z", Actor: "anna", Action: actionAddImage, Resource: "a1"}}
+	tests := []struct {
+		testName      string
+		requestURL    string
+		authorization string
+		filter        audit.Filter
+		queryError    error
+		response      string
+		statusCode    int
+	}{
+		{
+			testName:   "should return 404 when url is wrong",
+			requestURL: "/v1/admin/audit/wrong",
+			response:   `{"code":"not_found","message":"not found"}` + "\n",
+			statusCode: http.StatusNotFound,
+		},
+		{
+			testName:      "should return 401 when admin token is wrong",
+			requestURL:    "/v1/admin/audit",
+			authorization: "Bearer wrong",
+			response:      `{"code":"unauthorized","message":"invalid admin token"}` + "\n",
+			statusCode:    http.StatusUnauthorized,
+		},
+		{
+			testName:      "should return 400 when from is invalid",
+			requestURL:    "/v1/admin/audit?from=yesterday",
+			authorization: "Bearer secret",
+			response:      `{"code":"bad_request","message":"invalid from, expected RFC3339 time"}` + "\n",
+			statusCode:    http.StatusBadRequest,
+		},
+		{
+			testName:      "should return 400 when limit is too large",
+			requestURL:    "/v1/admin/audit?limit=5000",
+			authorization: "Bearer secret",
+			response:      `{"code":"bad_request","message":"invalid limit, expected number between 1 and 1000"}` + "\n",
+			statusCode:    http.StatusBadRequest,
+		},
+		{
+			testName:      "should return 500 when failed to read audit log",
+			requestURL:    "/v1/admin/audit",
+			authorization: "Bearer secret",
+			filter:        audit.Filter{Limit: 100},
+			queryError:    errors.New("connection refused"),
+			response:      `{"code":"internal_error","message":"failed to read audit log","details":"connection refused"}` + "\n",
+			statusCode:    http.StatusInternalServerError,
+		},
+		{
+			testName:      "should return filtered entries",
+			requestURL:    "/v1/admin/audit?actor=anna&action=image.add&resource=a1&requestId=r1&from=2009-11-10T00:00:00Z&to=2009-11-11T00:00:00Z&limit=10",
+			authorization: "Bearer secret",
+			filter: audit.Filter{
+				Actor:     "anna",
+				Action:    "image.add",
+				Resource:  "a1",
+				RequestID: "r1",
+				From:      time.Date(2009, 11, 10, 0, 0, 0, 0, time.UTC),
+				To:        time.Date(2009, 11, 11, 0, 0, 0, 0, time.UTC),
+				Limit:     10,
+			},
+			response:   `{"entries":[{"id":"2009-11-10T20:34:58.000000000Z-00000000","time":"2009-11-10T20:34:58Z","actor":"anna","action":"image.add","resource":"a1"}]}`,
+			statusCode: http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			//given
+			r := httptest.NewRequest("GET", tt.requestURL, nil)
+			if tt.authorization != "" {
+				r.Header.Set("Authorization", tt.authorization)
+			}
+			w := httptest.NewRecorder()
+			iAuditLogMock := mocks.IAuditLog{}
+			iAuditLogMock.On("Query", mock.Anything, tt.filter).Return(entries, tt.queryError)
+			testSubject := NewHandler(&mocks.IDatabase{}, nil).WithAuditLog(&iAuditLogMock).WithAdminToken("secret")
+
+			//when
+			testSubject.GetAuditLog(w, r)
+
+			//then
+			assert.Equal(t, tt.statusCode, w.Code)
+			assert.Equal(t, tt.response, w.Body.String())
+		})
+	}
+}
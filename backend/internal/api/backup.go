@@ -0,0 +1,508 @@
+package api
+
+import (
+	"backend/internal/backup"
+	"backend/internal/database"
+	"backend/internal/retention"
+	"backend/internal/structure"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Conflicts are records of a backup which exist in the database already.
+// Images are stored under checksums of their contents, so they never
+// conflict.
+const (
+	conflictSkip      = "skip"
+	conflictOverwrite = "overwrite"
+	conflictRename    = "rename"
+)
+
+// defaultMaxRestoreSize limits the size of restored archives, both of the
+// request body and of the files unpacked from it.
+const defaultMaxRestoreSize = 1 << 30
+
+// timeStampLength is the length of time stamps which start keys of results.
+const timeStampLength = len("2006-01-02T15:04:05Z")
+
+// interruptedJobError is set on results of jobs which did not finish before
+// the backup, as no algorithm reports them after it is restored.
+const interruptedJobError = "job did not finish before the backup"
+
+// WithMaxRestoreSize limits the size of archives sent to /v1/admin/restore,
+// compressed and unpacked, to size bytes. By default it is 1 GB.
+func (h Handler) WithMaxRestoreSize(size int64) Handler {
+	h.maxRestoreSize = size
+	return h
+}
+
+//GET /v1/admin/backup
+func (h Handler) Backup(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != h.backupEndpoint {
+		notFound(w, r)
+		return
+	}
+	if !h.authorizeAdmin(w, r) {
+		return
+	}
+	version, err := h.schemaVersion(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "failed to read schema version", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=backup-%s.tar.gz", time.Now().UTC().Format("20060102T150405Z")))
+	// the archive is streamed, so errors can only be logged; archives which
+	// were cut off are rejected when they are restored
+	if err = h.writeBackup(r.Context(), backup.NewWriter(w, version)); err != nil {
+		log.Print("backup: " + err.Error())
+	}
+}
+
+// writeBackup adds the image index, the model registry, results and images to
+// the archive. Upload sessions and pending jobs are left out, as they can not
+// be continued by another server.
+func (h Handler) writeBackup(ctx context.Context, writer *backup.Writer) error {
+	defer writer.Discard()
+	keys := []string{"images", "models"}
+	resultKeys, err := h.iDatabase.Keys(ctx, resultsPattern)
+	if err != nil {
+		return err
+	}
+	sort.Strings(resultKeys)
+	for _, key := range append(keys, resultKeys...) {
+		value, ok, err := h.lookup(ctx, key)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		if err = writer.AddRecord(key, value); err != nil {
+			return err
+		}
+	}
+
+	imageKeys, err := h.iDatabase.Keys(ctx, imageKey("*"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(imageKeys)
+	for _, key := range imageKeys {
+		content, ok, err := h.lookup(ctx, key)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		if err = writer.AddImage(strings.TrimPrefix(key, imageKey("")), content); err != nil {
+			return err
+		}
+	}
+	return writer.Close()
+}
+
+//POST /v1/admin/restore
+func (h Handler) Restore(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != h.restoreEndpoint {
+		notFound(w, r)
+		return
+	}
+	if !h.authorizeAdmin(w, r) {
+		return
+	}
+	query := r.URL.Query()
+	conflict := query.Get("conflict")
+	if conflict == "" {
+		conflict = conflictSkip
+	}
+	if conflict != conflictSkip && conflict != conflictOverwrite && conflict != conflictRename {
+		writeError(w, r, http.StatusBadRequest, "invalid conflict, expected skip, overwrite or rename", nil)
+		return
+	}
+	dryRun := query.Get("dryRun") == "true"
+
+	memory, err := database.NewMemory("")
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "failed to create staging database", err)
+		return
+	}
+	// the archive is unpacked into the staging database while the body is
+	// read, which is dropped when the archive turns out to be invalid
+	manifest, err := backup.Read(http.MaxBytesReader(w, r.Body, h.maxRestoreSize), h.maxRestoreSize, stagingLoader{r.Context(), memory})
+	if err != nil {
+		var se statusError
+		if errors.As(err, &se) {
+			writeStatusError(w, r, se)
+		} else {
+			writeError(w, r, http.StatusBadRequest, err.Error(), nil)
+		}
+		return
+	}
+	staging, err := h.stageArchive(r.Context(), memory, manifest)
+	if err != nil {
+		writeStatusError(w, r, err)
+		return
+	}
+	report := structure.RestoreReport{DryRun: dryRun, Conflict: conflict, SchemaVersion: manifest.SchemaVersion}
+	err = h.restore(r.Context(), staging, conflict, dryRun, &report)
+	if !dryRun {
+		// restores are not atomic, records restored before a failure are
+		// recorded as well
+		entry := h.auditEntry(r, actionRestore, "backup")
+		entry.After = map[string]string{
+			"conflict":      conflict,
+			"schemaVersion": strconv.Itoa(report.SchemaVersion),
+			"images":        restoreSummary(report.Images),
+			"models":        restoreSummary(report.Models),
+			"results":       restoreSummary(report.Results),
+		}
+		h.audit(r.Context(), entry)
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "failed to restore backup", err)
+		return
+	}
+
+	jsonReport, err := json.Marshal(report)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "failed to marshal report", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if _, err = fmt.Fprint(w, string(jsonReport)); err != nil {
+		log.Print("failed to write response: " + err.Error())
+	}
+}
+
+func restoreSummary(counts structure.RestoreCounts) string {
+	return fmt.Sprintf("added=%d skipped=%d overwritten=%d renamed=%d", counts.Added, counts.Skipped, counts.Overwritten, counts.Renamed)
+}
+
+// stagingLoader loads the records and images of an archive into the staging
+// database.
+type stagingLoader struct {
+	ctx    context.Context
+	memory database.Memory
+}
+
+func (l stagingLoader) AddRecord(key, value string) error {
+	if err := l.memory.Set(l.ctx, key, value); err != nil {
+		return statusError{http.StatusInternalServerError, "failed to stage record " + key}
+	}
+	return nil
+}
+
+func (l stagingLoader) AddImage(id, content string) error {
+	if imageID(content) != id {
+		return statusError{http.StatusBadRequest, "image " + id + " does not match its content"}
+	}
+	if err := l.memory.Set(l.ctx, imageKey(id), content); err != nil {
+		return statusError{http.StatusInternalServerError, "failed to stage image " + id}
+	}
+	return nil
+}
+
+// stageArchive applies the migrations the archive loaded into memory is
+// missing, so that backups of older versions are restored in the current
+// layout.
+func (h Handler) stageArchive(ctx context.Context, memory database.Memory, manifest backup.Manifest) (Handler, error) {
+	latest := migrations[len(migrations)-1].version
+	if manifest.SchemaVersion > latest {
+		return Handler{}, statusError{http.StatusBadRequest, fmt.Sprintf("backup schema version %d is newer than supported version %d", manifest.SchemaVersion, latest)}
+	}
+	staging := h
+	staging.iDatabase = memory
+	if err := memory.Set(ctx, schemaVersionKey, strconv.Itoa(manifest.SchemaVersion)); err != nil {
+		return Handler{}, err
+	}
+	if err := staging.migrate(ctx); err != nil {
+		return Handler{}, statusError{http.StatusBadRequest, "failed to migrate backup: " + err.Error()}
+	}
+	for _, key := range []string{"images", "models"} {
+		if exists, err := staging.exists(ctx, key); err != nil {
+			return Handler{}, err
+		} else if !exists {
+			return Handler{}, statusError{http.StatusBadRequest, "backup has no " + key + " record"}
+		}
+	}
+	return staging, nil
+}
+
+// restore copies images, models and results from the staging database.
+// Nothing is written in a dry run, the report shows what would be restored.
+func (h Handler) restore(ctx context.Context, staging Handler, conflict string, dryRun bool, report *structure.RestoreReport) error {
+	if err := h.restoreImages(ctx, staging, dryRun, &report.Images); err != nil {
+		return errors.Wrap(err, "images")
+	}
+	if err := h.restoreModels(ctx, staging, conflict, dryRun, &report.Models); err != nil {
+		return errors.Wrap(err, "models")
+	}
+	if err := h.restoreResults(ctx, staging, conflict, dryRun, &report.Results); err != nil {
+		return errors.Wrap(err, "results")
+	}
+	return nil
+}
+
+func (h Handler) restoreImages(ctx context.Context, staging Handler, dryRun bool, counts *structure.RestoreCounts) error {
+	keys, err := staging.iDatabase.Keys(ctx, imageKey("*"))
+	if err != nil {
+		return err
+	}
+	restored := map[string]bool{}
+	for _, key := range keys {
+		restored[strings.TrimPrefix(key, imageKey(""))] = true
+		exists, err := h.exists(ctx, key)
+		if err != nil {
+			return err
+		}
+		if exists {
+			counts.Skipped++
+			continue
+		}
+		counts.Added++
+		if dryRun {
+			continue
+		}
+		content, err := staging.getImage(ctx, strings.TrimPrefix(key, imageKey("")))
+		if err != nil {
+			return err
+		}
+		if err = h.iDatabase.Set(ctx, key, content); err != nil {
+			return err
+		}
+	}
+	if dryRun {
+		return nil
+	}
+
+	// images are added to the gallery after their contents are stored, ids
+	// without contents are left out
+	archived, err := staging.getImageIndex(ctx)
+	if err != nil {
+		return err
+	}
+	return h.iDatabase.Update(ctx, "images", func(fromDB string) (string, error) {
+		var index structure.ImageIndex
+		if err := json.Unmarshal([]byte(fromDB), &index); err != nil {
+			return "", errors.New("failed to unmarshal images")
+		}
+		for _, id := range archived.IDs {
+			if restored[id] {
+				index = addToIndex(index, id)
+			}
+		}
+		jsonIndex, err := json.Marshal(index)
+		if err != nil {
+			return "", err
+		}
+		return string(jsonIndex), nil
+	})
+}
+
+// restoreModels merges the archived model registry into the stored one.
+// Renamed models get the first free name with the "-restored" suffix. Model
+// files are not part of backups, they have to be deployed to the algorithms
+// separately.
+func (h Handler) restoreModels(ctx context.Context, staging Handler, conflict string, dryRun bool, counts *structure.RestoreCounts) error {
+	archived, err := staging.getRegistry(ctx)
+	if err != nil {
+		return err
+	}
+	merge := func(registry *structure.Registry) error {
+		// updates are retried when the registry changes in the meantime
+		*counts = structure.RestoreCounts{}
+		for alg, archivedModels := range archived.Algorithms {
+			current, ok := registry.Algorithms[alg]
+			if !ok {
+				current = &structure.AlgorithmModels{}
+				registry.Algorithms[alg] = current
+			}
+			if current.Models == nil {
+				current.Models = map[string][]structure.Model{}
+			}
+			defaultName := archivedModels.Default
+			for _, name := range modelNames(archivedModels.Models) {
+				target := name
+				if _, exists := current.Models[name]; !exists {
+					counts.Added++
+				} else if conflict == conflictSkip {
+					counts.Skipped++
+					continue
+				} else if conflict == conflictOverwrite {
+					counts.Overwritten++
+				} else {
+					target = restoredModelName(current.Models, name)
+					counts.Renamed++
+				}
+				if err := checkModelName(target); err != nil {
+					return errors.Wrap(err, "model "+target)
+				}
+				current.Models[target] = restoredVersions(archivedModels.Models[name], target)
+				if name == archivedModels.Default {
+					defaultName = target
+				}
+			}
+			if _, ok := current.Models[current.Default]; !ok {
+				current.Default = defaultName
+			}
+		}
+		return nil
+	}
+
+	if dryRun {
+		registry, err := h.getRegistry(ctx)
+		if err != nil {
+			return err
+		}
+		return merge(&registry)
+	}
+	return h.updateRegistry(ctx, merge)
+}
+
+// restoredModelName returns the first free name with the "-restored" suffix.
+// The name is cut so that the suffix fits into the length limit of names.
+func restoredModelName(models map[string][]structure.Model, name string) string {
+	for i := 1; ; i++ {
+		suffix := "-restored"
+		if i > 1 {
+			suffix = fmt.Sprintf("-restored-%d", i)
+		}
+		base := name
+		if len(base)+len(suffix) > maxModelNameLength {
+			base = base[:maxModelNameLength-len(suffix)]
+		}
+		if _, exists := models[base+suffix]; !exists {
+			return base + suffix
+		}
+	}
+}
+
+// restoredVersions copies the versions under name. Uploads which did not
+// finish before the backup are marked as failed, as they are never completed.
+func restoredVersions(versions []structure.Model, name string) []structure.Model {
+	restored := make([]structure.Model, len(versions))
+	for i, model := range versions {
+		model.Name = name
+		if model.Status == modelUploading {
+			model.Status = modelFailed
+		}
+		restored[i] = model
+	}
+	return restored
+}
+
+// restoreResults copies results of simulations. Renamed results are moved to
+// the first free second after their time stamp. Results of jobs which still
+// run are never overwritten.
+func (h Handler) restoreResults(ctx context.Context, staging Handler, conflict string, dryRun bool, counts *structure.RestoreCounts) error {
+	keys, err := staging.iDatabase.Keys(ctx, resultsPattern)
+	if err != nil {
+		return err
+	}
+	sort.Strings(keys)
+	taken := map[string]bool{}
+	for _, key := range keys {
+		results, err := staging.getJob(ctx, key)
+		if err != nil {
+			return err
+		}
+		if !retention.Terminal(results.Status) {
+			results.Status = "error"
+			results.Error = interruptedJobError
+			results.Position = 0
+		}
+
+		target := key
+		existing, exists, err := h.lookup(ctx, key)
+		if err != nil {
+			return err
+		}
+		if exists || taken[key] {
+			var current structure.Results
+			json.Unmarshal([]byte(existing), &current)
+			switch {
+			case conflict == conflictSkip || (conflict == conflictOverwrite && !retention.Terminal(current.Status)):
+				counts.Skipped++
+				continue
+			case conflict == conflictOverwrite:
+				counts.Overwritten++
+			default:
+				if target, err = h.freeResultKey(ctx, key, taken); err != nil {
+					return err
+				}
+				results.TimeStamp = target[:timeStampLength]
+				counts.Renamed++
+			}
+		} else {
+			counts.Added++
+		}
+		taken[target] = true
+		if dryRun {
+			continue
+		}
+		jsonResults, err := json.Marshal(results)
+		if err != nil {
+			return err
+		}
+		if err = h.iDatabase.Set(ctx, target, string(jsonResults)); err != nil {
+			return err
+		}
+		// overwritten results are summarized again by the next sweep
+		if err = h.deleteResultSummary(ctx, target); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// freeResultKey returns the key of the first second after the time stamp of
+// key which is not used yet.
+func (h Handler) freeResultKey(ctx context.Context, key string, taken map[string]bool) (string, error) {
+	timeStamp, err := time.Parse(time.RFC3339, key[:timeStampLength])
+	if err != nil {
+		return "", err
+	}
+	for {
+		timeStamp = timeStamp.Add(time.Second)
+		candidate := timeStamp.Format(time.RFC3339) + key[timeStampLength:]
+		exists, err := h.exists(ctx, candidate)
+		if err != nil {
+			return "", err
+		}
+		if !exists && !taken[candidate] {
+			return candidate, nil
+		}
+	}
+}
+
+// lookup returns the value of key and whether it exists.
+func (h Handler) lookup(ctx context.Context, key string) (string, bool, error) {
+	fromDB, err := h.iDatabase.Get(ctx, key)
+	if err != nil {
+		if err.Error() == "key does not exist" {
+			return "", false, nil
+		}
+		return "", false, err
+	}
+	return fromDB.(string), true, nil
+}
+
+func modelNames(models map[string][]structure.Model) []string {
+	names := make([]string, 0, len(models))
+	for name := range models {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,381 @@
+package api
+
+import (
+	"backend/internal/backup"
+	"backend/internal/database"
+	"backend/internal/structure"
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const adminToken = "secret"
+
+// memoryHandler returns a handler with a configured database in memory.
+func memoryHandler(t *testing.T, records map[string]interface{}) (Handler, database.Memory) {
+	memory, err := database.NewMemory("")
+	assert.NoError(t, err)
+	h := NewHandler(memory, nil).WithAdminToken(adminToken)
+	assert.NoError(t, h.Config(context.Background()))
+	for key, value := range records {
+		content, ok := value.(string)
+		if !ok {
+			jsonValue, err := json.Marshal(value)
+			assert.NoError(t, err)
+			content = string(jsonValue)
+		}
+		assert.NoError(t, memory.Set(context.Background(), key, content))
+	}
+	return h, memory
+}
+
+// adminRequest returns a request which carries the admin token.
+func adminRequest(method, target string, body io.Reader) *http.Request {
+	r := httptest.NewRequest(method, target, body)
+	r.Header.Set("Authorization", "Bearer "+adminToken)
+	return r
+}
+
+func get(t *testing.T, memory database.Memory, key string) string {
+	value, err := memory.Get(context.Background(), key)
+	assert.NoError(t, err)
+	return value.(string)
+}
+
+func TestHandler_BackupAndRestore(t *testing.T) {
+	image := "data:image/png;base64,iVBORw0KGgo="
+	otherImage := "data:image/png;base64,iVBORw0KGgoA"
+	finishedKey := "2009-11-10T20:34:58Zalg1demo"
+	runningKey := "2009-11-10T20:35:58Zalg1demo"
+	registry := func(model structure.Model) structure.Registry {
+		return structure.Registry{Algorithms: map[string]*structure.AlgorithmModels{
+			"alg1": {Default: model.Name, Models: map[string][]structure.Model{model.Name: {model}}},
+		}}
+	}
+	source := map[string]interface{}{
+		"images":                 structure.ImageIndex{IDs: []string{imageID(image)}},
+		imageKey(imageID(image)): image,
+		"models":                 registry(structure.Model{Name: "coco", Version: 1, File: "coco.h5", Status: modelReady}),
+		finishedKey:              structure.Results{Algorithm: "alg1", Model: "coco", TimeStamp: "2009-11-10T20:34:58Z", Status: "finished", Result: "{}"},
+		runningKey:               structure.Results{Algorithm: "alg1", Model: "coco", TimeStamp: "2009-11-10T20:35:58Z", Status: "in-progress"},
+		"upload:1":               structure.UploadSession{ID: "1"},
+	}
+	target := map[string]interface{}{
+		"images":                      structure.ImageIndex{IDs: []string{imageID(otherImage)}},
+		imageKey(imageID(otherImage)): otherImage,
+		"models":                      registry(structure.Model{Name: "coco", Version: 3, File: "coco-v3.h5", Status: modelReady}),
+		finishedKey:                   structure.Results{Algorithm: "alg1", Model: "coco", TimeStamp: "2009-11-10T20:34:58Z", Status: "error"},
+	}
+	tests := []struct {
+		testName     string
+		query        string
+		report       structure.RestoreReport
+		modelNames   []string
+		modelVersion int
+		results      map[string]string
+	}{
+		{
+			testName: "should skip existing records",
+			query:    "",
+			report: structure.RestoreReport{
+				Conflict: "skip",
+				Images:   structure.RestoreCounts{Added: 1},
+				Models:   structure.RestoreCounts{Skipped: 1},
+				Results:  structure.RestoreCounts{Added: 1, Skipped: 1},
+			},
+			modelNames:   []string{"coco"},
+			modelVersion: 3,
+			results:      map[string]string{finishedKey: "error", runningKey: "error"},
+		},
+		{
+			testName: "should overwrite existing records",
+			query:    "?conflict=overwrite",
+			report: structure.RestoreReport{
+				Conflict: "overwrite",
+				Images:   structure.RestoreCounts{Added: 1},
+				Models:   structure.RestoreCounts{Overwritten: 1},
+				Results:  structure.RestoreCounts{Added: 1, Overwritten: 1},
+			},
+			modelNames:   []string{"coco"},
+			modelVersion: 1,
+			results:      map[string]string{finishedKey: "finished", runningKey: "error"},
+		},
+		{
+			testName: "should rename existing records",
+			query:    "?conflict=rename",
+			report: structure.RestoreReport{
+				Conflict: "rename",
+				Images:   structure.RestoreCounts{Added: 1},
+				Models:   structure.RestoreCounts{Renamed: 1},
+				Results:  structure.RestoreCounts{Added: 1, Renamed: 1},
+			},
+			modelNames:   []string{"coco", "coco-restored"},
+			modelVersion: 3,
+			// the renamed result takes the next second, so the result after it
+			// is moved as well
+			results: map[string]string{finishedKey: "error", "2009-11-10T20:34:59Zalg1demo": "finished", runningKey: "error"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			//given
+			sourceHandler, _ := memoryHandler(t, source)
+			targetHandler, targetMemory := memoryHandler(t, target)
+			w := httptest.NewRecorder()
+			sourceHandler.Backup(w, adminRequest("GET", "/v1/admin/backup", nil))
+			assert.Equal(t, http.StatusOK, w.Code)
+			r := adminRequest("POST", "/v1/admin/restore"+tt.query, w.Body)
+
+			//when
+			w = httptest.NewRecorder()
+			targetHandler.Restore(w, r)
+
+			//then
+			assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+			var report structure.RestoreReport
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+			tt.report.SchemaVersion = migrations[len(migrations)-1].version
+			assert.Equal(t, tt.report, report)
+
+			index, err := targetHandler.getImageIndex(context.Background())
+			assert.NoError(t, err)
+			assert.ElementsMatch(t, []string{imageID(image), imageID(otherImage)}, index.IDs)
+			assert.Equal(t, image, get(t, targetMemory, imageKey(imageID(image))))
+			models, err := targetHandler.getRegistry(context.Background())
+			assert.NoError(t, err)
+			assert.ElementsMatch(t, tt.modelNames, modelNames(models.Algorithms["alg1"].Models))
+			assert.Equal(t, tt.modelVersion, models.Algorithms["alg1"].Models["coco"][0].Version)
+			assert.Equal(t, "coco", models.Algorithms["alg1"].Default)
+			for key, status := range tt.results {
+				results, err := targetHandler.getJob(context.Background(), key)
+				assert.NoError(t, err)
+				assert.Equal(t, status, results.Status, key)
+			}
+			_, err = targetMemory.Get(context.Background(), "upload:1")
+			assert.EqualError(t, err, "key does not exist")
+		})
+	}
+}
+
+func TestHandler_Restore(t *testing.T) {
+	legacyImage := "data:image/png;base64,iVBORw0KGgo="
+	archive := func(t *testing.T, schemaVersion int, records map[string]string) *bytes.Buffer {
+		var buffer bytes.Buffer
+		writer := backup.NewWriter(&buffer, schemaVersion)
+		for key, value := range records {
+			assert.NoError(t, writer.AddRecord(key, value))
+		}
+		assert.NoError(t, writer.Close())
+		return &buffer
+	}
+	t.Run("should migrate backups of older versions", func(t *testing.T) {
+		//given
+		h, memory := memoryHandler(t, nil)
+		body := archive(t, 2, map[string]string{
+			"images": `{"images":["` + legacyImage + `"]}`,
+			"models": `{"algorithms":{}}`,
+		})
+		w := httptest.NewRecorder()
+
+		//when
+		h.Restore(w, adminRequest("POST", "/v1/admin/restore", body))
+
+		//then
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		assert.JSONEq(t, `{"dryRun":false,"conflict":"skip","schemaVersion":2,`+
+			`"images":{"added":1,"skipped":0,"overwritten":0,"renamed":0},`+
+			`"models":{"added":0,"skipped":0,"overwritten":0,"renamed":0},`+
+			`"results":{"added":0,"skipped":0,"overwritten":0,"renamed":0}}`, w.Body.String())
+		assert.Equal(t, `{"ids":["`+imageID(legacyImage)+`"]}`, get(t, memory, "images"))
+		assert.Equal(t, legacyImage, get(t, memory, imageKey(imageID(legacyImage))))
+	})
+	t.Run("should not write anything in dry run", func(t *testing.T) {
+		//given
+		h, memory := memoryHandler(t, nil)
+		body := archive(t, 4, map[string]string{
+			"images":                       `{"ids":[]}`,
+			"models":                       `{"algorithms":{}}`,
+			"2009-11-10T20:34:58Zalg1demo": `{"algorithm":"alg1","status":"finished"}`,
+		})
+		w := httptest.NewRecorder()
+
+		//when
+		h.Restore(w, adminRequest("POST", "/v1/admin/restore?dryRun=true", body))
+
+		//then
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		assert.Contains(t, w.Body.String(), `"results":{"added":1,`)
+		keys, err := memory.Keys(context.Background(), resultsPattern)
+		assert.NoError(t, err)
+		assert.Empty(t, keys)
+	})
+	t.Run("should reject archives larger than limit", func(t *testing.T) {
+		//given
+		h, _ := memoryHandler(t, nil)
+		h = h.WithMaxRestoreSize(1 << 20)
+		body := archive(t, 4, map[string]string{
+			"images":                       `{"ids":[]}`,
+			"models":                       `{"algorithms":{}}`,
+			"2009-11-10T20:34:58Zalg1demo": strings.Repeat("a", 1<<20),
+		})
+		w := httptest.NewRecorder()
+
+		//when
+		h.Restore(w, adminRequest("POST", "/v1/admin/restore", body))
+
+		//then
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Equal(t, `{"code":"bad_request","message":"archive is larger than 1 MB"}`+"\n", w.Body.String())
+	})
+	t.Run("should reject bodies larger than limit", func(t *testing.T) {
+		//given
+		h, memory := memoryHandler(t, nil)
+		h = h.WithMaxRestoreSize(16)
+		body := archive(t, 4, map[string]string{
+			"images": `{"ids":[]}`,
+			"models": `{"algorithms":{}}`,
+		})
+		w := httptest.NewRecorder()
+
+		//when
+		h.Restore(w, adminRequest("POST", "/v1/admin/restore", body))
+
+		//then
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), "request body too large")
+		assert.Equal(t, `{"ids":[]}`, get(t, memory, "images"))
+	})
+	t.Run("should reject images which do not match their content", func(t *testing.T) {
+		//given
+		h, memory := memoryHandler(t, nil)
+		var body bytes.Buffer
+		writer := backup.NewWriter(&body, 4)
+		assert.NoError(t, writer.AddRecord("images", `{"ids":["a1"]}`))
+		assert.NoError(t, writer.AddRecord("models", `{"algorithms":{}}`))
+		assert.NoError(t, writer.AddImage("a1", legacyImage))
+		assert.NoError(t, writer.Close())
+		w := httptest.NewRecorder()
+
+		//when
+		h.Restore(w, adminRequest("POST", "/v1/admin/restore", &body))
+
+		//then
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Equal(t, `{"code":"bad_request","message":"image a1 does not match its content"}`+"\n", w.Body.String())
+		assert.Equal(t, `{"ids":[]}`, get(t, memory, "images"))
+	})
+	tests := []struct {
+		testName   string
+		requestURL string
+		body       *bytes.Buffer
+		response   string
+		statusCode int
+	}{
+		{
+			testName:   "should return 404 when url is wrong",
+			requestURL: "/v1/admin/restore/wrong",
+			body:       &bytes.Buffer{},
+			response:   `{"code":"not_found","message":"not found"}` + "\n",
+			statusCode: http.StatusNotFound,
+		},
+		{
+			testName:   "should return 400 when conflict is invalid",
+			requestURL: "/v1/admin/restore?conflict=merge",
+			body:       &bytes.Buffer{},
+			response:   `{"code":"bad_request","message":"invalid conflict, expected skip, overwrite or rename"}` + "\n",
+			statusCode: http.StatusBadRequest,
+		},
+		{
+			testName:   "should return 400 when body is not an archive",
+			requestURL: "/v1/admin/restore",
+			body:       bytes.NewBufferString("backup"),
+			response:   `{"code":"bad_request","message":"invalid archive: unexpected EOF"}` + "\n",
+			statusCode: http.StatusBadRequest,
+		},
+		{
+			testName:   "should return 400 when models are missing",
+			requestURL: "/v1/admin/restore",
+			body:       archive(t, 4, map[string]string{"images": `{"ids":[]}`}),
+			response:   `{"code":"bad_request","message":"backup has no models record"}` + "\n",
+			statusCode: http.StatusBadRequest,
+		},
+		{
+			testName:   "should return 400 when schema version is newer",
+			requestURL: "/v1/admin/restore",
+			body:       archive(t, 99, nil),
+			response:   `{"code":"bad_request","message":"backup schema version 99 is newer than supported version 6"}` + "\n",
+			statusCode: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			//given
+			h, _ := memoryHandler(t, nil)
+			w := httptest.NewRecorder()
+
+			//when
+			h.Restore(w, adminRequest("POST", tt.requestURL, tt.body))
+
+			//then
+			assert.Equal(t, tt.statusCode, w.Code)
+			assert.Equal(t, tt.response, w.Body.String())
+		})
+	}
+}
+
+func TestRestoredModelName(t *testing.T) {
+	long := strings.Repeat("a", maxModelNameLength)
+	tests := []struct {
+		testName string
+		models   []string
+		name     string
+		expected string
+	}{
+		{
+			testName: "should add suffix",
+			models:   []string{"coco"},
+			name:     "coco",
+			expected: "coco-restored",
+		},
+		{
+			testName: "should number names which are taken",
+			models:   []string{"coco", "coco-restored", "coco-restored-2"},
+			name:     "coco",
+			expected: "coco-restored-3",
+		},
+		{
+			testName: "should cut long names to fit suffix",
+			models:   []string{long},
+			name:     long,
+			expected: long[:maxModelNameLength-len("-restored")] + "-restored",
+		},
+		{
+			testName: "should cut long names to fit numbered suffix",
+			models:   []string{long, long[:maxModelNameLength-len("-restored")] + "-restored"},
+			name:     long,
+			expected: long[:maxModelNameLength-len("-restored-2")] + "-restored-2",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			//given
+			models := map[string][]structure.Model{}
+			for _, name := range tt.models {
+				models[name] = nil
+			}
+
+			//when
+			name := restoredModelName(models, tt.name)
+
+			//then
+			assert.Equal(t, tt.expected, name)
+			assert.NoError(t, checkModelName(name))
+		})
+	}
+}
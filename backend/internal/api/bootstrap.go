@@ -0,0 +1,239 @@
+package api
+
+import (
+	"backend/internal/structure"
+	"context"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"log"
+	"strconv"
+	"strings"
+)
+
+const schemaVersionKey = "schema_version"
+
+type migration struct {
+	version     int
+	description string
+	apply       func(ctx context.Context, h Handler) error
+}
+
+// migrations upgrade data written by older versions of the server. They are
+// applied in order, each one at most once, and have to be safe to run on
+// data which is already partially in the new layout.
+var migrations = []migration{
+	{version: 1, description: "create images list", apply: createImages},
+	{version: 2, description: "convert model names to model registry", apply: migrateModelRegistry},
+	{version: 3, description: "store images under ids", apply: migrateImageIDs},
+	{version: 4, description: "create pending jobs list", apply: createPendingJobs},
+	{version: 5, description: "list images of simulations", apply: indexSimulationImages},
+	{version: 6, description: "store pending jobs under their own keys", apply: splitPendingJobs},
+}
+
+// Config prepares the database for the server: it applies pending migrations
+// and registers configured algorithms which are not known yet. Existing data
+// is never overwritten.
+func (h Handler) Config(ctx context.Context) error {
+	if err := h.migrate(ctx); err != nil {
+		return err
+	}
+	return h.seedAlgorithms(ctx)
+}
+
+// migrate applies migrations which were not applied to the database yet.
+func (h Handler) migrate(ctx context.Context) error {
+	current, err := h.schemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+	latest := migrations[len(migrations)-1].version
+	if current > latest {
+		return errors.Errorf("database schema version %d is newer than supported version %d", current, latest)
+	}
+
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+		if err = m.apply(ctx, h); err != nil {
+			return errors.Wrapf(err, "migration %d (%s)", m.version, m.description)
+		}
+		if err = h.iDatabase.Set(ctx, schemaVersionKey, strconv.Itoa(m.version)); err != nil {
+			return err
+		}
+		log.Printf("applied migration %d: %s", m.version, m.description)
+	}
+	return nil
+}
+
+func (h Handler) schemaVersion(ctx context.Context) (int, error) {
+	fromDB, err := h.iDatabase.Get(ctx, schemaVersionKey)
+	if err != nil {
+		if err.Error() == "key does not exist" {
+			return 0, nil
+		}
+		return 0, err
+	}
+	version, err := strconv.Atoi(fromDB.(string))
+	if err != nil {
+		return 0, errors.New("invalid schema version " + fromDB.(string))
+	}
+	return version, nil
+}
+
+// seedAlgorithms registers configured algorithms which are not in the model
+// registry yet, with the default model. Registrations are recorded in the
+// audit log as made by "system", as they follow from the configuration.
+func (h Handler) seedAlgorithms(ctx context.Context) error {
+	registry, err := h.getRegistry(ctx)
+	if err != nil {
+		return err
+	}
+	var added []string
+	for _, item := range h.iAlgorithm {
+		if _, ok := registry.Algorithms[item.GetID()]; ok {
+			continue
+		}
+		registry.Algorithms[item.GetID()] = &structure.AlgorithmModels{
+			Default: "default",
+			Models: map[string][]structure.Model{
+				"default": {{Name: "default", Version: 1, File: "default", Uploader: "system", Classes: []string{}, Status: modelReady}},
+			},
+		}
+		added = append(added, item.GetID())
+	}
+	if len(added) == 0 {
+		return nil
+	}
+	if err = h.setRegistry(ctx, registry); err != nil {
+		return err
+	}
+	for _, id := range added {
+		h.audit(ctx, structure.AuditEntry{
+			Actor:    "system",
+			Action:   actionAddAlgorithm,
+			Resource: id,
+			After:    map[string]string{"default": "default"},
+		})
+	}
+	return nil
+}
+
+func createImages(ctx context.Context, h Handler) error {
+	exists, err := h.exists(ctx, "images")
+	if err != nil || exists {
+		return err
+	}
+	jsonImages, err := json.Marshal(structure.Images{Images: []string{}})
+	if err != nil {
+		return err
+	}
+	return h.iDatabase.Set(ctx, "images", string(jsonImages))
+}
+
+// migrateImageIDs moves image contents out of the `images` list to their own
+// keys, leaving only the ids in the list. Results recorded before keep their
+// embedded image.
+func migrateImageIDs(ctx context.Context, h Handler) error {
+	fromDB, err := h.iDatabase.Get(ctx, "images")
+	if err != nil {
+		return err
+	}
+	var layout map[string]json.RawMessage
+	if err = json.Unmarshal([]byte(fromDB.(string)), &layout); err != nil {
+		return errors.New("failed to unmarshal images")
+	}
+	if _, ok := layout["images"]; !ok {
+		return nil
+	}
+
+	var legacy structure.Images
+	if err = json.Unmarshal([]byte(fromDB.(string)), &legacy); err != nil {
+		return errors.New("failed to unmarshal images")
+	}
+	index := structure.ImageIndex{IDs: []string{}}
+	for _, content := range legacy.Images {
+		id, err := h.storeImage(ctx, content)
+		if err != nil {
+			return err
+		}
+		index = addToIndex(index, id)
+	}
+	jsonIndex, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	return h.iDatabase.Set(ctx, "images", string(jsonIndex))
+}
+
+// indexSimulationImages lists images which were sent inline with simulations,
+// and so stored without being added to the image index.
+func indexSimulationImages(ctx context.Context, h Handler) error {
+	keys, err := h.iDatabase.Keys(ctx, imageKey("*"))
+	if err != nil {
+		return err
+	}
+	index, err := h.getImageIndex(ctx)
+	if err != nil {
+		return err
+	}
+	listed := len(index.IDs)
+	for _, key := range keys {
+		index = addToIndex(index, strings.TrimPrefix(key, imageKey("")))
+	}
+	if len(index.IDs) == listed {
+		return nil
+	}
+	jsonIndex, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	return h.iDatabase.Set(ctx, "images", string(jsonIndex))
+}
+
+func migrateModelRegistry(ctx context.Context, h Handler) error {
+	fromDB, err := h.iDatabase.Get(ctx, "models")
+	if err != nil {
+		if err.Error() != "key does not exist" {
+			return err
+		}
+		fromDB = "{}"
+	}
+	var layout map[string]json.RawMessage
+	if err = json.Unmarshal([]byte(fromDB.(string)), &layout); err != nil {
+		return errors.New("failed to unmarshal models")
+	}
+	if _, ok := layout["algorithms"]; ok {
+		return nil
+	}
+
+	var legacy structure.Algorithm
+	if err = json.Unmarshal([]byte(fromDB.(string)), &legacy); err != nil {
+		return errors.New("failed to unmarshal models")
+	}
+	registry := structure.Registry{Algorithms: map[string]*structure.AlgorithmModels{}}
+	for id, names := range legacy.Models {
+		algModels := &structure.AlgorithmModels{Models: map[string][]structure.Model{}}
+		for _, name := range names {
+			if _, ok := algModels.Models[name]; ok {
+				continue
+			}
+			algModels.Models[name] = []structure.Model{{Name: name, Version: 1, File: name, Classes: []string{}, Status: modelReady}}
+			if name == "default" {
+				algModels.Default = name
+			}
+		}
+		registry.Algorithms[id] = algModels
+	}
+	return h.setRegistry(ctx, registry)
+}
+
+func (h Handler) exists(ctx context.Context, key string) (bool, error) {
+	if _, err := h.iDatabase.Get(ctx, key); err != nil {
+		if err.Error() == "key does not exist" {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
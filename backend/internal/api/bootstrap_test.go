@@ -0,0 +1,214 @@
+package api
+
+import (
+	"backend/internal/api/mocks"
+	"backend/internal/structure"
+	"context"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"strings"
+	"testing"
+)
+
+func TestHandler_Config(t *testing.T) {
+	id := "algID"
+	notExist := errors.New("key does not exist")
+	defaultModel := structure.Model{Name: "default", Version: 1, File: "default", Uploader: "system", Classes: []string{}, Status: "ready"}
+	jsonEmptyImages, err := json.Marshal(structure.Images{Images: []string{}})
+	assert.NoError(t, err)
+	jsonEmptyIndex, err := json.Marshal(structure.ImageIndex{IDs: []string{}})
+	assert.NoError(t, err)
+	jsonIndex, err := json.Marshal(structure.ImageIndex{IDs: []string{imageID("image")}})
+	assert.NoError(t, err)
+	jsonSimulationIndex, err := json.Marshal(structure.ImageIndex{IDs: []string{imageID("image"), imageID("simulation")}})
+	assert.NoError(t, err)
+	jsonEmptyRegistry, err := json.Marshal(structure.Registry{Algorithms: map[string]*structure.AlgorithmModels{}})
+	assert.NoError(t, err)
+	jsonSeeded, err := json.Marshal(testRegistry(id, defaultModel))
+	assert.NoError(t, err)
+	jsonLegacy, err := json.Marshal(structure.Algorithm{Models: map[string][]string{id: {"default", "model.h5", "model.h5"}}})
+	assert.NoError(t, err)
+	jsonPending, err := json.Marshal(structure.JobIndex{IDs: []string{}})
+	assert.NoError(t, err)
+	jsonMigrated, err := json.Marshal(testRegistry(id,
+		structure.Model{Name: "default", Version: 1, File: "default", Classes: []string{}, Status: "ready"},
+		structure.Model{Name: "model.h5", Version: 1, File: "model.h5", Classes: []string{}, Status: "ready"}))
+	assert.NoError(t, err)
+	type get struct {
+		key      string
+		returned string
+		err      error
+	}
+	tests := []struct {
+		testName   string
+		gets       []get
+		imageKeys  []string
+		insertData map[string][]string
+		created    []string
+		deleted    []string
+		registered []string
+		err        string
+	}{
+		{
+			testName: "should create missing keys in empty database",
+			gets: []get{
+				{key: "schema_version", err: notExist},
+				{key: "images", err: notExist},
+				{key: "models", err: notExist},
+				{key: "images", returned: string(jsonEmptyImages)},
+				{key: "pending_jobs", err: notExist},
+				{key: "images", returned: string(jsonEmptyIndex)},
+				{key: "pending_jobs", returned: string(jsonPending)},
+				{key: "models", returned: string(jsonEmptyRegistry)},
+			},
+			insertData: map[string][]string{
+				"images":         {string(jsonEmptyImages), string(jsonEmptyIndex)},
+				"models":         {string(jsonEmptyRegistry), string(jsonSeeded)},
+				"pending_jobs":   {string(jsonPending)},
+				"schema_version": {"1", "2", "3", "4", "5", "6"},
+			},
+			deleted:    []string{"pending_jobs"},
+			registered: []string{id},
+		},
+		{
+			testName: "should move images under ids and convert model names of unversioned database",
+			gets: []get{
+				{key: "schema_version", err: notExist},
+				{key: "images", returned: `{"images":["image","image"]}`},
+				{key: "models", returned: string(jsonLegacy)},
+				{key: "images", returned: `{"images":["image","image"]}`},
+				{key: "pending_jobs", err: notExist},
+				{key: "images", returned: string(jsonIndex)},
+				{key: "pending_jobs", returned: string(jsonPending)},
+				{key: "models", returned: string(jsonMigrated)},
+			},
+			imageKeys: []string{"image:" + imageID("image")},
+			insertData: map[string][]string{
+				"image:" + imageID("image"): {"image", "image"},
+				"images":                    {string(jsonIndex)},
+				"models":                    {string(jsonMigrated)},
+				"pending_jobs":              {string(jsonPending)},
+				"schema_version":            {"1", "2", "3", "4", "5", "6"},
+			},
+			deleted: []string{"pending_jobs"},
+		},
+		{
+			testName: "should not rewrite images which are already stored under ids",
+			gets: []get{
+				{key: "schema_version", returned: "2"},
+				{key: "images", returned: string(jsonIndex)},
+				{key: "pending_jobs", returned: string(jsonPending)},
+				{key: "images", returned: string(jsonIndex)},
+				{key: "pending_jobs", returned: string(jsonPending)},
+				{key: "models", returned: string(jsonSeeded)},
+			},
+			imageKeys: []string{"image:" + imageID("image")},
+			insertData: map[string][]string{
+				"schema_version": {"3", "4", "5", "6"},
+			},
+			deleted: []string{"pending_jobs"},
+		},
+		{
+			testName: "should list images of simulations which are missing from image index",
+			gets: []get{
+				{key: "schema_version", returned: "4"},
+				{key: "images", returned: string(jsonIndex)},
+				{key: "pending_jobs", err: notExist},
+				{key: "models", returned: string(jsonSeeded)},
+			},
+			imageKeys: []string{"image:" + imageID("image"), "image:" + imageID("simulation")},
+			insertData: map[string][]string{
+				"images":         {string(jsonSimulationIndex)},
+				"schema_version": {"5", "6"},
+			},
+		},
+		{
+			testName: "should move pending jobs under their own keys",
+			gets: []get{
+				{key: "schema_version", returned: "5"},
+				{key: "pending_jobs", returned: `{"ids":["1","2"]}`},
+				{key: "models", returned: string(jsonSeeded)},
+			},
+			insertData: map[string][]string{
+				"schema_version": {"6"},
+			},
+			created: []string{"pending:1", "pending:2"},
+			deleted: []string{"pending_jobs"},
+		},
+		{
+			testName: "should not write anything when database is up to date",
+			gets: []get{
+				{key: "schema_version", returned: "6"},
+				{key: "models", returned: string(jsonSeeded)},
+			},
+		},
+		{
+			testName: "should return error when database schema is newer than supported",
+			gets: []get{
+				{key: "schema_version", returned: "7"},
+			},
+			err: "database schema version 7 is newer than supported version 6",
+		},
+		{
+			testName: "should return error when migration fails",
+			gets: []get{
+				{key: "schema_version", returned: "1"},
+				{key: "models", returned: "not json"},
+			},
+			err: "migration 2 (convert model names to model registry): failed to unmarshal models",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			//given
+			iDatabaseMock := mocks.IDatabase{}
+			iAlgorithmMock := mocks.IAlgorithm{}
+			iAuditLogMock := mocks.IAuditLog{}
+			testSubject := NewHandler(&iDatabaseMock, []IAlgorithm{&iAlgorithmMock}).WithAuditLog(&iAuditLogMock)
+			iAuditLogMock.On("Record", mock.Anything, mock.Anything).Return(nil)
+			iAlgorithmMock.On("GetID").Return(id)
+			iDatabaseMock.On("Keys", mock.Anything, "image:*").Return(tt.imageKeys, nil)
+			for _, g := range tt.gets {
+				iDatabaseMock.On("Get", mock.Anything, g.key).Return(g.returned, g.err).Once()
+			}
+			inserts := 0
+			for key, values := range tt.insertData {
+				for _, value := range values {
+					iDatabaseMock.On("Set", mock.Anything, key, value).Return(nil).Once()
+					inserts++
+				}
+			}
+			for _, key := range tt.created {
+				iDatabaseMock.On("Create", mock.Anything, key, strings.TrimPrefix(key, "pending:")).Return(nil).Once()
+			}
+			for _, key := range tt.deleted {
+				iDatabaseMock.On("Delete", mock.Anything, key).Return(nil).Once()
+			}
+
+			//when
+			err := testSubject.Config(context.Background())
+
+			//then
+			if tt.err != "" {
+				assert.EqualError(t, err, tt.err)
+			} else {
+				assert.NoError(t, err)
+			}
+			iDatabaseMock.AssertNumberOfCalls(t, "Get", len(tt.gets))
+			iDatabaseMock.AssertNumberOfCalls(t, "Set", inserts)
+			iDatabaseMock.AssertNumberOfCalls(t, "Create", len(tt.created))
+			iDatabaseMock.AssertNumberOfCalls(t, "Delete", len(tt.deleted))
+			iAuditLogMock.AssertNumberOfCalls(t, "Record", len(tt.registered))
+			for _, registered := range tt.registered {
+				iAuditLogMock.AssertCalled(t, "Record", mock.Anything, structure.AuditEntry{
+					Actor:    "system",
+					Action:   actionAddAlgorithm,
+					Resource: registered,
+					After:    map[string]string{"default": "default"},
+				})
+			}
+		})
+	}
+}
@@ -0,0 +1,25 @@
+package api
+
+import (
+	"context"
+	"time"
+)
+
+// detachedContext keeps the values of a request context, like its request id
+// and trace, but is not cancelled together with it.
+type detachedContext struct {
+	context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+
+func (detachedContext) Done() <-chan struct{} { return nil }
+
+func (detachedContext) Err() error { return nil }
+
+// detach is used to record the outcome of work which was already started
+// outside of the backend, e.g. when the request is aborted during shutdown
+// after the algorithm accepted the job or received part of a model.
+func detach(ctx context.Context) context.Context {
+	return detachedContext{ctx}
+}
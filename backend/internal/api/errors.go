@@ -0,0 +1,78 @@
+package api
+
+import (
+	"backend/internal/logging"
+	"backend/internal/structure"
+	"encoding/json"
+	"go.uber.org/zap"
+	"log"
+	"net/http"
+)
+
+// errorCodes are sent with error responses, so that clients can tell errors
+// apart without parsing their messages.
+var errorCodes = map[int]string{
+	http.StatusBadRequest:          "bad_request",
+	http.StatusUnauthorized:        "unauthorized",
+	http.StatusNotFound:            "not_found",
+	http.StatusMethodNotAllowed:    "method_not_allowed",
+	http.StatusConflict:            "conflict",
+	http.StatusInternalServerError: "internal_error",
+	http.StatusBadGateway:          "upstream_error",
+	http.StatusServiceUnavailable:  "unavailable",
+}
+
+// statusError is returned by helpers shared between handlers, when the error
+// has to be reported with a status other than 500.
+type statusError struct {
+	status  int
+	message string
+}
+
+func (e statusError) Error() string {
+	return e.message
+}
+
+// writeError replies with the error envelope. The error which caused the
+// failure, if any, is sent as details and added to the request log.
+func writeError(w http.ResponseWriter, r *http.Request, status int, message string, cause error) {
+	response := structure.Error{
+		Code:      errorCodes[status],
+		Message:   message,
+		RequestID: logging.RequestID(r.Context()),
+	}
+	if response.Code == "" {
+		response.Code = "error"
+	}
+	if cause != nil {
+		response.Details = cause.Error()
+		logging.AddFields(r.Context(), zap.Error(cause))
+	}
+	jsonResponse, err := json.Marshal(response)
+	if err != nil {
+		log.Print("failed to marshal error: " + err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	w.Write(append(jsonResponse, '\n'))
+}
+
+// writeStatusError replies with the status of a statusError, or with 500 and
+// the error as details for any other error.
+func writeStatusError(w http.ResponseWriter, r *http.Request, err error) {
+	if se, ok := err.(statusError); ok {
+		writeError(w, r, se.status, se.message, nil)
+		return
+	}
+	writeError(w, r, http.StatusInternalServerError, "internal error", err)
+}
+
+func notFound(w http.ResponseWriter, r *http.Request) {
+	writeError(w, r, http.StatusNotFound, "not found", nil)
+}
+
+func methodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	writeError(w, r, http.StatusMethodNotAllowed, "method not allowed", nil)
+}
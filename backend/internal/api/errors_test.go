@@ -0,0 +1,134 @@
+package api
+
+import (
+	"backend/internal/logging"
+	"context"
+	"errors"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWriteError(t *testing.T) {
+	tests := []struct {
+		testName   string
+		status     int
+		message    string
+		cause      error
+		requestID  string
+		body       string
+		statusCode int
+	}{
+		{
+			testName:   "should write error without details",
+			status:     http.StatusBadRequest,
+			message:    "invalid content type",
+			body:       `{"code":"bad_request","message":"invalid content type"}` + "\n",
+			statusCode: http.StatusBadRequest,
+		},
+		{
+			testName:   "should write cause of error as details",
+			status:     http.StatusBadGateway,
+			message:    "failed to run simulation",
+			cause:      errors.New("algorithm responded with status 500"),
+			body:       `{"code":"upstream_error","message":"failed to run simulation","details":"algorithm responded with status 500"}` + "\n",
+			statusCode: http.StatusBadGateway,
+		},
+		{
+			testName:   "should write request id",
+			status:     http.StatusNotFound,
+			message:    "not found",
+			requestID:  "requestID",
+			body:       `{"code":"not_found","message":"not found","requestId":"requestID"}` + "\n",
+			statusCode: http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			//given
+			r := httptest.NewRequest("GET", "/", nil)
+			if tt.requestID != "" {
+				r = r.WithContext(logging.WithRequestID(context.Background(), tt.requestID))
+			}
+			w := httptest.NewRecorder()
+
+			//when
+			writeError(w, r, tt.status, tt.message, tt.cause)
+
+			//then
+			assert.Equal(t, tt.body, w.Body.String())
+			assert.Equal(t, tt.statusCode, w.Code)
+			assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+		})
+	}
+}
+
+func TestWriteStatusError(t *testing.T) {
+	t.Run("should write status and message of status error", func(t *testing.T) {
+		//given
+		r := httptest.NewRequest("GET", "/", nil)
+		w := httptest.NewRecorder()
+
+		//when
+		writeStatusError(w, r, statusError{http.StatusConflict, "model is already uploaded"})
+
+		//then
+		assert.Equal(t, `{"code":"conflict","message":"model is already uploaded"}`+"\n", w.Body.String())
+		assert.Equal(t, http.StatusConflict, w.Code)
+	})
+	t.Run("should write 500 with details for other errors", func(t *testing.T) {
+		//given
+		r := httptest.NewRequest("GET", "/", nil)
+		w := httptest.NewRecorder()
+
+		//when
+		writeStatusError(w, r, errors.New("connection refused"))
+
+		//then
+		assert.Equal(t, `{"code":"internal_error","message":"internal error","details":"connection refused"}`+"\n", w.Body.String())
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+	})
+}
+
+func TestHandler_InitializeEndpoints(t *testing.T) {
+	tests := []struct {
+		testName   string
+		method     string
+		requestURL string
+		body       string
+		statusCode int
+	}{
+		{
+			testName:   "should return json error for unknown path",
+			method:     "GET",
+			requestURL: "/v1/unknown",
+			body:       `{"code":"not_found","message":"not found"}` + "\n",
+			statusCode: http.StatusNotFound,
+		},
+		{
+			testName:   "should return json error for unsupported method",
+			method:     "DELETE",
+			requestURL: "/v1/images",
+			body:       `{"code":"method_not_allowed","message":"method not allowed"}` + "\n",
+			statusCode: http.StatusMethodNotAllowed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			//given
+			router := mux.NewRouter()
+			NewHandler(nil, nil).InitializeEndpoints(router)
+			r := httptest.NewRequest(tt.method, tt.requestURL, nil)
+			w := httptest.NewRecorder()
+
+			//when
+			router.ServeHTTP(w, r)
+
+			//then
+			assert.Equal(t, tt.body, w.Body.String())
+			assert.Equal(t, tt.statusCode, w.Code)
+		})
+	}
+}
@@ -0,0 +1,343 @@
+package api
+
+import (
+	"backend/internal/logging"
+	"backend/internal/structure"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cocoCategories maps class names returned by COCO trained detectors to the
+// category ids used in the official annotation files.
+var cocoCategories = map[string]int{
+	"person": 1, "bicycle": 2, "car": 3, "motorcycle": 4, "airplane": 5, "bus": 6, "train": 7,
+	"truck": 8, "boat": 9, "traffic light": 10, "fire hydrant": 11, "stop sign": 13,
+	"parking meter": 14, "bench": 15, "bird": 16, "cat": 17, "dog": 18, "horse": 19, "sheep": 20,
+	"cow": 21, "elephant": 22, "bear": 23, "zebra": 24, "giraffe": 25, "backpack": 27,
+	"umbrella": 28, "handbag": 31, "tie": 32, "suitcase": 33, "frisbee": 34, "skis": 35,
+	"snowboard": 36, "sports ball": 37, "kite": 38, "baseball bat": 39, "baseball glove": 40,
+	"skateboard": 41, "surfboard": 42, "tennis racket": 43, "bottle": 44, "wine glass": 46,
+	"cup": 47, "fork": 48, "knife": 49, "spoon": 50, "bowl": 51, "banana": 52, "apple": 53,
+	"sandwich": 54, "orange": 55, "broccoli": 56, "carrot": 57, "hot dog": 58, "pizza": 59,
+	"donut": 60, "cake": 61, "chair": 62, "couch": 63, "potted plant": 64, "bed": 65,
+	"dining table": 67, "toilet": 70, "tv": 72, "laptop": 73, "mouse": 74, "remote": 75,
+	"keyboard": 76, "cell phone": 77, "microwave": 78, "oven": 79, "toaster": 80, "sink": 81,
+	"refrigerator": 82, "book": 84, "clock": 85, "vase": 86, "scissors": 87, "teddy bear": 88,
+	"hair drier": 89, "toothbrush": 90,
+}
+
+type exportFilter struct {
+	status   string
+	model    string
+	from     time.Time
+	to       time.Time
+	minScore float64
+}
+
+type detection struct {
+	Class string    `json:"class"`
+	Score float64   `json:"score"`
+	BBox  []float64 `json:"bbox"`
+}
+
+type exportJob struct {
+	ID         string      `json:"id"`
+	Algorithm  string      `json:"algorithm"`
+	Model      string      `json:"model"`
+	TimeStamp  string      `json:"timeStamp"`
+	Status     string      `json:"status"`
+	Detections []detection `json:"detections"`
+	image      string
+}
+
+type exporter interface {
+	contentType() string
+	extension() string
+	begin(w io.Writer) error
+	write(w io.Writer, job exportJob) error
+	end(w io.Writer) error
+}
+
+var exporters = map[string]func() exporter{
+	"csv":   func() exporter { return &csvExporter{} },
+	"jsonl": func() exporter { return &jsonlExporter{} },
+	"coco":  func() exporter { return &cocoExporter{images: map[string]int{}} },
+}
+
+//GET /v1/simulation-results/{type}/{alg}/export
+func (h Handler) ExportResults(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	opType := params["type"]
+	id := params["alg"]
+	url := strings.Replace(strings.Replace(h.exportSimulationResultsEndpoint, "{type}", opType, 1), "{alg}", id, 1)
+	if r.URL.Path != url {
+		notFound(w, r)
+		return
+	}
+	logging.AddFields(r.Context(), zap.String("algorithm", id))
+
+	query := r.URL.Query()
+	format := query.Get("format")
+	if format == "" {
+		format = "csv"
+	}
+	newExporter, ok := exporters[format]
+	if !ok {
+		writeError(w, r, http.StatusBadRequest, "unsupported export format "+format, nil)
+		return
+	}
+	filter, err := newExportFilter(query)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	keys, err := h.resultKeys(r.Context(), id, opType)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "failed to read results", err)
+		return
+	}
+	sort.Strings(keys)
+
+	exp := newExporter()
+	w.Header().Set("Content-Type", exp.contentType())
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s-%s.%s", id, opType, exp.extension()))
+	if err = exp.begin(w); err != nil {
+		log.Print("export: " + err.Error())
+		return
+	}
+	for _, key := range keys {
+		fromDB, err := h.iDatabase.Get(r.Context(), key)
+		if err != nil {
+			log.Print("export: " + err.Error())
+			return
+		}
+		var results structure.Results
+		if err = json.Unmarshal([]byte(fromDB.(string)), &results); err != nil {
+			log.Print("export: failed to unmarshal " + key)
+			return
+		}
+		job, ok := filter.apply(key, results)
+		if !ok {
+			continue
+		}
+		if err = exp.write(w, job); err != nil {
+			log.Print("export: " + err.Error())
+			return
+		}
+		if flusher, ok := w.(http.Flusher); ok {
+			flusher.Flush()
+		}
+	}
+	if err = exp.end(w); err != nil {
+		log.Print("export: " + err.Error())
+	}
+}
+
+func newExportFilter(query url.Values) (exportFilter, error) {
+	filter := exportFilter{status: query.Get("status"), model: query.Get("model")}
+	var err error
+	if from := query.Get("from"); from != "" {
+		if filter.from, err = time.Parse(time.RFC3339, from); err != nil {
+			return exportFilter{}, errors.New("invalid from, expected RFC3339 time")
+		}
+	}
+	if to := query.Get("to"); to != "" {
+		if filter.to, err = time.Parse(time.RFC3339, to); err != nil {
+			return exportFilter{}, errors.New("invalid to, expected RFC3339 time")
+		}
+	}
+	if score := query.Get("score"); score != "" {
+		if filter.minScore, err = strconv.ParseFloat(score, 64); err != nil {
+			return exportFilter{}, errors.New("invalid score, expected number")
+		}
+	}
+	return filter, nil
+}
+
+func (f exportFilter) apply(id string, results structure.Results) (exportJob, bool) {
+	if f.status != "" && f.status != results.Status {
+		return exportJob{}, false
+	}
+	if f.model != "" && f.model != results.Model {
+		return exportJob{}, false
+	}
+	if !f.from.IsZero() || !f.to.IsZero() {
+		timeStamp, err := time.Parse(time.RFC3339, results.TimeStamp)
+		if err != nil || (!f.from.IsZero() && timeStamp.Before(f.from)) || (!f.to.IsZero() && timeStamp.After(f.to)) {
+			return exportJob{}, false
+		}
+	}
+
+	job := exportJob{
+		ID:         id,
+		Algorithm:  results.Algorithm,
+		Model:      results.Model,
+		TimeStamp:  results.TimeStamp,
+		Status:     results.Status,
+		Detections: []detection{},
+		image:      results.ImageID,
+	}
+	if job.image == "" {
+		job.image = results.Image
+	}
+	if results.Status != "finished" {
+		return job, true
+	}
+	var detections structure.Detections
+	if err := json.Unmarshal([]byte(results.Result), &detections); err != nil {
+		return job, true
+	}
+	for i, name := range detections.Names {
+		if i >= len(detections.Scores) || i >= len(detections.BBox) || len(detections.BBox[i]) != 4 {
+			break
+		}
+		if detections.Scores[i] < f.minScore {
+			continue
+		}
+		// detectors report boxes as [y1, x1, y2, x2], exports use [x1, y1, x2, y2]
+		bbox := detections.BBox[i]
+		job.Detections = append(job.Detections, detection{
+			Class: name,
+			Score: detections.Scores[i],
+			BBox:  []float64{bbox[1], bbox[0], bbox[3], bbox[2]},
+		})
+	}
+	return job, true
+}
+
+type csvExporter struct {
+	writer *csv.Writer
+}
+
+func (e *csvExporter) contentType() string { return "text/csv" }
+
+func (e *csvExporter) extension() string { return "csv" }
+
+func (e *csvExporter) begin(w io.Writer) error {
+	e.writer = csv.NewWriter(w)
+	return e.flush([]string{"id", "algorithm", "model", "timeStamp", "status", "class", "score", "x_min", "y_min", "x_max", "y_max"})
+}
+
+func (e *csvExporter) write(_ io.Writer, job exportJob) error {
+	row := []string{job.ID, job.Algorithm, job.Model, job.TimeStamp, job.Status}
+	if len(job.Detections) == 0 {
+		return e.flush(append(row, "", "", "", "", "", ""))
+	}
+	for _, d := range job.Detections {
+		record := append(append([]string{}, row...), d.Class, formatFloat(d.Score))
+		for _, coordinate := range d.BBox {
+			record = append(record, formatFloat(coordinate))
+		}
+		if err := e.writer.Write(record); err != nil {
+			return err
+		}
+	}
+	e.writer.Flush()
+	return e.writer.Error()
+}
+
+func (e *csvExporter) end(_ io.Writer) error { return nil }
+
+func (e *csvExporter) flush(record []string) error {
+	if err := e.writer.Write(record); err != nil {
+		return err
+	}
+	e.writer.Flush()
+	return e.writer.Error()
+}
+
+type jsonlExporter struct {
+	encoder *json.Encoder
+}
+
+func (e *jsonlExporter) contentType() string { return "application/x-ndjson" }
+
+func (e *jsonlExporter) extension() string { return "jsonl" }
+
+func (e *jsonlExporter) begin(w io.Writer) error {
+	e.encoder = json.NewEncoder(w)
+	return nil
+}
+
+func (e *jsonlExporter) write(_ io.Writer, job exportJob) error {
+	return e.encoder.Encode(job)
+}
+
+func (e *jsonlExporter) end(_ io.Writer) error { return nil }
+
+type cocoResult struct {
+	ImageID    int       `json:"image_id"`
+	CategoryID int       `json:"category_id"`
+	BBox       []float64 `json:"bbox"`
+	Score      float64   `json:"score"`
+}
+
+// cocoExporter writes the detection results format read by pycocotools'
+// COCO.loadRes. Image ids are assigned in export order, one per distinct
+// image, and classes outside of the COCO category list are skipped.
+type cocoExporter struct {
+	images  map[string]int
+	written bool
+}
+
+func (e *cocoExporter) contentType() string { return "application/json" }
+
+func (e *cocoExporter) extension() string { return "json" }
+
+func (e *cocoExporter) begin(w io.Writer) error {
+	_, err := io.WriteString(w, "[")
+	return err
+}
+
+func (e *cocoExporter) write(w io.Writer, job exportJob) error {
+	imageID, ok := e.images[job.image]
+	if !ok {
+		imageID = len(e.images) + 1
+		e.images[job.image] = imageID
+	}
+	for _, d := range job.Detections {
+		categoryID, ok := cocoCategories[d.Class]
+		if !ok {
+			continue
+		}
+		jsonResult, err := json.Marshal(cocoResult{
+			ImageID:    imageID,
+			CategoryID: categoryID,
+			BBox:       []float64{d.BBox[0], d.BBox[1], d.BBox[2] - d.BBox[0], d.BBox[3] - d.BBox[1]},
+			Score:      d.Score,
+		})
+		if err != nil {
+			return err
+		}
+		if e.written {
+			jsonResult = append([]byte(","), jsonResult...)
+		}
+		if _, err = w.Write(jsonResult); err != nil {
+			return err
+		}
+		e.written = true
+	}
+	return nil
+}
+
+func (e *cocoExporter) end(w io.Writer) error {
+	_, err := io.WriteString(w, "]")
+	return err
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
@@ -0,0 +1,135 @@
+package api
+
+import (
+	"backend/internal/api/mocks"
+	"backend/internal/structure"
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHandler_ExportResults(t *testing.T) {
+	alg := "algID"
+	opType := "demo"
+	pattern := "20[0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9]Z" + alg + opType + "*"
+	finishedKey := "2009-11-10T20:34:58Z" + alg + opType
+	errorKey := "2009-11-10T20:35:58Z" + alg + opType
+	detections := structure.Detections{
+		Names:  []string{"person", "dog"},
+		Scores: []float64{0.9, 0.4},
+		BBox:   [][]float64{{10, 20, 110, 220}, {5, 5, 15, 25}},
+	}
+	jsonDetections, err := json.Marshal(detections)
+	assert.NoError(t, err)
+	finished := structure.Results{Algorithm: alg, Model: "default", Image: "image", TimeStamp: "2009-11-10T20:34:58Z", Status: "finished", Result: string(jsonDetections)}
+	jsonFinished, err := json.Marshal(finished)
+	assert.NoError(t, err)
+	failed := structure.Results{Algorithm: alg, Model: "default", Image: "image", TimeStamp: "2009-11-10T20:35:58Z", Status: "error"}
+	jsonFailed, err := json.Marshal(failed)
+	assert.NoError(t, err)
+	tests := []struct {
+		testName       string
+		requestURL     string
+		keysReturned   []string
+		keysError      error
+		assertNoOfKeys int
+		body           string
+		contentType    string
+		statusCode     int
+	}{
+		{
+			testName:   "should return 404 when url is wrong",
+			requestURL: "/v1/simulation-results/wrong",
+			body:       `{"code":"not_found","message":"not found"}` + "\n",
+			statusCode: http.StatusNotFound,
+		},
+		{
+			testName:    "should return 400 when format is not supported",
+			requestURL:  "/v1/simulation-results/" + opType + "/" + alg + "/export?format=xml",
+			body:        `{"code":"bad_request","message":"unsupported export format xml"}` + "\n",
+			contentType: "application/json",
+			statusCode:  http.StatusBadRequest,
+		},
+		{
+			testName:   "should return 400 when time filter is invalid",
+			requestURL: "/v1/simulation-results/" + opType + "/" + alg + "/export?from=yesterday",
+			body:       `{"code":"bad_request","message":"invalid from, expected RFC3339 time"}` + "\n",
+			statusCode: http.StatusBadRequest,
+		},
+		{
+			testName:       "should return 500 when failed to get keys from database",
+			requestURL:     "/v1/simulation-results/" + opType + "/" + alg + "/export",
+			keysError:      errors.New("failed to get keys from database"),
+			assertNoOfKeys: 1,
+			body:           `{"code":"internal_error","message":"failed to read results","details":"failed to get keys from database"}` + "\n",
+			statusCode:     http.StatusInternalServerError,
+		},
+		{
+			testName:       "should export one csv row per detection",
+			requestURL:     "/v1/simulation-results/" + opType + "/" + alg + "/export",
+			keysReturned:   []string{errorKey, finishedKey},
+			assertNoOfKeys: 1,
+			body: "id,algorithm,model,timeStamp,status,class,score,x_min,y_min,x_max,y_max\n" +
+				finishedKey + ",algID,default,2009-11-10T20:34:58Z,finished,person,0.9,20,10,220,110\n" +
+				finishedKey + ",algID,default,2009-11-10T20:34:58Z,finished,dog,0.4,5,5,25,15\n" +
+				errorKey + ",algID,default,2009-11-10T20:35:58Z,error,,,,,,\n",
+			contentType: "text/csv",
+			statusCode:  http.StatusOK,
+		},
+		{
+			testName:       "should export filtered json lines",
+			requestURL:     "/v1/simulation-results/" + opType + "/" + alg + "/export?format=jsonl&status=finished&score=0.5",
+			keysReturned:   []string{finishedKey, errorKey},
+			assertNoOfKeys: 1,
+			body: `{"id":"` + finishedKey + `","algorithm":"algID","model":"default","timeStamp":"2009-11-10T20:34:58Z","status":"finished",` +
+				`"detections":[{"class":"person","score":0.9,"bbox":[20,10,220,110]}]}` + "\n",
+			contentType: "application/x-ndjson",
+			statusCode:  http.StatusOK,
+		},
+		{
+			testName:       "should export coco detection results",
+			requestURL:     "/v1/simulation-results/" + opType + "/" + alg + "/export?format=coco",
+			keysReturned:   []string{finishedKey, errorKey},
+			assertNoOfKeys: 1,
+			body: `[{"image_id":1,"category_id":1,"bbox":[20,10,200,100],"score":0.9},` +
+				`{"image_id":1,"category_id":18,"bbox":[5,5,20,10],"score":0.4}]`,
+			contentType: "application/json",
+			statusCode:  http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			//given
+			r, err := http.NewRequest("GET", tt.requestURL, nil)
+			assert.NoError(t, err)
+			vars := map[string]string{
+				"type": opType,
+				"alg":  alg,
+			}
+			r = mux.SetURLVars(r, vars)
+			w := httptest.NewRecorder()
+			iDatabaseMock := mocks.IDatabase{}
+			iAlgorithmMock := mocks.IAlgorithm{}
+			testSubject := NewHandler(&iDatabaseMock, []IAlgorithm{&iAlgorithmMock})
+			iDatabaseMock.On("Keys", mock.Anything, pattern).Return(tt.keysReturned, tt.keysError)
+			iDatabaseMock.On("Get", mock.Anything, finishedKey).Return(string(jsonFinished), nil)
+			iDatabaseMock.On("Get", mock.Anything, errorKey).Return(string(jsonFailed), nil)
+
+			//when
+			testSubject.ExportResults(w, r)
+
+			//then
+			iDatabaseMock.AssertNumberOfCalls(t, "Keys", tt.assertNoOfKeys)
+			assert.Equal(t, tt.body, w.Body.String())
+			assert.Equal(t, tt.statusCode, w.Code)
+			if tt.contentType != "" {
+				assert.Equal(t, tt.contentType, w.Header().Get("Content-Type"))
+			}
+		})
+	}
+}
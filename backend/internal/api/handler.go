@@ -1,176 +1,313 @@
 package api
 
 import (
+	"backend/internal/audit"
+	"backend/internal/logging"
+	"backend/internal/metrics"
+	"backend/internal/queue"
+	"backend/internal/retention"
 	"backend/internal/structure"
+	"backend/internal/worker"
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"io"
 	"io/ioutil"
+	"log"
 	"mime/multipart"
 	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 )
 
 //go:generate mockery --name=IDatabase
 type IDatabase interface {
-	Set(key string, value string) error
-	Get(key string) (interface{}, error)
-	Keys(pattern string) ([]string, error)
+	Set(ctx context.Context, key string, value string) error
+	Create(ctx context.Context, key string, value string) error
+	Get(ctx context.Context, key string) (interface{}, error)
+	Keys(ctx context.Context, pattern string) ([]string, error)
+	Update(ctx context.Context, key string, update func(value string) (string, error)) error
+	Delete(ctx context.Context, key string) error
+}
+
+// IDatabaseSizer is a database which reports the size of stored values
+// without returning them, so that storage is measured without downloading
+// every image and result.
+//
+//go:generate mockery --name=IDatabaseSizer
+type IDatabaseSizer interface {
+	IDatabase
+	Sizes(ctx context.Context, pattern string) (map[string]int64, error)
 }
 
 //go:generate mockery --name=IAlgorithm
 type IAlgorithm interface {
 	GetID() string
-	UploadModel(modelFile multipart.File, modelHeader *multipart.FileHeader) error
-	RunSimulation(opType string, data []byte) (int, error)
+	UploadModel(ctx context.Context, name string, model io.Reader) error
+	RunSimulation(ctx context.Context, opType string, data []byte) (int, error)
+}
+
+// IWorkerPool is an algorithm whose simulations are leased by workers, which
+// poll the backend for them, instead of being sent to the algorithm.
+//
+//go:generate mockery --name=IWorkerPool
+type IWorkerPool interface {
+	IAlgorithm
+	Lease(ctx context.Context) (worker.Lease, bool)
+	Heartbeat(id string) (worker.Lease, error)
+	Complete(id string) (worker.Lease, error)
+}
+
+// IResultStream is an algorithm which streams results of simulations to the
+// backend, instead of sending them to PUT /v1/simulation-results.
+//
+//go:generate mockery --name=IResultStream
+type IResultStream interface {
+	IAlgorithm
+	WatchResults(ctx context.Context, report func(id, content string)) error
+}
+
+// IStatusReporter is an algorithm which reports whether it received a
+// simulation, so that jobs whose dispatch outcome is unknown are not sent
+// twice.
+//
+//go:generate mockery --name=IStatusReporter
+type IStatusReporter interface {
+	IAlgorithm
+	GetStatus(ctx context.Context, id string) (bool, error)
 }
 
+// IAuditLog keeps the trail of mutating operations.
+//
+//go:generate mockery --name=IAuditLog
+type IAuditLog interface {
+	Record(ctx context.Context, entry structure.AuditEntry) error
+	Query(ctx context.Context, filter audit.Filter) ([]structure.AuditEntry, error)
+}
+
+//go:generate mockery --name=IQueue
+type IQueue interface {
+	Push(job queue.Job) (int, error)
+	Done(id string)
+	Position(id string) int
+	Resume(job queue.Job, startedAt time.Time)
+}
+
+// defaultJobTimeout is how long a started job keeps the slot of its
+// algorithm, when the algorithm does not report the results.
+const defaultJobTimeout = 30 * time.Minute
+
 type Handler struct {
 	iDatabase         IDatabase
 	iAlgorithm        []IAlgorithm
+	iQueue            IQueue
+	iAuditLog         IAuditLog
 	getImagesEndpoint string
 	putImageEndpoint  string
+	getImageEndpoint  string
 
-	getModelsEndpoint string
-	postModelEndpoint string
+	getAlgorithmsEndpoint   string
+	getModelsEndpoint       string
+	postModelEndpoint       string
+	getModelEndpoint        string
+	putDefaultModelEndpoint string
 
 	postSimulationResultsEndpoint string
 	putSimulationResultsEndpoint  string
 	getSimulationResultsEndpoint  string
+
+	exportSimulationResultsEndpoint string
+
+	getJobEndpoint    string
+	renderJobEndpoint string
+
+	leaseEndpoint        string
+	heartbeatEndpoint    string
+	leaseResultsEndpoint string
+
+	postUploadEndpoint     string
+	uploadEndpoint         string
+	completeUploadEndpoint string
+	uploadDir              string
+	uploadTTL              time.Duration
+
+	purgeEndpoint   string
+	backupEndpoint  string
+	restoreEndpoint string
+	maxRestoreSize  int64
+	auditEndpoint   string
+	statsEndpoint   string
+	retention       retention.Policy
+	adminToken      string
+	workerToken     string
+
+	metricsEndpoint string
 }
 
 func (h Handler) InitializeEndpoints(mux *mux.Router) {
 	mux.HandleFunc(h.getImagesEndpoint, h.GetImages).Methods("GET")
 	mux.HandleFunc(h.putImageEndpoint, h.AddImage).Methods("PUT")
+	mux.HandleFunc(h.getImageEndpoint, h.GetImage).Methods("GET")
+	mux.HandleFunc(h.getAlgorithmsEndpoint, h.GetAlgorithms).Methods("GET")
 	mux.HandleFunc(h.getModelsEndpoint, h.GetModels).Methods("GET")
 	mux.HandleFunc(h.postModelEndpoint, h.UploadModel).Methods("PUT")
+	mux.HandleFunc(h.getModelEndpoint, h.GetModel).Methods("GET")
+	mux.HandleFunc(h.putDefaultModelEndpoint, h.SetDefaultModel).Methods("PUT")
 	mux.HandleFunc(h.postSimulationResultsEndpoint, h.RunSimulation).Methods("POST")
 	mux.HandleFunc(h.putSimulationResultsEndpoint, h.UpdateResults).Methods("PUT")
 	mux.HandleFunc(h.getSimulationResultsEndpoint, h.GetResults).Methods("GET")
+	mux.HandleFunc(h.exportSimulationResultsEndpoint, h.ExportResults).Methods("GET")
+	mux.HandleFunc(h.getJobEndpoint, h.GetJob).Methods("GET")
+	mux.HandleFunc(h.renderJobEndpoint, h.RenderJob).Methods("GET")
+	mux.HandleFunc(h.leaseEndpoint, h.LeaseJob).Methods("POST")
+	mux.HandleFunc(h.heartbeatEndpoint, h.Heartbeat).Methods("POST")
+	mux.HandleFunc(h.leaseResultsEndpoint, h.SubmitLeaseResults).Methods("PUT")
+	mux.HandleFunc(h.postUploadEndpoint, h.CreateUpload).Methods("POST")
+	mux.HandleFunc(h.uploadEndpoint, h.GetUpload).Methods("GET")
+	mux.HandleFunc(h.uploadEndpoint, h.UploadChunk).Methods("PUT")
+	mux.HandleFunc(h.completeUploadEndpoint, h.CompleteUpload).Methods("POST")
+	mux.HandleFunc(h.purgeEndpoint, h.PurgeResults).Methods("POST")
+	mux.HandleFunc(h.backupEndpoint, h.Backup).Methods("GET")
+	mux.HandleFunc(h.restoreEndpoint, h.Restore).Methods("POST")
+	mux.HandleFunc(h.auditEndpoint, h.GetAuditLog).Methods("GET")
+	mux.HandleFunc(h.statsEndpoint, h.GetStats).Methods("GET")
+	mux.Handle(h.metricsEndpoint, metrics.Handler()).Methods("GET")
+	mux.NotFoundHandler = http.HandlerFunc(notFound)
+	mux.MethodNotAllowedHandler = http.HandlerFunc(methodNotAllowed)
 }
 
 func NewHandler(iDatabase IDatabase, iAlgorithm []IAlgorithm) Handler {
 	return Handler{
 		iDatabase:                     iDatabase,
 		iAlgorithm:                    iAlgorithm,
+		iQueue:                        queue.New(1, nil, defaultJobTimeout),
 		getImagesEndpoint:             "/v1/images",
 		putImageEndpoint:              "/v1/images",
+		getImageEndpoint:              "/v1/images/{id}",
+		getAlgorithmsEndpoint:         "/v1/algorithms",
 		getModelsEndpoint:             "/v1/models/{alg}",
 		postSimulationResultsEndpoint: "/v1/simulation-results/{type}",
 		putSimulationResultsEndpoint:  "/v1/simulation-results",
 		getSimulationResultsEndpoint:  "/v1/simulation-results/{type}/{alg}",
 		postModelEndpoint:             "/v1/models",
-	}
-}
+		getModelEndpoint:              "/v1/models/{alg}/{name}",
+		putDefaultModelEndpoint:       "/v1/models/{alg}/{name}/default",
 
-func (h Handler) Config() error {
-	model := map[string][]string{}
-	for _, item := range h.iAlgorithm {
-		model[item.GetID()] = []string{"default"}
-	}
+		exportSimulationResultsEndpoint: "/v1/simulation-results/{type}/{alg}/export",
 
-	if _, err := h.iDatabase.Get("models"); err != nil {
-		if err.Error() != "key does not exist" {
-			return err
-		}
-	}
-	models := structure.Algorithm{Models: model}
-	jsonModels, err := json.Marshal(models)
-	if err != nil {
-		return err
-	}
-	if err = h.iDatabase.Set("models", string(jsonModels)); err != nil {
-		return err
-	}
-	if _, err = h.iDatabase.Get("images"); err != nil {
-		if err.Error() != "key does not exist" {
-			return err
-		}
-	}
-	images := structure.Images{}
-	jsonImages, err := json.Marshal(images)
-	if err = h.iDatabase.Set("images", string(jsonImages)); err != nil {
-		return err
+		getJobEndpoint:    "/v1/jobs/{id}",
+		renderJobEndpoint: "/v1/jobs/{id}/render",
+
+		leaseEndpoint:        "/v1/workers/{alg}/lease",
+		heartbeatEndpoint:    "/v1/workers/{alg}/leases/{id}/heartbeat",
+		leaseResultsEndpoint: "/v1/workers/{alg}/leases/{id}/results",
+
+		postUploadEndpoint:     "/v1/uploads",
+		uploadEndpoint:         "/v1/uploads/{id}",
+		completeUploadEndpoint: "/v1/uploads/{id}/complete",
+		uploadDir:              filepath.Join(os.TempDir(), "model-uploads"),
+		uploadTTL:              defaultUploadTTL,
+
+		purgeEndpoint:   "/v1/admin/purge",
+		backupEndpoint:  "/v1/admin/backup",
+		restoreEndpoint: "/v1/admin/restore",
+		maxRestoreSize:  defaultMaxRestoreSize,
+		auditEndpoint:   "/v1/admin/audit",
+		statsEndpoint:   "/v1/stats",
+
+		metricsEndpoint: "/metrics",
 	}
+}
 
-	return nil
+// WithUploadDir sets the directory where chunks of resumable uploads are kept.
+func (h Handler) WithUploadDir(dir string) Handler {
+	h.uploadDir = dir
+	return h
+}
+
+// WithQueue sets the queue which limits the number of simulations running in
+// each algorithm. By default every algorithm runs one simulation at a time.
+func (h Handler) WithQueue(q IQueue) Handler {
+	h.iQueue = q
+	return h
 }
 
 //PUT /v1/images/
 func (h Handler) AddImage(w http.ResponseWriter, r *http.Request) {
 	if r.URL.Path != h.putImageEndpoint {
-		http.Error(w, "404 not found", http.StatusNotFound)
+		notFound(w, r)
 		return
 	}
 	if r.Header.Get("Content-Type") != "application/json" {
-		http.Error(w, "invalid content type", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, "invalid content type", nil)
 		return
 	}
 
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, http.StatusBadRequest, "failed to read body", err)
 		return
 	}
 
 	var data structure.Data
 	if err = json.Unmarshal(body, &data); err != nil {
-		http.Error(w, "failed to unmarshal body", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, "failed to unmarshal body", nil)
 		return
 	}
 
-	fromDB, err := h.iDatabase.Get("images")
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err = validateImage(data.Content); err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error(), nil)
 		return
 	}
 
-	var allImages structure.Images
-	if err = json.Unmarshal([]byte(fromDB.(string)), &allImages); err != nil {
-		http.Error(w, "failed to unmarshal images", http.StatusInternalServerError)
+	id, err := h.addImage(r, data.Content)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "failed to store image", err)
 		return
 	}
-	allImages.Images = append(allImages.Images, data.Content)
 
-	jsonAllImages, err := json.Marshal(allImages)
+	jsonImage, err := json.Marshal(structure.Image{ID: id})
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, "failed to marshal image", err)
 		return
 	}
-
-	if err = h.iDatabase.Set("images", string(jsonAllImages)); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+	w.Header().Set("Content-Type", "application/json")
+	if _, err = fmt.Fprint(w, string(jsonImage)); err != nil {
+		log.Print("failed to write response: " + err.Error())
 	}
 }
 
 //GET /v1/images
 func (h Handler) GetImages(w http.ResponseWriter, r *http.Request) {
 	if r.URL.Path != h.getImagesEndpoint {
-		http.Error(w, "404 not found", http.StatusNotFound)
+		notFound(w, r)
 		return
 	}
 
-	fromDB, err := h.iDatabase.Get("images")
+	index, err := h.getImageIndex(r.Context())
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, "failed to read images", err)
 		return
 	}
-
-	var images structure.Images
-	if err = json.Unmarshal([]byte(fromDB.(string)), &images); err != nil {
-		http.Error(w, "failed to unmarshal", http.StatusInternalServerError)
-		return
+	// contents are served one by one by GetImage
+	images := structure.ImageList{Images: []structure.Image{}}
+	for _, id := range index.IDs {
+		images.Images = append(images.Images, structure.Image{ID: id, URL: strings.Replace(h.getImageEndpoint, "{id}", id, 1)})
 	}
 	jsonImages, err := json.Marshal(images)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, "failed to marshal images", err)
 		return
 	}
 
 	if _, err = fmt.Fprint(w, string(jsonImages)); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		log.Print("failed to write response: " + err.Error())
 		return
 	}
 }
@@ -178,65 +315,97 @@ func (h Handler) GetImages(w http.ResponseWriter, r *http.Request) {
 //PUT /v1/models
 func (h Handler) UploadModel(w http.ResponseWriter, r *http.Request) {
 	if r.URL.Path != h.postModelEndpoint {
-		http.Error(w, "404 not found", http.StatusNotFound)
-		return
-	}
-	if err := r.ParseMultipartForm(32 << 20); err != nil {
-		http.Error(w, "ParseMultipartForm"+err.Error(), http.StatusBadRequest)
+		notFound(w, r)
 		return
 	}
-
-	modelFile, modelHeader, err := r.FormFile("model")
+	reader, err := r.MultipartReader()
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, "invalid multipart body", err)
 		return
 	}
-	defer modelFile.Close()
 
-	name := r.PostFormValue("name")
-	modelHeader.Filename = name
-
-	id := r.PostFormValue("id")
-	index := -1
-	for i, item := range h.iAlgorithm {
-		if algID := item.GetID(); algID == id {
-			index = i
+	// form values have to be sent before the `model` file, which is streamed
+	// to the algorithm as it arrives
+	var upload structure.ModelUpload
+	var modelPart *multipart.Part
+	for modelPart == nil {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			writeError(w, r, http.StatusBadRequest, http.ErrMissingFile.Error(), nil)
+			return
+		}
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, err.Error(), nil)
+			return
+		}
+		if part.FormName() == "model" {
+			modelPart = part
 			break
 		}
+		value, err := ioutil.ReadAll(io.LimitReader(part, 1<<20))
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, err.Error(), nil)
+			return
+		}
+		if err = setUploadField(&upload, part.FormName(), string(value)); err != nil {
+			writeError(w, r, http.StatusBadRequest, err.Error(), nil)
+			return
+		}
 	}
-	if index < 0 {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	defer modelPart.Close()
+	if err = checkModelName(upload.Name); err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error(), nil)
 		return
 	}
 
-	if err = h.iAlgorithm[index].UploadModel(modelFile, modelHeader); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	logging.AddFields(r.Context(), zap.String("algorithm", upload.Algorithm), zap.String("model", upload.Name))
+	alg, ok := h.findAlgorithm(upload.Algorithm)
+	if !ok {
+		writeError(w, r, http.StatusNotFound, "algorithm "+upload.Algorithm+" does not exist", nil)
+		return
 	}
 
-	fromDB, err := h.iDatabase.Get("models")
+	model, err := h.storeModel(r.Context(), alg, upload, modelPart)
+	h.auditUpload(r, upload, model)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	var allModels structure.Algorithm
-	if err = json.Unmarshal([]byte(fromDB.(string)), &allModels); err != nil {
-		http.Error(w, "failed to unmarshal "+err.Error(), http.StatusInternalServerError)
+		writeStatusError(w, r, err)
 		return
 	}
-	models := allModels.Models[id]
-	models = append(models, name)
-	allModels.Models[id] = models
 
-	jsonAllModels, err := json.Marshal(allModels)
+	jsonModel, err := json.Marshal(model)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, "failed to marshal model", err)
 		return
 	}
-	if err = h.iDatabase.Set("models", string(jsonAllModels)); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if _, err = fmt.Fprint(w, string(jsonModel)); err != nil {
+		log.Print("failed to write response: " + err.Error())
 		return
 	}
+}
 
+func setUploadField(upload *structure.ModelUpload, field, value string) error {
+	var err error
+	switch field {
+	case "id":
+		upload.Algorithm = value
+	case "name":
+		upload.Name = value
+	case "sha256":
+		upload.SHA256 = value
+	case "size":
+		if upload.Size, err = strconv.ParseInt(value, 10, 64); err != nil {
+			return errors.New("invalid size, expected number")
+		}
+	case "uploader":
+		upload.Uploader = value
+	case "description":
+		upload.Description = value
+	case "classes":
+		upload.Classes = splitList(value)
+	case "default":
+		upload.Default = value == "true"
+	}
+	return nil
 }
 
 //GET /v1/models/{alg}
@@ -245,32 +414,24 @@ func (h Handler) GetModels(w http.ResponseWriter, r *http.Request) {
 	id := params["alg"]
 	url := strings.Replace(h.getModelsEndpoint, "{alg}", id, 1)
 	if r.URL.Path != url {
-		http.Error(w, "404 not found", http.StatusNotFound)
+		notFound(w, r)
 		return
 	}
 
-	fromDB, err := h.iDatabase.Get("models")
+	registry, err := h.getRegistry(r.Context())
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, "failed to read models", err)
 		return
 	}
 
-	var allModels structure.Algorithm
-	err = json.Unmarshal([]byte(fromDB.(string)), &allModels)
+	jsonModels, err := json.Marshal(modelSummaries(registry.Algorithms[id]))
 	if err != nil {
-		http.Error(w, "failed to unmarshal", http.StatusInternalServerError)
-		return
-	}
-
-	models := allModels.Models[id]
-	jsonModels, err := json.Marshal(models)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, "failed to marshal models", err)
 		return
 	}
 
 	if _, err = fmt.Fprint(w, string(jsonModels)); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		log.Print("failed to write response: " + err.Error())
 		return
 	}
 }
@@ -281,24 +442,37 @@ func (h Handler) RunSimulation(w http.ResponseWriter, r *http.Request) {
 	opType := params["type"]
 	url := strings.Replace(h.postSimulationResultsEndpoint, "{type}", opType, 1)
 	if r.URL.Path != url {
-		http.Error(w, "404 not found", http.StatusNotFound)
+		notFound(w, r)
 		return
 	}
 
-	bd, err := ioutil.ReadAll(r.Body)
+	bd, err := ioutil.ReadAll(io.LimitReader(r.Body, maxSimulationBody+1))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, http.StatusBadRequest, "failed to read body", err)
+		return
+	}
+	if len(bd) > maxSimulationBody {
+		writeError(w, r, http.StatusBadRequest, fmt.Sprintf("body is larger than %d MB", maxSimulationBody>>20), nil)
 		return
 	}
 
 	var body structure.Body
 	if err = json.Unmarshal(bd, &body); err != nil {
-		http.Error(w, "failed to unmarshal body", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, "failed to unmarshal body", nil)
+		return
+	}
+	if err = validateSimulation(body); err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error(), nil)
 		return
 	}
 
 	timeStamp := time.Now()
-	dbID := timeStamp.Format(time.RFC3339) + body.ID + opType
+	dbID, err := newJobID(timeStamp, body.ID, opType)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "failed to create job id", err)
+		return
+	}
+	logging.AddFields(r.Context(), zap.String("algorithm", body.ID), zap.String("job", dbID))
 
 	index := -1
 	for i, item := range h.iAlgorithm {
@@ -308,95 +482,183 @@ func (h Handler) RunSimulation(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 	if index < 0 {
-		http.Error(w, "algorithm with this id does not exists", http.StatusInternalServerError)
+		writeError(w, r, http.StatusNotFound, "algorithm "+body.ID+" does not exist", nil)
 		return
 	}
 
-	sendData := structure.Body{ID: dbID, Model: body.Model, Image: body.Image}
-	jsonSendData, err := json.Marshal(sendData)
+	registry, err := h.getRegistry(r.Context())
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, "failed to read models", err)
+		return
+	}
+	algModels := registry.Algorithms[body.ID]
+	model := body.Model
+	if model == "" && algModels != nil {
+		model = algModels.Default
+	}
+	var resolved structure.Model
+	ok := false
+	if algModels != nil && model != "" {
+		resolved, ok = resolveModel(algModels, model, body.Version)
+	}
+	if !ok {
+		writeError(w, r, http.StatusBadRequest, modelNotFound(algModels, body.ID, model, body.Version), nil)
 		return
 	}
 
-	respCode, err := h.iAlgorithm[index].RunSimulation(opType, jsonSendData)
-	if err != nil || respCode != 200 {
-		http.Error(w, "failed to run simulation", http.StatusInternalServerError)
+	imageID, _, err := h.simulationImage(r.Context(), body)
+	if err != nil {
+		writeStatusError(w, r, err)
 		return
 	}
 
 	results := structure.Results{
-		Algorithm: body.ID,
-		Model:     body.Model,
-		Image:     body.Image,
-		TimeStamp: timeStamp.Format(time.RFC3339),
-		Status:    "in-progress",
+		Algorithm:    body.ID,
+		Operation:    opType,
+		Model:        model,
+		ModelVersion: resolved.Version,
+		ImageID:      imageID,
+		Priority:     body.Priority,
+		TimeStamp:    timeStamp.Format(time.RFC3339),
+		Status:       "queued",
+		RequestID:    logging.RequestID(r.Context()),
 	}
 
 	jsonResults, err := json.Marshal(results)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, "failed to marshal results", err)
 		return
 	}
-
-	if err = h.iDatabase.Set(dbID, string(jsonResults)); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err = h.iDatabase.Create(r.Context(), dbID, string(jsonResults)); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "failed to store job", err)
+		return
+	}
+	// the job outlives the request, but keeps its request id and trace
+	ctx := detach(r.Context())
+	// images sent inline join the gallery only once a job uses them
+	if body.ImageID == "" {
+		if _, err = h.addImage(r, body.Image); err != nil {
+			h.failJob(ctx, dbID, "failed to store image: "+err.Error())
+			writeError(w, r, http.StatusInternalServerError, "failed to store image", err)
+			return
+		}
+	}
+	if err = h.addPendingJob(r.Context(), dbID); err != nil {
+		h.failJob(ctx, dbID, "failed to queue simulation: "+err.Error())
+		writeError(w, r, http.StatusInternalServerError, "failed to queue simulation", err)
 		return
 	}
 
+	job := structure.JobStatus{ID: dbID, Status: "queued"}
+	position, err := h.iQueue.Push(h.queueJob(ctx, dbID, results))
+	if err != nil {
+		// the server is stopping, the job is queued again after restart
+		logging.AddFields(r.Context(), zap.Error(err))
+	} else if position == 0 {
+		job.Status = "in-progress"
+	} else {
+		job.Position = position
+		logging.AddFields(r.Context(), zap.Int("position", position))
+	}
+	entry := h.auditEntry(r, actionRunJob, dbID)
+	entry.After = map[string]string{
+		"status":       job.Status,
+		"algorithm":    results.Algorithm,
+		"model":        results.Model,
+		"modelVersion": strconv.Itoa(results.ModelVersion),
+		"image":        results.ImageID,
+	}
+	h.audit(r.Context(), entry)
+	jsonJob, err := json.Marshal(job)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "failed to marshal job", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusAccepted)
+	if _, err = fmt.Fprint(w, string(jsonJob)); err != nil {
+		log.Print("failed to write response: " + err.Error())
+	}
 }
 
 //PUT /v1/simulation-results
 func (h Handler) UpdateResults(w http.ResponseWriter, r *http.Request) {
 	if r.URL.Path != h.putSimulationResultsEndpoint {
-		http.Error(w, "404 not found", http.StatusNotFound)
+		notFound(w, r)
 		return
 	}
 	if r.Header.Get("Content-Type") != "application/json" {
-		http.Error(w, "invalid content type", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, "invalid content type", nil)
 		return
 	}
 
 	value, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, http.StatusBadRequest, "failed to read body", err)
 		return
 	}
 	var data structure.Data
 	if err = json.Unmarshal(value, &data); err != nil {
-		http.Error(w, "failed to unmarshal "+err.Error(), http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, "failed to unmarshal", err)
 		return
 	}
+	logging.AddFields(r.Context(), zap.String("job", data.ID))
 
-	fromDB, err := h.iDatabase.Get(data.ID)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	var results structure.Results
-	if err = json.Unmarshal([]byte(fromDB.(string)), &results); err != nil {
-		http.Error(w, "failed to unmarshal "+err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	if data.Content == "\"error\"" {
-		results.Status = "error"
-	} else {
-		results.Result = data.Content
-		results.Status = "finished"
+	if err = h.finishJob(r.Context(), data.ID, "", data.Content, h.auditEntry(r, actionJobResults, data.ID)); err != nil {
+		writeStatusError(w, r, err)
 	}
+}
 
-	jsonResults, err := json.Marshal(results)
+// finishJob stores the results reported for a job. Content "error" marks the
+// job as failed. Results are only accepted for jobs which were sent to their
+// algorithm and did not finish yet and, when they come from a worker lease,
+// which are still held by that lease. The change of the status is recorded
+// with the actor and request of entry, results sent without an actor are
+// attributed to the algorithm.
+func (h Handler) finishJob(ctx context.Context, id, lease, content string, entry structure.AuditEntry) error {
+	var previousStatus string
+	leased := true
+	results, finished, err := h.updateJob(ctx, id, func(results *structure.Results) bool {
+		previousStatus = results.Status
+		if results.Status != "dispatching" && results.Status != "in-progress" {
+			return false
+		}
+		if lease != "" && results.Lease != lease {
+			leased = false
+			return false
+		}
+		if content == "\"error\"" {
+			results.Status = "error"
+		} else {
+			results.Result = content
+			results.Status = "finished"
+		}
+		return true
+	})
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		if err.Error() == "key does not exist" {
+			return statusError{http.StatusNotFound, "job " + id + " does not exist"}
+		}
+		return errors.Wrap(err, "failed to store results")
 	}
-
-	if err = h.iDatabase.Set(data.ID, string(jsonResults)); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+	if !leased {
+		return statusError{http.StatusConflict, "lease " + lease + " does not hold job " + id}
 	}
+	if !finished {
+		return statusError{http.StatusConflict, "job " + id + " is " + previousStatus}
+	}
+	h.iQueue.Done(id)
+	h.removePendingJob(ctx, id)
+	metrics.JobFinished(results.Algorithm, results.Status)
+	entry.Action = actionJobResults
+	entry.Resource = id
+	if entry.Actor == "" {
+		entry.Actor = "algorithm:" + results.Algorithm
+	}
+	entry.Before = map[string]string{"status": previousStatus}
+	entry.After = map[string]string{"status": results.Status}
+	h.audit(ctx, entry)
+	return nil
 }
 
 //GET /v1/simulation-results/{type}/{alg}
@@ -406,37 +668,42 @@ func (h Handler) GetResults(w http.ResponseWriter, r *http.Request) {
 	id := params["alg"]
 	url := strings.Replace(strings.Replace(h.getSimulationResultsEndpoint, "{type}", opType, 1), "{alg}", id, 1)
 	if r.URL.Path != url {
-		http.Error(w, "404 not found", http.StatusNotFound)
+		notFound(w, r)
 		return
 	}
+	logging.AddFields(r.Context(), zap.String("algorithm", id))
 
-	pattern := fmt.Sprintf("20[0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9]Z%s%s", id, opType)
-
-	keys, err := h.iDatabase.Keys(pattern)
+	keys, err := h.resultKeys(r.Context(), id, opType)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, "failed to read results", err)
+		return
 	}
 
-	var result structure.Results
 	var results []structure.Results
 	for _, key := range keys {
-		fromDB, err := h.iDatabase.Get(key)
+		fromDB, err := h.iDatabase.Get(r.Context(), key)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			writeError(w, r, http.StatusInternalServerError, "failed to read results", err)
 			return
 		}
+		var result structure.Results
 		if err = json.Unmarshal([]byte(fromDB.(string)), &result); err != nil {
-			http.Error(w, "failed to unmarshal", http.StatusInternalServerError)
+			writeError(w, r, http.StatusInternalServerError, "failed to unmarshal", nil)
 			return
 		}
+		if result.Status == "queued" {
+			result.Position = h.iQueue.Position(key)
+		}
 
 		results = append(results, result)
 	}
 	jsonResults, err := json.Marshal(results)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, "failed to marshal results", err)
 		return
 	}
 
-	fmt.Fprintf(w, string(jsonResults))
+	if _, err = fmt.Fprint(w, string(jsonResults)); err != nil {
+		log.Print("failed to write response: " + err.Error())
+	}
 }
@@ -2,17 +2,29 @@ package api
 
 import (
 	"backend/internal/api/mocks"
+	"backend/internal/database"
+	"backend/internal/logging"
+	"backend/internal/queue"
 	"backend/internal/structure"
 	"bou.ke/monkey"
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
+	"github.com/alicebob/miniredis/v2"
 	"github.com/gorilla/mux"
 	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 	"io"
+	"io/ioutil"
 	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -25,13 +37,16 @@ func fixedTime() string {
 }
 
 func TestHandler_AddImage(t *testing.T) {
-	data := structure.Data{ID: "algID", Content: "image"}
+	image := testImage(t, "png", 4, 3)
+	data := structure.Data{ID: "algID", Content: image}
 	jsonData, err := json.Marshal(data)
 	assert.NoError(t, err)
-	img := structure.Images{Images: []string{"image"}}
-	jsonImg, err := json.Marshal(img)
-	images := structure.Images{Images: []string{"image", "image"}}
-	jsonImages, err := json.Marshal(images)
+	jsonInvalidData, err := json.Marshal(structure.Data{ID: "algID", Content: testImage(t, "gif", 4, 3)})
+	assert.NoError(t, err)
+	id := imageID(image)
+	jsonEmptyIndex, err := json.Marshal(structure.ImageIndex{IDs: []string{}})
+	assert.NoError(t, err)
+	jsonIndex, err := json.Marshal(structure.ImageIndex{IDs: []string{id}})
 	assert.NoError(t, err)
 	tests := []struct {
 		testName         string
@@ -42,6 +57,7 @@ func TestHandler_AddImage(t *testing.T) {
 		getError         error
 		insertData       string
 		insertError      error
+		storeError       error
 		assertNoOfInsert int
 		assertNoOfGet    int
 		bodyContains     string
@@ -50,7 +66,7 @@ func TestHandler_AddImage(t *testing.T) {
 		{
 			testName:     "should return 404 when url is wrong",
 			requestURL:   "/v1/images/wrong",
-			bodyContains: "404 not found",
+			bodyContains: `"code":"not_found"`,
 			statusCode:   http.StatusNotFound,
 		},
 		{
@@ -69,68 +85,103 @@ func TestHandler_AddImage(t *testing.T) {
 			statusCode:   http.StatusBadRequest,
 		},
 		{
-			testName:      "should return 500 when database does not respond",
-			requestURL:    "/v1/images",
-			body:          bytes.NewBuffer(jsonData),
-			contentType:   "application/json",
-			getError:      errors.New("database not respond error"),
-			assertNoOfGet: 1,
-			bodyContains:  "database not respond error",
-			statusCode:    http.StatusInternalServerError,
+			testName:     "should return 400 without storing image when image is not valid",
+			requestURL:   "/v1/images",
+			body:         bytes.NewBuffer(jsonInvalidData),
+			contentType:  "application/json",
+			bodyContains: "unsupported image format gif, expected jpeg or png",
+			statusCode:   http.StatusBadRequest,
 		},
 		{
-			testName:      "should return 500 when there is no `images` in database",
-			requestURL:    "/v1/images",
-			body:          bytes.NewBuffer(jsonData),
-			contentType:   "application/json",
-			getError:      errors.New("key images does not exist"),
-			assertNoOfGet: 1,
-			bodyContains:  "key images does not exist",
-			statusCode:    http.StatusInternalServerError,
+			testName:         "should return 500 when failed to store image content",
+			requestURL:       "/v1/images",
+			body:             bytes.NewBuffer(jsonData),
+			contentType:      "application/json",
+			storeError:       errors.New("database not respond error"),
+			assertNoOfInsert: 1,
+			bodyContains:     "database not respond error",
+			statusCode:       http.StatusInternalServerError,
 		},
 		{
-			testName:      "should return 500 when `images` have no value assigned",
-			requestURL:    "/v1/images",
-			body:          bytes.NewBuffer(jsonData),
-			contentType:   "application/json",
-			getReturned:   "",
-			getError:      errors.New("for key images value is empty"),
-			assertNoOfGet: 1,
-			bodyContains:  "for key images value is empty",
-			statusCode:    http.StatusInternalServerError,
+			testName:         "should return 500 when database does not respond",
+			requestURL:       "/v1/images",
+			body:             bytes.NewBuffer(jsonData),
+			contentType:      "application/json",
+			getError:         errors.New("database not respond error"),
+			assertNoOfGet:    1,
+			assertNoOfInsert: 1,
+			bodyContains:     "database not respond error",
+			statusCode:       http.StatusInternalServerError,
 		},
 		{
-			testName:      "should return 500 when `images` are not in json format",
-			requestURL:    "/v1/images",
-			body:          bytes.NewBuffer(jsonData),
-			contentType:   "application/json",
-			getReturned:   "not json",
-			assertNoOfGet: 1,
-			bodyContains:  "failed to unmarshal",
-			statusCode:    http.StatusInternalServerError,
+			testName:         "should return 500 when there is no `images` in database",
+			requestURL:       "/v1/images",
+			body:             bytes.NewBuffer(jsonData),
+			contentType:      "application/json",
+			getError:         errors.New("key images does not exist"),
+			assertNoOfGet:    1,
+			assertNoOfInsert: 1,
+			bodyContains:     "key images does not exist",
+			statusCode:       http.StatusInternalServerError,
+		},
+		{
+			testName:         "should return 500 when `images` have no value assigned",
+			requestURL:       "/v1/images",
+			body:             bytes.NewBuffer(jsonData),
+			contentType:      "application/json",
+			getReturned:      "",
+			getError:         errors.New("for key images value is empty"),
+			assertNoOfGet:    1,
+			assertNoOfInsert: 1,
+			bodyContains:     "for key images value is empty",
+			statusCode:       http.StatusInternalServerError,
+		},
+		{
+			testName:         "should return 500 when `images` are not in json format",
+			requestURL:       "/v1/images",
+			body:             bytes.NewBuffer(jsonData),
+			contentType:      "application/json",
+			getReturned:      "not json",
+			assertNoOfGet:    1,
+			assertNoOfInsert: 1,
+			bodyContains:     "failed to unmarshal",
+			statusCode:       http.StatusInternalServerError,
 		},
 		{
 			testName:         "should return 500 when failed to insert `images` to database",
 			requestURL:       "/v1/images",
 			body:             bytes.NewBuffer(jsonData),
 			contentType:      "application/json",
-			getReturned:      string(jsonImg),
+			getReturned:      string(jsonEmptyIndex),
 			assertNoOfGet:    1,
-			insertData:       string(jsonImages),
+			insertData:       string(jsonIndex),
 			insertError:      errors.New("failed to insert json to database"),
-			assertNoOfInsert: 1,
+			assertNoOfInsert: 2,
 			bodyContains:     "failed to insert json to database",
 			statusCode:       http.StatusInternalServerError,
 		},
 		{
-			testName:         "should return 200 when images where take from database correctly",
+			testName:         "should return 200 and id of image when image was stored correctly",
 			requestURL:       "/v1/images",
 			body:             bytes.NewBuffer(jsonData),
 			contentType:      "application/json",
-			getReturned:      string(jsonImg),
-			insertData:       string(jsonImages),
+			getReturned:      string(jsonEmptyIndex),
+			insertData:       string(jsonIndex),
 			assertNoOfGet:    1,
-			assertNoOfInsert: 1,
+			assertNoOfInsert: 2,
+			bodyContains:     `{"id":"` + id + `"}`,
+			statusCode:       http.StatusOK,
+		},
+		{
+			testName:         "should not list the same image twice",
+			requestURL:       "/v1/images",
+			body:             bytes.NewBuffer(jsonData),
+			contentType:      "application/json",
+			getReturned:      string(jsonIndex),
+			insertData:       string(jsonIndex),
+			assertNoOfGet:    1,
+			assertNoOfInsert: 2,
+			bodyContains:     `{"id":"` + id + `"}`,
 			statusCode:       http.StatusOK,
 		},
 	}
@@ -144,8 +195,10 @@ func TestHandler_AddImage(t *testing.T) {
 			iDatabaseMock := mocks.IDatabase{}
 			iAlgorithmMock := []IAlgorithm{&mocks.IAlgorithm{}}
 			testSubject := NewHandler(&iDatabaseMock, iAlgorithmMock)
-			iDatabaseMock.On("Get", "images").Return(tt.getReturned, tt.getError)
-			iDatabaseMock.On("Set", "images", tt.insertData).Return(tt.insertError)
+			iDatabaseMock.On("Get", mock.Anything, "images").Return(tt.getReturned, tt.getError)
+			iDatabaseMock.On("Set", mock.Anything, "image:"+id, image).Return(tt.storeError)
+			iDatabaseMock.On("Set", mock.Anything, "images", tt.insertData).Return(tt.insertError)
+			mockUpdate(&iDatabaseMock, "images")
 
 			//when
 			testSubject.AddImage(w, r)
@@ -159,9 +212,49 @@ func TestHandler_AddImage(t *testing.T) {
 	}
 }
 
+func TestHandler_AddImage_Concurrent(t *testing.T) {
+	//given
+	const requests = 50
+	server := miniredis.RunT(t)
+	assert.NoError(t, server.Set("images", `{"ids":[]}`))
+	db := database.NewDBConnection(server.Addr(), "")
+	assert.NoError(t, db.Connect(context.Background()))
+	testSubject := NewHandler(db, []IAlgorithm{&mocks.IAlgorithm{}})
+
+	//when
+	var wg sync.WaitGroup
+	codes := make(chan int, requests)
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			body := bytes.NewBufferString(fmt.Sprintf(`{"content":"%s"}`, testImage(t, "png", i+1, 1)))
+			r, _ := http.NewRequest("PUT", "/v1/images", body)
+			r.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			testSubject.AddImage(w, r)
+			codes <- w.Code
+		}(i)
+	}
+	wg.Wait()
+	close(codes)
+
+	//then
+	for code := range codes {
+		assert.Equal(t, http.StatusOK, code)
+	}
+	fromDB, err := server.Get("images")
+	assert.NoError(t, err)
+	var index structure.ImageIndex
+	assert.NoError(t, json.Unmarshal([]byte(fromDB), &index))
+	assert.Len(t, index.IDs, requests)
+}
+
 func TestHandler_GetImages(t *testing.T) {
-	img := structure.Images{Images: []string{"image", "image"}}
-	jsonImg, err := json.Marshal(img)
+	id := imageID("image")
+	jsonIndex, err := json.Marshal(structure.ImageIndex{IDs: []string{id}})
+	assert.NoError(t, err)
+	jsonImages, err := json.Marshal(structure.ImageList{Images: []structure.Image{{ID: id, URL: "/v1/images/" + id}}})
 	assert.NoError(t, err)
 	tests := []struct {
 		testName      string
@@ -176,7 +269,7 @@ func TestHandler_GetImages(t *testing.T) {
 			testName:      "should return 404 when url is wrong",
 			requestURL:    "/v1/images/wrong",
 			assertNoOfGet: 0,
-			bodyContains:  "404 not found",
+			bodyContains:  `"code":"not_found"`,
 			statusCode:    http.StatusNotFound,
 		},
 		{
@@ -204,11 +297,19 @@ func TestHandler_GetImages(t *testing.T) {
 			statusCode:    http.StatusInternalServerError,
 		},
 		{
-			testName:      "should return 200 when images where take from database correctly",
+			testName:      "should return 200 with empty list when there are no images",
+			requestURL:    "/v1/images",
+			getReturned:   `{"ids":[]}`,
+			assertNoOfGet: 1,
+			bodyContains:  `{"images":[]}`,
+			statusCode:    http.StatusOK,
+		},
+		{
+			testName:      "should return 200 with ids without reading contents of images",
 			requestURL:    "/v1/images",
-			getReturned:   string(jsonImg),
+			getReturned:   string(jsonIndex),
 			assertNoOfGet: 1,
-			bodyContains:  string(jsonImg),
+			bodyContains:  string(jsonImages),
 			statusCode:    http.StatusOK,
 		},
 	}
@@ -221,7 +322,7 @@ func TestHandler_GetImages(t *testing.T) {
 			iDatabaseMock := mocks.IDatabase{}
 			iAlgorithmMock := []IAlgorithm{&mocks.IAlgorithm{}}
 			testSubject := NewHandler(&iDatabaseMock, iAlgorithmMock)
-			iDatabaseMock.On("Get", "images").Return(tt.getReturned, tt.getError)
+			iDatabaseMock.On("Get", mock.Anything, "images").Return(tt.getReturned, tt.getError)
 
 			//when
 			testSubject.GetImages(w, r)
@@ -234,16 +335,50 @@ func TestHandler_GetImages(t *testing.T) {
 	}
 }
 
+func testRegistry(id string, models ...structure.Model) structure.Registry {
+	algModels := &structure.AlgorithmModels{Default: "default", Models: map[string][]structure.Model{}}
+	for _, model := range models {
+		algModels.Models[model.Name] = append(algModels.Models[model.Name], model)
+	}
+	return structure.Registry{Algorithms: map[string]*structure.AlgorithmModels{id: algModels}}
+}
+
+// mockUpdate routes Update of key through the Get and Set expectations of the
+// mock. Like the database, later updates see the last successfully set value.
+func mockUpdate(iDatabaseMock *mocks.IDatabase, key interface{}) {
+	var stored *string
+	iDatabaseMock.On("Update", mock.Anything, key, mock.Anything).Return(func(ctx context.Context, key string, update func(string) (string, error)) error {
+		var fromDB string
+		if stored != nil {
+			fromDB = *stored
+		} else {
+			value, err := iDatabaseMock.Get(ctx, key)
+			if err != nil {
+				return err
+			}
+			fromDB = value.(string)
+		}
+		value, err := update(fromDB)
+		if err != nil {
+			return err
+		}
+		if err = iDatabaseMock.Set(ctx, key, value); err != nil {
+			return err
+		}
+		stored = &value
+		return nil
+	})
+}
+
 func TestHandler_GetModels(t *testing.T) {
 	id := "algID"
-	allModels := structure.Algorithm{
-		Models: map[string][]string{
-			id:       {"model", "model"},
-			id + "2": {"model"}}}
-	jsonAllModels, err := json.Marshal(allModels)
+	defaultModel := structure.Model{Name: "default", Version: 1, File: "default", Status: "ready"}
+	model := structure.Model{Name: "model.h5", Version: 1, File: "model.h5", Status: "ready"}
+	modelV2 := structure.Model{Name: "model.h5", Version: 2, File: "model-v2.h5", Status: "ready"}
+	jsonAllModels, err := json.Marshal(testRegistry(id, model, defaultModel, modelV2))
 	assert.NoError(t, err)
-	models := allModels.Models[id]
-	jsonModels, err := json.Marshal(models)
+	summaries := []modelSummary{{Model: defaultModel, Versions: 1, Default: true}, {Model: modelV2, Versions: 2}}
+	jsonModels, err := json.Marshal(summaries)
 	assert.NoError(t, err)
 	tests := []struct {
 		testName      string
@@ -257,7 +392,7 @@ func TestHandler_GetModels(t *testing.T) {
 		{
 			testName:     "should return 404 when url is wrong",
 			requestURL:   "/v1/models/{id}/wrong",
-			bodyContains: "404 not found",
+			bodyContains: `"code":"not_found"`,
 			statusCode:   http.StatusNotFound,
 		},
 		{
@@ -285,7 +420,7 @@ func TestHandler_GetModels(t *testing.T) {
 			statusCode:    http.StatusInternalServerError,
 		},
 		{
-			testName:      "should return 200 when images where take from database correctly",
+			testName:      "should return 200 with latest version of every model",
 			requestURL:    "/v1/models/" + id,
 			getReturned:   string(jsonAllModels),
 			assertNoOfGet: 1,
@@ -306,7 +441,7 @@ func TestHandler_GetModels(t *testing.T) {
 			iDatabaseMock := mocks.IDatabase{}
 			iAlgorithmMock := []IAlgorithm{&mocks.IAlgorithm{}}
 			testSubject := NewHandler(&iDatabaseMock, iAlgorithmMock)
-			iDatabaseMock.On("Get", "models").Return(tt.getReturned, tt.getError)
+			iDatabaseMock.On("Get", mock.Anything, "models").Return(tt.getReturned, tt.getError)
 
 			//when
 			testSubject.GetModels(w, r)
@@ -322,31 +457,52 @@ func TestHandler_GetModels(t *testing.T) {
 func TestHandler_UploadModel(t *testing.T) {
 	id := "algID"
 	modelFile := "model.h5"
-	body := new(bytes.Buffer)
-	writer := multipart.NewWriter(body)
-	allModels := structure.Algorithm{
-		Models: map[string][]string{
-			id: {modelFile, modelFile},
-		}}
-	jsonAllModels, err := json.Marshal(allModels)
-	assert.NoError(t, err)
-	newAllModels := structure.Algorithm{
-		Models: map[string][]string{
-			id: {modelFile, modelFile, modelFile},
-		}}
-	jsonNewAllModels, err := json.Marshal(newAllModels)
+	content := []byte("sample")
+	checksum := sha256.Sum256(content)
+	timeStamp := fixedTime()
+	modelV1 := structure.Model{Name: modelFile, Version: 1, File: modelFile, SHA256: "other", Status: "ready"}
+	jsonAllModels, err := json.Marshal(testRegistry(id, modelV1))
+	assert.NoError(t, err)
+	sameModel := structure.Model{Name: modelFile, Version: 1, File: modelFile, SHA256: hex.EncodeToString(checksum[:]), Status: "ready"}
+	jsonSameModels, err := json.Marshal(testRegistry(id, sameModel))
+	assert.NoError(t, err)
+	modelV2 := structure.Model{
+		Name:        modelFile,
+		Version:     2,
+		File:        "model@v2.h5",
+		Uploader:    "user",
+		UploadedAt:  timeStamp,
+		Description: "fine tuned",
+		Classes:     []string{"person", "car"},
+		Status:      "uploading",
+	}
+	jsonUploading, err := json.Marshal(testRegistry(id, modelV1, modelV2))
+	assert.NoError(t, err)
+	jsonSameUploading, err := json.Marshal(testRegistry(id, sameModel, modelV2))
+	assert.NoError(t, err)
+	modelV2.SHA256 = hex.EncodeToString(checksum[:])
+	modelV2.Size = int64(len(content))
+	modelV2.Status = "ready"
+	jsonReady, err := json.Marshal(testRegistry(id, modelV1, modelV2))
+	assert.NoError(t, err)
+	jsonModelV2, err := json.Marshal(modelV2)
+	assert.NoError(t, err)
+	modelV2.Status = "failed"
+	jsonFailed, err := json.Marshal(testRegistry(id, modelV1, modelV2))
 	assert.NoError(t, err)
 	tests := []struct {
 		testName              string
 		requestURL            string
-		body                  io.Reader
 		contentType           string
 		formModel             string
+		formName              string
+		formChecksum          string
 		getIDReturned         string
 		uploadError           error
+		cancelDuringUpload    bool
 		getReturned           string
 		getError              error
-		insertData            string
+		insertData            []string
 		insertError           error
 		assertNoOfInsert      int
 		assertNoOfGet         int
@@ -358,7 +514,7 @@ func TestHandler_UploadModel(t *testing.T) {
 		{
 			testName:     "should return 404 when url is wrong",
 			requestURL:   "/v1/models/wrong",
-			bodyContains: "404 not found",
+			bodyContains: `"code":"not_found"`,
 			statusCode:   http.StatusNotFound,
 		},
 		{
@@ -378,101 +534,191 @@ func TestHandler_UploadModel(t *testing.T) {
 		{
 			testName:     "should return 400 error when there is no `model` file",
 			requestURL:   "/v1/models",
-			contentType:  writer.FormDataContentType(),
 			formModel:    "-",
 			bodyContains: "no such file",
 			statusCode:   http.StatusBadRequest,
 		},
 		{
-			testName:              "should return 500 when failed to upload model",
-			requestURL:            "/v1/models",
-			contentType:           writer.FormDataContentType(),
-			formModel:             "model",
-			getIDReturned:         id,
-			uploadError:           errors.New("failed to upload model"),
-			assertNoOfGetID:       1,
-			assertNoOfUploadModel: 1,
-			assertNoOfGet:         1,
-			bodyContains:          "failed to upload model",
-			statusCode:            http.StatusInternalServerError,
+			testName:     "should return 400 error when name is missing",
+			requestURL:   "/v1/models",
+			formModel:    "model",
+			formName:     "-",
+			bodyContains: "name is required",
+			statusCode:   http.StatusBadRequest,
+		},
+		{
+			testName:     "should return 400 error when name is not a safe file name",
+			requestURL:   "/v1/models",
+			formModel:    "model",
+			formName:     "../model.h5",
+			bodyContains: "invalid name",
+			statusCode:   http.StatusBadRequest,
+		},
+		{
+			testName:        "should return 404 when failed to find algorithm",
+			requestURL:      "/v1/models",
+			formModel:       "model",
+			assertNoOfGetID: 2,
+			bodyContains:    "algorithm algID does not exist",
+			statusCode:      http.StatusNotFound,
+		},
+		{
+			testName:        "should return 500 when database does not respond",
+			requestURL:      "/v1/models",
+			formModel:       "model",
+			getIDReturned:   id,
+			getError:        errors.New("database not respond error"),
+			assertNoOfGetID: 1,
+			assertNoOfGet:   1,
+			bodyContains:    "database not respond error",
+			statusCode:      http.StatusInternalServerError,
+		},
+		{
+			testName:        "should return 500 error when failed to unmarshal",
+			requestURL:      "/v1/models",
+			formModel:       "model",
+			getIDReturned:   id,
+			assertNoOfGetID: 1,
+			assertNoOfGet:   1,
+			bodyContains:    "failed to unmarshal",
+			statusCode:      http.StatusInternalServerError,
+		},
+		{
+			testName:        "should return 409 when model with declared checksum was already uploaded",
+			requestURL:      "/v1/models",
+			formModel:       "model",
+			formChecksum:    hex.EncodeToString(checksum[:]),
+			getIDReturned:   id,
+			getReturned:     string(jsonSameModels),
+			assertNoOfGetID: 1,
+			assertNoOfGet:   1,
+			bodyContains:    "model model.h5 is already uploaded as version 1",
+			statusCode:      http.StatusConflict,
 		},
 		{
-			testName:              "should return 500 when database does not respond",
+			testName:              "should return 409 and drop new version when uploaded content was already uploaded",
 			requestURL:            "/v1/models",
-			contentType:           writer.FormDataContentType(),
 			formModel:             "model",
 			getIDReturned:         id,
-			getError:              errors.New("database not respond error"),
+			getReturned:           string(jsonSameModels),
+			insertData:            []string{string(jsonSameUploading), string(jsonSameModels)},
 			assertNoOfGetID:       1,
 			assertNoOfUploadModel: 1,
 			assertNoOfGet:         1,
-			bodyContains:          "database not respond error",
-			statusCode:            http.StatusInternalServerError,
+			assertNoOfInsert:      2,
+			bodyContains:          "model model.h5 is already uploaded as version 1",
+			statusCode:            http.StatusConflict,
 		},
 		{
-			testName:              "should return 500 error when failed to unmarshal",
+			testName:         "should return 500 when failed to insert to db",
+			requestURL:       "/v1/models",
+			formModel:        "model",
+			getIDReturned:    id,
+			getReturned:      string(jsonAllModels),
+			insertData:       []string{string(jsonUploading)},
+			insertError:      errors.New("failed to insert to db"),
+			assertNoOfGetID:  1,
+			assertNoOfGet:    1,
+			assertNoOfInsert: 1,
+			bodyContains:     "failed to insert to db",
+			statusCode:       http.StatusInternalServerError,
+		},
+		{
+			testName:              "should return 502 and mark version as failed when failed to upload model",
 			requestURL:            "/v1/models",
-			contentType:           writer.FormDataContentType(),
 			formModel:             "model",
 			getIDReturned:         id,
+			uploadError:           errors.New("failed to upload model"),
+			getReturned:           string(jsonAllModels),
+			insertData:            []string{string(jsonUploading), string(jsonFailed)},
 			assertNoOfGetID:       1,
 			assertNoOfUploadModel: 1,
 			assertNoOfGet:         1,
-			bodyContains:          "failed to unmarshal",
-			statusCode:            http.StatusInternalServerError,
+			assertNoOfInsert:      2,
+			bodyContains:          `"code":"upstream_error","message":"failed to upload model: failed to upload model"`,
+			statusCode:            http.StatusBadGateway,
 		},
 		{
-			testName:              "should return 500 when failed to insert to db",
+			testName:              "should mark version as failed when request is cancelled during upload",
 			requestURL:            "/v1/models",
-			contentType:           writer.FormDataContentType(),
 			formModel:             "model",
 			getIDReturned:         id,
+			uploadError:           context.Canceled,
+			cancelDuringUpload:    true,
 			getReturned:           string(jsonAllModels),
-			insertData:            string(jsonNewAllModels),
-			insertError:           errors.New("failed to insert to db"),
+			insertData:            []string{string(jsonUploading), string(jsonFailed)},
 			assertNoOfGetID:       1,
 			assertNoOfUploadModel: 1,
 			assertNoOfGet:         1,
-			assertNoOfInsert:      1,
-			bodyContains:          "failed to insert to db",
-			statusCode:            http.StatusInternalServerError,
+			assertNoOfInsert:      2,
+			bodyContains:          "failed to upload model: context canceled",
+			statusCode:            http.StatusBadGateway,
 		},
 		{
-			testName:              "should return 200 when model was correctly uploaded",
+			testName:              "should return 200 when model was correctly uploaded as new version",
 			requestURL:            "/v1/models",
-			contentType:           writer.FormDataContentType(),
 			formModel:             "model",
 			getIDReturned:         id,
 			getReturned:           string(jsonAllModels),
-			insertData:            string(jsonNewAllModels),
+			insertData:            []string{string(jsonUploading), string(jsonReady)},
 			assertNoOfGetID:       1,
 			assertNoOfUploadModel: 1,
 			assertNoOfGet:         1,
-			assertNoOfInsert:      1,
+			assertNoOfInsert:      2,
+			bodyContains:          string(jsonModelV2),
 			statusCode:            http.StatusOK,
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.testName, func(t *testing.T) {
 			//given
+			body := new(bytes.Buffer)
+			writer := multipart.NewWriter(body)
 			writer.WriteField("id", id)
-			writer.WriteField("name", modelFile)
+			switch tt.formName {
+			case "":
+				writer.WriteField("name", modelFile)
+			case "-":
+			default:
+				writer.WriteField("name", tt.formName)
+			}
+			writer.WriteField("uploader", "user")
+			writer.WriteField("description", "fine tuned")
+			writer.WriteField("classes", "person, car")
+			if tt.formChecksum != "" {
+				writer.WriteField("sha256", tt.formChecksum)
+			}
 			part, _ := writer.CreateFormFile(tt.formModel, modelFile)
-			part.Write([]byte(`sample`))
+			part.Write(content)
 			writer.Close()
 			r, err := http.NewRequest("PUT", tt.requestURL, body)
 			assert.NoError(t, err)
-			r.Header.Set("Content-Type", tt.contentType)
+			contentType := tt.contentType
+			if contentType == "" {
+				contentType = writer.FormDataContentType()
+			}
+			r.Header.Set("Content-Type", contentType)
+			ctx, cancel := context.WithCancel(r.Context())
+			defer cancel()
+			r = r.WithContext(ctx)
 			w := httptest.NewRecorder()
 			iDatabaseMock := mocks.IDatabase{}
 			iAlgorithmMock := mocks.IAlgorithm{}
 			iAlgorithmMocks := []IAlgorithm{&iAlgorithmMock, &iAlgorithmMock}
 			testSubject := NewHandler(&iDatabaseMock, iAlgorithmMocks)
-			modelFile, modelHeader, err := r.FormFile("model")
 			iAlgorithmMock.On("GetID").Return(tt.getIDReturned)
-			iAlgorithmMock.On("UploadModel", modelFile, modelHeader).Return(tt.uploadError)
-			iDatabaseMock.On("Get", "models").Return(tt.getReturned, tt.getError)
-			iDatabaseMock.On("Set", "models", tt.insertData).Return(tt.insertError)
+			iAlgorithmMock.On("UploadModel", mock.Anything, "model@v2.h5", mock.Anything).Return(tt.uploadError).Run(func(args mock.Arguments) {
+				ioutil.ReadAll(args.Get(2).(io.Reader))
+				if tt.cancelDuringUpload {
+					cancel()
+				}
+			})
+			iDatabaseMock.On("Get", mock.Anything, "models").Return(tt.getReturned, tt.getError)
+			notCancelled := mock.MatchedBy(func(ctx context.Context) bool { return ctx.Err() == nil })
+			for _, insertData := range tt.insertData {
+				iDatabaseMock.On("Set", notCancelled, "models", insertData).Return(tt.insertError).Once()
+			}
+			mockUpdate(&iDatabaseMock, "models")
 
 			//when
 			testSubject.UploadModel(w, r)
@@ -491,39 +737,60 @@ func TestHandler_UploadModel(t *testing.T) {
 func TestHandler_RunSimulation(t *testing.T) {
 	id := "algID"
 	model := "model.h5"
-	image := "image"
+	image := testImage(t, "png", 4, 3)
 	opType := "demo"
-	body := structure.Body{ID: id, Model: model, Image: image}
+	body := structure.Body{ID: id, Model: model, Image: image, Priority: 5}
 	jsonBody, err := json.Marshal(body)
 	assert.NoError(t, err)
-	timeStamp := fixedTime()
-	dbID := timeStamp + body.ID + opType
-	sendData := structure.Body{ID: dbID, Model: body.Model, Image: body.Image}
-	jsonSendData, err := json.Marshal(sendData)
+	jsonInvalidBody, err := json.Marshal(structure.Body{Model: model, Image: "image", Priority: 10})
+	assert.NoError(t, err)
+	jsonUnknownModelBody, err := json.Marshal(structure.Body{ID: id, Model: "other.h5", Image: image})
+	assert.NoError(t, err)
+	jsonUnknownVersionBody, err := json.Marshal(structure.Body{ID: id, Model: model, Version: 2, Image: image})
 	assert.NoError(t, err)
-	results := structure.Results{Algorithm: id, Model: body.Model, Image: body.Image, TimeStamp: timeStamp, Status: "in-progress"}
+	imgID := imageID(image)
+	jsonStoredImageBody, err := json.Marshal(structure.Body{ID: id, Model: model, ImageID: imgID, Priority: 5})
+	assert.NoError(t, err)
+	timeStamp := fixedTime()
+	var dbID string
+	isJob := mock.MatchedBy(func(key string) bool { return key == dbID })
+	results := structure.Results{Algorithm: id, Operation: opType, Model: body.Model, ModelVersion: 1, ImageID: imgID, Priority: 5, TimeStamp: timeStamp, Status: "queued", RequestID: "requestID"}
 	jsonResults, err := json.Marshal(results)
 	assert.NoError(t, err)
+	failed := results
+	failed.Status = "error"
+	failed.Error = "failed to queue simulation: failed to create pending job"
+	jsonFailed, err := json.Marshal(failed)
+	assert.NoError(t, err)
+	failed.Error = "failed to store image: failed to store image"
+	jsonImageFailed, err := json.Marshal(failed)
+	assert.NoError(t, err)
+	jsonAllModels, err := json.Marshal(testRegistry(id, structure.Model{Name: model, Version: 1, File: model, Status: "ready"}))
+	assert.NoError(t, err)
 	tests := []struct {
-		testName                string
-		requestURL              string
-		body                    io.Reader
-		getIDReturned           string
-		runSimulationData       []byte
-		runSimulationReturned   int
-		runSimulationError      error
-		insertData              string
-		insertError             error
-		assertNoOfGetID         int
-		assertNoOfRunSimulation int
-		assertNoOfInsert        int
-		bodyContains            string
-		statusCode              int
+		testName           string
+		requestURL         string
+		body               io.Reader
+		getIDReturned      string
+		getModelsError     error
+		getImageReturned   string
+		getImageError      error
+		storeImageError    error
+		insertError        error
+		createPendingError error
+		pushReturned       int
+		pushError          error
+		assertNoOfGetID    int
+		assertNoOfInsert   int
+		assertNoOfPush     int
+		imageAdded         bool
+		bodyContains       string
+		statusCode         int
 	}{
 		{
 			testName:     "should return 404 when url is wrong",
 			requestURL:   "/v1/simulation-results/{type}/wrong",
-			bodyContains: "404 not found",
+			bodyContains: `"code":"not_found"`,
 			statusCode:   http.StatusNotFound,
 		},
 		{
@@ -534,65 +801,129 @@ func TestHandler_RunSimulation(t *testing.T) {
 			statusCode:   http.StatusBadRequest,
 		},
 		{
-			testName:        "should return 500 when failed to find algorithm",
+			testName:     "should return 400 with all problems when request is invalid",
+			requestURL:   "/v1/simulation-results/",
+			body:         bytes.NewBuffer(jsonInvalidBody),
+			bodyContains: `"message":"id is required; priority has to be between 0 and 9; image is not valid base64"`,
+			statusCode:   http.StatusBadRequest,
+		},
+		{
+			testName:        "should return 400 when model does not exist",
+			requestURL:      "/v1/simulation-results/",
+			body:            bytes.NewBuffer(jsonUnknownModelBody),
+			getIDReturned:   id,
+			assertNoOfGetID: 1,
+			bodyContains:    "model other.h5 does not exist for algorithm algID",
+			statusCode:      http.StatusBadRequest,
+		},
+		{
+			testName:        "should return 400 when model version does not exist",
+			requestURL:      "/v1/simulation-results/",
+			body:            bytes.NewBuffer(jsonUnknownVersionBody),
+			getIDReturned:   id,
+			assertNoOfGetID: 1,
+			bodyContains:    "model model.h5 has no version 2",
+			statusCode:      http.StatusBadRequest,
+		},
+		{
+			testName:        "should return 404 when failed to find algorithm",
 			requestURL:      "/v1/simulation-results/",
 			body:            bytes.NewBuffer(jsonBody),
 			assertNoOfGetID: 2,
-			bodyContains:    "algorithm with this id does not exists",
+			bodyContains:    `"code":"not_found"`,
+			statusCode:      http.StatusNotFound,
+		},
+		{
+			testName:        "should return 500 when failed to get models from database",
+			requestURL:      "/v1/simulation-results/",
+			body:            bytes.NewBuffer(jsonBody),
+			getIDReturned:   id,
+			getModelsError:  errors.New("failed to get models"),
+			assertNoOfGetID: 1,
+			bodyContains:    "failed to get models",
 			statusCode:      http.StatusInternalServerError,
 		},
 		{
-			testName:                "should return 500 when run simulation response is not 200",
-			requestURL:              "/v1/simulation-results/",
-			body:                    bytes.NewBuffer(jsonBody),
-			getIDReturned:           id,
-			runSimulationData:       jsonSendData,
-			runSimulationReturned:   500,
-			assertNoOfGetID:         1,
-			assertNoOfRunSimulation: 1,
-			bodyContains:            "failed to run simulation",
-			statusCode:              http.StatusInternalServerError,
-		},
-		{
-			testName:                "should return 500 when failed to run simulation",
-			requestURL:              "/v1/simulation-results/",
-			body:                    bytes.NewBuffer(jsonBody),
-			getIDReturned:           id,
-			runSimulationData:       jsonSendData,
-			runSimulationError:      errors.New("failed to run simulation"),
-			runSimulationReturned:   200,
-			assertNoOfGetID:         1,
-			assertNoOfRunSimulation: 1,
-			bodyContains:            "failed to run simulation",
-			statusCode:              http.StatusInternalServerError,
-		},
-		{
-			testName:                "should return 500 when failed to insert data to database",
-			requestURL:              "/v1/simulation-results/",
-			body:                    bytes.NewBuffer(jsonBody),
-			getIDReturned:           id,
-			runSimulationData:       jsonSendData,
-			runSimulationReturned:   200,
-			insertData:              string(jsonResults),
-			insertError:             errors.New("failed to insert"),
-			assertNoOfGetID:         1,
-			assertNoOfRunSimulation: 1,
-			assertNoOfInsert:        1,
-			bodyContains:            "failed to insert",
-			statusCode:              http.StatusInternalServerError,
-		},
-		{
-			testName:                "should return 202 when simulation started and data inserted to database",
-			requestURL:              "/v1/simulation-results/",
-			body:                    bytes.NewBuffer(jsonBody),
-			getIDReturned:           id,
-			runSimulationData:       jsonSendData,
-			runSimulationReturned:   200,
-			insertData:              string(jsonResults),
-			assertNoOfGetID:         1,
-			assertNoOfRunSimulation: 1,
-			assertNoOfInsert:        1,
-			statusCode:              http.StatusAccepted,
+			testName:         "should return 500 and mark job as failed when failed to store image",
+			requestURL:       "/v1/simulation-results/",
+			body:             bytes.NewBuffer(jsonBody),
+			getIDReturned:    id,
+			storeImageError:  errors.New("failed to store image"),
+			assertNoOfGetID:  1,
+			assertNoOfInsert: 2,
+			bodyContains:     `"message":"failed to store image","details":"failed to store image"`,
+			statusCode:       http.StatusInternalServerError,
+		},
+		{
+			testName:        "should return 400 when stored image does not exist",
+			requestURL:      "/v1/simulation-results/",
+			body:            bytes.NewBuffer(jsonStoredImageBody),
+			getIDReturned:   id,
+			getImageError:   errors.New("key does not exist"),
+			assertNoOfGetID: 1,
+			bodyContains:    "image " + imgID + " does not exist",
+			statusCode:      http.StatusBadRequest,
+		},
+		{
+			testName:         "should return 500 without storing image when failed to insert data to database",
+			requestURL:       "/v1/simulation-results/",
+			body:             bytes.NewBuffer(jsonBody),
+			getIDReturned:    id,
+			insertError:      errors.New("failed to insert"),
+			assertNoOfGetID:  1,
+			assertNoOfInsert: 0,
+			bodyContains:     "failed to insert",
+			statusCode:       http.StatusInternalServerError,
+		},
+		{
+			testName:           "should return 500 and mark job as failed when failed to add it to pending jobs",
+			requestURL:         "/v1/simulation-results/",
+			body:               bytes.NewBuffer(jsonBody),
+			getIDReturned:      id,
+			createPendingError: errors.New("failed to create pending job"),
+			assertNoOfGetID:    1,
+			assertNoOfInsert:   3,
+			imageAdded:         true,
+			bodyContains:       "failed to queue simulation",
+			statusCode:         http.StatusInternalServerError,
+		},
+		{
+			testName:         "should return 202 without position when queue is stopped",
+			requestURL:       "/v1/simulation-results/",
+			body:             bytes.NewBuffer(jsonBody),
+			getIDReturned:    id,
+			pushError:        queue.ErrStopped,
+			assertNoOfGetID:  1,
+			assertNoOfInsert: 2,
+			assertNoOfPush:   1,
+			imageAdded:       true,
+			bodyContains:     `","status":"queued"}`,
+			statusCode:       http.StatusAccepted,
+		},
+		{
+			testName:         "should return 202 with position when simulation is queued",
+			requestURL:       "/v1/simulation-results/",
+			body:             bytes.NewBuffer(jsonBody),
+			getIDReturned:    id,
+			pushReturned:     2,
+			assertNoOfGetID:  1,
+			assertNoOfInsert: 2,
+			assertNoOfPush:   1,
+			imageAdded:       true,
+			bodyContains:     `","status":"queued","position":2}`,
+			statusCode:       http.StatusAccepted,
+		},
+		{
+			testName:         "should return 202 when simulation of stored image started right away",
+			requestURL:       "/v1/simulation-results/",
+			body:             bytes.NewBuffer(jsonStoredImageBody),
+			getIDReturned:    id,
+			getImageReturned: image,
+			assertNoOfGetID:  1,
+			assertNoOfInsert: 0,
+			assertNoOfPush:   1,
+			bodyContains:     `","status":"in-progress"}`,
+			statusCode:       http.StatusAccepted,
 		},
 	}
 	for _, tt := range tests {
@@ -604,24 +935,60 @@ func TestHandler_RunSimulation(t *testing.T) {
 			}
 			r = mux.SetURLVars(r, vars)
 			assert.NoError(t, err)
+			r = r.WithContext(logging.WithRequestID(r.Context(), "requestID"))
 			w := httptest.NewRecorder()
 			iDatabaseMock := mocks.IDatabase{}
 			iAlgorithmMock := mocks.IAlgorithm{}
 			iAlgorithmMocks := []IAlgorithm{&iAlgorithmMock, &iAlgorithmMock}
-			testSubject := NewHandler(&iDatabaseMock, iAlgorithmMocks)
+			iQueueMock := mocks.IQueue{}
+			iAuditLogMock := mocks.IAuditLog{}
+			testSubject := NewHandler(&iDatabaseMock, iAlgorithmMocks).WithQueue(&iQueueMock).WithAuditLog(&iAuditLogMock)
+			iAuditLogMock.On("Record", mock.Anything, mock.Anything).Return(nil)
 			iAlgorithmMock.On("GetID").Return(tt.getIDReturned)
-			iAlgorithmMock.On("RunSimulation", opType, tt.runSimulationData).Return(tt.runSimulationReturned, tt.runSimulationError)
-			iDatabaseMock.On("Set", dbID, tt.insertData).Return(tt.insertError)
+			iDatabaseMock.On("Get", mock.Anything, "models").Return(string(jsonAllModels), tt.getModelsError)
+			iDatabaseMock.On("Get", mock.Anything, "image:"+imgID).Return(tt.getImageReturned, tt.getImageError)
+			iDatabaseMock.On("Set", mock.Anything, "image:"+imgID, image).Return(tt.storeImageError)
+			iDatabaseMock.On("Get", mock.Anything, "images").Return(`{"ids":[]}`, nil)
+			iDatabaseMock.On("Set", mock.Anything, "images", `{"ids":["`+imgID+`"]}`).Return(nil)
+			dbID = ""
+			created := mock.MatchedBy(func(key string) bool {
+				return strings.HasPrefix(key, timeStamp+id+opType+"-651387237")
+			})
+			iDatabaseMock.On("Create", mock.Anything, created, string(jsonResults)).Return(tt.insertError).Run(func(args mock.Arguments) {
+				dbID = args.String(1)
+			})
+			iDatabaseMock.On("Get", mock.Anything, isJob).Return(string(jsonResults), nil)
+			iDatabaseMock.On("Set", mock.Anything, isJob, string(jsonFailed)).Return(nil)
+			iDatabaseMock.On("Set", mock.Anything, isJob, string(jsonImageFailed)).Return(nil)
+			isPending := mock.MatchedBy(func(key string) bool { return key == "pending:"+dbID })
+			iDatabaseMock.On("Create", mock.Anything, isPending, mock.AnythingOfType("string")).Return(tt.createPendingError)
+			iDatabaseMock.On("Delete", mock.Anything, isPending).Return(nil)
+			mockUpdate(&iDatabaseMock, "images")
+			mockUpdate(&iDatabaseMock, isJob)
+			pushed := mock.MatchedBy(func(job queue.Job) bool {
+				return job.ID == dbID && job.Algorithm == id && job.Priority == 5
+			})
+			iQueueMock.On("Push", pushed).Return(tt.pushReturned, tt.pushError)
 
 			//when
 			testSubject.RunSimulation(w, r)
 
 			//then
 			iAlgorithmMock.AssertNumberOfCalls(t, "GetID", tt.assertNoOfGetID)
-			iAlgorithmMock.AssertNumberOfCalls(t, "RunSimulation", tt.assertNoOfRunSimulation)
 			iDatabaseMock.AssertNumberOfCalls(t, "Set", tt.assertNoOfInsert)
+			iQueueMock.AssertNumberOfCalls(t, "Push", tt.assertNoOfPush)
+			imageAdded := false
+			for _, call := range iAuditLogMock.Calls {
+				if entry := call.Arguments.Get(1).(structure.AuditEntry); entry.Action == actionAddImage {
+					imageAdded = entry.Resource == imgID
+				}
+			}
+			assert.Equal(t, tt.imageAdded, imageAdded)
 			assert.Contains(t, w.Body.String(), tt.bodyContains)
 			assert.Equal(t, tt.statusCode, w.Code)
+			if tt.statusCode == http.StatusAccepted {
+				assert.Contains(t, w.Body.String(), `{"id":"`+dbID+`",`)
+			}
 		})
 	}
 }
@@ -668,7 +1035,7 @@ func TestHandler_UpdateResults(t *testing.T) {
 		{
 			testName:     "should return 404 when url is wrong",
 			requestURL:   "/v1/simulation-results/wrong",
-			bodyContains: "404 not found",
+			bodyContains: `"code":"not_found"`,
 			statusCode:   http.StatusNotFound,
 		},
 		{
@@ -697,6 +1064,16 @@ func TestHandler_UpdateResults(t *testing.T) {
 			bodyContains:  "failed to get data from database",
 			statusCode:    http.StatusInternalServerError,
 		},
+		{
+			testName:      "should return 404 when job does not exist",
+			requestURL:    "/v1/simulation-results",
+			body:          bytes.NewBuffer(jsonBody),
+			contentType:   "application/json",
+			getError:      errors.New("key does not exist"),
+			assertNoOfGet: 1,
+			bodyContains:  "job " + dbID + " does not exist",
+			statusCode:    http.StatusNotFound,
+		},
 		{
 			testName:      "should return 500 when failed to unmarshal data from database",
 			requestURL:    "/v1/simulation-results",
@@ -707,6 +1084,16 @@ func TestHandler_UpdateResults(t *testing.T) {
 			bodyContains:  "failed to unmarshal",
 			statusCode:    http.StatusInternalServerError,
 		},
+		{
+			testName:      "should return 409 without storing results when job already finished",
+			requestURL:    "/v1/simulation-results",
+			body:          bytes.NewBuffer(jsonBody),
+			contentType:   "application/json",
+			getReturned:   string(jsonErrorResults),
+			assertNoOfGet: 1,
+			bodyContains:  "job " + dbID + " is error",
+			statusCode:    http.StatusConflict,
+		},
 		{
 			testName:         "should return 200 when results were updated - with error ",
 			requestURL:       "/v1/simulation-results",
@@ -758,8 +1145,10 @@ func TestHandler_UpdateResults(t *testing.T) {
 			iAlgorithmMock := mocks.IAlgorithm{}
 			iAlgorithmMocks := []IAlgorithm{&iAlgorithmMock, &iAlgorithmMock}
 			testSubject := NewHandler(&iDatabaseMock, iAlgorithmMocks)
-			iDatabaseMock.On("Get", dbID).Return(tt.getReturned, tt.getError)
-			iDatabaseMock.On("Set", dbID, tt.insertData).Return(tt.insertError)
+			iDatabaseMock.On("Get", mock.Anything, dbID).Return(tt.getReturned, tt.getError)
+			iDatabaseMock.On("Set", mock.Anything, dbID, tt.insertData).Return(tt.insertError)
+			iDatabaseMock.On("Delete", mock.Anything, "pending:"+dbID).Return(nil)
+			mockUpdate(&iDatabaseMock, dbID)
 
 			//when
 			testSubject.UpdateResults(w, r)
@@ -780,13 +1169,21 @@ func TestHandler_GetResults(t *testing.T) {
 	image := "image"
 	results := "results"
 	timeStamp := fixedTime()
-	keys := []string{alg, alg}
+	key := timeStamp + alg + opType
+	// results of other operations starting with the same letters are skipped
+	keys := []string{key, key + "-651387237a1b2c3d4", key + "2"}
 	dbResult := structure.Results{Algorithm: alg, Model: model, Image: image, TimeStamp: timeStamp, Status: "finished", Result: results}
 	jsonDBResult, err := json.Marshal(dbResult)
 	assert.NoError(t, err)
 	dbResults := []structure.Results{dbResult, dbResult}
 	jsonDBResults, err := json.Marshal(dbResults)
 	assert.NoError(t, err)
+	queuedResult := structure.Results{Algorithm: alg, Model: model, ImageID: "imageID", TimeStamp: timeStamp, Status: "queued"}
+	jsonQueuedResult, err := json.Marshal(queuedResult)
+	assert.NoError(t, err)
+	queuedResult.Position = 2
+	jsonQueuedResults, err := json.Marshal([]structure.Results{queuedResult, queuedResult})
+	assert.NoError(t, err)
 	tests := []struct {
 		testName         string
 		requestURL       string
@@ -797,6 +1194,7 @@ func TestHandler_GetResults(t *testing.T) {
 		assertNoOfKeys   int
 		assertNoOfGet    int
 		assertNoOfInsert int
+		body             string
 		bodyContains     string
 		statusCode       int
 	}{
@@ -804,7 +1202,7 @@ func TestHandler_GetResults(t *testing.T) {
 			testName:     "should return 404 when url is wrong",
 			requestURL:   "/v1/simulation-results/wrong",
 			keysError:    errors.New("failed to get keys from database"),
-			bodyContains: "404 not found",
+			bodyContains: `"code":"not_found"`,
 			statusCode:   http.StatusNotFound,
 		},
 		{
@@ -812,7 +1210,7 @@ func TestHandler_GetResults(t *testing.T) {
 			requestURL:     "/v1/simulation-results/" + opType + "/" + alg,
 			keysError:      errors.New("failed to get keys from database"),
 			assertNoOfKeys: 1,
-			bodyContains:   "failed to get keys from database",
+			body:           `{"code":"internal_error","message":"failed to read results","details":"failed to get keys from database"}` + "\n",
 			statusCode:     http.StatusInternalServerError,
 		},
 		{
@@ -845,6 +1243,16 @@ func TestHandler_GetResults(t *testing.T) {
 			bodyContains:   string(jsonDBResults),
 			statusCode:     http.StatusOK,
 		},
+		{
+			testName:       "should return position of queued jobs",
+			requestURL:     "/v1/simulation-results/" + opType + "/" + alg,
+			keysReturned:   keys,
+			getReturned:    string(jsonQueuedResult),
+			assertNoOfKeys: 1,
+			assertNoOfGet:  2,
+			bodyContains:   string(jsonQueuedResults),
+			statusCode:     http.StatusOK,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.testName, func(t *testing.T) {
@@ -860,10 +1268,12 @@ func TestHandler_GetResults(t *testing.T) {
 			iDatabaseMock := mocks.IDatabase{}
 			iAlgorithmMock := mocks.IAlgorithm{}
 			iAlgorithmMocks := []IAlgorithm{&iAlgorithmMock, &iAlgorithmMock}
-			testSubject := NewHandler(&iDatabaseMock, iAlgorithmMocks)
-			iDatabaseMock.On("Keys", "20[0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9]Z"+alg+opType).Return(tt.keysReturned, tt.keysError)
-			iDatabaseMock.On("Get", alg).Return(tt.getReturned, tt.getError)
-			iDatabaseMock.On("Get", alg).Return(tt.getReturned, tt.getError)
+			iQueueMock := mocks.IQueue{}
+			testSubject := NewHandler(&iDatabaseMock, iAlgorithmMocks).WithQueue(&iQueueMock)
+			iQueueMock.On("Position", mock.Anything).Return(2)
+			iDatabaseMock.On("Keys", mock.Anything, "20[0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9]Z"+alg+opType+"*").Return(tt.keysReturned, tt.keysError)
+			iDatabaseMock.On("Get", mock.Anything, key).Return(tt.getReturned, tt.getError)
+			iDatabaseMock.On("Get", mock.Anything, key+"-651387237a1b2c3d4").Return(tt.getReturned, tt.getError)
 
 			//when
 			testSubject.GetResults(w, r)
@@ -871,6 +1281,9 @@ func TestHandler_GetResults(t *testing.T) {
 			//then
 			iDatabaseMock.AssertNumberOfCalls(t, "Keys", tt.assertNoOfKeys)
 			iDatabaseMock.AssertNumberOfCalls(t, "Get", tt.assertNoOfGet)
+			if tt.body != "" {
+				assert.Equal(t, tt.body, w.Body.String())
+			}
 			assert.Contains(t, w.Body.String(), tt.bodyContains)
 			assert.Equal(t, tt.statusCode, w.Code)
 		})
@@ -0,0 +1,158 @@
+package api
+
+import (
+	"backend/internal/render"
+	"backend/internal/structure"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var imageIDPattern = regexp.MustCompile("^[0-9a-f]{64}$")
+
+// imageID identifies an image by the SHA-256 checksum of its content, so
+// uploading the same image twice stores it once.
+func imageID(content string) string {
+	checksum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(checksum[:])
+}
+
+func imageKey(id string) string {
+	return "image:" + id
+}
+
+func (h Handler) getImage(ctx context.Context, id string) (string, error) {
+	fromDB, err := h.iDatabase.Get(ctx, imageKey(id))
+	if err != nil {
+		return "", err
+	}
+	return fromDB.(string), nil
+}
+
+// storeImage stores the content under its id and returns the id.
+func (h Handler) storeImage(ctx context.Context, content string) (string, error) {
+	id := imageID(content)
+	return id, h.iDatabase.Set(ctx, imageKey(id), content)
+}
+
+// indexImage adds the image to the image index and tells whether it was
+// listed already.
+func (h Handler) indexImage(ctx context.Context, id string) (bool, error) {
+	listed := false
+	err := h.iDatabase.Update(ctx, "images", func(fromDB string) (string, error) {
+		var index structure.ImageIndex
+		if err := json.Unmarshal([]byte(fromDB), &index); err != nil {
+			return "", errors.New("failed to unmarshal images")
+		}
+		indexed := addToIndex(index, id)
+		listed = len(indexed.IDs) == len(index.IDs)
+
+		jsonIndex, err := json.Marshal(indexed)
+		if err != nil {
+			return "", err
+		}
+		return string(jsonIndex), nil
+	})
+	return listed, err
+}
+
+// addImage stores the content, adds it to the image index and records the
+// addition in the audit log. The content was validated before.
+func (h Handler) addImage(r *http.Request, content string) (string, error) {
+	id, err := h.storeImage(r.Context(), content)
+	if err != nil {
+		return "", err
+	}
+	entry := h.auditEntry(r, actionAddImage, id)
+	listed, err := h.indexImage(r.Context(), id)
+	if err != nil {
+		return "", err
+	}
+	if listed {
+		entry.Before = map[string]string{"id": id}
+	}
+	entry.After = map[string]string{"id": id, "bytes": strconv.Itoa(len(content))}
+	h.audit(r.Context(), entry)
+	return id, nil
+}
+
+// simulationImage returns the id and content of the image of a simulation
+// request. Images sent inline are not stored yet: they are added to the
+// gallery with addImage once the job was created, so that results can
+// reference them by id.
+func (h Handler) simulationImage(ctx context.Context, body structure.Body) (string, string, error) {
+	if body.ImageID == "" {
+		return imageID(body.Image), body.Image, nil
+	}
+
+	content, err := h.getImage(ctx, body.ImageID)
+	if err != nil {
+		if err.Error() == "key does not exist" {
+			return "", "", statusError{http.StatusBadRequest, "image " + body.ImageID + " does not exist"}
+		}
+		return "", "", err
+	}
+	return body.ImageID, content, nil
+}
+
+func (h Handler) getImageIndex(ctx context.Context) (structure.ImageIndex, error) {
+	fromDB, err := h.iDatabase.Get(ctx, "images")
+	if err != nil {
+		return structure.ImageIndex{}, err
+	}
+	var index structure.ImageIndex
+	if err = json.Unmarshal([]byte(fromDB.(string)), &index); err != nil {
+		return structure.ImageIndex{}, errors.New("failed to unmarshal images")
+	}
+	return index, nil
+}
+
+// addToIndex appends id to the image index unless it is already listed.
+func addToIndex(index structure.ImageIndex, id string) structure.ImageIndex {
+	for _, listed := range index.IDs {
+		if listed == id {
+			return index
+		}
+	}
+	index.IDs = append(index.IDs, id)
+	return index
+}
+
+//GET /v1/images/{id}
+func (h Handler) GetImage(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	url := strings.Replace(h.getImageEndpoint, "{id}", id, 1)
+	if r.URL.Path != url || !imageIDPattern.MatchString(id) {
+		notFound(w, r)
+		return
+	}
+
+	content, err := h.getImage(r.Context(), id)
+	if err != nil {
+		if err.Error() == "key does not exist" {
+			writeError(w, r, http.StatusNotFound, "image "+id+" does not exist", nil)
+			return
+		}
+		writeError(w, r, http.StatusInternalServerError, "failed to read image", err)
+		return
+	}
+	raw, err := render.DecodeBase64(content)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "failed to decode image", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", http.DetectContentType(raw))
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	if _, err = w.Write(raw); err != nil {
+		log.Print("failed to write response: " + err.Error())
+	}
+}
@@ -0,0 +1,96 @@
+package api
+
+import (
+	"backend/internal/api/mocks"
+	"bytes"
+	"encoding/base64"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHandler_GetImage(t *testing.T) {
+	var buf bytes.Buffer
+	assert.NoError(t, png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 4, 3))))
+	img := "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes())
+	id := imageID(img)
+	tests := []struct {
+		testName      string
+		id            string
+		getReturned   string
+		getError      error
+		assertNoOfGet int
+		bodyContains  string
+		contentType   string
+		statusCode    int
+	}{
+		{
+			testName:     "should return 404 when id is not an image id",
+			id:           "image.png",
+			bodyContains: `"code":"not_found"`,
+			statusCode:   http.StatusNotFound,
+		},
+		{
+			testName:      "should return 404 when image does not exist",
+			id:            id,
+			getError:      errors.New("key does not exist"),
+			assertNoOfGet: 1,
+			bodyContains:  "image " + id + " does not exist",
+			statusCode:    http.StatusNotFound,
+		},
+		{
+			testName:      "should return 500 when database does not respond",
+			id:            id,
+			getError:      errors.New("database not respond error"),
+			assertNoOfGet: 1,
+			bodyContains:  "database not respond error",
+			statusCode:    http.StatusInternalServerError,
+		},
+		{
+			testName:      "should return 500 when stored image is not base64",
+			id:            id,
+			getReturned:   "image",
+			assertNoOfGet: 1,
+			bodyContains:  "failed to decode image",
+			statusCode:    http.StatusInternalServerError,
+		},
+		{
+			testName:      "should return decoded image",
+			id:            id,
+			getReturned:   img,
+			assertNoOfGet: 1,
+			bodyContains:  buf.String(),
+			contentType:   "image/png",
+			statusCode:    http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			//given
+			r, err := http.NewRequest("GET", "/v1/images/"+tt.id, nil)
+			assert.NoError(t, err)
+			r = mux.SetURLVars(r, map[string]string{"id": tt.id})
+			w := httptest.NewRecorder()
+			iDatabaseMock := mocks.IDatabase{}
+			testSubject := NewHandler(&iDatabaseMock, []IAlgorithm{&mocks.IAlgorithm{}})
+			iDatabaseMock.On("Get", mock.Anything, "image:"+tt.id).Return(tt.getReturned, tt.getError)
+
+			//when
+			testSubject.GetImage(w, r)
+
+			//then
+			iDatabaseMock.AssertNumberOfCalls(t, "Get", tt.assertNoOfGet)
+			assert.Contains(t, w.Body.String(), tt.bodyContains)
+			assert.Equal(t, tt.statusCode, w.Code)
+			if tt.contentType != "" {
+				assert.Equal(t, tt.contentType, w.Header().Get("Content-Type"))
+			}
+		})
+	}
+}
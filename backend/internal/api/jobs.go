@@ -0,0 +1,362 @@
+package api
+
+import (
+	"backend/internal/logging"
+	"backend/internal/metrics"
+	"backend/internal/render"
+	"backend/internal/retention"
+	"backend/internal/structure"
+	"backend/internal/worker"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// newJobID returns the key of a job submitted at timeStamp. Keys start with
+// the second of the submission, which results are sorted and removed by, and
+// end with its nanoseconds and a random suffix, so that jobs submitted within
+// the same second never share a key.
+func newJobID(timeStamp time.Time, algorithm, operation string) (string, error) {
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s%s%s-%09d%s", timeStamp.Format(time.RFC3339), algorithm, operation,
+		timeStamp.Nanosecond(), hex.EncodeToString(suffix)), nil
+}
+
+// resultKeys returns the keys of the results of an operation of the algorithm.
+// Keys of other algorithms or operations which start with the same letters are
+// skipped.
+func (h Handler) resultKeys(ctx context.Context, algorithm, operation string) ([]string, error) {
+	pattern := fmt.Sprintf("20[0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9]Z%s%s*", algorithm, operation)
+	keys, err := h.iDatabase.Keys(ctx, pattern)
+	if err != nil {
+		return nil, err
+	}
+	matching := []string{}
+	for _, key := range keys {
+		if _, name, ok := retention.ParseKey(key); ok && name == algorithm+operation {
+			matching = append(matching, key)
+		}
+	}
+	return matching, nil
+}
+
+//GET /v1/jobs/{id}
+func (h Handler) GetJob(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	url := strings.Replace(h.getJobEndpoint, "{id}", id, 1)
+	if r.URL.Path != url {
+		notFound(w, r)
+		return
+	}
+	logging.AddFields(r.Context(), zap.String("job", id))
+
+	results, err := h.getJob(r.Context(), id)
+	if err != nil {
+		writeStatusError(w, r, err)
+		return
+	}
+	if results.Status == "queued" {
+		results.Position = h.iQueue.Position(id)
+	}
+	jsonResults, err := json.Marshal(results)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "failed to marshal job", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if _, err = fmt.Fprint(w, string(jsonResults)); err != nil {
+		log.Print("failed to write response: " + err.Error())
+	}
+}
+
+//GET /v1/jobs/{id}/render
+func (h Handler) RenderJob(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	id := params["id"]
+	url := strings.Replace(h.renderJobEndpoint, "{id}", id, 1)
+	if r.URL.Path != url {
+		notFound(w, r)
+		return
+	}
+	logging.AddFields(r.Context(), zap.String("job", id))
+
+	opts, format, err := renderOptions(r.URL.Query())
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	results, err := h.getJob(r.Context(), id)
+	if err != nil {
+		writeStatusError(w, r, err)
+		return
+	}
+	if results.Status != "finished" {
+		writeError(w, r, http.StatusConflict, "job "+id+" is "+results.Status, nil)
+		return
+	}
+
+	var detections structure.Detections
+	if err = json.Unmarshal([]byte(results.Result), &detections); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "failed to unmarshal results", nil)
+		return
+	}
+	content := results.Image
+	if results.ImageID != "" {
+		if content, err = h.getImage(r.Context(), results.ImageID); err != nil {
+			writeError(w, r, http.StatusInternalServerError, "failed to read image", err)
+			return
+		}
+	}
+	img, _, err := render.DecodeImage(content)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "failed to decode image", err)
+		return
+	}
+
+	rendered, err := render.Draw(img, detections, opts)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "failed to render results", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/"+format)
+	if err = render.Encode(w, rendered, format); err != nil {
+		log.Print("render: " + err.Error())
+	}
+}
+
+func renderOptions(query url.Values) (render.Options, string, error) {
+	opts := render.Options{Line: render.LineSolid, Width: 2, Classes: map[string]bool{}}
+	format := render.FormatPNG
+	if f := query.Get("format"); f != "" {
+		if f == "jpg" {
+			f = render.FormatJPEG
+		}
+		if f != render.FormatPNG && f != render.FormatJPEG {
+			return render.Options{}, "", errors.New("unsupported format " + f)
+		}
+		format = f
+	}
+	if score := query.Get("score"); score != "" {
+		minScore, err := strconv.ParseFloat(score, 64)
+		if err != nil {
+			return render.Options{}, "", errors.New("invalid score, expected number")
+		}
+		opts.MinScore = minScore
+	}
+	if classes := query.Get("class"); classes != "" {
+		for _, class := range strings.Split(classes, ",") {
+			opts.Classes[strings.TrimSpace(class)] = true
+		}
+	}
+	if line := query.Get("line"); line != "" {
+		if line != render.LineSolid && line != render.LineDashed && line != render.LineDotted {
+			return render.Options{}, "", errors.New("unsupported line style " + line)
+		}
+		opts.Line = line
+	}
+	if width := query.Get("width"); width != "" {
+		lineWidth, err := strconv.Atoi(width)
+		if err != nil || lineWidth < 1 || lineWidth > 20 {
+			return render.Options{}, "", errors.New("invalid width, expected number between 1 and 20")
+		}
+		opts.Width = lineWidth
+	}
+	if masks := query.Get("masks"); masks != "" {
+		showMasks, err := strconv.ParseBool(masks)
+		if err != nil {
+			return render.Options{}, "", errors.New("invalid masks, expected boolean")
+		}
+		opts.Masks = showMasks
+	}
+	return opts, format, nil
+}
+
+func (h Handler) getJob(ctx context.Context, id string) (structure.Results, error) {
+	fromDB, err := h.iDatabase.Get(ctx, id)
+	if err != nil {
+		if err.Error() == "key does not exist" {
+			return structure.Results{}, statusError{http.StatusNotFound, "job " + id + " does not exist"}
+		}
+		return structure.Results{}, err
+	}
+	var results structure.Results
+	if err = json.Unmarshal([]byte(fromDB.(string)), &results); err != nil {
+		return structure.Results{}, errors.New("failed to unmarshal job")
+	}
+	return results, nil
+}
+
+// errUnchanged stops an update of a job which does not have to be changed.
+var errUnchanged = errors.New("job is unchanged")
+
+// updateJob changes the stored job with change. Jobs for which change returns
+// false are not written again.
+func (h Handler) updateJob(ctx context.Context, id string, change func(results *structure.Results) bool) (structure.Results, bool, error) {
+	var results structure.Results
+	err := h.iDatabase.Update(ctx, id, func(fromDB string) (string, error) {
+		results = structure.Results{}
+		if err := json.Unmarshal([]byte(fromDB), &results); err != nil {
+			return "", errors.New("failed to unmarshal job")
+		}
+		if !change(&results) {
+			return "", errUnchanged
+		}
+		jsonResults, err := json.Marshal(results)
+		if err != nil {
+			return "", err
+		}
+		return string(jsonResults), nil
+	})
+	if err == errUnchanged {
+		return results, false, nil
+	}
+	return results, err == nil, err
+}
+
+// dispatch sends a queued job to its algorithm. The job is claimed first by
+// moving it to dispatching, so that it is sent once even when it is queued by
+// several servers, and results of a fast algorithm are not overwritten. It is
+// marked as in progress once the algorithm accepted it.
+func (h Handler) dispatch(ctx context.Context, id string) error {
+	results, started, err := h.updateJob(ctx, id, func(results *structure.Results) bool {
+		if results.Status != "queued" {
+			return false
+		}
+		results.Status = "dispatching"
+		results.Attempts++
+		results.StartedAt = time.Now().Format(time.RFC3339)
+		return true
+	})
+	if err != nil {
+		log.Print("failed to start job " + id + ": " + err.Error())
+		return err
+	}
+	if !started {
+		return errors.New("job " + id + " is " + results.Status)
+	}
+	metrics.JobStarted(results.Algorithm)
+
+	if err = h.sendJob(ctx, id, results); err != nil {
+		if err == worker.ErrStopped {
+			h.requeueJob(ctx, id)
+			return err
+		}
+		h.failJob(ctx, id, "failed to run simulation: "+err.Error())
+		return err
+	}
+	_, _, err = h.updateJob(ctx, id, func(results *structure.Results) bool {
+		if results.Status != "dispatching" {
+			return false
+		}
+		results.Status = "in-progress"
+		return true
+	})
+	if err != nil {
+		log.Print("failed to mark job " + id + " as in progress: " + err.Error())
+	}
+	return nil
+}
+
+// sendJob resolves the model file and image of the job, which are read only
+// now, so that queued jobs do not hold images in memory.
+func (h Handler) sendJob(ctx context.Context, id string, results structure.Results) error {
+	var alg IAlgorithm
+	for _, item := range h.iAlgorithm {
+		if item.GetID() == results.Algorithm {
+			alg = item
+			break
+		}
+	}
+	if alg == nil {
+		return errors.New("algorithm " + results.Algorithm + " does not exist")
+	}
+
+	registry, err := h.getRegistry(ctx)
+	if err != nil {
+		return err
+	}
+	var model structure.Model
+	ok := false
+	if algModels := registry.Algorithms[results.Algorithm]; algModels != nil {
+		model, ok = findVersion(algModels.Models[results.Model], results.ModelVersion)
+	}
+	if !ok {
+		return errors.Errorf("model %s has no version %d", results.Model, results.ModelVersion)
+	}
+	content := results.Image
+	if results.ImageID != "" {
+		if content, err = h.getImage(ctx, results.ImageID); err != nil {
+			return errors.Wrap(err, "failed to read image")
+		}
+	}
+
+	jsonSendData, err := json.Marshal(structure.Body{ID: id, Model: model.File, Image: content})
+	if err != nil {
+		return err
+	}
+	respCode, err := alg.RunSimulation(ctx, results.Operation, jsonSendData)
+	if err == nil && respCode != http.StatusOK {
+		err = errors.Errorf("algorithm responded with status %d", respCode)
+	}
+	return err
+}
+
+// requeueJob returns a job which no worker took before the server stopped to
+// the queue, so that it is sent again after restart without counting as an
+// attempt.
+func (h Handler) requeueJob(ctx context.Context, id string) {
+	_, _, err := h.updateJob(ctx, id, func(results *structure.Results) bool {
+		if results.Status != "dispatching" {
+			return false
+		}
+		results.Status = "queued"
+		results.Attempts--
+		return true
+	})
+	if err != nil {
+		log.Print("failed to queue job " + id + " again: " + err.Error())
+	}
+}
+
+// failJob marks a job which did not finish as failed with the reason.
+func (h Handler) failJob(ctx context.Context, id, reason string) {
+	var previousStatus string
+	results, failed, err := h.updateJob(ctx, id, func(results *structure.Results) bool {
+		previousStatus = results.Status
+		if previousStatus != "queued" && previousStatus != "dispatching" && previousStatus != "in-progress" {
+			return false
+		}
+		results.Status = "error"
+		results.Error = reason
+		return true
+	})
+	if err != nil {
+		log.Print("failed to mark job " + id + " as failed: " + err.Error())
+		return
+	}
+	if !failed {
+		return
+	}
+	h.removePendingJob(ctx, id)
+	if previousStatus == "dispatching" || previousStatus == "in-progress" {
+		metrics.JobFinished(results.Algorithm, results.Status)
+	}
+	log.Print("job " + id + " failed: " + reason)
+}
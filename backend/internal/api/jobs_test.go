@@ -0,0 +1,334 @@
+package api
+
+import (
+	"backend/internal/api/mocks"
+	"backend/internal/structure"
+	"backend/internal/worker"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHandler_RenderJob(t *testing.T) {
+	id := "2009-11-10T20:34:58ZalgIDdemo"
+	var buf bytes.Buffer
+	assert.NoError(t, png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 32, 24))))
+	img := "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes())
+	detections := structure.Detections{Names: []string{"person"}, Scores: []float64{0.9}, BBox: [][]float64{{2, 2, 20, 20}}}
+	jsonDetections, err := json.Marshal(detections)
+	assert.NoError(t, err)
+	finished := structure.Results{Algorithm: "algID", Model: "default", Image: img, Status: "finished", Result: string(jsonDetections)}
+	jsonFinished, err := json.Marshal(finished)
+	assert.NoError(t, err)
+	stored := structure.Results{Algorithm: "algID", Model: "default", ImageID: imageID(img), Status: "finished", Result: string(jsonDetections)}
+	jsonStored, err := json.Marshal(stored)
+	assert.NoError(t, err)
+	inProgress := structure.Results{Algorithm: "algID", Model: "default", Image: img, Status: "in-progress"}
+	jsonInProgress, err := json.Marshal(inProgress)
+	assert.NoError(t, err)
+	tests := []struct {
+		testName      string
+		requestURL    string
+		getReturned   string
+		getError      error
+		imageError    error
+		assertNoOfGet int
+		bodyContains  string
+		contentType   string
+		statusCode    int
+	}{
+		{
+			testName:     "should return 404 when url is wrong",
+			requestURL:   "/v1/jobs/wrong/render",
+			bodyContains: `"code":"not_found"`,
+			statusCode:   http.StatusNotFound,
+		},
+		{
+			testName:     "should return 400 when line style is not supported",
+			requestURL:   "/v1/jobs/" + id + "/render?line=wavy",
+			bodyContains: "unsupported line style wavy",
+			statusCode:   http.StatusBadRequest,
+		},
+		{
+			testName:     "should return 400 when width is out of range",
+			requestURL:   "/v1/jobs/" + id + "/render?width=100",
+			bodyContains: "invalid width",
+			statusCode:   http.StatusBadRequest,
+		},
+		{
+			testName:      "should return 404 when job does not exist",
+			requestURL:    "/v1/jobs/" + id + "/render",
+			getError:      errors.New("key does not exist"),
+			assertNoOfGet: 1,
+			bodyContains:  "job " + id + " does not exist",
+			statusCode:    http.StatusNotFound,
+		},
+		{
+			testName:      "should return 500 when database does not respond",
+			requestURL:    "/v1/jobs/" + id + "/render",
+			getError:      errors.New("database not respond error"),
+			assertNoOfGet: 1,
+			bodyContains:  "database not respond error",
+			statusCode:    http.StatusInternalServerError,
+		},
+		{
+			testName:      "should return 409 when job is not finished",
+			requestURL:    "/v1/jobs/" + id + "/render",
+			getReturned:   string(jsonInProgress),
+			assertNoOfGet: 1,
+			bodyContains:  "job " + id + " is in-progress",
+			statusCode:    http.StatusConflict,
+		},
+		{
+			testName:      "should return png with detections",
+			requestURL:    "/v1/jobs/" + id + "/render?score=0.5&line=dashed",
+			getReturned:   string(jsonFinished),
+			assertNoOfGet: 1,
+			bodyContains:  "\x89PNG",
+			contentType:   "image/png",
+			statusCode:    http.StatusOK,
+		},
+		{
+			testName:      "should render image stored under id",
+			requestURL:    "/v1/jobs/" + id + "/render",
+			getReturned:   string(jsonStored),
+			assertNoOfGet: 2,
+			bodyContains:  "\x89PNG",
+			contentType:   "image/png",
+			statusCode:    http.StatusOK,
+		},
+		{
+			testName:      "should return 500 when image of job can not be read",
+			requestURL:    "/v1/jobs/" + id + "/render",
+			getReturned:   string(jsonStored),
+			imageError:    errors.New("key does not exist"),
+			assertNoOfGet: 2,
+			bodyContains:  "failed to read image",
+			statusCode:    http.StatusInternalServerError,
+		},
+		{
+			testName:      "should return jpeg when requested",
+			requestURL:    "/v1/jobs/" + id + "/render?format=jpg",
+			getReturned:   string(jsonFinished),
+			assertNoOfGet: 1,
+			bodyContains:  "\xff\xd8",
+			contentType:   "image/jpeg",
+			statusCode:    http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			//given
+			r, err := http.NewRequest("GET", tt.requestURL, nil)
+			assert.NoError(t, err)
+			r = mux.SetURLVars(r, map[string]string{"id": id})
+			w := httptest.NewRecorder()
+			iDatabaseMock := mocks.IDatabase{}
+			iAlgorithmMock := mocks.IAlgorithm{}
+			testSubject := NewHandler(&iDatabaseMock, []IAlgorithm{&iAlgorithmMock})
+			iDatabaseMock.On("Get", mock.Anything, id).Return(tt.getReturned, tt.getError)
+			iDatabaseMock.On("Get", mock.Anything, "image:"+imageID(img)).Return(img, tt.imageError)
+
+			//when
+			testSubject.RenderJob(w, r)
+
+			//then
+			iDatabaseMock.AssertNumberOfCalls(t, "Get", tt.assertNoOfGet)
+			assert.Contains(t, w.Body.String(), tt.bodyContains)
+			assert.Equal(t, tt.statusCode, w.Code)
+			if tt.contentType != "" {
+				assert.Equal(t, tt.contentType, w.Header().Get("Content-Type"))
+			}
+		})
+	}
+}
+
+func TestHandler_GetJob(t *testing.T) {
+	id := "2009-11-10T20:34:58ZalgIDdemo"
+	queued := structure.Results{Algorithm: "algID", Model: "default", ImageID: "imageID", Status: "queued"}
+	jsonQueued, err := json.Marshal(queued)
+	assert.NoError(t, err)
+	tests := []struct {
+		testName      string
+		requestURL    string
+		getReturned   string
+		getError      error
+		assertNoOfGet int
+		bodyContains  string
+		statusCode    int
+	}{
+		{
+			testName:     "should return 404 when url is wrong",
+			requestURL:   "/v1/jobs/wrong",
+			bodyContains: `"code":"not_found"`,
+			statusCode:   http.StatusNotFound,
+		},
+		{
+			testName:      "should return 404 when job does not exist",
+			requestURL:    "/v1/jobs/" + id,
+			getError:      errors.New("key does not exist"),
+			assertNoOfGet: 1,
+			bodyContains:  "job " + id + " does not exist",
+			statusCode:    http.StatusNotFound,
+		},
+		{
+			testName:      "should return 500 when database does not respond",
+			requestURL:    "/v1/jobs/" + id,
+			getError:      errors.New("database not respond error"),
+			assertNoOfGet: 1,
+			bodyContains:  "database not respond error",
+			statusCode:    http.StatusInternalServerError,
+		},
+		{
+			testName:      "should return job with its position in queue",
+			requestURL:    "/v1/jobs/" + id,
+			getReturned:   string(jsonQueued),
+			assertNoOfGet: 1,
+			bodyContains:  `"status":"queued","position":3`,
+			statusCode:    http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			//given
+			r, err := http.NewRequest("GET", tt.requestURL, nil)
+			assert.NoError(t, err)
+			r = mux.SetURLVars(r, map[string]string{"id": id})
+			w := httptest.NewRecorder()
+			iDatabaseMock := mocks.IDatabase{}
+			iQueueMock := mocks.IQueue{}
+			testSubject := NewHandler(&iDatabaseMock, []IAlgorithm{&mocks.IAlgorithm{}}).WithQueue(&iQueueMock)
+			iDatabaseMock.On("Get", mock.Anything, id).Return(tt.getReturned, tt.getError)
+			iQueueMock.On("Position", id).Return(3)
+
+			//when
+			testSubject.GetJob(w, r)
+
+			//then
+			iDatabaseMock.AssertNumberOfCalls(t, "Get", tt.assertNoOfGet)
+			assert.Contains(t, w.Body.String(), tt.bodyContains)
+			assert.Equal(t, tt.statusCode, w.Code)
+		})
+	}
+}
+
+func TestHandler_dispatch(t *testing.T) {
+	id := "2009-11-10T20:34:58ZalgIDdemo"
+	startedAt := fixedTime()
+	img := "image"
+	queued := structure.Results{Algorithm: "algID", Operation: "demo", Model: "model", ModelVersion: 2, ImageID: imageID(img), Status: "queued"}
+	jsonRegistry, err := json.Marshal(testRegistry("algID",
+		structure.Model{Name: "model", Version: 1, File: "model.h5", Status: "ready"},
+		structure.Model{Name: "model", Version: 2, File: "model-v2.h5", Status: "ready"}))
+	assert.NoError(t, err)
+	jsonSendData, err := json.Marshal(structure.Body{ID: id, Model: "model-v2.h5", Image: img})
+	assert.NoError(t, err)
+	withStatus := func(results structure.Results, status, reason string) string {
+		results.Status = status
+		results.Error = reason
+		results.Attempts = 1
+		results.StartedAt = startedAt
+		jsonResults, err := json.Marshal(results)
+		assert.NoError(t, err)
+		return string(jsonResults)
+	}
+	jsonRequeued, err := json.Marshal(structure.Results{Algorithm: "algID", Operation: "demo", Model: "model", ModelVersion: 2, ImageID: imageID(img), Status: "queued", StartedAt: startedAt})
+	assert.NoError(t, err)
+	requeued := string(jsonRequeued)
+	tests := []struct {
+		testName                string
+		stored                  structure.Results
+		imageError              error
+		runSimulationReturned   int
+		runSimulationError      error
+		inserted                []string
+		removesPending          bool
+		assertNoOfRunSimulation int
+		err                     string
+	}{
+		{
+			testName:                "should send model file and image of job and mark it as in progress",
+			stored:                  queued,
+			runSimulationReturned:   http.StatusOK,
+			inserted:                []string{withStatus(queued, "dispatching", ""), withStatus(queued, "in-progress", "")},
+			assertNoOfRunSimulation: 1,
+		},
+		{
+			testName:                "should mark job as failed when algorithm does not accept it",
+			stored:                  queued,
+			runSimulationReturned:   http.StatusInternalServerError,
+			inserted:                []string{withStatus(queued, "dispatching", ""), withStatus(queued, "error", "failed to run simulation: algorithm responded with status 500")},
+			removesPending:          true,
+			assertNoOfRunSimulation: 1,
+			err:                     "algorithm responded with status 500",
+		},
+		{
+			testName:       "should mark job as failed when image can not be read",
+			stored:         queued,
+			imageError:     errors.New("key does not exist"),
+			inserted:       []string{withStatus(queued, "dispatching", ""), withStatus(queued, "error", "failed to run simulation: failed to read image: key does not exist")},
+			removesPending: true,
+			err:            "failed to read image: key does not exist",
+		},
+		{
+			testName:                "should queue job again when no worker took it before pool stopped",
+			stored:                  queued,
+			runSimulationError:      worker.ErrStopped,
+			inserted:                []string{withStatus(queued, "dispatching", ""), requeued},
+			assertNoOfRunSimulation: 1,
+			err:                     worker.ErrStopped.Error(),
+		},
+		{
+			testName: "should not send job which is not queued",
+			stored:   structure.Results{Algorithm: "algID", Status: "finished"},
+			err:      "job " + id + " is finished",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			//given
+			iDatabaseMock := mocks.IDatabase{}
+			iAlgorithmMock := mocks.IAlgorithm{}
+			testSubject := NewHandler(&iDatabaseMock, []IAlgorithm{&iAlgorithmMock})
+			jsonStored, err := json.Marshal(tt.stored)
+			assert.NoError(t, err)
+			iDatabaseMock.On("Get", mock.Anything, id).Return(string(jsonStored), nil)
+			iDatabaseMock.On("Get", mock.Anything, "models").Return(string(jsonRegistry), nil)
+			iDatabaseMock.On("Get", mock.Anything, "image:"+imageID(img)).Return(img, tt.imageError)
+			for _, value := range tt.inserted {
+				iDatabaseMock.On("Set", mock.Anything, id, value).Return(nil).Once()
+			}
+			mockUpdate(&iDatabaseMock, id)
+			iDatabaseMock.On("Delete", mock.Anything, "pending:"+id).Return(nil)
+			iAlgorithmMock.On("GetID").Return("algID")
+			iAlgorithmMock.On("RunSimulation", mock.Anything, "demo", jsonSendData).Return(tt.runSimulationReturned, tt.runSimulationError)
+
+			//when
+			err = testSubject.dispatch(context.Background(), id)
+
+			//then
+			if tt.err != "" {
+				assert.EqualError(t, err, tt.err)
+			} else {
+				assert.NoError(t, err)
+			}
+			deletes := 0
+			if tt.removesPending {
+				deletes++
+			}
+			iDatabaseMock.AssertNumberOfCalls(t, "Set", len(tt.inserted))
+			iDatabaseMock.AssertNumberOfCalls(t, "Delete", deletes)
+			iAlgorithmMock.AssertNumberOfCalls(t, "RunSimulation", tt.assertNoOfRunSimulation)
+		})
+	}
+}
@@ -3,7 +3,9 @@
 package mocks
 
 import (
-	multipart "mime/multipart"
+	context "context"
+
+	io "io"
 
 	mock "github.com/stretchr/testify/mock"
 )
@@ -27,20 +29,20 @@ func (_m *IAlgorithm) GetID() string {
 	return r0
 }
 
-// RunSimulation provides a mock function with given fields: opType, data
-func (_m *IAlgorithm) RunSimulation(opType string, data []byte) (int, error) {
-	ret := _m.Called(opType, data)
+// RunSimulation provides a mock function with given fields: ctx, opType, data
+func (_m *IAlgorithm) RunSimulation(ctx context.Context, opType string, data []byte) (int, error) {
+	ret := _m.Called(ctx, opType, data)
 
 	var r0 int
-	if rf, ok := ret.Get(0).(func(string, []byte) int); ok {
-		r0 = rf(opType, data)
+	if rf, ok := ret.Get(0).(func(context.Context, string, []byte) int); ok {
+		r0 = rf(ctx, opType, data)
 	} else {
 		r0 = ret.Get(0).(int)
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(string, []byte) error); ok {
-		r1 = rf(opType, data)
+	if rf, ok := ret.Get(1).(func(context.Context, string, []byte) error); ok {
+		r1 = rf(ctx, opType, data)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -48,13 +50,13 @@ func (_m *IAlgorithm) RunSimulation(opType string, data []byte) (int, error) {
 	return r0, r1
 }
 
-// UploadModel provides a mock function with given fields: modelFile, modelHeader
-func (_m *IAlgorithm) UploadModel(modelFile multipart.File, modelHeader *multipart.FileHeader) error {
-	ret := _m.Called(modelFile, modelHeader)
+// UploadModel provides a mock function with given fields: ctx, name, model
+func (_m *IAlgorithm) UploadModel(ctx context.Context, name string, model io.Reader) error {
+	ret := _m.Called(ctx, name, model)
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(multipart.File, *multipart.FileHeader) error); ok {
-		r0 = rf(modelFile, modelHeader)
+	if rf, ok := ret.Get(0).(func(context.Context, string, io.Reader) error); ok {
+		r0 = rf(ctx, name, model)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -2,20 +2,52 @@
 
 package mocks
 
-import mock "github.com/stretchr/testify/mock"
+import (
+	context "context"
+
+	mock "github.com/stretchr/testify/mock"
+)
 
 // IDatabase is an autogenerated mock type for the IDatabase type
 type IDatabase struct {
 	mock.Mock
 }
 
-// Get provides a mock function with given fields: key
-func (_m *IDatabase) Get(key string) (interface{}, error) {
-	ret := _m.Called(key)
+// Create provides a mock function with given fields: ctx, key, value
+func (_m *IDatabase) Create(ctx context.Context, key string, value string) error {
+	ret := _m.Called(ctx, key, value)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) error); ok {
+		r0 = rf(ctx, key, value)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// Delete provides a mock function with given fields: ctx, key
+func (_m *IDatabase) Delete(ctx context.Context, key string) error {
+	ret := _m.Called(ctx, key)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, key)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// Get provides a mock function with given fields: ctx, key
+func (_m *IDatabase) Get(ctx context.Context, key string) (interface{}, error) {
+	ret := _m.Called(ctx, key)
 
 	var r0 interface{}
-	if rf, ok := ret.Get(0).(func(string) interface{}); ok {
-		r0 = rf(key)
+	if rf, ok := ret.Get(0).(func(context.Context, string) interface{}); ok {
+		r0 = rf(ctx, key)
 	} else {
 		if ret.Get(0) != nil {
 			r0 = ret.Get(0).(interface{})
@@ -23,8 +55,8 @@ func (_m *IDatabase) Get(key string) (interface{}, error) {
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(string) error); ok {
-		r1 = rf(key)
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, key)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -32,13 +64,13 @@ func (_m *IDatabase) Get(key string) (interface{}, error) {
 	return r0, r1
 }
 
-// Keys provides a mock function with given fields: pattern
-func (_m *IDatabase) Keys(pattern string) ([]string, error) {
-	ret := _m.Called(pattern)
+// Keys provides a mock function with given fields: ctx, pattern
+func (_m *IDatabase) Keys(ctx context.Context, pattern string) ([]string, error) {
+	ret := _m.Called(ctx, pattern)
 
 	var r0 []string
-	if rf, ok := ret.Get(0).(func(string) []string); ok {
-		r0 = rf(pattern)
+	if rf, ok := ret.Get(0).(func(context.Context, string) []string); ok {
+		r0 = rf(ctx, pattern)
 	} else {
 		if ret.Get(0) != nil {
 			r0 = ret.Get(0).([]string)
@@ -46,8 +78,8 @@ func (_m *IDatabase) Keys(pattern string) ([]string, error) {
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(string) error); ok {
-		r1 = rf(pattern)
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, pattern)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -55,13 +87,27 @@ func (_m *IDatabase) Keys(pattern string) ([]string, error) {
 	return r0, r1
 }
 
-// Set provides a mock function with given fields: key, value
-func (_m *IDatabase) Set(key string, value string) error {
-	ret := _m.Called(key, value)
+// Set provides a mock function with given fields: ctx, key, value
+func (_m *IDatabase) Set(ctx context.Context, key string, value string) error {
+	ret := _m.Called(ctx, key, value)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) error); ok {
+		r0 = rf(ctx, key, value)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// Update provides a mock function with given fields: ctx, key, update
+func (_m *IDatabase) Update(ctx context.Context, key string, update func(string) (string, error)) error {
+	ret := _m.Called(ctx, key, update)
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(string, string) error); ok {
-		r0 = rf(key, value)
+	if rf, ok := ret.Get(0).(func(context.Context, string, func(string) (string, error)) error); ok {
+		r0 = rf(ctx, key, update)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -0,0 +1,268 @@
+package api
+
+import (
+	"backend/internal/structure"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"log"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	modelUploading = "uploading"
+	modelReady     = "ready"
+	modelFailed    = "failed"
+)
+
+type modelSummary struct {
+	structure.Model
+	Versions int  `json:"versions"`
+	Default  bool `json:"default"`
+}
+
+// algorithmSummary describes an algorithm served by the backend. Workers is
+// set for algorithms whose simulations are leased by workers.
+type algorithmSummary struct {
+	ID      string `json:"id"`
+	Default string `json:"default"`
+	Models  int    `json:"models"`
+	Workers bool   `json:"workers"`
+}
+
+type modelDetails struct {
+	Name     string            `json:"name"`
+	Default  bool              `json:"default"`
+	Versions []structure.Model `json:"versions"`
+}
+
+//GET /v1/algorithms
+func (h Handler) GetAlgorithms(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != h.getAlgorithmsEndpoint {
+		notFound(w, r)
+		return
+	}
+
+	registry, err := h.getRegistry(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "failed to read models", err)
+		return
+	}
+	summaries := []algorithmSummary{}
+	for _, alg := range h.iAlgorithm {
+		summary := algorithmSummary{ID: alg.GetID()}
+		_, summary.Workers = alg.(IWorkerPool)
+		if algModels := registry.Algorithms[summary.ID]; algModels != nil {
+			summary.Default = algModels.Default
+			summary.Models = len(modelSummaries(algModels))
+		}
+		summaries = append(summaries, summary)
+	}
+
+	jsonAlgorithms, err := json.Marshal(summaries)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "failed to marshal algorithms", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if _, err = fmt.Fprint(w, string(jsonAlgorithms)); err != nil {
+		log.Print("failed to write response: " + err.Error())
+	}
+}
+
+//GET /v1/models/{alg}/{name}
+func (h Handler) GetModel(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	id := params["alg"]
+	name := params["name"]
+	url := strings.Replace(strings.Replace(h.getModelEndpoint, "{alg}", id, 1), "{name}", name, 1)
+	if r.URL.Path != url {
+		notFound(w, r)
+		return
+	}
+
+	registry, err := h.getRegistry(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "failed to read models", err)
+		return
+	}
+	algModels := registry.Algorithms[id]
+	if algModels == nil || len(algModels.Models[name]) == 0 {
+		writeError(w, r, http.StatusNotFound, "model "+name+" does not exist", nil)
+		return
+	}
+
+	var response interface{} = modelDetails{Name: name, Default: algModels.Default == name, Versions: algModels.Models[name]}
+	if v := r.URL.Query().Get("version"); v != "" {
+		version, err := strconv.Atoi(v)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, "invalid version, expected number", nil)
+			return
+		}
+		model, ok := findVersion(algModels.Models[name], version)
+		if !ok {
+			writeError(w, r, http.StatusNotFound, fmt.Sprintf("model %s has no version %d", name, version), nil)
+			return
+		}
+		response = model
+	}
+
+	jsonResponse, err := json.Marshal(response)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "failed to marshal response", err)
+		return
+	}
+	if _, err = fmt.Fprint(w, string(jsonResponse)); err != nil {
+		log.Print("failed to write response: " + err.Error())
+		return
+	}
+}
+
+//PUT /v1/models/{alg}/{name}/default
+func (h Handler) SetDefaultModel(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	id := params["alg"]
+	name := params["name"]
+	url := strings.Replace(strings.Replace(h.putDefaultModelEndpoint, "{alg}", id, 1), "{name}", name, 1)
+	if r.URL.Path != url {
+		notFound(w, r)
+		return
+	}
+
+	entry := h.auditEntry(r, actionDefaultModel, id)
+	err := h.updateRegistry(r.Context(), func(registry *structure.Registry) error {
+		algModels := registry.Algorithms[id]
+		if algModels == nil {
+			return statusError{http.StatusNotFound, "model " + name + " does not exist"}
+		}
+		if _, ok := resolveModel(algModels, name, 0); !ok {
+			return statusError{http.StatusNotFound, "model " + name + " has no ready version"}
+		}
+		entry.Before = map[string]string{"default": algModels.Default}
+		algModels.Default = name
+		return nil
+	})
+	if err != nil {
+		writeStatusError(w, r, err)
+		return
+	}
+	entry.After = map[string]string{"default": name}
+	h.audit(r.Context(), entry)
+}
+
+func (h Handler) getRegistry(ctx context.Context) (structure.Registry, error) {
+	fromDB, err := h.iDatabase.Get(ctx, "models")
+	if err != nil {
+		return structure.Registry{}, err
+	}
+	var registry structure.Registry
+	if err = json.Unmarshal([]byte(fromDB.(string)), &registry); err != nil {
+		return structure.Registry{}, errors.New("failed to unmarshal models")
+	}
+	if registry.Algorithms == nil {
+		registry.Algorithms = map[string]*structure.AlgorithmModels{}
+	}
+	return registry, nil
+}
+
+// updateRegistry atomically applies update to the stored model registry.
+func (h Handler) updateRegistry(ctx context.Context, update func(registry *structure.Registry) error) error {
+	return h.iDatabase.Update(ctx, "models", func(fromDB string) (string, error) {
+		var registry structure.Registry
+		if err := json.Unmarshal([]byte(fromDB), &registry); err != nil {
+			return "", errors.New("failed to unmarshal models")
+		}
+		if registry.Algorithms == nil {
+			registry.Algorithms = map[string]*structure.AlgorithmModels{}
+		}
+		if err := update(&registry); err != nil {
+			return "", err
+		}
+		jsonRegistry, err := json.Marshal(registry)
+		if err != nil {
+			return "", err
+		}
+		return string(jsonRegistry), nil
+	})
+}
+
+func (h Handler) setRegistry(ctx context.Context, registry structure.Registry) error {
+	jsonRegistry, err := json.Marshal(registry)
+	if err != nil {
+		return err
+	}
+	return h.iDatabase.Set(ctx, "models", string(jsonRegistry))
+}
+
+// resolveModel returns the requested version of a model, or its latest ready
+// version when version is 0.
+func resolveModel(algModels *structure.AlgorithmModels, name string, version int) (structure.Model, bool) {
+	versions := algModels.Models[name]
+	if version != 0 {
+		model, ok := findVersion(versions, version)
+		return model, ok && model.Status == modelReady
+	}
+	for i := len(versions) - 1; i >= 0; i-- {
+		if versions[i].Status == modelReady {
+			return versions[i], true
+		}
+	}
+	return structure.Model{}, false
+}
+
+func findVersion(versions []structure.Model, version int) (structure.Model, bool) {
+	for _, model := range versions {
+		if model.Version == version {
+			return model, true
+		}
+	}
+	return structure.Model{}, false
+}
+
+// versionFile names the file stored by the algorithm container, so that
+// uploading a new version does not overwrite the weights of older ones. The
+// version is separated with "@", which model names can not contain, so the
+// file of a version never is the file of another model. Versions uploaded
+// before were stored as name-vN and keep their files.
+func versionFile(name string, version int) string {
+	if version <= 1 {
+		return name
+	}
+	ext := filepath.Ext(name)
+	return fmt.Sprintf("%s@v%d%s", strings.TrimSuffix(name, ext), version, ext)
+}
+
+func modelSummaries(algModels *structure.AlgorithmModels) []modelSummary {
+	summaries := []modelSummary{}
+	if algModels == nil {
+		return summaries
+	}
+	for name, versions := range algModels.Models {
+		if len(versions) == 0 {
+			continue
+		}
+		summaries = append(summaries, modelSummary{
+			Model:    versions[len(versions)-1],
+			Versions: len(versions),
+			Default:  algModels.Default == name,
+		})
+	}
+	sort.Slice(summaries, func(i, j int) bool { return summaries[i].Name < summaries[j].Name })
+	return summaries
+}
+
+func splitList(value string) []string {
+	list := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
@@ -0,0 +1,261 @@
+package api
+
+import (
+	"backend/internal/api/mocks"
+	"backend/internal/structure"
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHandler_GetAlgorithms(t *testing.T) {
+	model := structure.Model{Name: "model.h5", Version: 1, File: "model.h5", Status: "ready"}
+	jsonAllModels, err := json.Marshal(testRegistry("alg1", model, structure.Model{Name: "default", Version: 1, Status: "ready"}))
+	assert.NoError(t, err)
+	tests := []struct {
+		testName     string
+		requestURL   string
+		getReturned  string
+		getError     error
+		bodyContains string
+		statusCode   int
+	}{
+		{
+			testName:     "should return 404 when url is wrong",
+			requestURL:   "/v1/algorithms/wrong",
+			bodyContains: `"code":"not_found"`,
+			statusCode:   http.StatusNotFound,
+		},
+		{
+			testName:     "should return 500 when database does not respond",
+			requestURL:   "/v1/algorithms",
+			getError:     errors.New("database not respond error"),
+			bodyContains: "database not respond error",
+			statusCode:   http.StatusInternalServerError,
+		},
+		{
+			testName:     "should return algorithms with their models",
+			requestURL:   "/v1/algorithms",
+			getReturned:  string(jsonAllModels),
+			bodyContains: `[{"id":"alg1","default":"default","models":2,"workers":false},{"id":"alg2","default":"","models":0,"workers":true}]`,
+			statusCode:   http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			//given
+			r, err := http.NewRequest("GET", tt.requestURL, nil)
+			assert.NoError(t, err)
+			w := httptest.NewRecorder()
+			iDatabaseMock := mocks.IDatabase{}
+			iAlgorithmMock := mocks.IAlgorithm{}
+			iWorkerPoolMock := mocks.IWorkerPool{}
+			testSubject := NewHandler(&iDatabaseMock, []IAlgorithm{&iAlgorithmMock, &iWorkerPoolMock})
+			iAlgorithmMock.On("GetID").Return("alg1")
+			iWorkerPoolMock.On("GetID").Return("alg2")
+			iDatabaseMock.On("Get", mock.Anything, "models").Return(tt.getReturned, tt.getError)
+
+			//when
+			testSubject.GetAlgorithms(w, r)
+
+			//then
+			assert.Contains(t, w.Body.String(), tt.bodyContains)
+			assert.Equal(t, tt.statusCode, w.Code)
+		})
+	}
+}
+
+func TestHandler_GetModel(t *testing.T) {
+	id := "algID"
+	name := "model.h5"
+	modelV1 := structure.Model{Name: name, Version: 1, File: name, Status: "ready"}
+	modelV2 := structure.Model{Name: name, Version: 2, File: "model-v2.h5", Status: "failed"}
+	jsonAllModels, err := json.Marshal(testRegistry(id, modelV1, modelV2))
+	assert.NoError(t, err)
+	jsonDetails, err := json.Marshal(modelDetails{Name: name, Versions: []structure.Model{modelV1, modelV2}})
+	assert.NoError(t, err)
+	jsonModelV2, err := json.Marshal(modelV2)
+	assert.NoError(t, err)
+	tests := []struct {
+		testName      string
+		requestURL    string
+		name          string
+		getReturned   string
+		getError      error
+		assertNoOfGet int
+		bodyContains  string
+		statusCode    int
+	}{
+		{
+			testName:     "should return 404 when url is wrong",
+			requestURL:   "/v1/models/" + id + "/wrong/url",
+			name:         name,
+			bodyContains: `"code":"not_found"`,
+			statusCode:   http.StatusNotFound,
+		},
+		{
+			testName:      "should return 500 when database does not respond",
+			requestURL:    "/v1/models/" + id + "/" + name,
+			name:          name,
+			getError:      errors.New("database not respond error"),
+			assertNoOfGet: 1,
+			bodyContains:  "database not respond error",
+			statusCode:    http.StatusInternalServerError,
+		},
+		{
+			testName:      "should return 404 when model does not exist",
+			requestURL:    "/v1/models/" + id + "/other.h5",
+			name:          "other.h5",
+			getReturned:   string(jsonAllModels),
+			assertNoOfGet: 1,
+			bodyContains:  "model other.h5 does not exist",
+			statusCode:    http.StatusNotFound,
+		},
+		{
+			testName:      "should return 404 when version does not exist",
+			requestURL:    "/v1/models/" + id + "/" + name + "?version=3",
+			name:          name,
+			getReturned:   string(jsonAllModels),
+			assertNoOfGet: 1,
+			bodyContains:  "model model.h5 has no version 3",
+			statusCode:    http.StatusNotFound,
+		},
+		{
+			testName:      "should return 200 with version history",
+			requestURL:    "/v1/models/" + id + "/" + name,
+			name:          name,
+			getReturned:   string(jsonAllModels),
+			assertNoOfGet: 1,
+			bodyContains:  string(jsonDetails),
+			statusCode:    http.StatusOK,
+		},
+		{
+			testName:      "should return 200 with requested version",
+			requestURL:    "/v1/models/" + id + "/" + name + "?version=2",
+			name:          name,
+			getReturned:   string(jsonAllModels),
+			assertNoOfGet: 1,
+			bodyContains:  string(jsonModelV2),
+			statusCode:    http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			//given
+			r, err := http.NewRequest("GET", tt.requestURL, nil)
+			assert.NoError(t, err)
+			r = mux.SetURLVars(r, map[string]string{"alg": id, "name": tt.name})
+			w := httptest.NewRecorder()
+			iDatabaseMock := mocks.IDatabase{}
+			testSubject := NewHandler(&iDatabaseMock, []IAlgorithm{&mocks.IAlgorithm{}})
+			iDatabaseMock.On("Get", mock.Anything, "models").Return(tt.getReturned, tt.getError)
+
+			//when
+			testSubject.GetModel(w, r)
+
+			//then
+			iDatabaseMock.AssertNumberOfCalls(t, "Get", tt.assertNoOfGet)
+			assert.Contains(t, w.Body.String(), tt.bodyContains)
+			assert.Equal(t, tt.statusCode, w.Code)
+		})
+	}
+}
+
+func TestHandler_SetDefaultModel(t *testing.T) {
+	id := "algID"
+	ready := structure.Model{Name: "model.h5", Version: 1, File: "model.h5", Status: "ready"}
+	failed := structure.Model{Name: "broken.h5", Version: 1, File: "broken.h5", Status: "failed"}
+	registry := testRegistry(id, ready, failed)
+	jsonAllModels, err := json.Marshal(registry)
+	assert.NoError(t, err)
+	registry.Algorithms[id].Default = "model.h5"
+	jsonNewModels, err := json.Marshal(registry)
+	assert.NoError(t, err)
+	tests := []struct {
+		testName         string
+		name             string
+		insertError      error
+		assertNoOfInsert int
+		bodyContains     string
+		statusCode       int
+	}{
+		{
+			testName:     "should return 404 when model has no ready version",
+			name:         "broken.h5",
+			bodyContains: "model broken.h5 has no ready version",
+			statusCode:   http.StatusNotFound,
+		},
+		{
+			testName:         "should return 500 when failed to insert to db",
+			name:             "model.h5",
+			insertError:      errors.New("failed to insert to db"),
+			assertNoOfInsert: 1,
+			bodyContains:     "failed to insert to db",
+			statusCode:       http.StatusInternalServerError,
+		},
+		{
+			testName:         "should return 200 when default model was changed",
+			name:             "model.h5",
+			assertNoOfInsert: 1,
+			statusCode:       http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			//given
+			r, err := http.NewRequest("PUT", "/v1/models/"+id+"/"+tt.name+"/default", nil)
+			assert.NoError(t, err)
+			r = mux.SetURLVars(r, map[string]string{"alg": id, "name": tt.name})
+			w := httptest.NewRecorder()
+			iDatabaseMock := mocks.IDatabase{}
+			testSubject := NewHandler(&iDatabaseMock, []IAlgorithm{&mocks.IAlgorithm{}})
+			iDatabaseMock.On("Get", mock.Anything, "models").Return(string(jsonAllModels), nil)
+			iDatabaseMock.On("Set", mock.Anything, "models", string(jsonNewModels)).Return(tt.insertError)
+			mockUpdate(&iDatabaseMock, "models")
+
+			//when
+			testSubject.SetDefaultModel(w, r)
+
+			//then
+			iDatabaseMock.AssertNumberOfCalls(t, "Set", tt.assertNoOfInsert)
+			assert.Contains(t, w.Body.String(), tt.bodyContains)
+			assert.Equal(t, tt.statusCode, w.Code)
+		})
+	}
+}
+
+func TestVersionFile(t *testing.T) {
+	tests := []struct {
+		testName string
+		name     string
+		version  int
+		file     string
+	}{
+		{testName: "should keep name of first version", name: "foo.h5", version: 1, file: "foo.h5"},
+		{testName: "should add version before extension", name: "foo.h5", version: 2, file: "foo@v2.h5"},
+		{testName: "should add version to name without extension", name: "foo", version: 3, file: "foo@v3"},
+		{testName: "should not reuse file of model named like a version", name: "foo-v2.h5", version: 1, file: "foo-v2.h5"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			//when
+			file := versionFile(tt.name, tt.version)
+
+			//then
+			assert.Equal(t, tt.file, file)
+		})
+	}
+	t.Run("should never name files of versions like models", func(t *testing.T) {
+		//when
+		file := versionFile("foo.h5", 2)
+
+		//then
+		assert.NotEqual(t, versionFile("foo-v2.h5", 1), file)
+		assert.False(t, modelNameRegexp.MatchString(file))
+	})
+}
@@ -0,0 +1,244 @@
+package api
+
+import (
+	"backend/internal/logging"
+	"backend/internal/metrics"
+	"backend/internal/queue"
+	"backend/internal/structure"
+	"context"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// pendingJobPrefix prefixes the keys of jobs which did not finish yet, so
+// that they can be picked up again after the server restarts. Every job has
+// its own key, so that adding or removing a job does not rewrite the others.
+// The keys are stored through IDatabase like every other record, so that they
+// work with all database drivers, instead of a Redis stream. The status of a
+// job takes the place of acknowledgements: a job keeps its key until it
+// finished.
+const pendingJobPrefix = "pending:"
+
+// pendingJobsKey held the list of pending jobs before every job got its own
+// key. It is only read by migrations.
+const pendingJobsKey = "pending_jobs"
+
+// maxDispatchAttempts limits how many times a job is sent again, when the
+// server stopped before it knew whether the algorithm accepted the job.
+const maxDispatchAttempts = 3
+
+func pendingJobKey(id string) string {
+	return pendingJobPrefix + id
+}
+
+func createPendingJobs(ctx context.Context, h Handler) error {
+	exists, err := h.exists(ctx, pendingJobsKey)
+	if err != nil || exists {
+		return err
+	}
+	jsonIndex, err := json.Marshal(structure.JobIndex{IDs: []string{}})
+	if err != nil {
+		return err
+	}
+	return h.iDatabase.Set(ctx, pendingJobsKey, string(jsonIndex))
+}
+
+// splitPendingJobs moves the jobs of the pending jobs list to their own keys
+// and removes the list.
+func splitPendingJobs(ctx context.Context, h Handler) error {
+	fromDB, err := h.iDatabase.Get(ctx, pendingJobsKey)
+	if err != nil {
+		if err.Error() == "key does not exist" {
+			return nil
+		}
+		return err
+	}
+	var index structure.JobIndex
+	if err = json.Unmarshal([]byte(fromDB.(string)), &index); err != nil {
+		return errors.New("failed to unmarshal pending jobs")
+	}
+	for _, id := range index.IDs {
+		if err = h.addPendingJob(ctx, id); err != nil {
+			return err
+		}
+	}
+	return h.iDatabase.Delete(ctx, pendingJobsKey)
+}
+
+// getPendingJobs returns the ids of pending jobs. Ids start with the time the
+// job was submitted, so they are sorted from the oldest job.
+func (h Handler) getPendingJobs(ctx context.Context) ([]string, error) {
+	keys, err := h.iDatabase.Keys(ctx, pendingJobPrefix+"*")
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(keys)
+	ids := make([]string, 0, len(keys))
+	for _, key := range keys {
+		ids = append(ids, strings.TrimPrefix(key, pendingJobPrefix))
+	}
+	return ids, nil
+}
+
+func (h Handler) addPendingJob(ctx context.Context, id string) error {
+	err := h.iDatabase.Create(ctx, pendingJobKey(id), id)
+	if err != nil && err.Error() == "key already exists" {
+		return nil
+	}
+	return err
+}
+
+// removePendingJob drops a finished job from the pending jobs. Failures are
+// only logged, a job left pending is dropped when the server restarts.
+func (h Handler) removePendingJob(ctx context.Context, id string) {
+	err := h.iDatabase.Delete(ctx, pendingJobKey(id))
+	if err != nil && err.Error() != "key does not exist" {
+		log.Print("failed to remove job " + id + " from pending jobs: " + err.Error())
+	}
+}
+
+// queueJob returns the queue entry of a stored job. Jobs are read again when
+// they are dispatched, so the entry only holds what the queue orders by.
+func (h Handler) queueJob(ctx context.Context, id string, results structure.Results) queue.Job {
+	return queue.Job{
+		ID:        id,
+		Algorithm: results.Algorithm,
+		Priority:  results.Priority,
+		Run:       func() error { return h.dispatch(ctx, id) },
+		Abandon:   func(reason error) { h.failJob(ctx, id, reason.Error()) },
+	}
+}
+
+// ResumeJobs picks up jobs stored by a previous run of the server. Queued jobs
+// are queued again. Jobs which were being sent when the server stopped are
+// reconciled with their algorithm. Jobs accepted by their algorithm keep their
+// slot until the algorithm reports the results or the rest of the timeout
+// passes, except for jobs leased by workers, which are offered to workers
+// again.
+func (h Handler) ResumeJobs(ctx context.Context) error {
+	ids, err := h.getPendingJobs(ctx)
+	if err != nil {
+		return err
+	}
+
+	resumed := 0
+	for _, id := range ids {
+		results, err := h.getJob(ctx, id)
+		if err != nil {
+			if _, ok := err.(statusError); ok {
+				h.removePendingJob(ctx, id)
+				continue
+			}
+			return err
+		}
+		jobCtx := logging.WithRequestID(context.Background(), results.RequestID)
+		if results.Status == "in-progress" && h.workerPool(results.Algorithm) != nil {
+			if results, err = h.reofferJob(ctx, id); err != nil {
+				return err
+			}
+		}
+		if results.Status == "dispatching" {
+			if results, err = h.reconcileJob(ctx, id, results.Algorithm); err != nil {
+				return err
+			}
+		}
+
+		switch results.Status {
+		case "queued":
+			if _, err = h.iQueue.Push(h.queueJob(jobCtx, id, results)); err != nil {
+				return err
+			}
+		case "in-progress":
+			// jobs stored without a start time keep their slot since they
+			// were submitted
+			startedAt, err := time.Parse(time.RFC3339, results.StartedAt)
+			if err != nil {
+				startedAt, _ = time.Parse(time.RFC3339, results.TimeStamp)
+			}
+			h.iQueue.Resume(h.queueJob(jobCtx, id, results), startedAt)
+			metrics.JobResumed(results.Algorithm)
+		default:
+			h.removePendingJob(ctx, id)
+			continue
+		}
+		resumed++
+	}
+	if resumed > 0 {
+		log.Print("resumed " + strconv.Itoa(resumed) + " jobs")
+	}
+	return nil
+}
+
+// reofferJob queues a job which a worker leased before the server restarted
+// again. Leases are only kept in memory by the worker pool, so the worker can
+// not report the results of the job any more and it is offered once more.
+func (h Handler) reofferJob(ctx context.Context, id string) (structure.Results, error) {
+	results, _, err := h.updateJob(ctx, id, func(results *structure.Results) bool {
+		if results.Status != "in-progress" {
+			return false
+		}
+		results.Status = "queued"
+		return true
+	})
+	return results, err
+}
+
+// reconcileJob settles a job whose dispatch outcome is unknown. Algorithms
+// which report the status of simulations are asked whether they received the
+// job: received jobs are in progress, others are queued again without
+// counting the attempt. When the algorithm can not tell, the job is queued
+// again, as the algorithm may not have received it, or marked as failed when
+// it was sent maxDispatchAttempts times already.
+func (h Handler) reconcileJob(ctx context.Context, id, algorithm string) (structure.Results, error) {
+	received, known := h.dispatchOutcome(ctx, id, algorithm)
+	results, changed, err := h.updateJob(ctx, id, func(results *structure.Results) bool {
+		if results.Status != "dispatching" {
+			return false
+		}
+		switch {
+		case known && received:
+			results.Status = "in-progress"
+		case known:
+			results.Status = "queued"
+			results.Attempts--
+		case results.Attempts >= maxDispatchAttempts:
+			results.Status = "error"
+			results.Error = "dispatch outcome unknown after " + strconv.Itoa(results.Attempts) + " attempts"
+		default:
+			results.Status = "queued"
+		}
+		return true
+	})
+	if err != nil {
+		return structure.Results{}, err
+	}
+	if changed && results.Status == "error" {
+		metrics.JobFinished(results.Algorithm, results.Status)
+		log.Print("job " + id + " failed: " + results.Error)
+	}
+	return results, nil
+}
+
+// dispatchOutcome asks the algorithm whether it received the job. known is
+// false when the algorithm does not report the status of simulations or could
+// not be asked.
+func (h Handler) dispatchOutcome(ctx context.Context, id, algorithm string) (received, known bool) {
+	for _, item := range h.iAlgorithm {
+		reporter, ok := item.(IStatusReporter)
+		if !ok || reporter.GetID() != algorithm {
+			continue
+		}
+		received, err := reporter.GetStatus(ctx, id)
+		if err != nil {
+			log.Print("failed to get status of job " + id + ": " + err.Error())
+			return false, false
+		}
+		return received, true
+	}
+	return false, false
+}
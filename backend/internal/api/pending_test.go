@@ -0,0 +1,220 @@
+package api
+
+import (
+	"backend/internal/api/mocks"
+	"backend/internal/queue"
+	"backend/internal/structure"
+	"context"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"testing"
+	"time"
+)
+
+func TestHandler_ResumeJobs(t *testing.T) {
+	startedAt := time.Date(2009, 11, 10, 20, 34, 58, 0, time.UTC)
+	job := func(status string, attempts int) structure.Results {
+		return structure.Results{Algorithm: "algID", Status: status, Attempts: attempts, StartedAt: startedAt.Format(time.RFC3339)}
+	}
+	tests := []struct {
+		testName    string
+		pending     []string
+		stored      map[string]structure.Results
+		pendingErr  error
+		updated     map[string]string
+		uncounted   bool
+		received    bool
+		statusErr   error
+		pushed      []string
+		resumed     []string
+		leftPending []string
+		err         string
+	}{
+		{
+			testName: "should queue jobs again and resume jobs which are in progress",
+			pending:  []string{"1", "2", "3"},
+			stored: map[string]structure.Results{
+				"1": job("queued", 0),
+				"2": job("in-progress", 1),
+				"3": job("queued", 0),
+			},
+			pushed:      []string{"1", "3"},
+			resumed:     []string{"2"},
+			leftPending: []string{"1", "2", "3"},
+		},
+		{
+			testName: "should resume job without start time since it was submitted",
+			pending:  []string{"1"},
+			stored: map[string]structure.Results{
+				"1": {Algorithm: "algID", Status: "in-progress", Attempts: 1, TimeStamp: startedAt.Format(time.RFC3339)},
+			},
+			resumed:     []string{"1"},
+			leftPending: []string{"1"},
+		},
+		{
+			testName: "should offer jobs leased by workers again",
+			pending:  []string{"1"},
+			stored: map[string]structure.Results{
+				"1": {Algorithm: "alg2", Status: "in-progress", Attempts: 1, StartedAt: startedAt.Format(time.RFC3339)},
+			},
+			updated: map[string]string{
+				"1": "queued",
+			},
+			pushed:      []string{"1"},
+			leftPending: []string{"1"},
+		},
+		{
+			testName: "should drop jobs which finished or do not exist",
+			pending:  []string{"1", "2", "3"},
+			stored: map[string]structure.Results{
+				"1": job("finished", 1),
+				"3": job("error", 1),
+			},
+			leftPending: []string{},
+		},
+		{
+			testName: "should send job again when its dispatch outcome is unknown",
+			pending:  []string{"1"},
+			stored: map[string]structure.Results{
+				"1": job("dispatching", 1),
+			},
+			updated: map[string]string{
+				"1": "queued",
+			},
+			pushed:      []string{"1"},
+			leftPending: []string{"1"},
+		},
+		{
+			testName: "should resume job which its algorithm received",
+			pending:  []string{"1"},
+			stored: map[string]structure.Results{
+				"1": {Algorithm: "alg3", Status: "dispatching", Attempts: 1, StartedAt: startedAt.Format(time.RFC3339)},
+			},
+			updated: map[string]string{
+				"1": "in-progress",
+			},
+			received:    true,
+			resumed:     []string{"1"},
+			leftPending: []string{"1"},
+		},
+		{
+			testName: "should send job again without counting attempt when its algorithm did not receive it",
+			pending:  []string{"1"},
+			stored: map[string]structure.Results{
+				"1": {Algorithm: "alg3", Status: "dispatching", Attempts: maxDispatchAttempts},
+			},
+			updated: map[string]string{
+				"1": "queued",
+			},
+			uncounted:   true,
+			pushed:      []string{"1"},
+			leftPending: []string{"1"},
+		},
+		{
+			testName: "should send job again when its algorithm can not be asked",
+			pending:  []string{"1"},
+			stored: map[string]structure.Results{
+				"1": {Algorithm: "alg3", Status: "dispatching", Attempts: 1},
+			},
+			updated: map[string]string{
+				"1": "queued",
+			},
+			statusErr:   errors.New("connection refused"),
+			pushed:      []string{"1"},
+			leftPending: []string{"1"},
+		},
+		{
+			testName: "should mark job as failed when it was sent too many times",
+			pending:  []string{"1"},
+			stored: map[string]structure.Results{
+				"1": job("dispatching", maxDispatchAttempts),
+			},
+			updated: map[string]string{
+				"1": "error",
+			},
+			leftPending: []string{},
+		},
+		{
+			testName:   "should return error when pending jobs can not be read",
+			pendingErr: errors.New("failed to get pending jobs"),
+			err:        "failed to get pending jobs",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			//given
+			iDatabaseMock := mocks.IDatabase{}
+			iQueueMock := mocks.IQueue{}
+			iWorkerPoolMock := mocks.IWorkerPool{}
+			iStatusReporterMock := mocks.IStatusReporter{}
+			testSubject := NewHandler(&iDatabaseMock, []IAlgorithm{&iWorkerPoolMock, &iStatusReporterMock}).WithQueue(&iQueueMock)
+			iWorkerPoolMock.On("GetID").Return("alg2")
+			iStatusReporterMock.On("GetID").Return("alg3")
+			iStatusReporterMock.On("GetStatus", mock.Anything, mock.Anything).Return(tt.received, tt.statusErr)
+			// keys are listed in any order
+			var pendingKeys []string
+			for i := len(tt.pending) - 1; i >= 0; i-- {
+				pendingKeys = append(pendingKeys, "pending:"+tt.pending[i])
+			}
+			iDatabaseMock.On("Keys", mock.Anything, "pending:*").Return(pendingKeys, tt.pendingErr)
+			iDatabaseMock.On("Delete", mock.Anything, mock.AnythingOfType("string")).Return(nil)
+			for _, id := range tt.pending {
+				results, ok := tt.stored[id]
+				if !ok {
+					iDatabaseMock.On("Get", mock.Anything, id).Return("", errors.New("key does not exist"))
+					continue
+				}
+				jsonResults, err := json.Marshal(results)
+				assert.NoError(t, err)
+				iDatabaseMock.On("Get", mock.Anything, id).Return(string(jsonResults), nil)
+				if status, ok := tt.updated[id]; ok {
+					results.Status = status
+					if tt.uncounted {
+						results.Attempts--
+					}
+					if status == "error" {
+						results.Error = "dispatch outcome unknown after 3 attempts"
+					}
+					jsonUpdated, err := json.Marshal(results)
+					assert.NoError(t, err)
+					iDatabaseMock.On("Set", mock.Anything, id, string(jsonUpdated)).Return(nil).Once()
+					mockUpdate(&iDatabaseMock, id)
+				}
+			}
+			var pushed, resumed []string
+			iQueueMock.On("Push", mock.Anything).Return(1, nil).Run(func(args mock.Arguments) {
+				pushed = append(pushed, args.Get(0).(queue.Job).ID)
+			})
+			iQueueMock.On("Resume", mock.Anything, startedAt).Run(func(args mock.Arguments) {
+				resumed = append(resumed, args.Get(0).(queue.Job).ID)
+			})
+
+			//when
+			err := testSubject.ResumeJobs(context.Background())
+
+			//then
+			if tt.err != "" {
+				assert.EqualError(t, err, tt.err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.pushed, pushed)
+			assert.Equal(t, tt.resumed, resumed)
+			deleted := map[string]bool{}
+			for _, call := range iDatabaseMock.Calls {
+				if call.Method == "Delete" {
+					deleted[call.Arguments.String(1)] = true
+				}
+			}
+			left := []string{}
+			for _, id := range tt.pending {
+				if !deleted["pending:"+id] {
+					left = append(left, id)
+				}
+			}
+			assert.Equal(t, tt.leftPending, left)
+		})
+	}
+}
@@ -0,0 +1,320 @@
+package api
+
+import (
+	"backend/internal/metrics"
+	"backend/internal/retention"
+	"backend/internal/structure"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// resultsPattern matches keys of the results of all algorithms.
+const resultsPattern = "20[0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9]Z*"
+
+// resultSummaryPrefix prefixes the keys of summaries of results, which hold
+// the fields retention and purges select results by. Results are summarized
+// after their job ended, as they do not change anymore.
+const resultSummaryPrefix = "summary:"
+
+func resultSummaryKey(key string) string {
+	return resultSummaryPrefix + key
+}
+
+// WithRetention sets the policy which decides how long results are kept. By
+// default results are never deleted.
+func (h Handler) WithRetention(policy retention.Policy) Handler {
+	h.retention = policy
+	return h
+}
+
+// WithAdminToken protects the /v1/admin endpoints with a bearer token. They
+// reject all requests when the token is empty.
+func (h Handler) WithAdminToken(token string) Handler {
+	h.adminToken = token
+	return h
+}
+
+// EnforceRetention deletes results which the retention policy does not keep
+// every interval, until ctx is done.
+func (h Handler) EnforceRetention(ctx context.Context, interval time.Duration) {
+	if !h.retention.Enabled() {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if _, err := h.ApplyRetention(ctx); err != nil && ctx.Err() == nil {
+				log.Print("failed to apply retention policy: " + err.Error())
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// ApplyRetention deletes results which the retention policy does not keep and
+// returns their number.
+func (h Handler) ApplyRetention(ctx context.Context) (int, error) {
+	results, err := h.storedResults(ctx)
+	if err != nil {
+		return 0, err
+	}
+	deleted, err := h.deleteResults(ctx, h.retention.Expired(results, time.Now()))
+	metrics.ResultsDeleted("retention", deleted)
+	return deleted, err
+}
+
+// storedResults lists the results of jobs which ended, sorted by their keys.
+// Results are listed from their summaries, so that the sweep does not read
+// whole results. Results without a summary are read once and summarized,
+// summaries of results which were deleted or expired are removed.
+func (h Handler) storedResults(ctx context.Context) ([]retention.Result, error) {
+	keys, err := h.iDatabase.Keys(ctx, resultsPattern)
+	if err != nil {
+		return nil, err
+	}
+	summaryKeys, err := h.iDatabase.Keys(ctx, resultSummaryPrefix+"*")
+	if err != nil {
+		return nil, err
+	}
+	summarized := map[string]bool{}
+	for _, summaryKey := range summaryKeys {
+		summarized[strings.TrimPrefix(summaryKey, resultSummaryPrefix)] = true
+	}
+
+	sort.Strings(keys)
+	stored := map[string]bool{}
+	var results []retention.Result
+	for _, key := range keys {
+		stored[key] = true
+		var result retention.Result
+		var ok bool
+		if summarized[key] {
+			result, ok, err = h.readResultSummary(ctx, key)
+		} else {
+			result, ok, err = h.summarizeResult(ctx, key)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			results = append(results, result)
+		}
+	}
+	for key := range summarized {
+		if !stored[key] {
+			if err = h.deleteResultSummary(ctx, key); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return results, nil
+}
+
+// readResultSummary reads the summary of the result stored under key. It
+// returns false when the summary was removed in the meantime.
+func (h Handler) readResultSummary(ctx context.Context, key string) (retention.Result, bool, error) {
+	fromDB, err := h.iDatabase.Get(ctx, resultSummaryKey(key))
+	if err != nil && err.Error() == "key does not exist" {
+		return retention.Result{}, false, nil
+	}
+	if err != nil {
+		return retention.Result{}, false, err
+	}
+	result, ok := retention.ParseResult(key, fromDB.(string))
+	return result, ok, nil
+}
+
+// summarizeResult reads the result stored under key and stores its summary,
+// when its job ended. Results which expire while they are read, results of
+// jobs which still run and values which are not results are skipped.
+func (h Handler) summarizeResult(ctx context.Context, key string) (retention.Result, bool, error) {
+	fromDB, err := h.iDatabase.Get(ctx, key)
+	if err != nil && err.Error() == "key does not exist" {
+		return retention.Result{}, false, nil
+	}
+	if err != nil {
+		return retention.Result{}, false, err
+	}
+	result, ok := retention.ParseResult(key, fromDB.(string))
+	if !ok || !retention.Terminal(result.Status) {
+		return retention.Result{}, false, nil
+	}
+	jsonSummary, err := json.Marshal(structure.ResultSummary{Algorithm: result.Algorithm, Model: result.Model, Status: result.Status})
+	if err != nil {
+		return retention.Result{}, false, err
+	}
+	err = h.iDatabase.Create(ctx, resultSummaryKey(key), string(jsonSummary))
+	if err != nil && err.Error() != "key already exists" {
+		return retention.Result{}, false, errors.Wrap(err, "failed to summarize "+key)
+	}
+	return result, true, nil
+}
+
+func (h Handler) deleteResultSummary(ctx context.Context, key string) error {
+	err := h.iDatabase.Delete(ctx, resultSummaryKey(key))
+	if err != nil && err.Error() != "key does not exist" {
+		return errors.Wrap(err, "failed to delete summary of "+key)
+	}
+	return nil
+}
+
+func (h Handler) deleteResults(ctx context.Context, keys []string) (int, error) {
+	for i, key := range keys {
+		if err := h.iDatabase.Delete(ctx, key); err != nil {
+			return i, errors.Wrap(err, "failed to delete "+key)
+		}
+		if err := h.deleteResultSummary(ctx, key); err != nil {
+			return i + 1, err
+		}
+	}
+	return len(keys), nil
+}
+
+//POST /v1/admin/purge
+func (h Handler) PurgeResults(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != h.purgeEndpoint {
+		notFound(w, r)
+		return
+	}
+	if !h.authorizeAdmin(w, r) {
+		return
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "failed to read body", err)
+		return
+	}
+	var request structure.PurgeRequest
+	if err = json.Unmarshal(body, &request); err != nil {
+		writeError(w, r, http.StatusBadRequest, "failed to unmarshal body", nil)
+		return
+	}
+	match, err := purgeFilter(request)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	results, err := h.storedResults(r.Context())
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "failed to read results", err)
+		return
+	}
+	purge := structure.PurgeResult{DryRun: request.DryRun, IDs: []string{}}
+	for _, result := range results {
+		if match(result) {
+			purge.IDs = append(purge.IDs, result.Key)
+		}
+	}
+	if !request.DryRun {
+		purge.Deleted, err = h.deleteResults(r.Context(), purge.IDs)
+		metrics.ResultsDeleted("purge", purge.Deleted)
+		entry := h.auditEntry(r, actionPurge, purgeResource(request))
+		entry.Before = map[string]string{"results": strconv.Itoa(len(purge.IDs))}
+		entry.After = map[string]string{"results": strconv.Itoa(len(purge.IDs) - purge.Deleted), "deleted": strconv.Itoa(purge.Deleted)}
+		h.audit(r.Context(), entry)
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, fmt.Sprintf("deleted %d of %d results", purge.Deleted, len(purge.IDs)), err)
+			return
+		}
+	}
+
+	jsonPurge, err := json.Marshal(purge)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "failed to marshal purge", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if _, err = fmt.Fprint(w, string(jsonPurge)); err != nil {
+		log.Print("failed to write response: " + err.Error())
+	}
+}
+
+// purgeResource describes the results selected by a purge in the audit log,
+// e.g. "results?algorithm=alg1&status=error".
+func purgeResource(request structure.PurgeRequest) string {
+	query := url.Values{}
+	for key, value := range map[string]string{
+		"algorithm": request.Algorithm,
+		"operation": request.Operation,
+		"status":    request.Status,
+		"model":     request.Model,
+		"before":    request.Before,
+	} {
+		if value != "" {
+			query.Set(key, value)
+		}
+	}
+	return "results?" + query.Encode()
+}
+
+// purgeFilter returns the function which selects results of the purge. Jobs
+// which did not end are never purged, as the server still waits for them.
+func purgeFilter(request structure.PurgeRequest) (func(result retention.Result) bool, error) {
+	if request == (structure.PurgeRequest{DryRun: request.DryRun}) {
+		return nil, errors.New("at least one filter is required")
+	}
+	if request.Status != "" && !retention.Terminal(request.Status) {
+		return nil, errors.New("invalid status, only finished and error results can be purged")
+	}
+	var before time.Time
+	if request.Before != "" {
+		var err error
+		if before, err = time.Parse(time.RFC3339, request.Before); err != nil {
+			return nil, errors.New("invalid before, expected RFC3339 time")
+		}
+	}
+	return func(result retention.Result) bool {
+		return retention.Terminal(result.Status) &&
+			(request.Algorithm == "" || request.Algorithm == result.Algorithm) &&
+			(request.Operation == "" || request.Operation == result.Operation) &&
+			(request.Status == "" || request.Status == result.Status) &&
+			(request.Model == "" || request.Model == result.Model) &&
+			(before.IsZero() || result.TimeStamp.Before(before))
+	}, nil
+}
+
+// authorizeAdmin checks the bearer token of requests to admin endpoints and
+// replies with 401 when it does not match.
+func (h Handler) authorizeAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if !h.isAdmin(r) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeError(w, r, http.StatusUnauthorized, "invalid admin token", nil)
+		return false
+	}
+	return true
+}
+
+// isAdmin reports whether the request carries the admin token. No request is
+// an admin one when the token is not configured.
+func (h Handler) isAdmin(r *http.Request) bool {
+	return hasToken(r, h.adminToken)
+}
+
+// hasToken reports whether the request carries token as its bearer token.
+// Requests never carry an empty token.
+func hasToken(r *http.Request, token string) bool {
+	if token == "" {
+		return false
+	}
+	bearer := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(bearer), []byte(token)) == 1
+}
@@ -0,0 +1,252 @@
+package api
+
+import (
+	"backend/internal/api/mocks"
+	"backend/internal/retention"
+	"backend/internal/structure"
+	"context"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// storedResults mocks the database with results stored under the keys. The
+// keys listed in summarized have summaries, also when they have no results.
+func storedResults(t *testing.T, iDatabaseMock *mocks.IDatabase, results map[string]structure.Results, summarized ...string) {
+	var keys []string
+	for key, result := range results {
+		jsonResult, err := json.Marshal(result)
+		assert.NoError(t, err)
+		keys = append(keys, key)
+		iDatabaseMock.On("Get", mock.Anything, key).Return(string(jsonResult), nil)
+	}
+	var summaryKeys []string
+	for _, key := range summarized {
+		result := results[key]
+		jsonSummary, err := json.Marshal(structure.ResultSummary{Algorithm: result.Algorithm, Model: result.Model, Status: result.Status})
+		assert.NoError(t, err)
+		summaryKeys = append(summaryKeys, resultSummaryKey(key))
+		iDatabaseMock.On("Get", mock.Anything, resultSummaryKey(key)).Return(string(jsonSummary), nil)
+	}
+	iDatabaseMock.On("Keys", mock.Anything, resultsPattern).Return(keys, nil)
+	iDatabaseMock.On("Keys", mock.Anything, resultSummaryPrefix+"*").Return(summaryKeys, nil)
+	iDatabaseMock.On("Create", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	iDatabaseMock.On("Delete", mock.Anything, mock.MatchedBy(func(key string) bool {
+		return strings.HasPrefix(key, resultSummaryPrefix)
+	})).Return(nil)
+}
+
+func TestHandler_ApplyRetention(t *testing.T) {
+	fixedTime()
+	oldKey := "2009-11-09T20:34:58Zalg1demo"
+	failedKey := "2009-11-10T19:34:58Zalg1demo"
+	runningKey := "2009-11-08T20:34:58Zalg1demo"
+	newKey := "2009-11-10T20:00:00Zalg1demo"
+	deletedKey := "2009-11-07T20:34:58Zalg1demo"
+	results := map[string]structure.Results{
+		oldKey:     {Algorithm: "alg1", Status: "finished"},
+		failedKey:  {Algorithm: "alg1", Status: "error"},
+		runningKey: {Algorithm: "alg1", Status: "in-progress"},
+		newKey:     {Algorithm: "alg1", Status: "finished"},
+	}
+	tests := []struct {
+		testName  string
+		policy    retention.Policy
+		deleteErr error
+		deleted   []string
+		err       string
+	}{
+		{
+			testName: "should delete old results and keep running jobs",
+			policy:   retention.Policy{MaxAge: 12 * time.Hour},
+			deleted:  []string{oldKey},
+		},
+		{
+			testName: "should delete failed and surplus results",
+			policy:   retention.Policy{MaxCount: 1, FinishedOnly: true},
+			deleted:  []string{oldKey, failedKey},
+		},
+		{
+			testName:  "should return error when failed to delete result",
+			policy:    retention.Policy{MaxAge: 12 * time.Hour},
+			deleteErr: errors.New("connection refused"),
+			err:       "failed to delete " + oldKey + ": connection refused",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			//given
+			iDatabaseMock := mocks.IDatabase{}
+			storedResults(t, &iDatabaseMock, results, oldKey, failedKey, deletedKey)
+			iDatabaseMock.On("Delete", mock.Anything, mock.Anything).Return(tt.deleteErr)
+			testSubject := NewHandler(&iDatabaseMock, nil).WithRetention(tt.policy)
+
+			//when
+			deleted, err := testSubject.ApplyRetention(context.Background())
+
+			//then
+			if tt.err != "" {
+				assert.EqualError(t, err, tt.err)
+				assert.Equal(t, 0, deleted)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, len(tt.deleted), deleted)
+			iDatabaseMock.AssertNumberOfCalls(t, "Delete", 2*len(tt.deleted)+1)
+			for _, key := range tt.deleted {
+				iDatabaseMock.AssertCalled(t, "Delete", mock.Anything, key)
+				iDatabaseMock.AssertCalled(t, "Delete", mock.Anything, resultSummaryKey(key))
+			}
+			iDatabaseMock.AssertCalled(t, "Delete", mock.Anything, resultSummaryKey(deletedKey))
+			iDatabaseMock.AssertNotCalled(t, "Get", mock.Anything, oldKey)
+			iDatabaseMock.AssertNotCalled(t, "Get", mock.Anything, failedKey)
+			iDatabaseMock.AssertNumberOfCalls(t, "Create", 1)
+			iDatabaseMock.AssertCalled(t, "Create", mock.Anything, resultSummaryKey(newKey), `{"algorithm":"alg1","model":"","status":"finished"}`)
+		})
+	}
+}
+
+func TestHandler_PurgeResults(t *testing.T) {
+	finishedKey := "2009-11-10T20:34:58Zalg1demo"
+	failedKey := "2009-11-10T20:35:58Zalg1demo"
+	runningKey := "2009-11-10T20:36:58Zalg1demo"
+	otherKey := "2009-11-10T20:34:58Zalg2segmentation"
+	results := map[string]structure.Results{
+		finishedKey: {Algorithm: "alg1", Model: "default", Status: "finished"},
+		failedKey:   {Algorithm: "alg1", Model: "default", Status: "error"},
+		runningKey:  {Algorithm: "alg1", Model: "default", Status: "in-progress"},
+		otherKey:    {Algorithm: "alg2", Model: "coco", Status: "finished"},
+	}
+	tests := []struct {
+		testName      string
+		requestURL    string
+		adminToken    string
+		authorization string
+		body          string
+		response      string
+		statusCode    int
+		deleted       []string
+	}{
+		{
+			testName:   "should return 404 when url is wrong",
+			requestURL: "/v1/admin/purge/wrong",
+			body:       `{"algorithm":"alg1"}`,
+			response:   `{"code":"not_found","message":"not found"}` + "\n",
+			statusCode: http.StatusNotFound,
+		},
+		{
+			testName:      "should return 401 when admin token is not configured",
+			requestURL:    "/v1/admin/purge",
+			adminToken:    "-",
+			authorization: "Bearer secret",
+			body:          `{"algorithm":"alg1"}`,
+			response:      `{"code":"unauthorized","message":"invalid admin token"}` + "\n",
+			statusCode:    http.StatusUnauthorized,
+		},
+		{
+			testName:   "should return 401 when admin token is missing",
+			requestURL: "/v1/admin/purge",
+			body:       `{"algorithm":"alg1"}`,
+			response:   `{"code":"unauthorized","message":"invalid admin token"}` + "\n",
+			statusCode: http.StatusUnauthorized,
+		},
+		{
+			testName:      "should return 401 when admin token is wrong",
+			requestURL:    "/v1/admin/purge",
+			authorization: "Bearer wrong",
+			body:          `{"algorithm":"alg1"}`,
+			response:      `{"code":"unauthorized","message":"invalid admin token"}` + "\n",
+			statusCode:    http.StatusUnauthorized,
+		},
+		{
+			testName:      "should return 400 when no filter is given",
+			requestURL:    "/v1/admin/purge",
+			authorization: "Bearer secret",
+			body:          `{"dryRun":true}`,
+			response:      `{"code":"bad_request","message":"at least one filter is required"}` + "\n",
+			statusCode:    http.StatusBadRequest,
+		},
+		{
+			testName:      "should return 400 when status is not terminal",
+			requestURL:    "/v1/admin/purge",
+			authorization: "Bearer secret",
+			body:          `{"status":"in-progress"}`,
+			response:      `{"code":"bad_request","message":"invalid status, only finished and error results can be purged"}` + "\n",
+			statusCode:    http.StatusBadRequest,
+		},
+		{
+			testName:      "should return 400 when before is invalid",
+			requestURL:    "/v1/admin/purge",
+			authorization: "Bearer secret",
+			body:          `{"before":"yesterday"}`,
+			response:      `{"code":"bad_request","message":"invalid before, expected RFC3339 time"}` + "\n",
+			statusCode:    http.StatusBadRequest,
+		},
+		{
+			testName:      "should list matching results in dry run",
+			requestURL:    "/v1/admin/purge",
+			authorization: "Bearer secret",
+			body:          `{"algorithm":"alg1","dryRun":true}`,
+			response:      `{"dryRun":true,"ids":["` + finishedKey + `","` + failedKey + `"],"deleted":0}`,
+			statusCode:    http.StatusOK,
+		},
+		{
+			testName:      "should delete matching results",
+			requestURL:    "/v1/admin/purge",
+			authorization: "Bearer secret",
+			body:          `{"status":"finished","before":"2009-11-10T20:35:00Z"}`,
+			response:      `{"dryRun":false,"ids":["` + finishedKey + `","` + otherKey + `"],"deleted":2}`,
+			statusCode:    http.StatusOK,
+			deleted:       []string{finishedKey, otherKey},
+		},
+		{
+			testName:      "should filter by operation and model",
+			requestURL:    "/v1/admin/purge",
+			authorization: "Bearer secret",
+			body:          `{"operation":"segmentation","model":"coco"}`,
+			response:      `{"dryRun":false,"ids":["` + otherKey + `"],"deleted":1}`,
+			statusCode:    http.StatusOK,
+			deleted:       []string{otherKey},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			//given
+			r, err := http.NewRequest("POST", tt.requestURL, strings.NewReader(tt.body))
+			assert.NoError(t, err)
+			if tt.authorization != "" {
+				r.Header.Set("Authorization", tt.authorization)
+			}
+			w := httptest.NewRecorder()
+			iDatabaseMock := mocks.IDatabase{}
+			storedResults(t, &iDatabaseMock, results, finishedKey, otherKey)
+			iDatabaseMock.On("Delete", mock.Anything, mock.Anything).Return(nil)
+			testSubject := NewHandler(&iDatabaseMock, nil)
+			if tt.adminToken != "-" {
+				testSubject = testSubject.WithAdminToken(adminToken)
+			}
+
+			//when
+			testSubject.PurgeResults(w, r)
+
+			//then
+			assert.Equal(t, tt.statusCode, w.Code)
+			if tt.statusCode == http.StatusOK {
+				assert.JSONEq(t, tt.response, w.Body.String())
+			} else {
+				assert.Equal(t, tt.response, w.Body.String())
+			}
+			iDatabaseMock.AssertNumberOfCalls(t, "Delete", 2*len(tt.deleted))
+			for _, key := range tt.deleted {
+				iDatabaseMock.AssertCalled(t, "Delete", mock.Anything, key)
+				iDatabaseMock.AssertCalled(t, "Delete", mock.Anything, resultSummaryKey(key))
+			}
+		})
+	}
+}
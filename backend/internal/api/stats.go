@@ -0,0 +1,268 @@
+package api
+
+import (
+	"backend/internal/retention"
+	"backend/internal/structure"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// allWindow counts all stored jobs.
+	allWindow      = "all"
+	defaultWindows = "1h,24h," + allWindow
+	maxWindows     = 10
+)
+
+//GET /v1/stats
+func (h Handler) GetStats(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != h.statsEndpoint {
+		notFound(w, r)
+		return
+	}
+	windows := r.URL.Query().Get("windows")
+	if windows == "" {
+		windows = defaultWindows
+	}
+	names, durations, err := parseWindows(windows)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	stats, err := h.stats(r.Context(), names, durations, time.Now())
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "failed to read stats", err)
+		return
+	}
+	jsonStats, err := json.Marshal(stats)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "failed to marshal stats", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if _, err = fmt.Fprint(w, string(jsonStats)); err != nil {
+		log.Print("failed to write response: " + err.Error())
+	}
+}
+
+// parseWindows reads a comma separated list of durations, like 1h or 30m,
+// and "all". The duration of "all" is 0.
+func parseWindows(value string) ([]string, []time.Duration, error) {
+	names := splitList(value)
+	if len(names) == 0 || len(names) > maxWindows {
+		return nil, nil, fmt.Errorf("invalid windows, expected 1 to %d windows", maxWindows)
+	}
+	durations := make([]time.Duration, len(names))
+	for i, name := range names {
+		if name == allWindow {
+			continue
+		}
+		duration, err := time.ParseDuration(name)
+		if err != nil || duration <= 0 {
+			return nil, nil, fmt.Errorf("invalid window %s, expected a duration or %s", name, allWindow)
+		}
+		durations[i] = duration
+	}
+	return names, durations, nil
+}
+
+// stats counts images and models from the image index and the model
+// registry, and jobs from the stored results. Only results are read, storage
+// is measured by the database.
+func (h Handler) stats(ctx context.Context, windows []string, durations []time.Duration, now time.Time) (structure.Stats, error) {
+	stats := structure.Stats{
+		GeneratedAt: now.UTC().Format(time.RFC3339),
+		Models:      map[string]structure.ModelStats{},
+	}
+	index, err := h.getImageIndex(ctx)
+	if err != nil {
+		return structure.Stats{}, err
+	}
+	stats.Images = len(index.IDs)
+	registry, err := h.getRegistry(ctx)
+	if err != nil {
+		return structure.Stats{}, err
+	}
+	for _, alg := range h.iAlgorithm {
+		stats.Models[alg.GetID()] = structure.ModelStats{}
+	}
+	for alg, algModels := range registry.Algorithms {
+		stats.Models[alg] = modelStats(algModels)
+	}
+
+	jobs := make([]*jobCounter, len(windows))
+	for i := range windows {
+		jobs[i] = &jobCounter{stats: structure.JobStats{Window: windows[i], Statuses: map[string]int{}}, groups: map[structure.JobGroup]int{}}
+		if durations[i] > 0 {
+			jobs[i].since = now.Add(-durations[i])
+			jobs[i].stats.Since = jobs[i].since.UTC().Format(time.RFC3339)
+		}
+	}
+	keys, err := h.iDatabase.Keys(ctx, resultsPattern)
+	if err != nil {
+		return structure.Stats{}, err
+	}
+	for _, key := range keys {
+		value, ok, err := h.lookup(ctx, key)
+		if err != nil {
+			return structure.Stats{}, err
+		}
+		if !ok {
+			continue
+		}
+		result, ok := retention.ParseResult(key, value)
+		if !ok {
+			continue
+		}
+		var results structure.Results
+		if err = json.Unmarshal([]byte(value), &results); err != nil {
+			continue
+		}
+		for _, counter := range jobs {
+			counter.add(result, results)
+		}
+		if results.Status == "in-progress" {
+			stats.OldestInProgress = olderJob(stats.OldestInProgress, key, results, now)
+		}
+	}
+	for _, counter := range jobs {
+		stats.Jobs = append(stats.Jobs, counter.result())
+	}
+
+	if stats.Storage, err = h.storage(ctx); err != nil {
+		return structure.Stats{}, err
+	}
+	return stats, nil
+}
+
+// storage sums the sizes of stored values by the kind of their records. It is
+// nil when the database does not report sizes.
+func (h Handler) storage(ctx context.Context) (map[string]structure.StorageUsage, error) {
+	sizer, ok := h.iDatabase.(IDatabaseSizer)
+	if !ok {
+		return nil, nil
+	}
+	sizes, err := sizer.Sizes(ctx, "*")
+	if err != nil {
+		return nil, err
+	}
+	storage := map[string]structure.StorageUsage{}
+	total := structure.StorageUsage{}
+	for key, size := range sizes {
+		kind := storageKind(key)
+		usage := storage[kind]
+		usage.Keys++
+		usage.Bytes += size
+		storage[kind] = usage
+		total.Keys++
+		total.Bytes += size
+	}
+	storage["total"] = total
+	return storage, nil
+}
+
+func modelStats(algModels *structure.AlgorithmModels) structure.ModelStats {
+	stats := structure.ModelStats{Default: algModels.Default, Models: len(algModels.Models)}
+	for _, versions := range algModels.Models {
+		stats.Versions += len(versions)
+		for _, version := range versions {
+			if version.Status == modelReady {
+				stats.Ready++
+			}
+		}
+	}
+	return stats
+}
+
+// storageKind groups keys by the records stored under them.
+func storageKind(key string) string {
+	if _, _, ok := retention.ParseKey(key); ok {
+		return "results"
+	}
+	switch {
+	case strings.HasPrefix(key, imageKey("")) || key == "images":
+		return "images"
+	case key == "models":
+		return "models"
+	case strings.HasPrefix(key, "upload:"):
+		return "uploads"
+	case strings.HasPrefix(key, "audit:"):
+		return "audit"
+	default:
+		return "other"
+	}
+}
+
+// olderJob returns the job which has been running longer. Jobs are running
+// since their last attempt started, or since they were submitted.
+func olderJob(oldest *structure.JobAge, id string, results structure.Results, now time.Time) *structure.JobAge {
+	startedAt := results.StartedAt
+	if startedAt == "" {
+		startedAt = results.TimeStamp
+	}
+	started, err := time.Parse(time.RFC3339, startedAt)
+	if err != nil {
+		return oldest
+	}
+	age := int64(now.Sub(started) / time.Second)
+	if oldest != nil && oldest.AgeSeconds >= age {
+		return oldest
+	}
+	return &structure.JobAge{ID: id, StartedAt: startedAt, AgeSeconds: age}
+}
+
+// jobCounter counts the jobs of a window.
+type jobCounter struct {
+	since  time.Time
+	stats  structure.JobStats
+	groups map[structure.JobGroup]int
+}
+
+// add counts the job, when it was submitted within the window. Results of
+// jobs which the backend failed itself, e.g. after a timeout, carry the
+// reason in Error, all other finished and failed jobs were reported by their
+// algorithm.
+func (c *jobCounter) add(result retention.Result, results structure.Results) {
+	if !c.since.IsZero() && result.TimeStamp.Before(c.since) {
+		return
+	}
+	c.stats.Total++
+	c.stats.Statuses[result.Status]++
+	c.groups[structure.JobGroup{Algorithm: result.Algorithm, Operation: result.Operation, Status: result.Status}]++
+	if result.Status == "finished" || (result.Status == "error" && results.Error == "") {
+		c.stats.Callbacks++
+		if result.Status == "error" {
+			c.stats.CallbackErrors++
+		}
+	}
+}
+
+func (c *jobCounter) result() structure.JobStats {
+	stats := c.stats
+	stats.Groups = []structure.JobGroup{}
+	for group, count := range c.groups {
+		group.Count = count
+		stats.Groups = append(stats.Groups, group)
+	}
+	sort.Slice(stats.Groups, func(i, j int) bool {
+		a, b := stats.Groups[i], stats.Groups[j]
+		if a.Algorithm != b.Algorithm {
+			return a.Algorithm < b.Algorithm
+		}
+		if a.Operation != b.Operation {
+			return a.Operation < b.Operation
+		}
+		return a.Status < b.Status
+	})
+	if stats.Callbacks > 0 {
+		stats.CallbackErrorRate = float64(stats.CallbackErrors) / float64(stats.Callbacks)
+	}
+	return stats
+}
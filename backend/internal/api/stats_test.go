@@ -0,0 +1,196 @@
+package api
+
+import (
+	"backend/internal/api/mocks"
+	"backend/internal/structure"
+	"encoding/json"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHandler_GetStats(t *testing.T) {
+	fixedTime()
+	image := "data:image/png;base64,iVBORw0KGgo="
+	records := map[string]interface{}{
+		"images":                 structure.ImageIndex{IDs: []string{imageID(image)}},
+		imageKey(imageID(image)): image,
+		"models": testRegistry("alg1",
+			structure.Model{Name: "coco", Version: 1, Status: modelReady},
+			structure.Model{Name: "coco", Version: 2, Status: modelFailed}),
+		"2009-11-10T20:30:00Zalg1demo":         structure.Results{Algorithm: "alg1", Status: "finished"},
+		"2009-11-10T20:00:00Zalg1demo":         structure.Results{Algorithm: "alg1", Status: "error"},
+		"2009-11-10T10:00:00Zalg1demo":         structure.Results{Algorithm: "alg1", Status: "error", Error: "job timed out"},
+		"2009-11-10T20:20:00Zalg1demo":         structure.Results{Algorithm: "alg1", TimeStamp: "2009-11-10T20:20:00Z", Status: "in-progress"},
+		"2009-11-08T20:34:58Zalg1segmentation": structure.Results{Algorithm: "alg1", TimeStamp: "2009-11-08T20:34:58Z", StartedAt: "2009-11-10T20:04:58Z", Status: "in-progress"},
+	}
+	iAlgorithmMock := &mocks.IAlgorithm{}
+	iAlgorithmMock.On("GetID").Return("alg2")
+
+	t.Run("should count records of the requested windows", func(t *testing.T) {
+		//given
+		testSubject, _ := memoryHandler(t, records)
+		testSubject.iAlgorithm = []IAlgorithm{iAlgorithmMock}
+		w := httptest.NewRecorder()
+
+		//when
+		testSubject.GetStats(w, httptest.NewRequest("GET", "/v1/stats?windows=1h,all", nil))
+
+		//then
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var stats structure.Stats
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &stats))
+		assert.Equal(t, "2009-11-10T20:34:58Z", stats.GeneratedAt)
+		assert.Equal(t, 1, stats.Images)
+		assert.Equal(t, map[string]structure.ModelStats{
+			"alg1": {Default: "default", Models: 1, Versions: 2, Ready: 1},
+			"alg2": {},
+		}, stats.Models)
+		assert.Equal(t, []structure.JobStats{
+			{
+				Window:   "1h",
+				Since:    "2009-11-10T19:34:58Z",
+				Total:    3,
+				Statuses: map[string]int{"finished": 1, "error": 1, "in-progress": 1},
+				Groups: []structure.JobGroup{
+					{Algorithm: "alg1", Operation: "demo", Status: "error", Count: 1},
+					{Algorithm: "alg1", Operation: "demo", Status: "finished", Count: 1},
+					{Algorithm: "alg1", Operation: "demo", Status: "in-progress", Count: 1},
+				},
+				Callbacks:         2,
+				CallbackErrors:    1,
+				CallbackErrorRate: 0.5,
+			},
+			{
+				Window:   "all",
+				Total:    5,
+				Statuses: map[string]int{"finished": 1, "error": 2, "in-progress": 2},
+				Groups: []structure.JobGroup{
+					{Algorithm: "alg1", Operation: "demo", Status: "error", Count: 2},
+					{Algorithm: "alg1", Operation: "demo", Status: "finished", Count: 1},
+					{Algorithm: "alg1", Operation: "demo", Status: "in-progress", Count: 1},
+					{Algorithm: "alg1", Operation: "segmentation", Status: "in-progress", Count: 1},
+				},
+				Callbacks:         2,
+				CallbackErrors:    1,
+				CallbackErrorRate: 0.5,
+			},
+		}, stats.Jobs)
+		assert.Equal(t, &structure.JobAge{ID: "2009-11-08T20:34:58Zalg1segmentation", StartedAt: "2009-11-10T20:04:58Z", AgeSeconds: 1800}, stats.OldestInProgress)
+		assert.Equal(t, 2, stats.Storage["images"].Keys)
+		assert.Equal(t, int64(len(image)+len(`{"ids":["`+imageID(image)+`"]}`)), stats.Storage["images"].Bytes)
+		assert.Equal(t, 5, stats.Storage["results"].Keys)
+		assert.Equal(t, 1, stats.Storage["models"].Keys)
+		total := 0
+		for kind, usage := range stats.Storage {
+			if kind != "total" {
+				total += usage.Keys
+			}
+		}
+		assert.Equal(t, total, stats.Storage["total"].Keys)
+	})
+
+	t.Run("should measure storage without reading values", func(t *testing.T) {
+		//given
+		iDatabaseMock := mocks.IDatabaseSizer{}
+		iDatabaseMock.On("Get", mock.Anything, "images").Return(`{"ids":["a1"]}`, nil)
+		iDatabaseMock.On("Get", mock.Anything, "models").Return(`{"algorithms":{}}`, nil)
+		iDatabaseMock.On("Keys", mock.Anything, resultsPattern).Return([]string{}, nil)
+		iDatabaseMock.On("Sizes", mock.Anything, "*").Return(map[string]int64{
+			"images":                       14,
+			imageKey("a1"):                 1 << 20,
+			"models":                       17,
+			"2009-11-10T20:30:00Zalg1demo": 100,
+			"upload:1":                     50,
+		}, nil)
+		testSubject := NewHandler(&iDatabaseMock, nil)
+		w := httptest.NewRecorder()
+
+		//when
+		testSubject.GetStats(w, httptest.NewRequest("GET", "/v1/stats", nil))
+
+		//then
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var stats structure.Stats
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &stats))
+		assert.Equal(t, map[string]structure.StorageUsage{
+			"images":  {Keys: 2, Bytes: 1<<20 + 14},
+			"models":  {Keys: 1, Bytes: 17},
+			"results": {Keys: 1, Bytes: 100},
+			"uploads": {Keys: 1, Bytes: 50},
+			"total":   {Keys: 5, Bytes: 1<<20 + 181},
+		}, stats.Storage)
+		iDatabaseMock.AssertNotCalled(t, "Get", mock.Anything, imageKey("a1"))
+		iDatabaseMock.AssertNotCalled(t, "Keys", mock.Anything, "*")
+	})
+	t.Run("should return 500 when failed to measure storage", func(t *testing.T) {
+		//given
+		iDatabaseMock := mocks.IDatabaseSizer{}
+		iDatabaseMock.On("Get", mock.Anything, "images").Return(`{"ids":[]}`, nil)
+		iDatabaseMock.On("Get", mock.Anything, "models").Return(`{"algorithms":{}}`, nil)
+		iDatabaseMock.On("Keys", mock.Anything, resultsPattern).Return([]string{}, nil)
+		iDatabaseMock.On("Sizes", mock.Anything, "*").Return(nil, errors.New("connection refused"))
+		testSubject := NewHandler(&iDatabaseMock, nil)
+		w := httptest.NewRecorder()
+
+		//when
+		testSubject.GetStats(w, httptest.NewRequest("GET", "/v1/stats", nil))
+
+		//then
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+		assert.Equal(t, `{"code":"internal_error","message":"failed to read stats","details":"connection refused"}`+"\n", w.Body.String())
+	})
+
+	tests := []struct {
+		testName   string
+		requestURL string
+		getError   error
+		response   string
+		statusCode int
+	}{
+		{
+			testName:   "should return 404 when url is wrong",
+			requestURL: "/v1/stats/wrong",
+			response:   `{"code":"not_found","message":"not found"}` + "\n",
+			statusCode: http.StatusNotFound,
+		},
+		{
+			testName:   "should return 400 when window is invalid",
+			requestURL: "/v1/stats?windows=1h,week",
+			response:   `{"code":"bad_request","message":"invalid window week, expected a duration or all"}` + "\n",
+			statusCode: http.StatusBadRequest,
+		},
+		{
+			testName:   "should return 400 when there are too many windows",
+			requestURL: "/v1/stats?windows=1h,2h,3h,4h,5h,6h,7h,8h,9h,10h,11h",
+			response:   `{"code":"bad_request","message":"invalid windows, expected 1 to 10 windows"}` + "\n",
+			statusCode: http.StatusBadRequest,
+		},
+		{
+			testName:   "should return 500 when failed to read images",
+			requestURL: "/v1/stats",
+			getError:   errors.New("connection refused"),
+			response:   `{"code":"internal_error","message":"failed to read stats","details":"connection refused"}` + "\n",
+			statusCode: http.StatusInternalServerError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			//given
+			iDatabaseMock := mocks.IDatabase{}
+			iDatabaseMock.On("Get", mock.Anything, "images").Return(nil, tt.getError)
+			testSubject := NewHandler(&iDatabaseMock, nil)
+			w := httptest.NewRecorder()
+
+			//when
+			testSubject.GetStats(w, httptest.NewRequest("GET", tt.requestURL, nil))
+
+			//then
+			assert.Equal(t, tt.statusCode, w.Code)
+			assert.Equal(t, tt.response, w.Body.String())
+		})
+	}
+}
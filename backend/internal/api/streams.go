@@ -0,0 +1,65 @@
+package api
+
+import (
+	"backend/internal/structure"
+	"context"
+	"log"
+	"time"
+)
+
+// resultStreamRetry is how long the backend waits before it opens a result
+// stream which broke again, e.g. while the algorithm restarts.
+// resultStoreRetry is how long it waits before it stores received results
+// again, e.g. while the database restarts.
+const (
+	resultStreamRetry = 5 * time.Second
+	resultStoreRetry  = time.Second
+)
+
+// WatchResults receives results from the algorithms which stream them until
+// ctx is done, and stores them like results sent to PUT
+// /v1/simulation-results.
+func (h Handler) WatchResults(ctx context.Context) {
+	for _, item := range h.iAlgorithm {
+		if stream, ok := item.(IResultStream); ok {
+			go h.watchResults(ctx, stream)
+		}
+	}
+}
+
+func (h Handler) watchResults(ctx context.Context, stream IResultStream) {
+	report := func(id, content string) {
+		// the algorithm drops results once they were received, so storing
+		// them is retried until it succeeds, can not succeed, e.g. because
+		// the job does not exist, or the server stops
+		for {
+			err := h.finishJob(ctx, id, "", content, structure.AuditEntry{})
+			if err == nil {
+				return
+			}
+			log.Print("failed to store results of " + id + ": " + err.Error())
+			if !retryable(err) {
+				return
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(resultStoreRetry):
+			}
+		}
+	}
+	for {
+		err := stream.WatchResults(ctx, report)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			log.Print("result stream of " + stream.GetID() + " broke: " + err.Error())
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(resultStreamRetry):
+		}
+	}
+}
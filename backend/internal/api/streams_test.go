@@ -0,0 +1,90 @@
+package api
+
+import (
+	"backend/internal/api/mocks"
+	"backend/internal/structure"
+	"context"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"testing"
+	"time"
+)
+
+func TestHandler_WatchResults(t *testing.T) {
+	jobID := "2009-11-10T20:34:58Zalg1demo"
+	jsonInProgress, err := json.Marshal(structure.Results{Algorithm: "alg1", Status: "in-progress"})
+	assert.NoError(t, err)
+	jsonFinished, err := json.Marshal(structure.Results{Algorithm: "alg1", Status: "finished", Result: "results"})
+	assert.NoError(t, err)
+	jsonFailed, err := json.Marshal(structure.Results{Algorithm: "alg1", Status: "error"})
+	assert.NoError(t, err)
+	tests := []struct {
+		testName         string
+		content          string
+		insertData       string
+		insertError      error
+		assertNoOfInsert int
+	}{
+		{
+			testName:   "should store streamed results",
+			content:    "results",
+			insertData: string(jsonFinished),
+		},
+		{
+			testName:   "should mark job as failed when algorithm reports error",
+			content:    `"error"`,
+			insertData: string(jsonFailed),
+		},
+		{
+			testName:         "should store results again when storing them failed",
+			content:          "results",
+			insertData:       string(jsonFinished),
+			insertError:      errors.New("connection refused"),
+			assertNoOfInsert: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			//given
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			iDatabaseMock := mocks.IDatabase{}
+			iAlgorithmMock := mocks.IAlgorithm{}
+			iResultStreamMock := mocks.IResultStream{}
+			iQueueMock := mocks.IQueue{}
+			testSubject := NewHandler(&iDatabaseMock, []IAlgorithm{&iAlgorithmMock, &iResultStreamMock}).WithQueue(&iQueueMock)
+			iDatabaseMock.On("Get", mock.Anything, jobID).Return(string(jsonInProgress), nil)
+			if tt.insertError != nil {
+				iDatabaseMock.On("Set", mock.Anything, jobID, tt.insertData).Return(tt.insertError).Once()
+			}
+			iDatabaseMock.On("Set", mock.Anything, jobID, tt.insertData).Return(nil)
+			iDatabaseMock.On("Delete", mock.Anything, "pending:"+jobID).Return(nil)
+			mockUpdate(&iDatabaseMock, jobID)
+			iQueueMock.On("Done", jobID)
+			watched := make(chan struct{})
+			iResultStreamMock.On("WatchResults", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+				args.Get(1).(func(string, string))(jobID, tt.content)
+				cancel()
+				close(watched)
+			}).Return(context.Canceled)
+
+			//when
+			testSubject.WatchResults(ctx)
+
+			//then
+			select {
+			case <-watched:
+			case <-time.After(resultStoreRetry + time.Second):
+				t.Fatal("results were not watched")
+			}
+			iResultStreamMock.AssertNumberOfCalls(t, "WatchResults", 1)
+			if tt.assertNoOfInsert > 0 {
+				iDatabaseMock.AssertNumberOfCalls(t, "Set", tt.assertNoOfInsert)
+			}
+			iDatabaseMock.AssertCalled(t, "Set", mock.Anything, jobID, tt.insertData)
+			iQueueMock.AssertCalled(t, "Done", jobID)
+		})
+	}
+}
@@ -0,0 +1,660 @@
+package api
+
+import (
+	"backend/internal/logging"
+	"backend/internal/structure"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	uploadInProgress = "in-progress"
+	uploadCompleting = "completing"
+	uploadCompleted  = "completed"
+	uploadFailed     = "failed"
+)
+
+// defaultUploadTTL is how long upload sessions and their files are kept
+// after the upload was created.
+const defaultUploadTTL = 24 * time.Hour
+
+// maxModelNameLength is the longest name matched by modelNameRegexp.
+const maxModelNameLength = 128
+
+var (
+	checksumRegexp     = regexp.MustCompile("^[0-9a-f]{64}$")
+	contentRangeRegexp = regexp.MustCompile(`^bytes (\d+)-(\d+)/(\d+)$`)
+	// model names are used as file names by the algorithm containers
+	modelNameRegexp = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9._-]{0,127}$`)
+)
+
+type byteCounter struct {
+	n int64
+}
+
+func (c *byteCounter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	return len(p), nil
+}
+
+// WithUploadTTL sets how long upload sessions and their files are kept after
+// the upload was created. By default they are kept for 24 hours.
+func (h Handler) WithUploadTTL(ttl time.Duration) Handler {
+	h.uploadTTL = ttl
+	return h
+}
+
+// ExpireUploads periodically deletes uploads older than the upload TTL until
+// ctx is done.
+func (h Handler) ExpireUploads(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if _, err := h.DeleteExpiredUploads(ctx); err != nil && ctx.Err() == nil {
+				log.Print("failed to delete expired uploads: " + err.Error())
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// DeleteExpiredUploads deletes upload sessions created before the upload TTL,
+// together with their files, and files left without a session, e.g. by a
+// server stopped while creating an upload. It returns the number of deleted
+// sessions.
+func (h Handler) DeleteExpiredUploads(ctx context.Context) (int, error) {
+	expired := time.Now().Add(-h.uploadTTL)
+	keys, err := h.iDatabase.Keys(ctx, "upload:*")
+	if err != nil {
+		return 0, err
+	}
+	deleted := 0
+	sessions := map[string]bool{}
+	for _, key := range keys {
+		id := strings.TrimPrefix(key, "upload:")
+		sessions[id] = true
+		session, err := h.getUpload(ctx, id)
+		if err != nil {
+			continue
+		}
+		createdAt, err := time.Parse(time.RFC3339, session.CreatedAt)
+		if err == nil && createdAt.After(expired) {
+			continue
+		}
+		if err = h.iDatabase.Delete(ctx, key); err != nil && err.Error() != "key does not exist" {
+			return deleted, err
+		}
+		os.Remove(h.uploadPath(id))
+		deleted++
+	}
+
+	files, err := ioutil.ReadDir(h.uploadDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return deleted, nil
+		}
+		return deleted, err
+	}
+	for _, file := range files {
+		if !sessions[file.Name()] && file.ModTime().Before(expired) {
+			os.Remove(filepath.Join(h.uploadDir, file.Name()))
+		}
+	}
+	return deleted, nil
+}
+
+//POST /v1/uploads
+func (h Handler) CreateUpload(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != h.postUploadEndpoint {
+		notFound(w, r)
+		return
+	}
+	if r.Header.Get("Content-Type") != "application/json" {
+		writeError(w, r, http.StatusBadRequest, "invalid content type", nil)
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "failed to read body", err)
+		return
+	}
+	var upload structure.ModelUpload
+	if err = json.Unmarshal(body, &upload); err != nil {
+		writeError(w, r, http.StatusBadRequest, "failed to unmarshal body", nil)
+		return
+	}
+	upload.SHA256 = strings.ToLower(upload.SHA256)
+	if err = checkModelName(upload.Name); err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+	switch {
+	case upload.Size <= 0:
+		writeError(w, r, http.StatusBadRequest, "size has to be greater than 0", nil)
+		return
+	case !checksumRegexp.MatchString(upload.SHA256):
+		writeError(w, r, http.StatusBadRequest, "sha256 has to be a hex encoded SHA-256 checksum", nil)
+		return
+	}
+	if _, ok := h.findAlgorithm(upload.Algorithm); !ok {
+		writeError(w, r, http.StatusNotFound, "algorithm "+upload.Algorithm+" does not exist", nil)
+		return
+	}
+
+	id, err := newUploadID()
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "failed to create upload", err)
+		return
+	}
+	if err = os.MkdirAll(h.uploadDir, 0755); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "failed to create upload", err)
+		return
+	}
+	file, err := os.Create(h.uploadPath(id))
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "failed to create upload", err)
+		return
+	}
+	file.Close()
+
+	session := structure.UploadSession{
+		ModelUpload: upload,
+		ID:          id,
+		Status:      uploadInProgress,
+		CreatedAt:   time.Now().Format(time.RFC3339),
+	}
+	if err = h.setUpload(r.Context(), session); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "failed to store upload", err)
+		return
+	}
+	w.Header().Set("Location", strings.Replace(h.uploadEndpoint, "{id}", id, 1))
+	h.writeUpload(w, r, session, http.StatusCreated)
+}
+
+//GET /v1/uploads/{id}
+func (h Handler) GetUpload(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if r.URL.Path != strings.Replace(h.uploadEndpoint, "{id}", id, 1) {
+		notFound(w, r)
+		return
+	}
+
+	session, err := h.getUpload(r.Context(), id)
+	if err != nil {
+		writeStatusError(w, r, err)
+		return
+	}
+	h.writeUpload(w, r, session, http.StatusOK)
+}
+
+//PUT /v1/uploads/{id}
+func (h Handler) UploadChunk(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if r.URL.Path != strings.Replace(h.uploadEndpoint, "{id}", id, 1) {
+		notFound(w, r)
+		return
+	}
+
+	match := contentRangeRegexp.FindStringSubmatch(r.Header.Get("Content-Range"))
+	if match == nil {
+		writeError(w, r, http.StatusBadRequest, "invalid Content-Range, expected bytes <start>-<end>/<size>", nil)
+		return
+	}
+	start, _ := strconv.ParseInt(match[1], 10, 64)
+	end, _ := strconv.ParseInt(match[2], 10, 64)
+	size, _ := strconv.ParseInt(match[3], 10, 64)
+
+	// the range is reserved before the chunk is written, so that concurrent
+	// chunks never write the same bytes
+	session, err := h.updateUpload(r.Context(), id, func(session *structure.UploadSession) error {
+		if session.Status != uploadInProgress {
+			return statusError{http.StatusConflict, "upload " + id + " is " + session.Status}
+		}
+		if size != session.Size || end < start || end >= size {
+			return statusError{http.StatusBadRequest, fmt.Sprintf("invalid Content-Range for upload of %d bytes", session.Size)}
+		}
+		if start != session.Received {
+			return statusError{http.StatusConflict, fmt.Sprintf("expected chunk starting at byte %d", session.Received)}
+		}
+		session.Received = end + 1
+		session.Progress = float64(session.Received) / float64(session.Size)
+		return nil
+	})
+	if err != nil {
+		writeStatusError(w, r, err)
+		return
+	}
+
+	if err = h.writeChunk(id, start, end-start+1, r.Body); err != nil {
+		h.releaseChunk(detach(r.Context()), id, start, end)
+		writeStatusError(w, r, err)
+		return
+	}
+	h.writeUpload(w, r, session, http.StatusOK)
+}
+
+// writeChunk writes length bytes of chunk to the file of an upload at start.
+func (h Handler) writeChunk(id string, start, length int64, chunk io.Reader) error {
+	file, err := os.OpenFile(h.uploadPath(id), os.O_WRONLY, 0644)
+	if err != nil {
+		return errors.Wrap(err, "failed to open upload")
+	}
+	defer file.Close()
+	if _, err = file.Seek(start, io.SeekStart); err != nil {
+		return errors.Wrap(err, "failed to write chunk")
+	}
+	if n, err := io.Copy(file, io.LimitReader(chunk, length)); err != nil || n != length {
+		return statusError{http.StatusBadRequest, fmt.Sprintf("incomplete chunk, expected %d bytes", length)}
+	}
+	return nil
+}
+
+// releaseChunk gives back the range of a chunk which was not written, so that
+// it can be sent again. When later chunks were reserved in the meantime, the
+// upload can not be completed anymore and fails.
+func (h Handler) releaseChunk(ctx context.Context, id string, start, end int64) {
+	released := false
+	_, err := h.updateUpload(ctx, id, func(session *structure.UploadSession) error {
+		if session.Status != uploadInProgress {
+			return errUnchanged
+		}
+		released = session.Received == end+1
+		if released {
+			session.Received = start
+			session.Progress = float64(session.Received) / float64(session.Size)
+			return nil
+		}
+		session.Status = uploadFailed
+		session.Error = fmt.Sprintf("chunk starting at byte %d was not received", start)
+		return nil
+	})
+	if err != nil && err != errUnchanged {
+		log.Print("failed to release chunk of upload " + id + ": " + err.Error())
+		return
+	}
+	if released {
+		os.Truncate(h.uploadPath(id), start)
+	} else if err == nil {
+		os.Remove(h.uploadPath(id))
+	}
+}
+
+//POST /v1/uploads/{id}/complete
+func (h Handler) CompleteUpload(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if r.URL.Path != strings.Replace(h.completeUploadEndpoint, "{id}", id, 1) {
+		notFound(w, r)
+		return
+	}
+
+	// the upload is claimed first, so that it registers a single version even
+	// when it is completed by several requests at once
+	session, err := h.updateUpload(r.Context(), id, func(session *structure.UploadSession) error {
+		if session.Status != uploadInProgress {
+			return statusError{http.StatusConflict, "upload " + id + " is " + session.Status}
+		}
+		if session.Received != session.Size {
+			return statusError{http.StatusConflict, fmt.Sprintf("upload is incomplete, received %d of %d bytes", session.Received, session.Size)}
+		}
+		if _, ok := h.findAlgorithm(session.Algorithm); !ok {
+			return statusError{http.StatusNotFound, "algorithm " + session.Algorithm + " does not exist"}
+		}
+		session.Status = uploadCompleting
+		session.Error = ""
+		return nil
+	})
+	if err != nil {
+		writeStatusError(w, r, err)
+		return
+	}
+	logging.AddFields(r.Context(), zap.String("algorithm", session.Algorithm), zap.String("model", session.Name))
+
+	var model structure.Model
+	alg, ok := h.findAlgorithm(session.Algorithm)
+	storeErr := error(statusError{http.StatusNotFound, "algorithm " + session.Algorithm + " does not exist"})
+	if ok {
+		storeErr = verifyUpload(h.uploadPath(id), session.ModelUpload)
+	}
+	if ok && storeErr == nil {
+		model, storeErr = h.storeUpload(r, alg, session)
+	}
+
+	// the assembled file is kept after errors of the backend or the algorithm,
+	// so that completing the upload can be retried
+	session, err = h.updateUpload(detach(r.Context()), id, func(session *structure.UploadSession) error {
+		if session.Status != uploadCompleting {
+			return statusError{http.StatusConflict, "upload " + id + " is " + session.Status}
+		}
+		switch {
+		case storeErr == nil:
+			session.Status = uploadCompleted
+			session.Version = model.Version
+		case retryable(storeErr):
+			session.Status = uploadInProgress
+			session.Error = storeErr.Error()
+		default:
+			session.Status = uploadFailed
+			session.Version = model.Version
+			session.Error = storeErr.Error()
+		}
+		return nil
+	})
+	if session.Status != uploadInProgress {
+		os.Remove(h.uploadPath(id))
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "failed to store upload", err)
+		return
+	}
+	if storeErr != nil {
+		writeStatusError(w, r, storeErr)
+		return
+	}
+
+	jsonModel, err := json.Marshal(model)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "failed to marshal model", err)
+		return
+	}
+	if _, err = fmt.Fprint(w, string(jsonModel)); err != nil {
+		log.Print("failed to write response: " + err.Error())
+		return
+	}
+}
+
+// verifyUpload checks the assembled file against the declared size and
+// checksum before anything is sent to the algorithm.
+func verifyUpload(path string, upload structure.ModelUpload) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return errors.Wrap(err, "failed to open upload")
+	}
+	defer file.Close()
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return errors.Wrap(err, "failed to read upload")
+	}
+	if size != upload.Size {
+		return statusError{http.StatusBadRequest, fmt.Sprintf("size mismatch, expected %d but received %d bytes", upload.Size, size)}
+	}
+	if checksum := hex.EncodeToString(hash.Sum(nil)); checksum != strings.ToLower(upload.SHA256) {
+		return statusError{http.StatusBadRequest, fmt.Sprintf("checksum mismatch, expected %s but received %s", upload.SHA256, checksum)}
+	}
+	return nil
+}
+
+// storeUpload streams the assembled file of an upload to the algorithm.
+func (h Handler) storeUpload(r *http.Request, alg IAlgorithm, session structure.UploadSession) (structure.Model, error) {
+	file, err := os.Open(h.uploadPath(session.ID))
+	if err != nil {
+		return structure.Model{}, errors.Wrap(err, "failed to open upload")
+	}
+	defer file.Close()
+	model, err := h.storeModel(r.Context(), alg, session.ModelUpload, file)
+	h.auditUpload(r, session.ModelUpload, model)
+	return model, err
+}
+
+// retryable tells whether a failed operation, like completing an upload, can
+// succeed when it is tried again. Requests which the client got wrong, like
+// conflicting versions, can not.
+func retryable(err error) bool {
+	se, ok := err.(statusError)
+	return !ok || se.status >= http.StatusInternalServerError
+}
+
+// storeModel registers a new version of a model and streams its weights to
+// the algorithm. The version only becomes ready once the content matches the
+// declared checksum and size, so interrupted uploads are never used.
+func (h Handler) storeModel(ctx context.Context, alg IAlgorithm, upload structure.ModelUpload, content io.Reader) (structure.Model, error) {
+	name := upload.Name
+	if err := checkModelName(name); err != nil {
+		return structure.Model{}, statusError{http.StatusBadRequest, err.Error()}
+	}
+	declared := strings.ToLower(upload.SHA256)
+	var model structure.Model
+	err := h.updateRegistry(ctx, func(registry *structure.Registry) error {
+		algModels := registry.Algorithms[upload.Algorithm]
+		if algModels == nil {
+			algModels = &structure.AlgorithmModels{Models: map[string][]structure.Model{}}
+			registry.Algorithms[upload.Algorithm] = algModels
+		}
+		versions := algModels.Models[name]
+		if existing, ok := findChecksum(versions, declared); ok {
+			return statusError{http.StatusConflict, fmt.Sprintf("model %s is already uploaded as version %d", name, existing.Version)}
+		}
+
+		version := 1
+		if len(versions) > 0 {
+			version = versions[len(versions)-1].Version + 1
+		}
+		model = structure.Model{
+			Name:        name,
+			Version:     version,
+			File:        versionFile(name, version),
+			SHA256:      declared,
+			Size:        upload.Size,
+			Uploader:    upload.Uploader,
+			UploadedAt:  time.Now().Format(time.RFC3339),
+			Description: upload.Description,
+			Classes:     upload.Classes,
+			Status:      modelUploading,
+		}
+		if model.Classes == nil {
+			model.Classes = []string{}
+		}
+		algModels.Models[name] = append(versions, model)
+		return nil
+	})
+	if err != nil {
+		return structure.Model{}, err
+	}
+
+	hash := sha256.New()
+	counter := &byteCounter{}
+	uploadErr := alg.UploadModel(ctx, model.File, io.TeeReader(content, io.MultiWriter(hash, counter)))
+	model.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	model.Size = counter.n
+	model.Status = modelReady
+	switch {
+	case uploadErr != nil:
+		model.Status = modelFailed
+		uploadErr = statusError{http.StatusBadGateway, "failed to upload model: " + uploadErr.Error()}
+	case declared != "" && declared != model.SHA256:
+		model.Status = modelFailed
+		uploadErr = statusError{http.StatusBadRequest, fmt.Sprintf("checksum mismatch, expected %s but received %s", declared, model.SHA256)}
+	case upload.Size > 0 && upload.Size != model.Size:
+		model.Status = modelFailed
+		uploadErr = statusError{http.StatusBadRequest, fmt.Sprintf("size mismatch, expected %d but received %d bytes", upload.Size, model.Size)}
+	}
+
+	// the version is marked as failed also when the upload was cancelled
+	err = h.updateRegistry(detach(ctx), func(registry *structure.Registry) error {
+		algModels := registry.Algorithms[upload.Algorithm]
+		if algModels == nil {
+			return errors.New("algorithm " + upload.Algorithm + " disappeared from models")
+		}
+		versions := algModels.Models[name]
+		index := -1
+		for i, item := range versions {
+			if item.Version == model.Version {
+				index = i
+				break
+			}
+		}
+		if index < 0 {
+			return errors.Errorf("version %d of model %s disappeared from models", model.Version, name)
+		}
+		if model.Status == modelReady && declared == "" {
+			if existing, ok := findChecksum(versions, model.SHA256); ok {
+				algModels.Models[name] = append(versions[:index:index], versions[index+1:]...)
+				if len(algModels.Models[name]) == 0 {
+					delete(algModels.Models, name)
+				}
+				uploadErr = statusError{http.StatusConflict, fmt.Sprintf("model %s is already uploaded as version %d", name, existing.Version)}
+				return nil
+			}
+		}
+		if model.Status == modelReady && upload.Default {
+			algModels.Default = name
+		}
+		versions[index] = model
+		return nil
+	})
+	if err != nil {
+		return structure.Model{}, err
+	}
+	if uploadErr != nil {
+		if se, ok := uploadErr.(statusError); ok && se.status == http.StatusConflict {
+			return structure.Model{}, uploadErr
+		}
+	}
+	return model, uploadErr
+}
+
+// auditUpload records a new version of a model, also when its upload failed.
+// Models uploaded without an actor are attributed to their uploader.
+func (h Handler) auditUpload(r *http.Request, upload structure.ModelUpload, model structure.Model) {
+	if model.Version == 0 {
+		return
+	}
+	entry := h.auditEntry(r, actionUploadModel, upload.Algorithm+"/"+model.Name)
+	if entry.Actor == "" {
+		entry.Actor = upload.Uploader
+	}
+	entry.After = map[string]string{
+		"version": strconv.Itoa(model.Version),
+		"file":    model.File,
+		"sha256":  model.SHA256,
+		"status":  model.Status,
+		"default": strconv.FormatBool(upload.Default && model.Status == modelReady),
+	}
+	h.audit(r.Context(), entry)
+}
+
+func (h Handler) findAlgorithm(id string) (IAlgorithm, bool) {
+	for _, item := range h.iAlgorithm {
+		if item.GetID() == id {
+			return item, true
+		}
+	}
+	return nil, false
+}
+
+func (h Handler) getUpload(ctx context.Context, id string) (structure.UploadSession, error) {
+	fromDB, err := h.iDatabase.Get(ctx, "upload:" + id)
+	if err != nil {
+		if err.Error() == "key does not exist" {
+			return structure.UploadSession{}, statusError{http.StatusNotFound, "upload " + id + " does not exist"}
+		}
+		return structure.UploadSession{}, err
+	}
+	var session structure.UploadSession
+	if err = json.Unmarshal([]byte(fromDB.(string)), &session); err != nil {
+		return structure.UploadSession{}, statusError{http.StatusInternalServerError, "failed to unmarshal upload"}
+	}
+	return session, nil
+}
+
+// updateUpload changes the stored upload session with change. Sessions for
+// which change returns an error are not written again.
+func (h Handler) updateUpload(ctx context.Context, id string, change func(session *structure.UploadSession) error) (structure.UploadSession, error) {
+	var session structure.UploadSession
+	err := h.iDatabase.Update(ctx, "upload:"+id, func(fromDB string) (string, error) {
+		session = structure.UploadSession{}
+		if err := json.Unmarshal([]byte(fromDB), &session); err != nil {
+			return "", statusError{http.StatusInternalServerError, "failed to unmarshal upload"}
+		}
+		if err := change(&session); err != nil {
+			return "", err
+		}
+		jsonSession, err := json.Marshal(session)
+		if err != nil {
+			return "", err
+		}
+		return string(jsonSession), nil
+	})
+	if err != nil && err.Error() == "key does not exist" {
+		return structure.UploadSession{}, statusError{http.StatusNotFound, "upload " + id + " does not exist"}
+	}
+	return session, err
+}
+
+func (h Handler) setUpload(ctx context.Context, session structure.UploadSession) error {
+	jsonSession, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	return h.iDatabase.Set(ctx, "upload:"+session.ID, string(jsonSession))
+}
+
+func (h Handler) writeUpload(w http.ResponseWriter, r *http.Request, session structure.UploadSession, status int) {
+	jsonSession, err := json.Marshal(session)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "failed to marshal upload", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	fmt.Fprint(w, string(jsonSession))
+}
+
+func (h Handler) uploadPath(id string) string {
+	return filepath.Join(h.uploadDir, id)
+}
+
+func findChecksum(versions []structure.Model, checksum string) (structure.Model, bool) {
+	if checksum == "" {
+		return structure.Model{}, false
+	}
+	for _, model := range versions {
+		if model.SHA256 == checksum && model.Status == modelReady {
+			return model, true
+		}
+	}
+	return structure.Model{}, false
+}
+
+func newUploadID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// checkModelName makes sure that the name of a model can be used as a file
+// name by the algorithm containers, which join it with their model directory.
+func checkModelName(name string) error {
+	switch {
+	case name == "":
+		return errors.New("name is required")
+	case !modelNameRegexp.MatchString(name) || strings.Contains(name, ".."):
+		return errors.New("invalid name, expected up to 128 letters, digits, '.', '_' or '-'")
+	}
+	return nil
+}
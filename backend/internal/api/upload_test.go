@@ -0,0 +1,370 @@
+package api
+
+import (
+	"backend/internal/api/mocks"
+	"backend/internal/structure"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHandler_CreateUpload(t *testing.T) {
+	id := "algID"
+	checksum := sha256.Sum256([]byte("sample"))
+	valid := structure.ModelUpload{Algorithm: id, Name: "model.h5", Size: 6, SHA256: hex.EncodeToString(checksum[:])}
+	tests := []struct {
+		testName         string
+		upload           structure.ModelUpload
+		assertNoOfInsert int
+		bodyContains     string
+		statusCode       int
+	}{
+		{
+			testName:     "should return 400 when name is missing",
+			upload:       structure.ModelUpload{Algorithm: id, Size: 6, SHA256: valid.SHA256},
+			bodyContains: "name is required",
+			statusCode:   http.StatusBadRequest,
+		},
+		{
+			testName:     "should return 400 when name is not a safe file name",
+			upload:       structure.ModelUpload{Algorithm: id, Name: "models/../../etc/passwd", Size: 6, SHA256: valid.SHA256},
+			bodyContains: "invalid name",
+			statusCode:   http.StatusBadRequest,
+		},
+		{
+			testName:     "should return 400 when checksum is invalid",
+			upload:       structure.ModelUpload{Algorithm: id, Name: "model.h5", Size: 6, SHA256: "abc"},
+			bodyContains: "sha256 has to be a hex encoded SHA-256 checksum",
+			statusCode:   http.StatusBadRequest,
+		},
+		{
+			testName:     "should return 404 when algorithm does not exist",
+			upload:       structure.ModelUpload{Algorithm: "other", Name: "model.h5", Size: 6, SHA256: valid.SHA256},
+			bodyContains: "algorithm other does not exist",
+			statusCode:   http.StatusNotFound,
+		},
+		{
+			testName:         "should return 201 when upload was created",
+			upload:           valid,
+			assertNoOfInsert: 1,
+			bodyContains:     `"status":"in-progress"`,
+			statusCode:       http.StatusCreated,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			//given
+			jsonUpload, err := json.Marshal(tt.upload)
+			assert.NoError(t, err)
+			r, err := http.NewRequest("POST", "/v1/uploads", bytes.NewBuffer(jsonUpload))
+			assert.NoError(t, err)
+			r.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			iDatabaseMock := mocks.IDatabase{}
+			iAlgorithmMock := mocks.IAlgorithm{}
+			testSubject := NewHandler(&iDatabaseMock, []IAlgorithm{&iAlgorithmMock}).WithUploadDir(t.TempDir())
+			iAlgorithmMock.On("GetID").Return(id)
+			iDatabaseMock.On("Set", mock.Anything, mock.AnythingOfType("string"), mock.AnythingOfType("string")).Return(nil)
+
+			//when
+			testSubject.CreateUpload(w, r)
+
+			//then
+			iDatabaseMock.AssertNumberOfCalls(t, "Set", tt.assertNoOfInsert)
+			assert.Contains(t, w.Body.String(), tt.bodyContains)
+			assert.Equal(t, tt.statusCode, w.Code)
+		})
+	}
+}
+
+func TestHandler_UploadChunk(t *testing.T) {
+	uploadID := "upload"
+	tests := []struct {
+		testName         string
+		status           string
+		contentRange     string
+		chunk            string
+		assertNoOfInsert int
+		received         int64
+		fileContent      string
+		bodyContains     string
+		statusCode       int
+	}{
+		{
+			testName:     "should return 400 when Content-Range is missing",
+			status:       "in-progress",
+			chunk:        "ple",
+			fileContent:  "sam",
+			bodyContains: "invalid Content-Range",
+			statusCode:   http.StatusBadRequest,
+		},
+		{
+			testName:     "should return 409 when upload is not in progress",
+			status:       "completing",
+			contentRange: "bytes 3-5/6",
+			chunk:        "ple",
+			fileContent:  "sam",
+			bodyContains: "upload upload is completing",
+			statusCode:   http.StatusConflict,
+		},
+		{
+			testName:     "should return 409 when chunk does not continue the upload",
+			status:       "in-progress",
+			contentRange: "bytes 0-2/6",
+			chunk:        "sam",
+			fileContent:  "sam",
+			bodyContains: "expected chunk starting at byte 3",
+			statusCode:   http.StatusConflict,
+		},
+		{
+			testName:         "should return 400 and release chunk when it is shorter than declared",
+			status:           "in-progress",
+			contentRange:     "bytes 3-5/6",
+			chunk:            "p",
+			assertNoOfInsert: 2,
+			received:         3,
+			fileContent:      "sam",
+			bodyContains:     "incomplete chunk, expected 3 bytes",
+			statusCode:       http.StatusBadRequest,
+		},
+		{
+			testName:         "should return 200 with progress when chunk was stored",
+			status:           "in-progress",
+			contentRange:     "bytes 3-5/6",
+			chunk:            "ple",
+			assertNoOfInsert: 1,
+			received:         6,
+			fileContent:      "sample",
+			bodyContains:     `"progress":1`,
+			statusCode:       http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			//given
+			dir := t.TempDir()
+			assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, uploadID), []byte("sam"), 0644))
+			session := structure.UploadSession{ModelUpload: structure.ModelUpload{Algorithm: "algID", Name: "model.h5", Size: 6}, ID: uploadID, Received: 3, Status: tt.status}
+			jsonSession, err := json.Marshal(session)
+			assert.NoError(t, err)
+			r, err := http.NewRequest("PUT", "/v1/uploads/"+uploadID, bytes.NewBufferString(tt.chunk))
+			assert.NoError(t, err)
+			r.Header.Set("Content-Range", tt.contentRange)
+			r = mux.SetURLVars(r, map[string]string{"id": uploadID})
+			w := httptest.NewRecorder()
+			iDatabaseMock := mocks.IDatabase{}
+			testSubject := NewHandler(&iDatabaseMock, []IAlgorithm{&mocks.IAlgorithm{}}).WithUploadDir(dir)
+			iDatabaseMock.On("Get", mock.Anything, "upload:"+uploadID).Return(string(jsonSession), nil)
+			var stored structure.UploadSession
+			iDatabaseMock.On("Set", mock.Anything, "upload:"+uploadID, mock.AnythingOfType("string")).Return(nil).Run(func(args mock.Arguments) {
+				assert.NoError(t, json.Unmarshal([]byte(args.String(2)), &stored))
+			})
+			mockUpdate(&iDatabaseMock, "upload:"+uploadID)
+
+			//when
+			testSubject.UploadChunk(w, r)
+
+			//then
+			iDatabaseMock.AssertNumberOfCalls(t, "Set", tt.assertNoOfInsert)
+			assert.Equal(t, tt.received, stored.Received)
+			content, err := ioutil.ReadFile(filepath.Join(dir, uploadID))
+			assert.NoError(t, err)
+			assert.Equal(t, tt.fileContent, string(content))
+			assert.Contains(t, w.Body.String(), tt.bodyContains)
+			assert.Equal(t, tt.statusCode, w.Code)
+		})
+	}
+}
+
+func TestHandler_CompleteUpload(t *testing.T) {
+	id := "algID"
+	uploadID := "upload"
+	checksum := sha256.Sum256([]byte("sample"))
+	jsonAllModels, err := json.Marshal(testRegistry(id))
+	assert.NoError(t, err)
+	tests := []struct {
+		testName              string
+		status                string
+		received              int64
+		checksum              string
+		uploadError           error
+		assertNoOfUploadModel int
+		fileRemoved           bool
+		sessionStatus         string
+		bodyContains          string
+		statusCode            int
+	}{
+		{
+			testName:     "should return 409 when upload is incomplete",
+			status:       "in-progress",
+			received:     3,
+			checksum:     hex.EncodeToString(checksum[:]),
+			bodyContains: "upload is incomplete, received 3 of 6 bytes",
+			statusCode:   http.StatusConflict,
+		},
+		{
+			testName:     "should return 409 without uploading model when upload is being completed",
+			status:       "completing",
+			received:     6,
+			checksum:     hex.EncodeToString(checksum[:]),
+			bodyContains: "upload upload is completing",
+			statusCode:   http.StatusConflict,
+		},
+		{
+			testName:      "should return 400 without uploading model when checksum does not match",
+			status:        "in-progress",
+			received:      6,
+			checksum:      "0000000000000000000000000000000000000000000000000000000000000000",
+			fileRemoved:   true,
+			sessionStatus: "failed",
+			bodyContains:  "checksum mismatch",
+			statusCode:    http.StatusBadRequest,
+		},
+		{
+			testName:              "should return 502 and keep upload completable when failed to upload model",
+			status:                "in-progress",
+			received:              6,
+			checksum:              hex.EncodeToString(checksum[:]),
+			uploadError:           errors.New("connection refused"),
+			assertNoOfUploadModel: 1,
+			sessionStatus:         "in-progress",
+			bodyContains:          "failed to upload model: connection refused",
+			statusCode:            http.StatusBadGateway,
+		},
+		{
+			testName:              "should return 200 when model was verified and uploaded",
+			status:                "in-progress",
+			received:              6,
+			checksum:              hex.EncodeToString(checksum[:]),
+			assertNoOfUploadModel: 1,
+			fileRemoved:           true,
+			sessionStatus:         "completed",
+			bodyContains:          `"status":"ready"`,
+			statusCode:            http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			//given
+			dir := t.TempDir()
+			assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, uploadID), []byte("sample"), 0644))
+			session := structure.UploadSession{
+				ModelUpload: structure.ModelUpload{Algorithm: id, Name: "model.h5", Size: 6, SHA256: tt.checksum},
+				ID:          uploadID,
+				Received:    tt.received,
+				Status:      tt.status,
+			}
+			jsonSession, err := json.Marshal(session)
+			assert.NoError(t, err)
+			r, err := http.NewRequest("POST", "/v1/uploads/"+uploadID+"/complete", nil)
+			assert.NoError(t, err)
+			r = mux.SetURLVars(r, map[string]string{"id": uploadID})
+			w := httptest.NewRecorder()
+			iDatabaseMock := mocks.IDatabase{}
+			iAlgorithmMock := mocks.IAlgorithm{}
+			testSubject := NewHandler(&iDatabaseMock, []IAlgorithm{&iAlgorithmMock}).WithUploadDir(dir)
+			iAlgorithmMock.On("GetID").Return(id)
+			iAlgorithmMock.On("UploadModel", mock.Anything, "model.h5", mock.Anything).Return(tt.uploadError).Run(func(args mock.Arguments) {
+				ioutil.ReadAll(args.Get(2).(io.Reader))
+			})
+			iDatabaseMock.On("Get", mock.Anything, "upload:"+uploadID).Return(string(jsonSession), nil)
+			iDatabaseMock.On("Get", mock.Anything, "models").Return(string(jsonAllModels), nil)
+			var stored structure.UploadSession
+			iDatabaseMock.On("Set", mock.Anything, "upload:"+uploadID, mock.AnythingOfType("string")).Return(nil).Run(func(args mock.Arguments) {
+				assert.NoError(t, json.Unmarshal([]byte(args.String(2)), &stored))
+			})
+			iDatabaseMock.On("Set", mock.Anything, mock.AnythingOfType("string"), mock.AnythingOfType("string")).Return(nil)
+			mockUpdate(&iDatabaseMock, "models")
+			mockUpdate(&iDatabaseMock, "upload:"+uploadID)
+
+			//when
+			testSubject.CompleteUpload(w, r)
+
+			//then
+			iAlgorithmMock.AssertNumberOfCalls(t, "UploadModel", tt.assertNoOfUploadModel)
+			_, err = os.Stat(filepath.Join(dir, uploadID))
+			assert.Equal(t, tt.fileRemoved, os.IsNotExist(err))
+			assert.Equal(t, tt.sessionStatus, stored.Status)
+			assert.Contains(t, w.Body.String(), tt.bodyContains)
+			assert.Equal(t, tt.statusCode, w.Code)
+		})
+	}
+}
+
+func TestHandler_DeleteExpiredUploads(t *testing.T) {
+	old := time.Now().Add(-48 * time.Hour)
+	tests := []struct {
+		testName string
+		sessions map[string]time.Time
+		files    map[string]time.Time
+		deleted  int
+		kept     []string
+	}{
+		{
+			testName: "should keep uploads younger than ttl",
+			sessions: map[string]time.Time{"new": time.Now()},
+			files:    map[string]time.Time{"new": time.Now()},
+			kept:     []string{"new"},
+		},
+		{
+			testName: "should delete uploads older than ttl with their files",
+			sessions: map[string]time.Time{"old": old, "new": time.Now()},
+			files:    map[string]time.Time{"old": old, "new": time.Now()},
+			deleted:  1,
+			kept:     []string{"new"},
+		},
+		{
+			testName: "should delete old files without session",
+			files:    map[string]time.Time{"orphan": old, "created": time.Now()},
+			kept:     []string{"created"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			//given
+			dir := t.TempDir()
+			records := map[string]interface{}{}
+			for id, createdAt := range tt.sessions {
+				records["upload:"+id] = structure.UploadSession{ID: id, Status: "in-progress", CreatedAt: createdAt.Format(time.RFC3339)}
+			}
+			for id, modified := range tt.files {
+				path := filepath.Join(dir, id)
+				assert.NoError(t, ioutil.WriteFile(path, []byte("sam"), 0644))
+				assert.NoError(t, os.Chtimes(path, modified, modified))
+			}
+			testSubject, memory := memoryHandler(t, records)
+			testSubject = testSubject.WithUploadDir(dir).WithUploadTTL(24 * time.Hour)
+
+			//when
+			deleted, err := testSubject.DeleteExpiredUploads(context.Background())
+
+			//then
+			assert.NoError(t, err)
+			assert.Equal(t, tt.deleted, deleted)
+			files, err := ioutil.ReadDir(dir)
+			assert.NoError(t, err)
+			var kept []string
+			for _, file := range files {
+				kept = append(kept, file.Name())
+			}
+			assert.Equal(t, tt.kept, kept)
+			keys, err := memory.Keys(context.Background(), "upload:*")
+			assert.NoError(t, err)
+			assert.Len(t, keys, len(tt.sessions)-tt.deleted)
+		})
+	}
+}
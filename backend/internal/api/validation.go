@@ -0,0 +1,123 @@
+package api
+
+import (
+	"backend/internal/render"
+	"backend/internal/structure"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"github.com/pkg/errors"
+	"image"
+	"strings"
+)
+
+const (
+	// maxImageBytes limits the size of the image file sent for a simulation.
+	maxImageBytes = 10 << 20
+	// maxImageDimension limits the width and height of the image, as the
+	// decoded image is held in memory by the algorithm.
+	maxImageDimension = 8192
+	// maxPriority is the highest priority of a simulation, the default is 0.
+	maxPriority = 9
+	// maxSimulationBody leaves room for the data URL prefix and other fields
+	// next to the base64 encoded image.
+	maxSimulationBody = 4*maxImageBytes/3 + 1<<20
+)
+
+// simulationImageFormats are the formats which can be decoded both here and
+// by the algorithms.
+var simulationImageFormats = map[string]bool{
+	render.FormatJPEG: true,
+	render.FormatPNG:  true,
+}
+
+// validationError lists all problems found in a request, so that the client
+// can fix them at once.
+type validationError []string
+
+func (e validationError) Error() string {
+	return strings.Join(e, "; ")
+}
+
+// validateSimulation checks the fields of a simulation request which do not
+// depend on stored data. Images referenced by id were validated when they were
+// added.
+func validateSimulation(body structure.Body) error {
+	var problems validationError
+	if body.ID == "" {
+		problems = append(problems, "id is required")
+	}
+	if body.Version < 0 {
+		problems = append(problems, "version has to be a positive number")
+	}
+	if body.Priority < 0 || body.Priority > maxPriority {
+		problems = append(problems, fmt.Sprintf("priority has to be between 0 and %d", maxPriority))
+	}
+	switch {
+	case body.Image != "" && body.ImageID != "":
+		problems = append(problems, "only one of image and imageId can be given")
+	case body.ImageID != "":
+		if !imageIDPattern.MatchString(body.ImageID) {
+			problems = append(problems, "imageId has to be a hex encoded SHA-256 checksum")
+		}
+	case body.Image == "":
+		problems = append(problems, "image or imageId is required")
+	default:
+		if err := validateImage(body.Image); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	if len(problems) > 0 {
+		return problems
+	}
+	return nil
+}
+
+// validateImage checks that the image is in a supported format and within the
+// size limits. Only the header is decoded, as decoding the pixels of the
+// largest allowed image takes hundreds of megabytes; images which are broken
+// past the header are reported by the algorithm.
+func validateImage(content string) error {
+	if content == "" {
+		return errors.New("image is required")
+	}
+	if len(content) > base64.StdEncoding.EncodedLen(maxImageBytes)+len("data:image/jpeg;base64,") {
+		return errors.Errorf("image is larger than %d MB", maxImageBytes>>20)
+	}
+	raw, err := render.DecodeBase64(content)
+	if err != nil {
+		return err
+	}
+	if len(raw) > maxImageBytes {
+		return errors.Errorf("image is larger than %d MB", maxImageBytes>>20)
+	}
+	config, format, err := image.DecodeConfig(bytes.NewReader(raw))
+	if err != nil {
+		return errors.New("failed to decode image: " + err.Error())
+	}
+	if !simulationImageFormats[format] {
+		return errors.Errorf("unsupported image format %s, expected jpeg or png", format)
+	}
+	if config.Width > maxImageDimension || config.Height > maxImageDimension {
+		return errors.Errorf("image is %dx%d pixels, expected at most %d pixels per side", config.Width, config.Height, maxImageDimension)
+	}
+	return nil
+}
+
+// modelNotFound describes why a model requested for a simulation can not be
+// used.
+func modelNotFound(algModels *structure.AlgorithmModels, algorithm, name string, version int) string {
+	if name == "" {
+		return fmt.Sprintf("model is required, algorithm %s has no default model", algorithm)
+	}
+	if algModels == nil || len(algModels.Models[name]) == 0 {
+		return fmt.Sprintf("model %s does not exist for algorithm %s", name, algorithm)
+	}
+	if version != 0 {
+		if model, ok := findVersion(algModels.Models[name], version); ok {
+			return fmt.Sprintf("version %d of model %s is %s", version, name, model.Status)
+		}
+		return fmt.Sprintf("model %s has no version %d", name, version)
+	}
+	return fmt.Sprintf("model %s has no ready version", name)
+}
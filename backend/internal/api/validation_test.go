@@ -0,0 +1,149 @@
+package api
+
+import (
+	"backend/internal/structure"
+	"bytes"
+	"encoding/base64"
+	"github.com/stretchr/testify/assert"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+func testImage(t *testing.T, format string, width, height int) string {
+	var buf bytes.Buffer
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	switch format {
+	case "png":
+		assert.NoError(t, png.Encode(&buf, img))
+	case "jpeg":
+		assert.NoError(t, jpeg.Encode(&buf, img, nil))
+	case "gif":
+		assert.NoError(t, gif.Encode(&buf, img, nil))
+	}
+	return "data:image/" + format + ";base64," + base64.StdEncoding.EncodeToString(buf.Bytes())
+}
+
+func TestValidateSimulation(t *testing.T) {
+	valid := testImage(t, "png", 4, 3)
+	tests := []struct {
+		testName string
+		body     structure.Body
+		err      string
+	}{
+		{
+			testName: "should accept png image",
+			body:     structure.Body{ID: "alg1", Image: valid},
+		},
+		{
+			testName: "should accept jpeg image as plain base64",
+			body:     structure.Body{ID: "alg1", Image: strings.TrimPrefix(testImage(t, "jpeg", 4, 3), "data:image/jpeg;base64,")},
+		},
+		{
+			testName: "should return error when fields are missing",
+			body:     structure.Body{Version: -1},
+			err:      "id is required; version has to be a positive number; image or imageId is required",
+		},
+		{
+			testName: "should accept id of stored image",
+			body:     structure.Body{ID: "alg1", ImageID: imageID(valid)},
+		},
+		{
+			testName: "should return error when image id is malformed",
+			body:     structure.Body{ID: "alg1", ImageID: "image.png"},
+			err:      "imageId has to be a hex encoded SHA-256 checksum",
+		},
+		{
+			testName: "should return error when both image and image id are given",
+			body:     structure.Body{ID: "alg1", Image: valid, ImageID: imageID(valid)},
+			err:      "only one of image and imageId can be given",
+		},
+		{
+			testName: "should return error when image is not an image",
+			body:     structure.Body{ID: "alg1", Image: base64.StdEncoding.EncodeToString([]byte("text"))},
+			err:      "failed to decode image: image: unknown format",
+		},
+		{
+			testName: "should return error when image format is not supported",
+			body:     structure.Body{ID: "alg1", Image: testImage(t, "gif", 4, 3)},
+			err:      "unsupported image format gif, expected jpeg or png",
+		},
+		{
+			testName: "should return error when image is too large",
+			body:     structure.Body{ID: "alg1", Image: testImage(t, "png", maxImageDimension+1, 1)},
+			err:      "image is 8193x1 pixels, expected at most 8192 pixels per side",
+		},
+		{
+			testName: "should return error when image header is truncated",
+			body:     structure.Body{ID: "alg1", Image: valid[:len("data:image/png;base64,")+24]},
+			err:      "failed to decode image: unexpected EOF",
+		},
+		{
+			testName: "should return error when file is larger than limit",
+			body:     structure.Body{ID: "alg1", Image: strings.Repeat("A", base64.StdEncoding.EncodedLen(maxImageBytes)+4)},
+			err:      "image is larger than 10 MB",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			//when
+			err := validateSimulation(tt.body)
+
+			//then
+			if tt.err == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.EqualError(t, err, tt.err)
+		})
+	}
+}
+
+func TestModelNotFound(t *testing.T) {
+	algModels := &structure.AlgorithmModels{Models: map[string][]structure.Model{
+		"model.h5": {{Name: "model.h5", Version: 1, Status: modelFailed}},
+	}}
+	tests := []struct {
+		testName  string
+		algModels *structure.AlgorithmModels
+		name      string
+		version   int
+		message   string
+	}{
+		{
+			testName: "should describe missing default model",
+			message:  "model is required, algorithm alg1 has no default model",
+		},
+		{
+			testName:  "should describe missing model",
+			algModels: algModels,
+			name:      "other.h5",
+			message:   "model other.h5 does not exist for algorithm alg1",
+		},
+		{
+			testName:  "should describe version which is not ready",
+			algModels: algModels,
+			name:      "model.h5",
+			version:   1,
+			message:   "version 1 of model model.h5 is failed",
+		},
+		{
+			testName:  "should describe model without ready version",
+			algModels: algModels,
+			name:      "model.h5",
+			message:   "model model.h5 has no ready version",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			//when
+			message := modelNotFound(tt.algModels, "alg1", tt.name, tt.version)
+
+			//then
+			assert.Equal(t, tt.message, message)
+		})
+	}
+}
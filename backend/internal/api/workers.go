@@ -0,0 +1,214 @@
+package api
+
+import (
+	"backend/internal/logging"
+	"backend/internal/structure"
+	"backend/internal/worker"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// defaultLeaseWait is how long a lease request waits for a job, unless the
+// worker sets wait. maxLeaseWait keeps requests shorter than usual proxy
+// timeouts.
+const (
+	defaultLeaseWait = 30 * time.Second
+	maxLeaseWait     = time.Minute
+)
+
+// WithWorkerToken sets the bearer token which workers send to lease jobs and
+// report their results. Without it all requests of workers are rejected.
+func (h Handler) WithWorkerToken(token string) Handler {
+	h.workerToken = token
+	return h
+}
+
+//POST /v1/workers/{alg}/lease
+func (h Handler) LeaseJob(w http.ResponseWriter, r *http.Request) {
+	alg := mux.Vars(r)["alg"]
+	url := strings.Replace(h.leaseEndpoint, "{alg}", alg, 1)
+	if r.URL.Path != url {
+		notFound(w, r)
+		return
+	}
+	if !h.authorizeWorker(w, r) {
+		return
+	}
+	logging.AddFields(r.Context(), zap.String("algorithm", alg))
+	pool := h.workerPool(alg)
+	if pool == nil {
+		writeError(w, r, http.StatusNotFound, "algorithm "+alg+" is not served by workers", nil)
+		return
+	}
+
+	wait := defaultLeaseWait
+	if value := r.URL.Query().Get("wait"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed < 0 || parsed > maxLeaseWait {
+			writeError(w, r, http.StatusBadRequest, "invalid wait, expected duration up to "+maxLeaseWait.String(), nil)
+			return
+		}
+		wait = parsed
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), wait)
+	defer cancel()
+	for {
+		lease, ok := pool.Lease(ctx)
+		if !ok {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		// the job records its lease, so that results of a lease which expired
+		// meanwhile are rejected
+		_, owned, err := h.updateJob(r.Context(), lease.JobID, func(results *structure.Results) bool {
+			if results.Status != "dispatching" && results.Status != "in-progress" {
+				return false
+			}
+			results.Lease = lease.ID
+			return true
+		})
+		if err != nil && err.Error() != "key does not exist" {
+			// the lease expires and the job is offered again
+			writeError(w, r, http.StatusInternalServerError, "failed to lease job", err)
+			return
+		}
+		if owned {
+			logging.AddFields(r.Context(), zap.String("job", lease.JobID))
+			writeLease(w, r, lease, true)
+			return
+		}
+		// the job failed or was removed while it waited for a worker
+		pool.Complete(lease.ID)
+	}
+}
+
+//POST /v1/workers/{alg}/leases/{id}/heartbeat
+func (h Handler) Heartbeat(w http.ResponseWriter, r *http.Request) {
+	pool, id, ok := h.leaseRequest(w, r, h.heartbeatEndpoint)
+	if !ok {
+		return
+	}
+	lease, err := pool.Heartbeat(id)
+	if err != nil {
+		writeError(w, r, http.StatusNotFound, "lease "+id+" does not exist or expired", nil)
+		return
+	}
+	writeLease(w, r, lease, false)
+}
+
+//PUT /v1/workers/{alg}/leases/{id}/results
+func (h Handler) SubmitLeaseResults(w http.ResponseWriter, r *http.Request) {
+	pool, id, ok := h.leaseRequest(w, r, h.leaseResultsEndpoint)
+	if !ok {
+		return
+	}
+	if r.Header.Get("Content-Type") != "application/json" {
+		writeError(w, r, http.StatusBadRequest, "invalid content type", nil)
+		return
+	}
+	value, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "failed to read body", err)
+		return
+	}
+	var data structure.Data
+	if err = json.Unmarshal(value, &data); err != nil {
+		writeError(w, r, http.StatusBadRequest, "failed to unmarshal", err)
+		return
+	}
+
+	// the lease is extended, so that it does not expire while results are
+	// stored, and kept when they can not be stored, so the worker can retry
+	lease, err := pool.Heartbeat(id)
+	if err != nil {
+		writeError(w, r, http.StatusNotFound, "lease "+id+" does not exist or expired", nil)
+		return
+	}
+	logging.AddFields(r.Context(), zap.String("job", lease.JobID))
+	entry := h.auditEntry(r, actionJobResults, lease.JobID)
+	if entry.Actor == "" {
+		entry.Actor = "worker:" + mux.Vars(r)["alg"]
+	}
+	if err = h.finishJob(r.Context(), lease.JobID, id, data.Content, entry); err != nil {
+		// a lease whose job finished or was given to another worker is ended
+		if !retryable(err) {
+			pool.Complete(id)
+		}
+		writeStatusError(w, r, err)
+		return
+	}
+	pool.Complete(id)
+}
+
+// leaseRequest checks the path and the worker token of a request for a lease
+// and returns the pool of its algorithm and the lease id. It replies with an
+// error when it returns false.
+func (h Handler) leaseRequest(w http.ResponseWriter, r *http.Request, endpoint string) (IWorkerPool, string, bool) {
+	params := mux.Vars(r)
+	alg, id := params["alg"], params["id"]
+	url := strings.Replace(strings.Replace(endpoint, "{alg}", alg, 1), "{id}", id, 1)
+	if r.URL.Path != url {
+		notFound(w, r)
+		return nil, "", false
+	}
+	if !h.authorizeWorker(w, r) {
+		return nil, "", false
+	}
+	logging.AddFields(r.Context(), zap.String("algorithm", alg), zap.String("lease", id))
+	pool := h.workerPool(alg)
+	if pool == nil {
+		writeError(w, r, http.StatusNotFound, "algorithm "+alg+" is not served by workers", nil)
+		return nil, "", false
+	}
+	return pool, id, true
+}
+
+// authorizeWorker replies with 401 and returns false, when the request does
+// not carry the worker token.
+func (h Handler) authorizeWorker(w http.ResponseWriter, r *http.Request) bool {
+	if !hasToken(r, h.workerToken) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeError(w, r, http.StatusUnauthorized, "invalid worker token", nil)
+		return false
+	}
+	return true
+}
+
+func (h Handler) workerPool(alg string) IWorkerPool {
+	for _, item := range h.iAlgorithm {
+		if pool, ok := item.(IWorkerPool); ok && pool.GetID() == alg {
+			return pool
+		}
+	}
+	return nil
+}
+
+func writeLease(w http.ResponseWriter, r *http.Request, lease worker.Lease, withJob bool) {
+	response := structure.Lease{
+		ID:        lease.ID,
+		JobID:     lease.JobID,
+		Operation: lease.Operation,
+		ExpiresAt: lease.ExpiresAt.Format(time.RFC3339),
+	}
+	if withJob {
+		response.Job = lease.Data
+	}
+	jsonLease, err := json.Marshal(response)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "failed to marshal lease", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if _, err = fmt.Fprint(w, string(jsonLease)); err != nil {
+		log.Print("failed to write response: " + err.Error())
+	}
+}
@@ -0,0 +1,343 @@
+package api
+
+import (
+	"backend/internal/api/mocks"
+	"backend/internal/structure"
+	"backend/internal/worker"
+	"bytes"
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// workerToken is the token of workers in tests, which send it unless a test
+// sets another one.
+const workerToken = "secret"
+
+func TestHandler_LeaseJob(t *testing.T) {
+	jobID := "2009-11-10T20:34:58Zalg2demo"
+	expiresAt := time.Date(2009, 11, 10, 20, 35, 58, 0, time.UTC)
+	lease := worker.Lease{ID: "lease1", JobID: jobID, Operation: "demo", Data: []byte(`{"id":"` + jobID + `"}`), ExpiresAt: expiresAt}
+	jsonInProgress, err := json.Marshal(structure.Results{Algorithm: "alg2", Status: "in-progress"})
+	assert.NoError(t, err)
+	jsonLeased, err := json.Marshal(structure.Results{Algorithm: "alg2", Status: "in-progress", Lease: "lease1"})
+	assert.NoError(t, err)
+	jsonFailed, err := json.Marshal(structure.Results{Algorithm: "alg2", Status: "error"})
+	assert.NoError(t, err)
+	type leased struct {
+		jobReturned string
+		jobError    error
+	}
+	tests := []struct {
+		testName        string
+		requestURL      string
+		alg             string
+		token           string
+		leases          []leased
+		assertNoOfLease int
+		leasedJob       string
+		completed       int
+		bodyContains    string
+		statusCode      int
+	}{
+		{
+			testName:     "should return 404 when url is wrong",
+			requestURL:   "/v1/workers/alg2/wrong",
+			alg:          "alg2",
+			bodyContains: `"code":"not_found"`,
+			statusCode:   http.StatusNotFound,
+		},
+		{
+			testName:     "should return 401 when worker token is wrong",
+			requestURL:   "/v1/workers/alg2/lease",
+			alg:          "alg2",
+			token:        "wrong",
+			bodyContains: `{"code":"unauthorized","message":"invalid worker token"}`,
+			statusCode:   http.StatusUnauthorized,
+		},
+		{
+			testName:     "should return 404 when algorithm is not served by workers",
+			requestURL:   "/v1/workers/alg1/lease",
+			alg:          "alg1",
+			bodyContains: "algorithm alg1 is not served by workers",
+			statusCode:   http.StatusNotFound,
+		},
+		{
+			testName:     "should return 400 when wait is too long",
+			requestURL:   "/v1/workers/alg2/lease?wait=2m",
+			alg:          "alg2",
+			bodyContains: "invalid wait, expected duration up to 1m0s",
+			statusCode:   http.StatusBadRequest,
+		},
+		{
+			testName:        "should return 204 when there is no job",
+			requestURL:      "/v1/workers/alg2/lease?wait=1s",
+			alg:             "alg2",
+			assertNoOfLease: 1,
+			statusCode:      http.StatusNoContent,
+		},
+		{
+			testName:        "should return lease with job",
+			requestURL:      "/v1/workers/alg2/lease",
+			alg:             "alg2",
+			leases:          []leased{{jobReturned: string(jsonInProgress)}},
+			assertNoOfLease: 1,
+			leasedJob:       string(jsonLeased),
+			bodyContains:    `{"id":"lease1","jobId":"` + jobID + `","operation":"demo","expiresAt":"2009-11-10T20:35:58Z","job":{"id":"` + jobID + `"}}`,
+			statusCode:      http.StatusOK,
+		},
+		{
+			testName:        "should skip jobs which failed or do not exist any more",
+			requestURL:      "/v1/workers/alg2/lease",
+			alg:             "alg2",
+			leases:          []leased{{jobReturned: string(jsonFailed)}, {jobError: errors.New("key does not exist")}},
+			assertNoOfLease: 3,
+			completed:       2,
+			statusCode:      http.StatusNoContent,
+		},
+		{
+			testName:        "should return 500 when job can not be read",
+			requestURL:      "/v1/workers/alg2/lease",
+			alg:             "alg2",
+			leases:          []leased{{jobError: errors.New("connection refused")}},
+			assertNoOfLease: 1,
+			bodyContains:    `"message":"failed to lease job","details":"connection refused"`,
+			statusCode:      http.StatusInternalServerError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			//given
+			r, err := http.NewRequest("POST", tt.requestURL, nil)
+			assert.NoError(t, err)
+			r = mux.SetURLVars(r, map[string]string{"alg": tt.alg})
+			r.Header.Set("Authorization", "Bearer "+bearer(tt.token))
+			w := httptest.NewRecorder()
+			iDatabaseMock := mocks.IDatabase{}
+			iAlgorithmMock := mocks.IAlgorithm{}
+			iWorkerPoolMock := mocks.IWorkerPool{}
+			testSubject := NewHandler(&iDatabaseMock, []IAlgorithm{&iAlgorithmMock, &iWorkerPoolMock}).WithWorkerToken(workerToken)
+			iAlgorithmMock.On("GetID").Return("alg1")
+			iWorkerPoolMock.On("GetID").Return("alg2")
+			for _, l := range tt.leases {
+				iWorkerPoolMock.On("Lease", mock.Anything).Return(lease, true).Once()
+				iDatabaseMock.On("Get", mock.Anything, jobID).Return(l.jobReturned, l.jobError).Once()
+			}
+			iWorkerPoolMock.On("Lease", mock.Anything).Return(worker.Lease{}, false)
+			iWorkerPoolMock.On("Complete", "lease1").Return(lease, nil)
+			var stored string
+			iDatabaseMock.On("Set", mock.Anything, jobID, mock.AnythingOfType("string")).Return(nil).Run(func(args mock.Arguments) {
+				stored = args.String(2)
+			})
+			mockUpdate(&iDatabaseMock, jobID)
+
+			//when
+			testSubject.LeaseJob(w, r)
+
+			//then
+			assert.Equal(t, tt.leasedJob, stored)
+			iWorkerPoolMock.AssertNumberOfCalls(t, "Lease", tt.assertNoOfLease)
+			iWorkerPoolMock.AssertNumberOfCalls(t, "Complete", tt.completed)
+			assert.Contains(t, w.Body.String(), tt.bodyContains)
+			assert.Equal(t, tt.statusCode, w.Code)
+		})
+	}
+}
+
+func TestHandler_Heartbeat(t *testing.T) {
+	expiresAt := time.Date(2009, 11, 10, 20, 35, 58, 0, time.UTC)
+	lease := worker.Lease{ID: "lease1", JobID: "job1", Operation: "demo", Data: []byte(`{"id":"job1"}`), ExpiresAt: expiresAt}
+	tests := []struct {
+		testName       string
+		requestURL     string
+		token          string
+		heartbeatError error
+		bodyContains   string
+		statusCode     int
+	}{
+		{
+			testName:     "should return 404 when url is wrong",
+			requestURL:   "/v1/workers/alg2/leases/lease1/wrong",
+			bodyContains: `"code":"not_found"`,
+			statusCode:   http.StatusNotFound,
+		},
+		{
+			testName:     "should return 401 when worker token is missing",
+			requestURL:   "/v1/workers/alg2/leases/lease1/heartbeat",
+			token:        "-",
+			bodyContains: `{"code":"unauthorized","message":"invalid worker token"}`,
+			statusCode:   http.StatusUnauthorized,
+		},
+		{
+			testName:       "should return 404 when lease expired",
+			requestURL:     "/v1/workers/alg2/leases/lease1/heartbeat",
+			heartbeatError: worker.ErrUnknownLease,
+			bodyContains:   "lease lease1 does not exist or expired",
+			statusCode:     http.StatusNotFound,
+		},
+		{
+			testName:     "should return extended lease without job",
+			requestURL:   "/v1/workers/alg2/leases/lease1/heartbeat",
+			bodyContains: `{"id":"lease1","jobId":"job1","operation":"demo","expiresAt":"2009-11-10T20:35:58Z"}`,
+			statusCode:   http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			//given
+			r, err := http.NewRequest("POST", tt.requestURL, nil)
+			assert.NoError(t, err)
+			r = mux.SetURLVars(r, map[string]string{"alg": "alg2", "id": "lease1"})
+			if tt.token != "-" {
+				r.Header.Set("Authorization", "Bearer "+bearer(tt.token))
+			}
+			w := httptest.NewRecorder()
+			iWorkerPoolMock := mocks.IWorkerPool{}
+			testSubject := NewHandler(&mocks.IDatabase{}, []IAlgorithm{&iWorkerPoolMock}).WithWorkerToken(workerToken)
+			iWorkerPoolMock.On("GetID").Return("alg2")
+			iWorkerPoolMock.On("Heartbeat", "lease1").Return(lease, tt.heartbeatError)
+
+			//when
+			testSubject.Heartbeat(w, r)
+
+			//then
+			assert.Contains(t, w.Body.String(), tt.bodyContains)
+			assert.Equal(t, tt.statusCode, w.Code)
+		})
+	}
+}
+
+func TestHandler_SubmitLeaseResults(t *testing.T) {
+	jobID := "2009-11-10T20:34:58Zalg2demo"
+	lease := worker.Lease{ID: "lease1", JobID: jobID, Operation: "demo"}
+	jsonBody, err := json.Marshal(structure.Data{Content: "results"})
+	assert.NoError(t, err)
+	jsonInProgress, err := json.Marshal(structure.Results{Algorithm: "alg2", Status: "in-progress", Lease: "lease1"})
+	assert.NoError(t, err)
+	jsonReleased, err := json.Marshal(structure.Results{Algorithm: "alg2", Status: "in-progress", Lease: "lease2"})
+	assert.NoError(t, err)
+	jsonFinished, err := json.Marshal(structure.Results{Algorithm: "alg2", Status: "finished", Result: "results", Lease: "lease1"})
+	assert.NoError(t, err)
+	tests := []struct {
+		testName         string
+		body             io.Reader
+		contentType      string
+		token            string
+		heartbeatError   error
+		jobReturned      string
+		insertError      error
+		assertNoOfInsert int
+		completed        int
+		bodyContains     string
+		statusCode       int
+	}{
+		{
+			testName:     "should return 401 when worker token is wrong",
+			body:         bytes.NewBuffer(jsonBody),
+			contentType:  "application/json",
+			token:        "wrong",
+			bodyContains: `{"code":"unauthorized","message":"invalid worker token"}`,
+			statusCode:   http.StatusUnauthorized,
+		},
+		{
+			testName:     "should return 400 when content type is invalid",
+			body:         bytes.NewBuffer(jsonBody),
+			contentType:  "text/plain",
+			bodyContains: "invalid content type",
+			statusCode:   http.StatusBadRequest,
+		},
+		{
+			testName:       "should return 404 when lease expired",
+			body:           bytes.NewBuffer(jsonBody),
+			contentType:    "application/json",
+			heartbeatError: worker.ErrUnknownLease,
+			bodyContains:   "lease lease1 does not exist or expired",
+			statusCode:     http.StatusNotFound,
+		},
+		{
+			testName:     "should return 409 and end lease when job was leased again",
+			body:         bytes.NewBuffer(jsonBody),
+			contentType:  "application/json",
+			jobReturned:  string(jsonReleased),
+			completed:    1,
+			bodyContains: "lease lease1 does not hold job " + jobID,
+			statusCode:   http.StatusConflict,
+		},
+		{
+			testName:     "should return 409 and end lease when job already finished",
+			body:         bytes.NewBuffer(jsonBody),
+			contentType:  "application/json",
+			jobReturned:  string(jsonFinished),
+			completed:    1,
+			bodyContains: "job " + jobID + " is finished",
+			statusCode:   http.StatusConflict,
+		},
+		{
+			testName:         "should keep lease when results can not be stored",
+			body:             bytes.NewBuffer(jsonBody),
+			contentType:      "application/json",
+			jobReturned:      string(jsonInProgress),
+			insertError:      errors.New("failed to insert"),
+			assertNoOfInsert: 1,
+			bodyContains:     "failed to insert",
+			statusCode:       http.StatusInternalServerError,
+		},
+		{
+			testName:         "should store results of leased job and complete lease",
+			body:             bytes.NewBuffer(jsonBody),
+			contentType:      "application/json",
+			jobReturned:      string(jsonInProgress),
+			assertNoOfInsert: 1,
+			completed:        1,
+			statusCode:       http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			//given
+			r, err := http.NewRequest("PUT", "/v1/workers/alg2/leases/lease1/results", tt.body)
+			assert.NoError(t, err)
+			r = mux.SetURLVars(r, map[string]string{"alg": "alg2", "id": "lease1"})
+			r.Header.Set("Content-Type", tt.contentType)
+			r.Header.Set("Authorization", "Bearer "+bearer(tt.token))
+			w := httptest.NewRecorder()
+			iDatabaseMock := mocks.IDatabase{}
+			iWorkerPoolMock := mocks.IWorkerPool{}
+			iQueueMock := mocks.IQueue{}
+			testSubject := NewHandler(&iDatabaseMock, []IAlgorithm{&iWorkerPoolMock}).WithQueue(&iQueueMock).WithWorkerToken(workerToken)
+			iWorkerPoolMock.On("GetID").Return("alg2")
+			iWorkerPoolMock.On("Heartbeat", "lease1").Return(lease, tt.heartbeatError)
+			iWorkerPoolMock.On("Complete", "lease1").Return(lease, nil)
+			iDatabaseMock.On("Get", mock.Anything, jobID).Return(tt.jobReturned, nil)
+			iDatabaseMock.On("Set", mock.Anything, jobID, string(jsonFinished)).Return(tt.insertError)
+			iDatabaseMock.On("Delete", mock.Anything, "pending:"+jobID).Return(nil)
+			mockUpdate(&iDatabaseMock, jobID)
+			iQueueMock.On("Done", jobID)
+
+			//when
+			testSubject.SubmitLeaseResults(w, r)
+
+			//then
+			iDatabaseMock.AssertNumberOfCalls(t, "Set", tt.assertNoOfInsert)
+			iWorkerPoolMock.AssertNumberOfCalls(t, "Complete", tt.completed)
+			assert.Contains(t, w.Body.String(), tt.bodyContains)
+			assert.Equal(t, tt.statusCode, w.Code)
+		})
+	}
+}
+
+// bearer returns the token a worker sends, which is the worker token unless
+// the test sets another one.
+func bearer(token string) string {
+	if token == "" {
+		return workerToken
+	}
+	return token
+}
@@ -0,0 +1,121 @@
+// Package audit keeps the trail of mutating operations. Every entry is stored
+// under its own key, which starts with the time of the entry, so entries are
+// never lost to concurrent writes and can be selected by time without reading
+// them.
+package audit
+
+import (
+	"backend/internal/structure"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	keyPrefix = "audit:"
+	// timeFormat has a fixed width, so that keys sort by time.
+	timeFormat = "2006-01-02T15:04:05.000000000Z"
+)
+
+// Store is the part of the database the log is kept in.
+type Store interface {
+	Set(ctx context.Context, key string, value string) error
+	Get(ctx context.Context, key string) (interface{}, error)
+	Keys(ctx context.Context, pattern string) ([]string, error)
+}
+
+// Filter selects entries of the log. Empty fields match any entry, From and
+// To include entries at exactly that time.
+type Filter struct {
+	Actor     string
+	Action    string
+	Resource  string
+	RequestID string
+	From      time.Time
+	To        time.Time
+	// Limit is the maximum number of entries returned, zero returns all.
+	Limit int
+}
+
+type Log struct {
+	store Store
+}
+
+func New(store Store) *Log {
+	return &Log{store: store}
+}
+
+// Record stores the entry with the current time and a new id.
+func (l *Log) Record(ctx context.Context, entry structure.AuditEntry) error {
+	now := time.Now().UTC()
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return err
+	}
+	entry.ID = now.Format(timeFormat) + "-" + hex.EncodeToString(suffix)
+	entry.Time = now.Format(time.RFC3339Nano)
+	jsonEntry, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return l.store.Set(ctx, keyPrefix+entry.ID, string(jsonEntry))
+}
+
+// Query returns the entries matching the filter, newest first.
+func (l *Log) Query(ctx context.Context, filter Filter) ([]structure.AuditEntry, error) {
+	keys, err := l.store.Keys(ctx, keyPrefix+"*")
+	if err != nil {
+		return nil, err
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(keys)))
+
+	entries := []structure.AuditEntry{}
+	for _, key := range keys {
+		if filter.Limit > 0 && len(entries) == filter.Limit {
+			break
+		}
+		if !filter.covers(key) {
+			continue
+		}
+		fromDB, err := l.store.Get(ctx, key)
+		if err != nil {
+			if err.Error() == "key does not exist" {
+				continue
+			}
+			return nil, err
+		}
+		var entry structure.AuditEntry
+		if err = json.Unmarshal([]byte(fromDB.(string)), &entry); err != nil {
+			return nil, errors.New("failed to unmarshal audit entry " + key)
+		}
+		if filter.matches(entry) {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+// covers reports whether the time in the key is within the filter.
+func (f Filter) covers(key string) bool {
+	stamp := strings.TrimPrefix(key, keyPrefix)
+	if len(stamp) < len(timeFormat) {
+		return false
+	}
+	at, err := time.Parse(timeFormat, stamp[:len(timeFormat)])
+	if err != nil {
+		return false
+	}
+	return (f.From.IsZero() || !at.Before(f.From)) && (f.To.IsZero() || !at.After(f.To))
+}
+
+func (f Filter) matches(entry structure.AuditEntry) bool {
+	return (f.Actor == "" || f.Actor == entry.Actor) &&
+		(f.Action == "" || f.Action == entry.Action) &&
+		(f.Resource == "" || f.Resource == entry.Resource) &&
+		(f.RequestID == "" || f.RequestID == entry.RequestID)
+}
@@ -0,0 +1,59 @@
+package audit
+
+import (
+	"backend/internal/database"
+	"backend/internal/structure"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestLog(t *testing.T) {
+	memory, err := database.NewMemory("")
+	assert.NoError(t, err)
+	log := New(memory)
+	ctx := context.Background()
+	start := time.Now().UTC()
+	assert.NoError(t, log.Record(ctx, structure.AuditEntry{Actor: "anna", Action: "image.add", Resource: "a1", RequestID: "r1"}))
+	assert.NoError(t, log.Record(ctx, structure.AuditEntry{Actor: "admin", Action: "results.purge", Resource: "results"}))
+	middle := time.Now().UTC()
+	assert.NoError(t, log.Record(ctx, structure.AuditEntry{Actor: "anna", Action: "model.default", Resource: "alg1",
+		Before: map[string]string{"default": "coco"}, After: map[string]string{"default": "balloon"}}))
+	assert.NoError(t, memory.Set(ctx, "2009-11-10T20:34:58Zalg1demo", "{}"))
+
+	tests := []struct {
+		testName string
+		filter   Filter
+		actions  []string
+	}{
+		{testName: "should return all entries newest first", actions: []string{"model.default", "results.purge", "image.add"}},
+		{testName: "should filter by actor", filter: Filter{Actor: "anna"}, actions: []string{"model.default", "image.add"}},
+		{testName: "should filter by action and resource", filter: Filter{Action: "image.add", Resource: "a1"}, actions: []string{"image.add"}},
+		{testName: "should filter by request id", filter: Filter{RequestID: "r1"}, actions: []string{"image.add"}},
+		{testName: "should filter by time", filter: Filter{From: start, To: middle}, actions: []string{"results.purge", "image.add"}},
+		{testName: "should limit entries", filter: Filter{Limit: 1}, actions: []string{"model.default"}},
+		{testName: "should return no entries", filter: Filter{Actor: "other"}, actions: []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			//when
+			entries, err := log.Query(ctx, tt.filter)
+
+			//then
+			assert.NoError(t, err)
+			actions := []string{}
+			for _, entry := range entries {
+				actions = append(actions, entry.Action)
+				assert.NotEmpty(t, entry.ID)
+				assert.NotEmpty(t, entry.Time)
+			}
+			assert.Equal(t, tt.actions, actions)
+		})
+	}
+
+	entries, err := log.Query(ctx, Filter{Action: "model.default"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"default": "coco"}, entries[0].Before)
+	assert.Equal(t, map[string]string{"default": "balloon"}, entries[0].After)
+}
@@ -0,0 +1,295 @@
+// Package backup writes and reads archives with the state of the backend: a
+// gzipped tar with records of the database in records.jsonl, images in the
+// images directory and manifest.json, which describes the archive and holds
+// checksums of all other files.
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	// FormatVersion is the version of the archive layout written by Writer.
+	FormatVersion = 1
+
+	manifestFile = "manifest.json"
+	recordsFile  = "records.jsonl"
+	imagesDir    = "images/"
+)
+
+// Manifest describes an archive. SchemaVersion is the version of the
+// database schema the records were read from.
+type Manifest struct {
+	Format        int               `json:"format"`
+	SchemaVersion int               `json:"schemaVersion"`
+	CreatedAt     string            `json:"createdAt"`
+	Records       int               `json:"records"`
+	Images        int               `json:"images"`
+	Checksums     map[string]string `json:"checksums"`
+}
+
+// Record is a value of the database stored under Key.
+type Record struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// Loader receives the records and images of an archive while it is read.
+type Loader interface {
+	AddRecord(key, value string) error
+	AddImage(id, content string) error
+}
+
+// Writer writes an archive. Images are written as they are added, records are
+// spooled to a temporary file and written on Close, followed by the manifest.
+// Writers which fail before they are closed have to be discarded.
+type Writer struct {
+	gzip     *gzip.Writer
+	tar      *tar.Writer
+	records  *os.File
+	manifest Manifest
+}
+
+func NewWriter(w io.Writer, schemaVersion int) *Writer {
+	gz := gzip.NewWriter(w)
+	return &Writer{
+		gzip: gz,
+		tar:  tar.NewWriter(gz),
+		manifest: Manifest{
+			Format:        FormatVersion,
+			SchemaVersion: schemaVersion,
+			CreatedAt:     time.Now().UTC().Format(time.RFC3339),
+			Checksums:     map[string]string{},
+		},
+	}
+}
+
+func (w *Writer) AddRecord(key, value string) error {
+	if w.records == nil {
+		records, err := ioutil.TempFile("", "backup-*.jsonl")
+		if err != nil {
+			return errors.Wrap(err, "failed to create records file")
+		}
+		w.records = records
+	}
+	jsonRecord, err := json.Marshal(Record{Key: key, Value: value})
+	if err != nil {
+		return err
+	}
+	if _, err = w.records.Write(append(jsonRecord, '\n')); err != nil {
+		return errors.Wrap(err, "failed to write records file")
+	}
+	w.manifest.Records++
+	return nil
+}
+
+func (w *Writer) AddImage(id, content string) error {
+	if id == "" || strings.ContainsAny(id, "/\\") || id == "." || id == ".." {
+		return errors.New("invalid image id " + id)
+	}
+	if err := w.writeFile(imagesDir+id, strings.NewReader(content), int64(len(content))); err != nil {
+		return err
+	}
+	w.manifest.Images++
+	return nil
+}
+
+// Close writes the records and the manifest. It does not close the
+// underlying writer.
+func (w *Writer) Close() error {
+	defer w.Discard()
+	var records io.Reader = &bytes.Buffer{}
+	var size int64
+	if w.records != nil {
+		info, err := w.records.Stat()
+		if err != nil {
+			return err
+		}
+		if _, err = w.records.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		records, size = w.records, info.Size()
+	}
+	if err := w.writeFile(recordsFile, records, size); err != nil {
+		return err
+	}
+
+	jsonManifest, err := json.MarshalIndent(w.manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = w.writeFile(manifestFile, bytes.NewReader(jsonManifest), int64(len(jsonManifest))); err != nil {
+		return err
+	}
+	if err = w.tar.Close(); err != nil {
+		return err
+	}
+	return w.gzip.Close()
+}
+
+// Discard removes the records spooled so far. It is safe to call after Close.
+func (w *Writer) Discard() {
+	if w.records == nil {
+		return
+	}
+	w.records.Close()
+	os.Remove(w.records.Name())
+	w.records = nil
+}
+
+func (w *Writer) writeFile(name string, content io.Reader, size int64) error {
+	header := &tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    size,
+		ModTime: time.Now(),
+	}
+	if err := w.tar.WriteHeader(header); err != nil {
+		return err
+	}
+	checksum := sha256.New()
+	if _, err := io.Copy(w.tar, io.TeeReader(content, checksum)); err != nil {
+		return err
+	}
+	if name != manifestFile {
+		w.manifest.Checksums[name] = hex.EncodeToString(checksum.Sum(nil))
+	}
+	return nil
+}
+
+// Read reads the archive from r and passes its records and images to the
+// loader as they are unpacked, so archives are never held in memory as a
+// whole. Archives of a newer format, with files missing from the manifest or
+// with files whose checksums do not match are rejected, as are archives whose
+// files take more than maxSize bytes together, before the files are read. The
+// manifest comes last, so the archive is only validated after everything was
+// loaded; callers discard what was loaded when Read fails.
+func Read(r io.Reader, maxSize int64, loader Loader) (Manifest, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return Manifest{}, errors.Wrap(err, "invalid archive")
+	}
+	defer gz.Close()
+
+	var manifest *Manifest
+	checksums := map[string]string{}
+	var records, images int
+	var size int64
+	reader := tar.NewReader(gz)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return Manifest{}, errors.Wrap(err, "invalid archive")
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		name := path.Clean(header.Name)
+		if _, ok := checksums[name]; ok || (name == manifestFile && manifest != nil) {
+			return Manifest{}, errors.New("duplicate file " + name + " in archive")
+		}
+		if size += header.Size; size > maxSize {
+			return Manifest{}, errors.Errorf("archive is larger than %d MB", maxSize>>20)
+		}
+
+		checksum := sha256.New()
+		content := io.TeeReader(reader, checksum)
+		switch {
+		case name == manifestFile:
+			manifest = &Manifest{}
+			if err = json.NewDecoder(content).Decode(manifest); err != nil {
+				return Manifest{}, errors.New("invalid " + manifestFile)
+			}
+			continue
+		case name == recordsFile:
+			records, err = readRecords(content, loader)
+		case strings.HasPrefix(name, imagesDir):
+			images++
+			err = readImage(content, strings.TrimPrefix(name, imagesDir), loader)
+		}
+		if err != nil {
+			return Manifest{}, err
+		}
+		// files which are not loaded are still checked against the manifest
+		if _, err = io.Copy(ioutil.Discard, content); err != nil {
+			return Manifest{}, errors.Wrap(err, "invalid archive")
+		}
+		checksums[name] = hex.EncodeToString(checksum.Sum(nil))
+	}
+
+	if manifest == nil {
+		return Manifest{}, errors.New("archive has no " + manifestFile)
+	}
+	if manifest.Format < 1 || manifest.Format > FormatVersion {
+		return Manifest{}, errors.Errorf("archive format %d is not supported, expected at most %d", manifest.Format, FormatVersion)
+	}
+	if err = checkFiles(manifest.Checksums, checksums); err != nil {
+		return Manifest{}, err
+	}
+	if records != manifest.Records || images != manifest.Images {
+		return Manifest{}, errors.Errorf("archive has %d records and %d images, manifest lists %d and %d",
+			records, images, manifest.Records, manifest.Images)
+	}
+	return *manifest, nil
+}
+
+// checkFiles compares the checksums of the files with the checksums of the
+// manifest.
+func checkFiles(expected map[string]string, checksums map[string]string) error {
+	if _, ok := checksums[recordsFile]; !ok {
+		return errors.New("archive has no " + recordsFile)
+	}
+	for name, checksum := range checksums {
+		if _, ok := expected[name]; !ok {
+			return errors.New("file " + name + " is not listed in " + manifestFile)
+		}
+		if checksum != expected[name] {
+			return errors.New("checksum of " + name + " does not match")
+		}
+	}
+	for name := range expected {
+		if _, ok := checksums[name]; !ok {
+			return errors.New("file " + name + " is missing from archive")
+		}
+	}
+	return nil
+}
+
+func readRecords(content io.Reader, loader Loader) (int, error) {
+	var records int
+	decoder := json.NewDecoder(content)
+	for decoder.More() {
+		var record Record
+		if err := decoder.Decode(&record); err != nil || record.Key == "" {
+			return 0, errors.Errorf("invalid record %d in %s", records+1, recordsFile)
+		}
+		if err := loader.AddRecord(record.Key, record.Value); err != nil {
+			return 0, err
+		}
+		records++
+	}
+	return records, nil
+}
+
+func readImage(content io.Reader, id string, loader Loader) error {
+	image, err := ioutil.ReadAll(content)
+	if err != nil {
+		return errors.Wrap(err, "invalid archive")
+	}
+	return loader.AddImage(id, string(image))
+}
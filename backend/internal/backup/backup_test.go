@@ -0,0 +1,169 @@
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+// archive writes the files to a gzipped tar.
+func archive(t *testing.T, files map[string]string) *bytes.Buffer {
+	var buffer bytes.Buffer
+	gz := gzip.NewWriter(&buffer)
+	tw := tar.NewWriter(gz)
+	for name, content := range files {
+		assert.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content))}))
+		_, err := tw.Write([]byte(content))
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, tw.Close())
+	assert.NoError(t, gz.Close())
+	return &buffer
+}
+
+func manifest(t *testing.T, m Manifest) string {
+	content, err := json.Marshal(m)
+	assert.NoError(t, err)
+	return string(content)
+}
+
+// loader collects the records and images of an archive.
+type loader struct {
+	records []Record
+	images  map[string]string
+}
+
+func (l *loader) AddRecord(key, value string) error {
+	l.records = append(l.records, Record{Key: key, Value: value})
+	return nil
+}
+
+func (l *loader) AddImage(id, content string) error {
+	if l.images == nil {
+		l.images = map[string]string{}
+	}
+	l.images[id] = content
+	return nil
+}
+
+func TestWriter(t *testing.T) {
+	t.Run("should read written archive", func(t *testing.T) {
+		//given
+		var buffer bytes.Buffer
+		writer := NewWriter(&buffer, 4)
+		assert.NoError(t, writer.AddRecord("models", `{"algorithms":{}}`))
+		assert.NoError(t, writer.AddRecord("images", `{"ids":["a1"]}`))
+		assert.NoError(t, writer.AddImage("a1", "data:image/png;base64,iVBORw0KGgo="))
+		assert.NoError(t, writer.Close())
+
+		loaded := &loader{}
+
+		//when
+		manifest, err := Read(&buffer, 1<<20, loaded)
+
+		//then
+		assert.NoError(t, err)
+		assert.Equal(t, FormatVersion, manifest.Format)
+		assert.Equal(t, 4, manifest.SchemaVersion)
+		assert.Equal(t, []Record{{Key: "models", Value: `{"algorithms":{}}`}, {Key: "images", Value: `{"ids":["a1"]}`}}, loaded.records)
+		assert.Equal(t, map[string]string{"a1": "data:image/png;base64,iVBORw0KGgo="}, loaded.images)
+	})
+	t.Run("should write archive without records", func(t *testing.T) {
+		//given
+		var buffer bytes.Buffer
+		writer := NewWriter(&buffer, 4)
+		assert.NoError(t, writer.Close())
+
+		//when
+		manifest, err := Read(&buffer, 1<<20, &loader{})
+
+		//then
+		assert.NoError(t, err)
+		assert.Equal(t, 0, manifest.Records)
+	})
+	t.Run("should reject image ids which are not file names", func(t *testing.T) {
+		//given
+		writer := NewWriter(&bytes.Buffer{}, 4)
+
+		//when
+		err := writer.AddImage("../a1", "content")
+
+		//then
+		assert.EqualError(t, err, "invalid image id ../a1")
+	})
+}
+
+func TestRead(t *testing.T) {
+	records := `{"key":"models","value":"{}"}` + "\n"
+	otherChecksum := "8b3bbe8a2a5b8e5acb55ef45a08be11b9a3bde6e2d20de8e8b05a6e2e9b1cf3b"
+	image := strings.Repeat("a", 600<<10)
+	tests := []struct {
+		testName string
+		files    map[string]string
+		err      string
+	}{
+		{
+			testName: "should reject archive without manifest",
+			files:    map[string]string{"records.jsonl": records},
+			err:      "archive has no manifest.json",
+		},
+		{
+			testName: "should reject newer format",
+			files:    map[string]string{"manifest.json": manifest(t, Manifest{Format: FormatVersion + 1})},
+			err:      "archive format 2 is not supported, expected at most 1",
+		},
+		{
+			testName: "should reject files which do not match checksums",
+			files: map[string]string{
+				"manifest.json": manifest(t, Manifest{Format: 1, Records: 1, Checksums: map[string]string{"records.jsonl": otherChecksum}}),
+				"records.jsonl": records,
+			},
+			err: "checksum of records.jsonl does not match",
+		},
+		{
+			testName: "should reject files which are not listed",
+			files: map[string]string{
+				"manifest.json": manifest(t, Manifest{Format: 1, Checksums: map[string]string{}}),
+				"records.jsonl": records,
+			},
+			err: "file records.jsonl is not listed in manifest.json",
+		},
+		{
+			testName: "should reject files larger than limit",
+			files:    map[string]string{"images/a1": image + image},
+			err:      "archive is larger than 1 MB",
+		},
+		{
+			testName: "should reject files which are larger than limit together",
+			files:    map[string]string{"images/a1": image, "images/a2": image},
+			err:      "archive is larger than 1 MB",
+		},
+		{
+			testName: "should reject data which is not an archive",
+			err:      "invalid archive: unexpected EOF",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			//given
+			data := &bytes.Buffer{}
+			if tt.files != nil {
+				data = archive(t, tt.files)
+			} else {
+				gz := gzip.NewWriter(data)
+				gz.Write([]byte("not a tar"))
+				gz.Flush()
+			}
+
+			//when
+			_, err := Read(data, 1<<20, &loader{})
+
+			//then
+			assert.EqualError(t, err, tt.err)
+		})
+	}
+}
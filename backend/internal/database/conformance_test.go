@@ -0,0 +1,285 @@
+package database
+
+import (
+	"backend/internal/structure"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+type store interface {
+	Set(ctx context.Context, key string, value string) error
+	Create(ctx context.Context, key string, value string) error
+	Get(ctx context.Context, key string) (interface{}, error)
+	Keys(ctx context.Context, pattern string) ([]string, error)
+	Sizes(ctx context.Context, pattern string) (map[string]int64, error)
+	Update(ctx context.Context, key string, update func(value string) (string, error)) error
+	Delete(ctx context.Context, key string) error
+}
+
+// testConformance checks the behaviour the api package relies on. Every
+// storage backend has to pass it; open has to return an empty store.
+func testConformance(t *testing.T, open func(t *testing.T) store) {
+	ctx := context.Background()
+	resultKey := "2022-01-20T10:00:00Zalg1segmentation"
+	result := `{"algorithm":"alg1","model":"default","image":"image","result":"","timeStamp":"2022-01-20T10:00:00Z","status":"in progress"}`
+
+	t.Run("should return error when key does not exist", func(t *testing.T) {
+		//given
+		s := open(t)
+
+		//when
+		_, err := s.Get(ctx, "key")
+
+		//then
+		assert.EqualError(t, err, "key does not exist")
+	})
+	t.Run("should return error when context is cancelled", func(t *testing.T) {
+		//given
+		s := open(t)
+		assert.NoError(t, s.Set(ctx, "key", "value"))
+		cancelled, cancel := context.WithCancel(ctx)
+		cancel()
+
+		//when
+		_, getErr := s.Get(cancelled, "key")
+		setErr := s.Set(cancelled, "key", "new value")
+		updateErr := s.Update(cancelled, "key", func(value string) (string, error) { return "new value", nil })
+
+		//then
+		assert.Error(t, getErr)
+		assert.Error(t, setErr)
+		assert.Error(t, updateErr)
+		value, err := s.Get(ctx, "key")
+		assert.NoError(t, err)
+		assert.Equal(t, "value", value)
+	})
+	t.Run("should return last value set for key", func(t *testing.T) {
+		//given
+		s := open(t)
+		assert.NoError(t, s.Set(ctx, "key", "value"))
+		assert.NoError(t, s.Set(ctx, "key", "new value"))
+		assert.NoError(t, s.Set(ctx, resultKey, result))
+		assert.NoError(t, s.Set(ctx, resultKey, "not json"))
+
+		//when
+		value, err := s.Get(ctx, "key")
+		resultValue, resultErr := s.Get(ctx, resultKey)
+
+		//then
+		assert.NoError(t, err)
+		assert.Equal(t, "new value", value)
+		assert.NoError(t, resultErr)
+		assert.Equal(t, "not json", resultValue)
+	})
+	t.Run("should return keys matching glob pattern", func(t *testing.T) {
+		//given
+		s := open(t)
+		for _, key := range []string{"images", "models", "upload:1", "upload:2", "hello", "hallo", "h_llo", "h%llo", resultKey, "2022-01-20T10:00:00Zalg2segmentation"} {
+			assert.NoError(t, s.Set(ctx, key, result))
+		}
+		tests := map[string][]string{
+			"images":   {"images"},
+			"upload:*": {"upload:1", "upload:2"},
+			"h?llo":    {"hello", "hallo", "h_llo", "h%llo"},
+			"h_llo":    {"h_llo"},
+			"h%*":      {"h%llo"},
+			"h[ae]llo": {"hello", "hallo"},
+			"h[^e]llo": {"hallo", "h_llo", "h%llo"},
+			"nothing*": {},
+			"*alg1*":   {resultKey},
+			"20[0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9]Zalg1segmentation": {resultKey},
+		}
+		for pattern, expected := range tests {
+			//when
+			keys, err := s.Keys(ctx, pattern)
+
+			//then
+			assert.NoError(t, err)
+			assert.ElementsMatch(t, expected, keys, pattern)
+		}
+	})
+	t.Run("should return sizes of values matching glob pattern", func(t *testing.T) {
+		//given
+		s := open(t)
+		image := "data:image/png;base64,iVBORw0KGgo="
+		index := `{"ids":["a1"]}`
+		assert.NoError(t, s.Set(ctx, "key", "zażółć"))
+		assert.NoError(t, s.Set(ctx, resultKey, result))
+		assert.NoError(t, s.Set(ctx, "image:a1", image))
+		assert.NoError(t, s.Set(ctx, "images", index))
+
+		//when
+		sizes, err := s.Sizes(ctx, "*")
+		imageSizes, imageErr := s.Sizes(ctx, "image*")
+
+		//then
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]int64{
+			"key":      int64(len("zażółć")),
+			resultKey:  int64(len(result)),
+			"image:a1": int64(len(image)),
+			"images":   int64(len(index)),
+		}, sizes)
+		assert.NoError(t, imageErr)
+		assert.Equal(t, map[string]int64{"image:a1": int64(len(image)), "images": int64(len(index))}, imageSizes)
+	})
+	t.Run("should return error and keep value when update fails", func(t *testing.T) {
+		//given
+		s := open(t)
+		assert.NoError(t, s.Set(ctx, "key", "value"))
+
+		//when
+		missingErr := s.Update(ctx, "missing", func(value string) (string, error) { return value, nil })
+		err := s.Update(ctx, "key", func(value string) (string, error) { return "new value", errors.New("update error") })
+
+		//then
+		assert.EqualError(t, missingErr, "key does not exist")
+		assert.EqualError(t, err, "update error")
+		value, err := s.Get(ctx, "key")
+		assert.NoError(t, err)
+		assert.Equal(t, "value", value)
+	})
+	t.Run("should update value of result key", func(t *testing.T) {
+		//given
+		s := open(t)
+		assert.NoError(t, s.Set(ctx, resultKey, result))
+
+		//when
+		err := s.Update(ctx, resultKey, func(value string) (string, error) { return value + " ", nil })
+
+		//then
+		assert.NoError(t, err)
+		value, err := s.Get(ctx, resultKey)
+		assert.NoError(t, err)
+		assert.Equal(t, result+" ", value)
+	})
+	t.Run("should create only keys which do not exist", func(t *testing.T) {
+		//given
+		s := open(t)
+		assert.NoError(t, s.Set(ctx, "key", "value"))
+		assert.NoError(t, s.Set(ctx, resultKey, result))
+
+		//when
+		err := s.Create(ctx, "key", "new value")
+		resultErr := s.Create(ctx, resultKey, "new result")
+		createErr := s.Create(ctx, "other", "value")
+
+		//then
+		assert.EqualError(t, err, "key already exists")
+		assert.EqualError(t, resultErr, "key already exists")
+		assert.NoError(t, createErr)
+		value, err := s.Get(ctx, "key")
+		assert.NoError(t, err)
+		assert.Equal(t, "value", value)
+		value, err = s.Get(ctx, resultKey)
+		assert.NoError(t, err)
+		assert.Equal(t, result, value)
+		value, err = s.Get(ctx, "other")
+		assert.NoError(t, err)
+		assert.Equal(t, "value", value)
+	})
+	t.Run("should delete keys", func(t *testing.T) {
+		//given
+		s := open(t)
+		assert.NoError(t, s.Set(ctx, "key", "value"))
+		assert.NoError(t, s.Set(ctx, resultKey, result))
+
+		//when
+		err := s.Delete(ctx, "key")
+		resultErr := s.Delete(ctx, resultKey)
+		missingErr := s.Delete(ctx, "missing")
+
+		//then
+		assert.NoError(t, err)
+		assert.NoError(t, resultErr)
+		assert.NoError(t, missingErr)
+		_, err = s.Get(ctx, "key")
+		assert.EqualError(t, err, "key does not exist")
+		_, err = s.Get(ctx, resultKey)
+		assert.EqualError(t, err, "key does not exist")
+		keys, err := s.Keys(ctx, "*")
+		assert.NoError(t, err)
+		assert.Empty(t, keys)
+	})
+	t.Run("should not lose any of concurrent updates", func(t *testing.T) {
+		//given
+		const workers, updates = 5, 10
+		s := open(t)
+		assert.NoError(t, s.Set(ctx, "counter", "0"))
+		increment := func(value string) (string, error) {
+			n, err := strconv.Atoi(value)
+			return strconv.Itoa(n + 1), err
+		}
+
+		//when
+		var wg sync.WaitGroup
+		errs := make(chan error, workers*updates)
+		for i := 0; i < workers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < updates; j++ {
+					errs <- s.Update(ctx, "counter", increment)
+				}
+			}()
+		}
+		wg.Wait()
+		close(errs)
+
+		//then
+		for err := range errs {
+			assert.NoError(t, err)
+		}
+		value, err := s.Get(ctx, "counter")
+		assert.NoError(t, err)
+		assert.Equal(t, strconv.Itoa(workers*updates), value)
+	})
+	t.Run("should not lose any of concurrent updates of documents", func(t *testing.T) {
+		//given
+		const workers, updates = 5, 10
+		s := open(t)
+		assert.NoError(t, s.Set(ctx, "images", `{"ids":[]}`))
+		add := func(id string) func(value string) (string, error) {
+			return func(value string) (string, error) {
+				var index structure.ImageIndex
+				if err := json.Unmarshal([]byte(value), &index); err != nil {
+					return "", err
+				}
+				index.IDs = append(index.IDs, id)
+				content, err := json.Marshal(index)
+				return string(content), err
+			}
+		}
+
+		//when
+		var wg sync.WaitGroup
+		errs := make(chan error, workers*updates)
+		for i := 0; i < workers; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				for j := 0; j < updates; j++ {
+					errs <- s.Update(ctx, "images", add(fmt.Sprintf("a%d-%d", i, j)))
+				}
+			}(i)
+		}
+		wg.Wait()
+		close(errs)
+
+		//then
+		for err := range errs {
+			assert.NoError(t, err)
+		}
+		value, err := s.Get(ctx, "images")
+		assert.NoError(t, err)
+		var index structure.ImageIndex
+		assert.NoError(t, json.Unmarshal([]byte(value.(string)), &index))
+		assert.Len(t, index.IDs, workers*updates)
+	})
+}
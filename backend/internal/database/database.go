@@ -1,28 +1,56 @@
 package database
 
 import (
+	"backend/internal/metrics"
+	"backend/internal/tracing"
 	"context"
 	"github.com/go-redis/redis/v8"
 	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.7.0"
+	"go.opentelemetry.io/otel/trace"
 	"log"
+	"math/rand"
+	"time"
+)
+
+const (
+	maxUpdateRetries = 100
+	defaultTimeout   = 5 * time.Second
 )
 
 type Database struct {
 	address    string
 	password   string
 	connection *redis.Client
-	ctx        context.Context
+	timeout    time.Duration
+	expiration func(key, value string) time.Duration
 }
 
 func NewDBConnection(address, password string) Database {
 	return Database{
 		address:  address,
 		password: password,
-		ctx:      context.Background(),
+		timeout:  defaultTimeout,
 	}
 }
 
-func (d *Database) Connect() error {
+// WithTimeout sets the deadline of every single call to the database. Calls
+// are cancelled earlier when their context is done.
+func (d Database) WithTimeout(timeout time.Duration) Database {
+	d.timeout = timeout
+	return d
+}
+
+// WithExpiration sets the function which decides how long a value written to
+// key is kept. Values for which it returns 0 never expire.
+func (d Database) WithExpiration(expiration func(key, value string) time.Duration) Database {
+	d.expiration = expiration
+	return d
+}
+
+func (d *Database) Connect(ctx context.Context) error {
 	if d.connection != nil {
 		log.Print("Connection to database is already initialized")
 		return nil
@@ -33,27 +61,61 @@ func (d *Database) Connect() error {
 		Password: d.password,
 		DB:       0,
 	})
+	d.connection.AddHook(metricsHook{})
+	d.connection.AddHook(tracingHook{})
 
-	if err := d.connection.Ping(d.ctx).Err(); err != nil {
+	ctx, cancel := withTimeout(ctx, d.timeout)
+	defer cancel()
+	if err := d.connection.Ping(ctx).Err(); err != nil {
 		return err
 	}
 	return nil
 }
 
-func (d Database) Set(key string, value string) error {
+// Close closes the connection to Redis. Commands run after Close return an
+// error.
+func (d Database) Close() error {
+	if d.connection == nil {
+		return nil
+	}
+	return d.connection.Close()
+}
+
+func (d Database) Set(ctx context.Context, key string, value string) error {
 	if d.connection == nil {
 		return errors.New("no connection to database")
 	}
-	_, err := d.connection.Set(d.ctx, key, value, 0).Result()
+	ctx, cancel := withTimeout(ctx, d.timeout)
+	defer cancel()
+	_, err := d.connection.Set(ctx, key, value, d.ttl(key, value)).Result()
 	return err
 }
 
-func (d Database) Get(key string) (interface{}, error) {
+// Create stores value under key, unless the key exists already.
+func (d Database) Create(ctx context.Context, key string, value string) error {
+	if d.connection == nil {
+		return errors.New("no connection to database")
+	}
+	ctx, cancel := withTimeout(ctx, d.timeout)
+	defer cancel()
+	created, err := d.connection.SetNX(ctx, key, value, d.ttl(key, value)).Result()
+	if err != nil {
+		return err
+	}
+	if !created {
+		return errors.New("key already exists")
+	}
+	return nil
+}
+
+func (d Database) Get(ctx context.Context, key string) (interface{}, error) {
 	if d.connection == nil {
 		return nil, errors.New("no connection to database")
 	}
+	ctx, cancel := withTimeout(ctx, d.timeout)
+	defer cancel()
 
-	val, err := d.connection.Get(d.ctx, key).Result()
+	val, err := d.connection.Get(ctx, key).Result()
 
 	switch {
 	case err == redis.Nil:
@@ -62,14 +124,193 @@ func (d Database) Get(key string) (interface{}, error) {
 	return val, err
 }
 
-func (d Database) Keys(pattern string) ([]string, error) {
+func (d Database) Keys(ctx context.Context, pattern string) ([]string, error) {
 	if d.connection == nil {
 		return nil, errors.New("no connection to database")
 	}
+	ctx, cancel := withTimeout(ctx, d.timeout)
+	defer cancel()
 
-	keys, err := d.connection.Keys(d.ctx, pattern).Result()
+	keys, err := d.connection.Keys(ctx, pattern).Result()
 	if err != nil {
 		return nil, err
 	}
 	return keys, nil
 }
+
+// Sizes returns the length of the values of keys matching pattern. The
+// lengths are read with STRLEN in a single pipeline, so the values are never
+// transferred.
+func (d Database) Sizes(ctx context.Context, pattern string) (map[string]int64, error) {
+	if d.connection == nil {
+		return nil, errors.New("no connection to database")
+	}
+	ctx, cancel := withTimeout(ctx, d.timeout)
+	defer cancel()
+
+	keys, err := d.connection.Keys(ctx, pattern).Result()
+	if err != nil {
+		return nil, err
+	}
+	pipe := d.connection.Pipeline()
+	lengths := make([]*redis.IntCmd, len(keys))
+	for i, key := range keys {
+		lengths[i] = pipe.StrLen(ctx, key)
+	}
+	if len(keys) > 0 {
+		if _, err = pipe.Exec(ctx); err != nil {
+			return nil, err
+		}
+	}
+	sizes := make(map[string]int64, len(keys))
+	for i, key := range keys {
+		sizes[key] = lengths[i].Val()
+	}
+	return sizes, nil
+}
+
+// Update replaces the value of key with the result of update. The key is
+// watched while update runs and the whole read-modify-write is retried when
+// another client modifies it in the meantime, so concurrent updates are never
+// lost.
+func (d Database) Update(ctx context.Context, key string, update func(value string) (string, error)) error {
+	if d.connection == nil {
+		return errors.New("no connection to database")
+	}
+	ctx, cancel := withTimeout(ctx, d.timeout)
+	defer cancel()
+
+	transaction := func(tx *redis.Tx) error {
+		value, err := tx.Get(ctx, key).Result()
+		if err == redis.Nil {
+			return errors.New("key does not exist")
+		}
+		if err != nil {
+			return err
+		}
+		newValue, err := update(value)
+		if err != nil {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, key, newValue, d.ttl(key, newValue))
+			return nil
+		})
+		return err
+	}
+
+	for i := 0; i < maxUpdateRetries; i++ {
+		err := d.connection.Watch(ctx, transaction, key)
+		if err != redis.TxFailedErr {
+			return err
+		}
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+		time.Sleep(time.Duration(rand.Intn(i+1)) * time.Millisecond)
+	}
+	return errors.New("too many concurrent updates of key " + key)
+}
+
+// Delete removes key. Deleting a key which does not exist is not an error.
+func (d Database) Delete(ctx context.Context, key string) error {
+	if d.connection == nil {
+		return errors.New("no connection to database")
+	}
+	ctx, cancel := withTimeout(ctx, d.timeout)
+	defer cancel()
+	return d.connection.Del(ctx, key).Err()
+}
+
+// ttl returns the expiration of value written to key. Every write sets it
+// again, as SET drops the expiration the key had before.
+func (d Database) ttl(key, value string) time.Duration {
+	if d.expiration == nil {
+		return 0
+	}
+	return d.expiration(key, value)
+}
+
+type startKey struct{}
+
+// metricsHook measures the latency of every Redis command. Transactions are
+// recorded as a single "pipeline" call.
+type metricsHook struct{}
+
+func (metricsHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	return context.WithValue(ctx, startKey{}, time.Now()), nil
+}
+
+func (metricsHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	if start, ok := ctx.Value(startKey{}).(time.Time); ok {
+		metrics.ObserveDatabaseCall(cmd.Name(), time.Since(start))
+	}
+	return nil
+}
+
+func (metricsHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	return context.WithValue(ctx, startKey{}, time.Now()), nil
+}
+
+func (metricsHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	if start, ok := ctx.Value(startKey{}).(time.Time); ok {
+		metrics.ObserveDatabaseCall("pipeline", time.Since(start))
+	}
+	return nil
+}
+
+// tracingHook records a client span for every Redis command, as a child of
+// the span of the request the command is run for.
+type tracingHook struct{}
+
+func (tracingHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	ctx, _ = startSpan(ctx, "redis "+cmd.Name(), attribute.String("db.operation", cmd.Name()))
+	return ctx, nil
+}
+
+func (tracingHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	endSpan(ctx, cmd.Err())
+	return nil
+}
+
+func (tracingHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	ctx, _ = startSpan(ctx, "redis pipeline", attribute.Int("db.redis.num_cmd", len(cmds)))
+	return ctx, nil
+}
+
+func (tracingHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	var err error
+	for _, cmd := range cmds {
+		if cmd.Err() != nil {
+			err = cmd.Err()
+			break
+		}
+	}
+	endSpan(ctx, err)
+	return nil
+}
+
+func startSpan(ctx context.Context, name string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracing.Tracer().Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(append(attributes, semconv.DBSystemRedis)...))
+}
+
+// endSpan ends the span started in ctx. Missing keys are not reported as
+// errors, as they are an expected result of a lookup.
+func endSpan(ctx context.Context, err error) {
+	span := trace.SpanFromContext(ctx)
+	if err != nil && err != redis.Nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// withTimeout limits ctx to timeout, unless the timeout is disabled.
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
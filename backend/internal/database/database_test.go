@@ -1,21 +1,31 @@
 package database
 
 import (
+	"backend/internal/metrics"
 	"context"
 	"errors"
+	"github.com/alicebob/miniredis/v2"
 	"github.com/go-redis/redismock/v8"
 	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"net/http/httptest"
+	"strconv"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestDatabase_Connect(t *testing.T) {
 	t.Run("should return nil when there is already a connection to database", func(t *testing.T) {
 		//given
 		client, _ := redismock.NewClientMock()
-		d := Database{address: "localhost:8081", password: "", connection: client, ctx: context.Background()}
+		d := Database{address: "localhost:8081", password: "", connection: client}
 
 		//when
-		err := d.Connect()
+		err := d.Connect(context.Background())
 
 		//then
 		assert.NoError(t, err)
@@ -23,10 +33,10 @@ func TestDatabase_Connect(t *testing.T) {
 	t.Run("should return error when couldn't ping database", func(t *testing.T) {
 
 		//given
-		d := Database{address: "localhost:8081", password: "", ctx: context.Background()}
+		d := Database{address: "localhost:8081", password: ""}
 
 		//when
-		err := d.Connect()
+		err := d.Connect(context.Background())
 
 		//then
 		assert.Error(t, err)
@@ -34,6 +44,32 @@ func TestDatabase_Connect(t *testing.T) {
 	})
 }
 
+func TestDatabase_Close(t *testing.T) {
+	t.Run("should return nil when there is no connection to database", func(t *testing.T) {
+		//given
+		d := NewDBConnection("localhost:8081", "")
+
+		//when
+		err := d.Close()
+
+		//then
+		assert.NoError(t, err)
+	})
+	t.Run("should close connection to database", func(t *testing.T) {
+		//given
+		server := miniredis.RunT(t)
+		d := NewDBConnection(server.Addr(), "")
+		assert.NoError(t, d.Connect(context.Background()))
+
+		//when
+		err := d.Close()
+
+		//then
+		assert.NoError(t, err)
+		assert.Error(t, d.Set(context.Background(), "key", "value"))
+	})
+}
+
 func TestDatabase_Set(t *testing.T) {
 	const key, value = "key", "value"
 	t.Run("should return error when there is no connection to database", func(t *testing.T) {
@@ -44,7 +80,7 @@ func TestDatabase_Set(t *testing.T) {
 		database := Database{connection: nil}
 
 		//when
-		err := database.Set(key, value)
+		err := database.Set(context.Background(), key, value)
 
 		//then
 		assert.Error(t, err, errors.New("no connection to database"))
@@ -61,7 +97,7 @@ func TestDatabase_Set(t *testing.T) {
 		clientMock.ExpectSet(key, value, 0)
 
 		//when
-		err := database.Set(key, value)
+		err := database.Set(context.Background(), key, value)
 
 		//then
 		assert.Error(t, err)
@@ -79,7 +115,7 @@ func TestDatabase_Set(t *testing.T) {
 		clientMock.ExpectSet(key, value, 0).SetVal(value)
 
 		//when
-		err := database.Set(key, value)
+		err := database.Set(context.Background(), key, value)
 
 		//then
 		assert.NoError(t, err)
@@ -99,7 +135,7 @@ func TestDatabase_Get(t *testing.T) {
 		database := Database{connection: nil}
 
 		//when
-		_, err := database.Get(key)
+		_, err := database.Get(context.Background(), key)
 
 		//then
 		assert.Error(t, err, errors.New("no connection to database"))
@@ -116,7 +152,7 @@ func TestDatabase_Get(t *testing.T) {
 		clientMock.ExpectGet(key).RedisNil()
 
 		//when
-		_, err := database.Get(key)
+		_, err := database.Get(context.Background(), key)
 
 		//then
 		assert.Equal(t, "key does not exist", err.Error())
@@ -134,7 +170,7 @@ func TestDatabase_Get(t *testing.T) {
 		clientMock.ExpectGet(key)
 
 		//when
-		_, err := database.Get(key)
+		_, err := database.Get(context.Background(), key)
 
 		//then
 		assert.Error(t, err)
@@ -152,7 +188,7 @@ func TestDatabase_Get(t *testing.T) {
 		clientMock.ExpectGet(key).SetVal(val)
 
 		//when
-		_, err := database.Get(key)
+		_, err := database.Get(context.Background(), key)
 
 		//then
 		assert.NoError(t, err)
@@ -171,7 +207,7 @@ func TestDatabase_Keys(t *testing.T) {
 		database := Database{connection: nil}
 
 		//when
-		_, err := database.Keys("*")
+		_, err := database.Keys(context.Background(), "*")
 
 		//then
 		assert.Error(t, err, errors.New("no connection to database"))
@@ -188,7 +224,7 @@ func TestDatabase_Keys(t *testing.T) {
 		clientMock.ExpectKeys("*")
 
 		//when
-		val, err := database.Keys("*")
+		val, err := database.Keys(context.Background(), "*")
 
 		//then
 		assert.Error(t, err)
@@ -207,7 +243,7 @@ func TestDatabase_Keys(t *testing.T) {
 		clientMock.ExpectKeys("*").SetVal([]string{"models", "images", "results"})
 
 		//when
-		_, err := database.Keys("*")
+		_, err := database.Keys(context.Background(), "*")
 
 		//then
 		assert.NoError(t, err)
@@ -216,3 +252,170 @@ func TestDatabase_Keys(t *testing.T) {
 		}
 	})
 }
+
+func TestDatabase_Update(t *testing.T) {
+	const key = "counter"
+	connect := func(t *testing.T) (Database, *miniredis.Miniredis) {
+		server := miniredis.RunT(t)
+		database := NewDBConnection(server.Addr(), "")
+		assert.NoError(t, database.Connect(context.Background()))
+		return database, server
+	}
+	t.Run("should return error when there is no connection to database", func(t *testing.T) {
+		//given
+		database := Database{connection: nil}
+
+		//when
+		err := database.Update(context.Background(), key, func(value string) (string, error) { return value, nil })
+
+		//then
+		assert.EqualError(t, err, "no connection to database")
+	})
+	t.Run("should return error when key does not exist", func(t *testing.T) {
+		//given
+		database, _ := connect(t)
+
+		//when
+		err := database.Update(context.Background(), key, func(value string) (string, error) { return value, nil })
+
+		//then
+		assert.EqualError(t, err, "key does not exist")
+	})
+	t.Run("should keep value when update returns error", func(t *testing.T) {
+		//given
+		database, server := connect(t)
+		assert.NoError(t, server.Set(key, "0"))
+
+		//when
+		err := database.Update(context.Background(), key, func(value string) (string, error) { return "1", errors.New("update error") })
+
+		//then
+		assert.EqualError(t, err, "update error")
+		value, _ := server.Get(key)
+		assert.Equal(t, "0", value)
+	})
+	t.Run("should not lose any of concurrent updates", func(t *testing.T) {
+		//given
+		const workers, updates = 10, 20
+		database, server := connect(t)
+		assert.NoError(t, server.Set(key, "0"))
+		increment := func(value string) (string, error) {
+			n, err := strconv.Atoi(value)
+			return strconv.Itoa(n + 1), err
+		}
+
+		//when
+		var wg sync.WaitGroup
+		errs := make(chan error, workers*updates)
+		for i := 0; i < workers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < updates; j++ {
+					errs <- database.Update(context.Background(), key, increment)
+				}
+			}()
+		}
+		wg.Wait()
+		close(errs)
+
+		//then
+		for err := range errs {
+			assert.NoError(t, err)
+		}
+		value, err := server.Get(key)
+		assert.NoError(t, err)
+		assert.Equal(t, strconv.Itoa(workers*updates), value)
+	})
+}
+
+func TestDatabase_Expiration(t *testing.T) {
+	expiration := func(key, value string) time.Duration {
+		if value == "finished" {
+			return time.Hour
+		}
+		return 0
+	}
+	t.Run("should set expiration of written values", func(t *testing.T) {
+		//given
+		server := miniredis.RunT(t)
+		database := NewDBConnection(server.Addr(), "").WithExpiration(expiration)
+		assert.NoError(t, database.Connect(context.Background()))
+
+		//when
+		setErr := database.Set(context.Background(), "result", "finished")
+		otherErr := database.Set(context.Background(), "other", "in-progress")
+
+		//then
+		assert.NoError(t, setErr)
+		assert.NoError(t, otherErr)
+		assert.Equal(t, time.Hour, server.TTL("result"))
+		assert.Equal(t, time.Duration(0), server.TTL("other"))
+	})
+	t.Run("should set expiration of updated values", func(t *testing.T) {
+		//given
+		server := miniredis.RunT(t)
+		database := NewDBConnection(server.Addr(), "").WithExpiration(expiration)
+		assert.NoError(t, database.Connect(context.Background()))
+		assert.NoError(t, server.Set("result", "in-progress"))
+
+		//when
+		err := database.Update(context.Background(), "result", func(value string) (string, error) { return "finished", nil })
+
+		//then
+		assert.NoError(t, err)
+		assert.Equal(t, time.Hour, server.TTL("result"))
+	})
+}
+
+func TestDatabase_Conformance(t *testing.T) {
+	testConformance(t, func(t *testing.T) store {
+		server := miniredis.RunT(t)
+		database := NewDBConnection(server.Addr(), "")
+		assert.NoError(t, database.Connect(context.Background()))
+		return database
+	})
+}
+
+func TestDatabase_Metrics(t *testing.T) {
+	t.Run("should measure latency of redis commands", func(t *testing.T) {
+		//given
+		server := miniredis.RunT(t)
+		database := NewDBConnection(server.Addr(), "")
+		assert.NoError(t, database.Connect(context.Background()))
+		assert.NoError(t, server.Set("key", "value"))
+
+		//when
+		_, err := database.Get(context.Background(), "key")
+
+		//then
+		assert.NoError(t, err)
+		w := httptest.NewRecorder()
+		metrics.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+		assert.Contains(t, w.Body.String(), `database_call_duration_seconds_count{command="get"}`)
+	})
+}
+
+func TestDatabase_Tracing(t *testing.T) {
+	t.Run("should record span of redis command as child of request span", func(t *testing.T) {
+		//given
+		server := miniredis.RunT(t)
+		database := NewDBConnection(server.Addr(), "")
+		assert.NoError(t, database.Connect(context.Background()))
+		recorder := tracetest.NewSpanRecorder()
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+		ctx, parent := otel.Tracer("test").Start(context.Background(), "request")
+
+		//when
+		_, err := database.Get(ctx, "missing")
+		parent.End()
+
+		//then
+		assert.Error(t, err)
+		spans := recorder.Ended()
+		assert.Len(t, spans, 2)
+		assert.Equal(t, "redis get", spans[0].Name())
+		assert.Equal(t, parent.SpanContext().SpanID(), spans[0].Parent().SpanID())
+		assert.Equal(t, codes.Unset, spans[0].Status().Code)
+	})
+}
@@ -0,0 +1,267 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// Memory keeps all data in process memory. It is meant for local development
+// and tests, where running Redis is not possible. When a snapshot file is
+// given, the data is loaded from it on start and written back after every
+// change.
+type Memory struct {
+	mu       *sync.RWMutex
+	data     map[string]string
+	snapshot string
+}
+
+func NewMemory(snapshot string) (Memory, error) {
+	m := Memory{
+		mu:       &sync.RWMutex{},
+		data:     map[string]string{},
+		snapshot: snapshot,
+	}
+	if snapshot == "" {
+		return m, nil
+	}
+
+	content, err := ioutil.ReadFile(snapshot)
+	if os.IsNotExist(err) {
+		return m, nil
+	}
+	if err != nil {
+		return Memory{}, err
+	}
+	if err = json.Unmarshal(content, &m.data); err != nil {
+		return Memory{}, errors.Wrap(err, "failed to read snapshot "+snapshot)
+	}
+	if m.data == nil {
+		m.data = map[string]string{}
+	}
+	return m, nil
+}
+
+func (m Memory) Set(ctx context.Context, key string, value string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.data[key] = value
+	return m.save()
+}
+
+// Create stores value under key, unless the key exists already.
+func (m Memory) Create(ctx context.Context, key string, value string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.data[key]; ok {
+		return errors.New("key already exists")
+	}
+	m.data[key] = value
+	return m.save()
+}
+
+func (m Memory) Get(ctx context.Context, key string) (interface{}, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	value, ok := m.data[key]
+	if !ok {
+		return "", errors.New("key does not exist")
+	}
+	return value, nil
+}
+
+func (m Memory) Keys(ctx context.Context, pattern string) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	keys := []string{}
+	for key := range m.data {
+		if matchPattern(pattern, key) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// Sizes returns the length of the values of keys matching pattern.
+func (m Memory) Sizes(ctx context.Context, pattern string) (map[string]int64, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	sizes := map[string]int64{}
+	for key, value := range m.data {
+		if matchPattern(pattern, key) {
+			sizes[key] = int64(len(value))
+		}
+	}
+	return sizes, nil
+}
+
+// Update replaces the value of key with the result of update. The whole
+// database is locked while update runs, so concurrent updates are never lost.
+func (m Memory) Update(ctx context.Context, key string, update func(value string) (string, error)) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	value, ok := m.data[key]
+	if !ok {
+		return errors.New("key does not exist")
+	}
+	newValue, err := update(value)
+	if err != nil {
+		return err
+	}
+	m.data[key] = newValue
+	return m.save()
+}
+
+// Delete removes key. Deleting a key which does not exist is not an error.
+func (m Memory) Delete(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.data[key]; !ok {
+		return nil
+	}
+	delete(m.data, key)
+	return m.save()
+}
+
+// save writes the snapshot to a temporary file first, so that a crash never
+// leaves a partially written snapshot behind. It has to be called with the
+// lock held.
+func (m Memory) save() error {
+	if m.snapshot == "" {
+		return nil
+	}
+	content, err := json.Marshal(m.data)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(m.snapshot), filepath.Base(m.snapshot)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err = tmp.Write(content); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), m.snapshot)
+}
+
+// matchPattern reports whether key matches the glob pattern with the same
+// rules as the Redis KEYS command: `*` matches any sequence, `?` a single
+// character, `[...]` a set or range (negated with `^`) and `\` escapes the
+// following character.
+func matchPattern(pattern, key string) bool {
+	p, k := []rune(pattern), []rune(key)
+	for len(p) > 0 {
+		switch p[0] {
+		case '*':
+			for len(p) > 1 && p[1] == '*' {
+				p = p[1:]
+			}
+			if len(p) == 1 {
+				return true
+			}
+			for i := 0; i <= len(k); i++ {
+				if matchPattern(string(p[1:]), string(k[i:])) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(k) == 0 {
+				return false
+			}
+			k = k[1:]
+			p = p[1:]
+		case '[':
+			if len(k) == 0 {
+				return false
+			}
+			matched, rest := matchSet(p[1:], k[0])
+			if !matched {
+				return false
+			}
+			k = k[1:]
+			p = rest
+		default:
+			if p[0] == '\\' && len(p) > 1 {
+				p = p[1:]
+			}
+			if len(k) == 0 || p[0] != k[0] {
+				return false
+			}
+			k = k[1:]
+			p = p[1:]
+		}
+	}
+	return len(k) == 0
+}
+
+// matchSet matches c against the set starting right after `[` and returns the
+// rest of the pattern after the closing `]`.
+func matchSet(p []rune, c rune) (bool, []rune) {
+	negate := len(p) > 0 && p[0] == '^'
+	if negate {
+		p = p[1:]
+	}
+	matched := false
+	for len(p) > 0 && p[0] != ']' {
+		switch {
+		case p[0] == '\\' && len(p) > 1:
+			matched = matched || p[1] == c
+			p = p[2:]
+		case len(p) > 2 && p[1] == '-' && p[2] != ']':
+			low, high := p[0], p[2]
+			if low > high {
+				low, high = high, low
+			}
+			matched = matched || (c >= low && c <= high)
+			p = p[3:]
+		default:
+			matched = matched || p[0] == c
+			p = p[1:]
+		}
+	}
+	if len(p) > 0 {
+		p = p[1:]
+	}
+	return matched != negate, p
+}
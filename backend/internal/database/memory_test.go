@@ -0,0 +1,168 @@
+package database
+
+import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestMemory_Get(t *testing.T) {
+	ctx := context.Background()
+	t.Run("should return error when key does not exist", func(t *testing.T) {
+		//given
+		m, err := NewMemory("")
+		assert.NoError(t, err)
+
+		//when
+		_, err = m.Get(ctx, "key")
+
+		//then
+		assert.EqualError(t, err, "key does not exist")
+	})
+	t.Run("should return value which was set", func(t *testing.T) {
+		//given
+		m, err := NewMemory("")
+		assert.NoError(t, err)
+		assert.NoError(t, m.Set(ctx, "key", "value"))
+
+		//when
+		value, err := m.Get(ctx, "key")
+
+		//then
+		assert.NoError(t, err)
+		assert.Equal(t, "value", value)
+	})
+}
+
+func TestMemory_Keys(t *testing.T) {
+	ctx := context.Background()
+	keys := []string{"images", "models", "h", "hallo", "hello", "hllo", "heeeello", "hxllo", "h*llo", "h[llo", "2022-01-20T10:00:00Zalg1segmentation"}
+	patterns := []string{
+		"*", "h?llo", "h*llo", "h[ae]llo", "h[^e]llo", "h[a-b]llo", `h\*llo`, `h\[llo`, "h[*]llo",
+		"*llo", "h*", "?", "nothing", "",
+		"20[0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9]Zalg1segmentation",
+	}
+	server := miniredis.RunT(t)
+	m, err := NewMemory("")
+	assert.NoError(t, err)
+	for _, key := range keys {
+		assert.NoError(t, server.Set(key, "value"))
+		assert.NoError(t, m.Set(ctx, key, "value"))
+	}
+	redisDB := NewDBConnection(server.Addr(), "")
+	assert.NoError(t, redisDB.Connect(context.Background()))
+	for _, pattern := range patterns {
+		t.Run("should match keys like Redis for pattern "+pattern, func(t *testing.T) {
+			//given
+			expected, err := redisDB.Keys(ctx, pattern)
+			assert.NoError(t, err)
+
+			//when
+			matched, err := m.Keys(ctx, pattern)
+
+			//then
+			assert.NoError(t, err)
+			assert.ElementsMatch(t, expected, matched)
+		})
+	}
+}
+
+func TestMemory_Update(t *testing.T) {
+	ctx := context.Background()
+	const key = "counter"
+	t.Run("should return error when key does not exist", func(t *testing.T) {
+		//given
+		m, err := NewMemory("")
+		assert.NoError(t, err)
+
+		//when
+		err = m.Update(ctx, key, func(value string) (string, error) { return value, nil })
+
+		//then
+		assert.EqualError(t, err, "key does not exist")
+	})
+	t.Run("should not lose any of concurrent updates", func(t *testing.T) {
+		//given
+		const workers, updates = 10, 20
+		m, err := NewMemory("")
+		assert.NoError(t, err)
+		assert.NoError(t, m.Set(ctx, key, "0"))
+		increment := func(value string) (string, error) {
+			n, err := strconv.Atoi(value)
+			return strconv.Itoa(n + 1), err
+		}
+
+		//when
+		var wg sync.WaitGroup
+		for i := 0; i < workers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < updates; j++ {
+					assert.NoError(t, m.Update(ctx, key, increment))
+				}
+			}()
+		}
+		wg.Wait()
+
+		//then
+		value, err := m.Get(ctx, key)
+		assert.NoError(t, err)
+		assert.Equal(t, strconv.Itoa(workers*updates), value)
+	})
+}
+
+func TestMemory_Snapshot(t *testing.T) {
+	ctx := context.Background()
+	t.Run("should start empty when snapshot does not exist", func(t *testing.T) {
+		//when
+		m, err := NewMemory(filepath.Join(t.TempDir(), "snapshot.json"))
+
+		//then
+		assert.NoError(t, err)
+		keys, err := m.Keys(ctx, "*")
+		assert.NoError(t, err)
+		assert.Empty(t, keys)
+	})
+	t.Run("should return error when snapshot is not in json format", func(t *testing.T) {
+		//given
+		snapshot := filepath.Join(t.TempDir(), "snapshot.json")
+		assert.NoError(t, ioutil.WriteFile(snapshot, []byte("not json"), 0644))
+
+		//when
+		_, err := NewMemory(snapshot)
+
+		//then
+		assert.Error(t, err)
+	})
+	t.Run("should restore data written before restart", func(t *testing.T) {
+		//given
+		snapshot := filepath.Join(t.TempDir(), "snapshot.json")
+		m, err := NewMemory(snapshot)
+		assert.NoError(t, err)
+		assert.NoError(t, m.Set(ctx, "images", `{"images":[]}`))
+		assert.NoError(t, m.Update(ctx, "images", func(string) (string, error) { return `{"images":["image"]}`, nil }))
+
+		//when
+		restarted, err := NewMemory(snapshot)
+
+		//then
+		assert.NoError(t, err)
+		value, err := restarted.Get(ctx, "images")
+		assert.NoError(t, err)
+		assert.Equal(t, `{"images":["image"]}`, value)
+	})
+}
+
+func TestMemory_Conformance(t *testing.T) {
+	testConformance(t, func(t *testing.T) store {
+		m, err := NewMemory(filepath.Join(t.TempDir(), "snapshot.json"))
+		assert.NoError(t, err)
+		return m
+	})
+}
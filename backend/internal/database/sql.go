@@ -0,0 +1,487 @@
+package database
+
+import (
+	"backend/internal/structure"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"regexp"
+	"strings"
+	"time"
+
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const (
+	SQLite   = "sqlite3"
+	Postgres = "postgres"
+)
+
+// resultKeyRegexp matches keys of simulation results, which are the time
+// stamp of the simulation followed by the algorithm id and operation type.
+// Keys of newer jobs end with the nanoseconds of the time stamp and a random
+// suffix, which keeps jobs submitted within the same second apart.
+var resultKeyRegexp = regexp.MustCompile(`^(\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}Z)(.+?)(-\d{9}[0-9a-f]{8})?$`)
+
+// sqlMigration creates a version of the schema. Statements of one version
+// are applied in a single transaction together with move, which moves data
+// stored by older versions, and have to work on both SQLite and PostgreSQL.
+type sqlMigration struct {
+	statements []string
+	move       func(ctx context.Context, s SQL, tx *sql.Tx) error
+}
+
+var sqlMigrations = []sqlMigration{
+	{
+		statements: []string{
+			`CREATE TABLE entries (
+				key TEXT PRIMARY KEY,
+				value TEXT NOT NULL
+			)`,
+			`CREATE TABLE results (
+				id TEXT PRIMARY KEY,
+				algorithm TEXT NOT NULL,
+				operation TEXT NOT NULL,
+				model TEXT NOT NULL,
+				status TEXT NOT NULL,
+				created_at TEXT NOT NULL,
+				value TEXT NOT NULL
+			)`,
+			`CREATE INDEX results_algorithm_idx ON results (algorithm, operation, created_at)`,
+			`CREATE INDEX results_status_idx ON results (status)`,
+		},
+	},
+	{
+		statements: []string{
+			`CREATE TABLE images (
+				id TEXT PRIMARY KEY,
+				content_type TEXT NOT NULL,
+				size INTEGER NOT NULL,
+				value TEXT NOT NULL
+			)`,
+			`CREATE TABLE image_index (
+				position INTEGER PRIMARY KEY,
+				image_id TEXT NOT NULL
+			)`,
+			`CREATE INDEX image_index_image_idx ON image_index (image_id)`,
+			`CREATE TABLE algorithms (
+				id TEXT PRIMARY KEY,
+				default_model TEXT NOT NULL
+			)`,
+			`CREATE TABLE model_versions (
+				algorithm TEXT NOT NULL REFERENCES algorithms (id),
+				name TEXT NOT NULL,
+				version INTEGER NOT NULL,
+				file TEXT NOT NULL,
+				sha256 TEXT NOT NULL,
+				size INTEGER NOT NULL,
+				uploader TEXT NOT NULL,
+				uploaded_at TEXT NOT NULL,
+				description TEXT NOT NULL,
+				classes TEXT NOT NULL,
+				status TEXT NOT NULL,
+				PRIMARY KEY (algorithm, name, version)
+			)`,
+			`CREATE INDEX model_versions_status_idx ON model_versions (status)`,
+			`CREATE TABLE documents (
+				key TEXT PRIMARY KEY
+			)`,
+		},
+		move: moveDocuments,
+	},
+}
+
+// SQL stores data in SQLite or PostgreSQL. Simulation results are kept in the
+// results table, with the fields used for filtering in separate indexed
+// columns. Images, the image index and the model registry are kept in their
+// own tables, see documentTables; all other keys are kept in the entries
+// table.
+type SQL struct {
+	driver     string
+	connection *sql.DB
+	forUpdate  string
+	// byteLength returns the length of the value column in bytes
+	byteLength string
+	timeout    time.Duration
+}
+
+func NewSQL(driver, dsn string) (SQL, error) {
+	s := SQL{driver: driver, timeout: defaultTimeout}
+	switch driver {
+	case SQLite:
+		s.byteLength = "length(CAST(value AS BLOB))"
+	case Postgres:
+		s.forUpdate = " FOR UPDATE"
+		s.byteLength = "octet_length(value)"
+	default:
+		return SQL{}, errors.New("unsupported sql driver " + driver)
+	}
+
+	connection, err := sql.Open(driver, dsn)
+	if err != nil {
+		return SQL{}, err
+	}
+	if driver == SQLite {
+		// SQLite allows a single writer, so all queries share one connection
+		// instead of failing with "database is locked".
+		connection.SetMaxOpenConns(1)
+	}
+	if err = connection.Ping(); err != nil {
+		connection.Close()
+		return SQL{}, err
+	}
+	s.connection = connection
+	if err = s.migrate(); err != nil {
+		connection.Close()
+		return SQL{}, err
+	}
+	return s, nil
+}
+
+// WithTimeout sets the deadline of every single call to the database. Calls
+// are cancelled earlier when their context is done.
+func (s SQL) WithTimeout(timeout time.Duration) SQL {
+	s.timeout = timeout
+	return s
+}
+
+func (s SQL) migrate() error {
+	ctx := context.Background()
+	if _, err := s.connection.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		applied_at TEXT NOT NULL
+	)`); err != nil {
+		return err
+	}
+	var current int
+	if err := s.connection.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current); err != nil {
+		return err
+	}
+	if current > len(sqlMigrations) {
+		return errors.Errorf("database schema version %d is newer than supported version %d", current, len(sqlMigrations))
+	}
+
+	for i := current; i < len(sqlMigrations); i++ {
+		version := i + 1
+		err := s.transaction(ctx, func(tx *sql.Tx) error {
+			for _, statement := range sqlMigrations[i].statements {
+				if _, err := tx.ExecContext(ctx, statement); err != nil {
+					return err
+				}
+			}
+			if move := sqlMigrations[i].move; move != nil {
+				if err := move(ctx, s, tx); err != nil {
+					return err
+				}
+			}
+			_, err := tx.ExecContext(ctx, `INSERT INTO schema_migrations (version, applied_at) VALUES ($1, $2)`,
+				version, time.Now().UTC().Format(time.RFC3339))
+			return err
+		})
+		if err != nil {
+			return errors.Wrapf(err, "sql migration %d", version)
+		}
+	}
+	return nil
+}
+
+func (s SQL) Close() error {
+	if s.connection == nil {
+		return nil
+	}
+	return s.connection.Close()
+}
+
+func (s SQL) Set(ctx context.Context, key string, value string) error {
+	if s.connection == nil {
+		return errors.New("no connection to database")
+	}
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.transaction(ctx, func(tx *sql.Tx) error {
+		return s.set(ctx, tx, key, value)
+	})
+}
+
+// Create stores value under key, unless the key exists already.
+func (s SQL) Create(ctx context.Context, key string, value string) error {
+	if s.connection == nil {
+		return errors.New("no connection to database")
+	}
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.transaction(ctx, func(tx *sql.Tx) error {
+		if strings.HasPrefix(key, imagePrefix) || documentTables[key] != nil {
+			_, err := s.get(ctx, tx, key, s.forUpdate)
+			if err == nil {
+				return errors.New("key already exists")
+			}
+			if err.Error() != "key does not exist" {
+				return err
+			}
+			return s.set(ctx, tx, key, value)
+		}
+		created, err := s.insert(ctx, tx, key, value, false)
+		if err == nil && !created {
+			return errors.New("key already exists")
+		}
+		return err
+	})
+}
+
+func (s SQL) Get(ctx context.Context, key string) (interface{}, error) {
+	if s.connection == nil {
+		return nil, errors.New("no connection to database")
+	}
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.get(ctx, s.connection, key, "")
+}
+
+func (s SQL) Keys(ctx context.Context, pattern string) ([]string, error) {
+	if s.connection == nil {
+		return nil, errors.New("no connection to database")
+	}
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+
+	like := escapeLike(literalPrefix(pattern)) + "%"
+	rows, err := s.connection.QueryContext(ctx, `SELECT key FROM entries WHERE key LIKE $1 ESCAPE '\'
+		UNION ALL SELECT id FROM results WHERE id LIKE $1 ESCAPE '\'
+		UNION ALL SELECT '`+imagePrefix+`' || id FROM images WHERE '`+imagePrefix+`' || id LIKE $1 ESCAPE '\'
+		UNION ALL SELECT key FROM documents WHERE key LIKE $1 ESCAPE '\'`, like)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []string{}
+	for rows.Next() {
+		var key string
+		if err = rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		if matchPattern(pattern, key) {
+			keys = append(keys, key)
+		}
+	}
+	return keys, rows.Err()
+}
+
+// Sizes returns the length of the values of keys matching pattern. Lengths
+// of results, images and entries are computed by the database, documents are
+// read, as they are stored as rows of their tables.
+func (s SQL) Sizes(ctx context.Context, pattern string) (map[string]int64, error) {
+	if s.connection == nil {
+		return nil, errors.New("no connection to database")
+	}
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+
+	like := escapeLike(literalPrefix(pattern)) + "%"
+	rows, err := s.connection.QueryContext(ctx, `SELECT key, `+s.byteLength+` FROM entries WHERE key LIKE $1 ESCAPE '\'
+		UNION ALL SELECT id, `+s.byteLength+` FROM results WHERE id LIKE $1 ESCAPE '\'
+		UNION ALL SELECT '`+imagePrefix+`' || id, size FROM images WHERE '`+imagePrefix+`' || id LIKE $1 ESCAPE '\'`, like)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sizes := map[string]int64{}
+	for rows.Next() {
+		var key string
+		var size int64
+		if err = rows.Scan(&key, &size); err != nil {
+			return nil, err
+		}
+		if matchPattern(pattern, key) {
+			sizes[key] = size
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	for key, document := range documentTables {
+		if _, ok := sizes[key]; ok || !matchPattern(pattern, key) {
+			continue
+		}
+		value, err := getDocument(ctx, s.connection, key, "", document)
+		if err != nil && err.Error() == "key does not exist" {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		sizes[key] = int64(len(value))
+	}
+	return sizes, nil
+}
+
+// Update replaces the value of key with the result of update. The row, or
+// the key of documents and images, is locked for the duration of the
+// transaction, so concurrent updates are never lost.
+func (s SQL) Update(ctx context.Context, key string, update func(value string) (string, error)) error {
+	if s.connection == nil {
+		return errors.New("no connection to database")
+	}
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.transaction(ctx, func(tx *sql.Tx) error {
+		value, err := s.get(ctx, tx, key, s.forUpdate)
+		if err != nil {
+			return err
+		}
+		newValue, err := update(value)
+		if err != nil {
+			return err
+		}
+		return s.set(ctx, tx, key, newValue)
+	})
+}
+
+// Delete removes key. Deleting a key which does not exist is not an error.
+func (s SQL) Delete(ctx context.Context, key string) error {
+	if s.connection == nil {
+		return errors.New("no connection to database")
+	}
+	ctx, cancel := withTimeout(ctx, s.timeout)
+	defer cancel()
+
+	switch {
+	case resultKeyRegexp.MatchString(key):
+		_, err := s.connection.ExecContext(ctx, `DELETE FROM results WHERE id = $1`, key)
+		return err
+	case strings.HasPrefix(key, imagePrefix):
+		_, err := s.connection.ExecContext(ctx, `DELETE FROM images WHERE id = $1`, strings.TrimPrefix(key, imagePrefix))
+		return err
+	case documentTables[key] != nil:
+		return s.transaction(ctx, func(tx *sql.Tx) error {
+			if err := s.lock(ctx, tx, key); err != nil {
+				return err
+			}
+			return deleteDocument(ctx, tx, key, documentTables[key])
+		})
+	}
+	_, err := s.connection.ExecContext(ctx, `DELETE FROM entries WHERE key = $1`, key)
+	return err
+}
+
+type queryer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+func (s SQL) set(ctx context.Context, q queryer, key, value string) error {
+	if strings.HasPrefix(key, imagePrefix) {
+		return setImage(ctx, q, strings.TrimPrefix(key, imagePrefix), value)
+	}
+	if document := documentTables[key]; document != nil {
+		if err := s.lock(ctx, q, key); err != nil {
+			return err
+		}
+		return setDocument(ctx, q, key, value, document)
+	}
+	_, err := s.insert(ctx, q, key, value, true)
+	return err
+}
+
+// insert writes a result or any other entry. Existing keys are replaced, or
+// kept unchanged when replace is false, and it returns whether the key was
+// written.
+func (s SQL) insert(ctx context.Context, q queryer, key, value string, replace bool) (bool, error) {
+	var written sql.Result
+	var err error
+	match := resultKeyRegexp.FindStringSubmatch(key)
+	if match == nil {
+		conflict := `ON CONFLICT (key) DO NOTHING`
+		if replace {
+			conflict = `ON CONFLICT (key) DO UPDATE SET value = excluded.value`
+		}
+		written, err = q.ExecContext(ctx, `INSERT INTO entries (key, value) VALUES ($1, $2) `+conflict, key, value)
+	} else {
+		// Values which are not valid results are still stored, only without
+		// the indexed columns.
+		var results structure.Results
+		json.Unmarshal([]byte(value), &results)
+		operation := strings.TrimPrefix(match[2], results.Algorithm)
+		conflict := `ON CONFLICT (id) DO NOTHING`
+		if replace {
+			conflict = `ON CONFLICT (id) DO UPDATE SET algorithm = excluded.algorithm, operation = excluded.operation,
+			model = excluded.model, status = excluded.status, value = excluded.value`
+		}
+		written, err = q.ExecContext(ctx, `INSERT INTO results (id, algorithm, operation, model, status, created_at, value)
+			VALUES ($1, $2, $3, $4, $5, $6, $7) `+conflict,
+			key, results.Algorithm, operation, results.Model, results.Status, match[1], value)
+	}
+	if err != nil {
+		return false, err
+	}
+	rows, err := written.RowsAffected()
+	return rows > 0, err
+}
+
+func (s SQL) get(ctx context.Context, q queryer, key, suffix string) (string, error) {
+	if suffix != "" && (documentTables[key] != nil || strings.HasPrefix(key, imagePrefix)) {
+		if err := s.lock(ctx, q, key); err != nil {
+			return "", err
+		}
+	}
+	if document := documentTables[key]; document != nil {
+		return getDocument(ctx, q, key, suffix, document)
+	}
+	query, arg := `SELECT value FROM entries WHERE key = $1`, key
+	switch {
+	case resultKeyRegexp.MatchString(key):
+		query = `SELECT value FROM results WHERE id = $1`
+	case strings.HasPrefix(key, imagePrefix):
+		query, arg = `SELECT value FROM images WHERE id = $1`, strings.TrimPrefix(key, imagePrefix)
+	}
+	var value string
+	err := q.QueryRowContext(ctx, query+suffix, arg).Scan(&value)
+	if err == sql.ErrNoRows {
+		return "", errors.New("key does not exist")
+	}
+	return value, err
+}
+
+// lock takes a PostgreSQL advisory lock of key until the transaction ends.
+// Rows of documents are deleted and inserted again when they are written, so
+// a transaction waiting for their row locks would find no rows once the lock
+// is released, and images which do not exist yet have no rows to lock. The
+// advisory lock belongs to the key instead. SQLite runs one transaction at a
+// time and needs no locks.
+func (s SQL) lock(ctx context.Context, q queryer, key string) error {
+	if s.driver != Postgres {
+		return nil
+	}
+	_, err := q.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext($1))`, key)
+	return err
+}
+
+func (s SQL) transaction(ctx context.Context, run func(tx *sql.Tx) error) error {
+	tx, err := s.connection.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err = run(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// literalPrefix returns the part of a glob pattern before its first special
+// character, which is used to narrow down the rows matched in SQL.
+func literalPrefix(pattern string) string {
+	if i := strings.IndexAny(pattern, `*?[\`); i >= 0 {
+		return pattern[:i]
+	}
+	return pattern
+}
+
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(value)
+}
@@ -0,0 +1,274 @@
+package database
+
+import (
+	"backend/internal/structure"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"strings"
+)
+
+// imagePrefix starts the keys of images, which are followed by the image id.
+const imagePrefix = "image:"
+
+// documentTable stores a JSON document, which the api package keeps under a
+// single key, as rows of tables. Documents which can not be written as rows
+// without changing them, e.g. the layouts of older versions, are kept in the
+// entries table instead.
+type documentTable struct {
+	// write stores the rows of value, or returns false when it can not
+	write func(ctx context.Context, q queryer, value string) (bool, error)
+	read  func(ctx context.Context, q queryer) (string, error)
+	clear func(ctx context.Context, q queryer) error
+}
+
+// documentTables are the tables of keys stored as rows. Stored keys are
+// listed in the documents table, so that empty documents still exist.
+var documentTables = map[string]*documentTable{
+	"images": {write: writeImageIndex, read: readImageIndex, clear: clearImageIndex},
+	"models": {write: writeRegistry, read: readRegistry, clear: clearRegistry},
+}
+
+func setImage(ctx context.Context, q queryer, id, value string) error {
+	_, err := q.ExecContext(ctx, `INSERT INTO images (id, content_type, size, value) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (id) DO UPDATE SET content_type = excluded.content_type, size = excluded.size, value = excluded.value`,
+		id, contentType(value), len(value), value)
+	return err
+}
+
+// contentType returns the media type of an image stored as a data URL.
+func contentType(value string) string {
+	if !strings.HasPrefix(value, "data:") {
+		return ""
+	}
+	if end := strings.IndexAny(value, ";,"); end > len("data:") {
+		return value[len("data:"):end]
+	}
+	return ""
+}
+
+func setDocument(ctx context.Context, q queryer, key, value string, table *documentTable) error {
+	if err := deleteDocument(ctx, q, key, table); err != nil {
+		return err
+	}
+	stored, err := table.write(ctx, q, value)
+	if err != nil {
+		return err
+	}
+	if stored {
+		_, err = q.ExecContext(ctx, `INSERT INTO documents (key) VALUES ($1)`, key)
+		return err
+	}
+	_, err = q.ExecContext(ctx, `INSERT INTO entries (key, value) VALUES ($1, $2)`, key, value)
+	return err
+}
+
+// getDocument locks the row of the document with suffix, the rows of the
+// tables are only changed together with it.
+func getDocument(ctx context.Context, q queryer, key, suffix string, table *documentTable) (string, error) {
+	var value string
+	err := q.QueryRowContext(ctx, `SELECT value FROM entries WHERE key = $1`+suffix, key).Scan(&value)
+	if err != sql.ErrNoRows {
+		return value, err
+	}
+	err = q.QueryRowContext(ctx, `SELECT key FROM documents WHERE key = $1`+suffix, key).Scan(&value)
+	if err == sql.ErrNoRows {
+		return "", errors.New("key does not exist")
+	}
+	if err != nil {
+		return "", err
+	}
+	return table.read(ctx, q)
+}
+
+func deleteDocument(ctx context.Context, q queryer, key string, table *documentTable) error {
+	if err := table.clear(ctx, q); err != nil {
+		return err
+	}
+	if _, err := q.ExecContext(ctx, `DELETE FROM documents WHERE key = $1`, key); err != nil {
+		return err
+	}
+	_, err := q.ExecContext(ctx, `DELETE FROM entries WHERE key = $1`, key)
+	return err
+}
+
+// moveDocuments moves images, the image index and the model registry from the
+// entries table to their own tables.
+func moveDocuments(ctx context.Context, s SQL, tx *sql.Tx) error {
+	rows, err := tx.QueryContext(ctx, `SELECT key, value FROM entries
+		WHERE key IN ('images', 'models') OR key LIKE '`+imagePrefix+`%'`)
+	if err != nil {
+		return err
+	}
+	moved := map[string]string{}
+	for rows.Next() {
+		var key, value string
+		if err = rows.Scan(&key, &value); err != nil {
+			rows.Close()
+			return err
+		}
+		moved[key] = value
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for key, value := range moved {
+		if _, err = tx.ExecContext(ctx, `DELETE FROM entries WHERE key = $1`, key); err != nil {
+			return err
+		}
+		if err = s.set(ctx, tx, key, value); err != nil {
+			return errors.Wrap(err, "failed to move "+key)
+		}
+	}
+	return nil
+}
+
+// canonical unmarshals value into v, when marshalling v gives value back.
+func canonical(value string, v interface{}) bool {
+	if err := json.Unmarshal([]byte(value), v); err != nil {
+		return false
+	}
+	marshalled, err := json.Marshal(v)
+	return err == nil && string(marshalled) == value
+}
+
+func writeImageIndex(ctx context.Context, q queryer, value string) (bool, error) {
+	var index structure.ImageIndex
+	if !canonical(value, &index) || index.IDs == nil {
+		return false, nil
+	}
+	for position, id := range index.IDs {
+		if _, err := q.ExecContext(ctx, `INSERT INTO image_index (position, image_id) VALUES ($1, $2)`, position, id); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func readImageIndex(ctx context.Context, q queryer) (string, error) {
+	rows, err := q.QueryContext(ctx, `SELECT image_id FROM image_index ORDER BY position`)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+	index := structure.ImageIndex{IDs: []string{}}
+	for rows.Next() {
+		var id string
+		if err = rows.Scan(&id); err != nil {
+			return "", err
+		}
+		index.IDs = append(index.IDs, id)
+	}
+	if err = rows.Err(); err != nil {
+		return "", err
+	}
+	value, err := json.Marshal(index)
+	return string(value), err
+}
+
+func clearImageIndex(ctx context.Context, q queryer) error {
+	_, err := q.ExecContext(ctx, `DELETE FROM image_index`)
+	return err
+}
+
+// writeRegistry stores every version of a model as a row. Registries are read
+// back with versions ordered by their number, so registries with versions in
+// another order, or models without versions, are not stored as rows.
+func writeRegistry(ctx context.Context, q queryer, value string) (bool, error) {
+	var registry structure.Registry
+	if !canonical(value, &registry) || registry.Algorithms == nil {
+		return false, nil
+	}
+	for _, algModels := range registry.Algorithms {
+		if algModels == nil || algModels.Models == nil {
+			return false, nil
+		}
+		for name, versions := range algModels.Models {
+			if len(versions) == 0 {
+				return false, nil
+			}
+			for i, model := range versions {
+				if model.Name != name || (i > 0 && versions[i-1].Version >= model.Version) {
+					return false, nil
+				}
+			}
+		}
+	}
+
+	for id, algModels := range registry.Algorithms {
+		if _, err := q.ExecContext(ctx, `INSERT INTO algorithms (id, default_model) VALUES ($1, $2)`, id, algModels.Default); err != nil {
+			return false, err
+		}
+		for _, versions := range algModels.Models {
+			for _, model := range versions {
+				classes, err := json.Marshal(model.Classes)
+				if err != nil {
+					return false, err
+				}
+				if _, err = q.ExecContext(ctx, `INSERT INTO model_versions (algorithm, name, version, file, sha256, size,
+					uploader, uploaded_at, description, classes, status) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`,
+					id, model.Name, model.Version, model.File, model.SHA256, model.Size,
+					model.Uploader, model.UploadedAt, model.Description, string(classes), model.Status); err != nil {
+					return false, err
+				}
+			}
+		}
+	}
+	return true, nil
+}
+
+func readRegistry(ctx context.Context, q queryer) (string, error) {
+	registry := structure.Registry{Algorithms: map[string]*structure.AlgorithmModels{}}
+	rows, err := q.QueryContext(ctx, `SELECT id, default_model FROM algorithms`)
+	if err != nil {
+		return "", err
+	}
+	for rows.Next() {
+		var id, defaultModel string
+		if err = rows.Scan(&id, &defaultModel); err != nil {
+			rows.Close()
+			return "", err
+		}
+		registry.Algorithms[id] = &structure.AlgorithmModels{Default: defaultModel, Models: map[string][]structure.Model{}}
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return "", err
+	}
+
+	rows, err = q.QueryContext(ctx, `SELECT algorithm, name, version, file, sha256, size, uploader, uploaded_at,
+		description, classes, status FROM model_versions ORDER BY algorithm, name, version`)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var algorithm, classes string
+		var model structure.Model
+		if err = rows.Scan(&algorithm, &model.Name, &model.Version, &model.File, &model.SHA256, &model.Size,
+			&model.Uploader, &model.UploadedAt, &model.Description, &classes, &model.Status); err != nil {
+			return "", err
+		}
+		if err = json.Unmarshal([]byte(classes), &model.Classes); err != nil {
+			return "", err
+		}
+		algModels := registry.Algorithms[algorithm]
+		algModels.Models[model.Name] = append(algModels.Models[model.Name], model)
+	}
+	if err = rows.Err(); err != nil {
+		return "", err
+	}
+	value, err := json.Marshal(registry)
+	return string(value), err
+}
+
+func clearRegistry(ctx context.Context, q queryer) error {
+	if _, err := q.ExecContext(ctx, `DELETE FROM model_versions`); err != nil {
+		return err
+	}
+	_, err := q.ExecContext(ctx, `DELETE FROM algorithms`)
+	return err
+}
@@ -0,0 +1,200 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSQL_Conformance(t *testing.T) {
+	t.Run("sqlite", func(t *testing.T) {
+		testConformance(t, func(t *testing.T) store {
+			s, err := NewSQL(SQLite, filepath.Join(t.TempDir(), "backend.db"))
+			assert.NoError(t, err)
+			t.Cleanup(func() { s.Close() })
+			return s
+		})
+	})
+	t.Run("postgres", func(t *testing.T) {
+		dsn := os.Getenv("POSTGRES_TEST_DSN")
+		if dsn == "" {
+			t.Skip("POSTGRES_TEST_DSN is not set")
+		}
+		testConformance(t, func(t *testing.T) store {
+			s, err := NewSQL(Postgres, dsn)
+			assert.NoError(t, err)
+			t.Cleanup(func() { s.Close() })
+			// every test starts with empty tables of a shared database
+			for _, table := range []string{"entries", "results", "images", "image_index", "model_versions", "algorithms", "documents"} {
+				_, err = s.connection.Exec(fmt.Sprintf("DELETE FROM %s", table))
+				assert.NoError(t, err)
+			}
+			return s
+		})
+	})
+}
+
+func TestNewSQL(t *testing.T) {
+	ctx := context.Background()
+	t.Run("should return error when driver is not supported", func(t *testing.T) {
+		//when
+		_, err := NewSQL("mysql", "")
+
+		//then
+		assert.EqualError(t, err, "unsupported sql driver mysql")
+	})
+	t.Run("should apply migrations only once", func(t *testing.T) {
+		//given
+		path := filepath.Join(t.TempDir(), "backend.db")
+		s, err := NewSQL(SQLite, path)
+		assert.NoError(t, err)
+		assert.NoError(t, s.Set(ctx, "key", "value"))
+		assert.NoError(t, s.Close())
+
+		//when
+		s, err = NewSQL(SQLite, path)
+
+		//then
+		assert.NoError(t, err)
+		defer s.Close()
+		value, err := s.Get(ctx, "key")
+		assert.NoError(t, err)
+		assert.Equal(t, "value", value)
+	})
+	t.Run("should return error when schema is newer than supported", func(t *testing.T) {
+		//given
+		path := filepath.Join(t.TempDir(), "backend.db")
+		s, err := NewSQL(SQLite, path)
+		assert.NoError(t, err)
+		_, err = s.connection.Exec(`INSERT INTO schema_migrations (version, applied_at) VALUES ($1, $2)`, 99, time.Now().Format(time.RFC3339))
+		assert.NoError(t, err)
+		assert.NoError(t, s.Close())
+
+		//when
+		_, err = NewSQL(SQLite, path)
+
+		//then
+		assert.EqualError(t, err, "database schema version 99 is newer than supported version 2")
+	})
+}
+
+func TestSQL_Results(t *testing.T) {
+	ctx := context.Background()
+	t.Run("should store filter fields of results in indexed columns", func(t *testing.T) {
+		//given
+		s, err := NewSQL(SQLite, filepath.Join(t.TempDir(), "backend.db"))
+		assert.NoError(t, err)
+		defer s.Close()
+
+		//when
+		err = s.Set(ctx, "2022-01-20T10:00:00Zalg1segmentation", `{"algorithm":"alg1","model":"default","status":"finished"}`)
+
+		//then
+		assert.NoError(t, err)
+		var algorithm, operation, model, status, createdAt string
+		err = s.connection.QueryRow(`SELECT algorithm, operation, model, status, created_at FROM results`).
+			Scan(&algorithm, &operation, &model, &status, &createdAt)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"alg1", "segmentation", "default", "finished", "2022-01-20T10:00:00Z"},
+			[]string{algorithm, operation, model, status, createdAt})
+	})
+}
+
+func TestSQL_Documents(t *testing.T) {
+	ctx := context.Background()
+	image := "data:image/png;base64,iVBORw0KGgo="
+	index := `{"ids":["b","a"]}`
+	registry := `{"algorithms":{"alg1":{"default":"coco","models":{"coco":[` +
+		`{"name":"coco","version":1,"file":"coco.h5","sha256":"abc","size":6,"uploader":"user","uploadedAt":"2022-01-20T10:00:00Z","description":"","classes":["person"],"status":"ready"},` +
+		`{"name":"coco","version":2,"file":"coco-v2.h5","sha256":"def","size":7,"uploader":"","uploadedAt":"","description":"","classes":null,"status":"failed"}]}},` +
+		`"alg2":{"default":"","models":{}}}}`
+	t.Run("should store images, image index and models in their tables", func(t *testing.T) {
+		//given
+		s, err := NewSQL(SQLite, filepath.Join(t.TempDir(), "backend.db"))
+		assert.NoError(t, err)
+		defer s.Close()
+
+		//when
+		assert.NoError(t, s.Set(ctx, "image:a", image))
+		assert.NoError(t, s.Set(ctx, "images", index))
+		assert.NoError(t, s.Set(ctx, "models", registry))
+
+		//then
+		for key, value := range map[string]string{"image:a": image, "images": index, "models": registry} {
+			stored, err := s.Get(ctx, key)
+			assert.NoError(t, err)
+			assert.Equal(t, value, stored, key)
+		}
+		keys, err := s.Keys(ctx, "image*")
+		assert.NoError(t, err)
+		assert.ElementsMatch(t, []string{"image:a", "images"}, keys)
+		var entries, imageIDs, versions int
+		var contentType string
+		assert.NoError(t, s.connection.QueryRow(`SELECT COUNT(*) FROM entries`).Scan(&entries))
+		assert.NoError(t, s.connection.QueryRow(`SELECT content_type FROM images WHERE id = 'a'`).Scan(&contentType))
+		assert.NoError(t, s.connection.QueryRow(`SELECT COUNT(*) FROM image_index`).Scan(&imageIDs))
+		assert.NoError(t, s.connection.QueryRow(`SELECT COUNT(*) FROM model_versions WHERE algorithm = 'alg1' AND status = 'ready'`).Scan(&versions))
+		assert.Equal(t, []int{0, 2, 1}, []int{entries, imageIDs, versions})
+		assert.Equal(t, "image/png", contentType)
+	})
+	t.Run("should keep documents of other layouts in entries", func(t *testing.T) {
+		//given
+		s, err := NewSQL(SQLite, filepath.Join(t.TempDir(), "backend.db"))
+		assert.NoError(t, err)
+		defer s.Close()
+		assert.NoError(t, s.Set(ctx, "images", index))
+		legacy := `{"images":["` + image + `"]}`
+
+		//when
+		err = s.Set(ctx, "images", legacy)
+
+		//then
+		assert.NoError(t, err)
+		value, err := s.Get(ctx, "images")
+		assert.NoError(t, err)
+		assert.Equal(t, legacy, value)
+		keys, err := s.Keys(ctx, "*")
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"images"}, keys)
+		assert.NoError(t, s.Delete(ctx, "images"))
+		_, err = s.Get(ctx, "images")
+		assert.EqualError(t, err, "key does not exist")
+	})
+	t.Run("should move documents stored by schema version 1", func(t *testing.T) {
+		//given
+		path := filepath.Join(t.TempDir(), "backend.db")
+		connection, err := sql.Open(SQLite, path)
+		assert.NoError(t, err)
+		for _, statement := range append(sqlMigrations[0].statements,
+			`CREATE TABLE schema_migrations (version INTEGER PRIMARY KEY, applied_at TEXT NOT NULL)`,
+			`INSERT INTO schema_migrations (version, applied_at) VALUES (1, '2022-01-20T10:00:00Z')`) {
+			_, err = connection.Exec(statement)
+			assert.NoError(t, err)
+		}
+		for key, value := range map[string]string{"image:a": image, "images": index, "models": registry, "key": "value"} {
+			_, err = connection.Exec(`INSERT INTO entries (key, value) VALUES ($1, $2)`, key, value)
+			assert.NoError(t, err)
+		}
+		assert.NoError(t, connection.Close())
+
+		//when
+		s, err := NewSQL(SQLite, path)
+
+		//then
+		assert.NoError(t, err)
+		defer s.Close()
+		for key, value := range map[string]string{"image:a": image, "images": index, "models": registry, "key": "value"} {
+			stored, err := s.Get(ctx, key)
+			assert.NoError(t, err)
+			assert.Equal(t, value, stored, key)
+		}
+		var entries int
+		assert.NoError(t, s.connection.QueryRow(`SELECT COUNT(*) FROM entries`).Scan(&entries))
+		assert.Equal(t, 1, entries)
+	})
+}
@@ -0,0 +1,110 @@
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"go.uber.org/zap"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// RequestIDHeader carries the correlation id of a request. It is accepted from
+// clients, returned in responses and forwarded to algorithm containers, which
+// send it back with the results of a simulation.
+const RequestIDHeader = "X-Request-ID"
+
+var requestIDRegexp = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+type contextKey struct{}
+
+type requestInfo struct {
+	id     string
+	mu     sync.Mutex
+	fields []zap.Field
+}
+
+// WithRequestID returns a context carrying the request id.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, &requestInfo{id: id})
+}
+
+// RequestID returns the request id carried by ctx, or an empty string.
+func RequestID(ctx context.Context) string {
+	if info, ok := ctx.Value(contextKey{}).(*requestInfo); ok {
+		return info.id
+	}
+	return ""
+}
+
+// AddFields attaches fields, like algorithm or job ids, to the log entry of
+// the request handled with ctx.
+func AddFields(ctx context.Context, fields ...zap.Field) {
+	if info, ok := ctx.Value(contextKey{}).(*requestInfo); ok {
+		info.mu.Lock()
+		info.fields = append(info.fields, fields...)
+		info.mu.Unlock()
+	}
+}
+
+// Middleware logs every request with its id, method, path, status, latency and
+// the fields added by handlers.
+func Middleware(logger *zap.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			id := r.Header.Get(RequestIDHeader)
+			if !requestIDRegexp.MatchString(id) {
+				id = newRequestID()
+			}
+			w.Header().Set(RequestIDHeader, id)
+			ctx := WithRequestID(r.Context(), id)
+			recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+			next.ServeHTTP(recorder, r.WithContext(ctx))
+
+			info := ctx.Value(contextKey{}).(*requestInfo)
+			info.mu.Lock()
+			fields := append([]zap.Field{
+				zap.String("requestId", id),
+				zap.String("method", r.Method),
+				zap.String("path", r.URL.Path),
+				zap.Int("status", recorder.status),
+				zap.Duration("latency", time.Since(start)),
+			}, info.fields...)
+			info.mu.Unlock()
+			if recorder.status >= http.StatusInternalServerError {
+				logger.Error("request failed", fields...)
+				return
+			}
+			logger.Info("request handled", fields...)
+		})
+	}
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Flush keeps streaming responses working behind the middleware.
+func (r *statusRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return time.Now().Format("20060102150405.000000000")
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,100 @@
+package logging
+
+import (
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMiddleware(t *testing.T) {
+	tests := []struct {
+		testName      string
+		requestID     string
+		status        int
+		keepRequestID bool
+		level         zapcore.Level
+	}{
+		{
+			testName: "should generate request id when it is missing",
+			status:   http.StatusOK,
+			level:    zapcore.InfoLevel,
+		},
+		{
+			testName:      "should keep request id sent by client",
+			requestID:     "client-id.1",
+			status:        http.StatusAccepted,
+			keepRequestID: true,
+			level:         zapcore.InfoLevel,
+		},
+		{
+			testName:  "should replace invalid request id",
+			requestID: "not valid\n",
+			status:    http.StatusOK,
+			level:     zapcore.InfoLevel,
+		},
+		{
+			testName:      "should log failed request as error",
+			requestID:     "client-id",
+			status:        http.StatusInternalServerError,
+			keepRequestID: true,
+			level:         zapcore.ErrorLevel,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			//given
+			core, logs := observer.New(zapcore.InfoLevel)
+			var handlerRequestID string
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				handlerRequestID = RequestID(r.Context())
+				AddFields(r.Context(), zap.String("job", "jobID"))
+				w.WriteHeader(tt.status)
+			})
+			r, err := http.NewRequest("POST", "/v1/simulation-results/demo", nil)
+			assert.NoError(t, err)
+			if tt.requestID != "" {
+				r.Header.Set(RequestIDHeader, tt.requestID)
+			}
+			w := httptest.NewRecorder()
+
+			//when
+			Middleware(zap.New(core))(next).ServeHTTP(w, r)
+
+			//then
+			requestID := w.Header().Get(RequestIDHeader)
+			assert.Regexp(t, requestIDRegexp, requestID)
+			assert.Equal(t, tt.keepRequestID, requestID == tt.requestID)
+			assert.Equal(t, requestID, handlerRequestID)
+			if assert.Equal(t, 1, logs.Len()) {
+				entry := logs.All()[0]
+				assert.Equal(t, tt.level, entry.Level)
+				fields := entry.ContextMap()
+				assert.Equal(t, requestID, fields["requestId"])
+				assert.Equal(t, "POST", fields["method"])
+				assert.Equal(t, "/v1/simulation-results/demo", fields["path"])
+				assert.Equal(t, int64(tt.status), fields["status"])
+				assert.Equal(t, "jobID", fields["job"])
+				assert.Contains(t, fields, "latency")
+			}
+		})
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	t.Run("should return empty id when context has no request", func(t *testing.T) {
+		//given
+		r, err := http.NewRequest("GET", "/", nil)
+		assert.NoError(t, err)
+
+		//when
+		id := RequestID(r.Context())
+
+		//then
+		assert.Empty(t, id)
+		AddFields(r.Context(), zap.String("job", "jobID"))
+	})
+}
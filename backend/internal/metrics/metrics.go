@@ -0,0 +1,142 @@
+package metrics
+
+import (
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "Number of HTTP requests by route, method and status code.",
+	}, []string{"route", "method", "status"})
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "Latency of HTTP requests by route and method.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "method"})
+
+	dispatchDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "algorithm_dispatch_duration_seconds",
+		Help:    "Latency of starting simulations in algorithm containers.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"algorithm", "operation"})
+	dispatchErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "algorithm_dispatch_errors_total",
+		Help: "Number of simulations which algorithm containers failed to start.",
+	}, []string{"algorithm", "operation"})
+
+	jobs = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "jobs_total",
+		Help: "Number of simulation jobs by algorithm and status they reached.",
+	}, []string{"algorithm", "status"})
+	jobsInProgress = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "jobs_in_progress",
+		Help: "Number of simulation jobs started by this server which did not finish yet.",
+	}, []string{"algorithm"})
+	jobsQueued = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "jobs_queued",
+		Help: "Number of simulation jobs waiting for a free slot of their algorithm.",
+	}, []string{"algorithm"})
+
+	resultsDeleted = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "results_deleted_total",
+		Help: "Number of simulation results deleted by the retention policy or purges.",
+	}, []string{"reason"})
+
+	databaseDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "database_call_duration_seconds",
+		Help:    "Latency of database calls by command.",
+		Buckets: []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+	}, []string{"command"})
+)
+
+// Handler serves all registered metrics in the Prometheus text format.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// Middleware counts requests and measures their latency. It has to be used on
+// a mux router, so that requests are labeled with the route template instead
+// of the path, which would contain ids.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		next.ServeHTTP(recorder, r)
+
+		route := "unknown"
+		if current := mux.CurrentRoute(r); current != nil {
+			if template, err := current.GetPathTemplate(); err == nil {
+				route = template
+			}
+		}
+		httpRequests.WithLabelValues(route, r.Method, strconv.Itoa(recorder.status)).Inc()
+		httpDuration.WithLabelValues(route, r.Method).Observe(time.Since(start).Seconds())
+	})
+}
+
+// ObserveDispatch records a request starting a simulation in an algorithm
+// container.
+func ObserveDispatch(algorithm, operation string, start time.Time, failed bool) {
+	dispatchDuration.WithLabelValues(algorithm, operation).Observe(time.Since(start).Seconds())
+	if failed {
+		dispatchErrors.WithLabelValues(algorithm, operation).Inc()
+	}
+}
+
+// JobStarted records a simulation job accepted by an algorithm.
+func JobStarted(algorithm string) {
+	jobs.WithLabelValues(algorithm, "in-progress").Inc()
+	jobsInProgress.WithLabelValues(algorithm).Inc()
+}
+
+// JobResumed records a simulation job started by a previous run of the
+// server, whose results this server waits for.
+func JobResumed(algorithm string) {
+	jobsInProgress.WithLabelValues(algorithm).Inc()
+}
+
+// JobFinished records the final status of a simulation job.
+func JobFinished(algorithm, status string) {
+	jobs.WithLabelValues(algorithm, status).Inc()
+	jobsInProgress.WithLabelValues(algorithm).Dec()
+}
+
+// SetJobsQueued records the number of jobs waiting for the algorithm.
+func SetJobsQueued(algorithm string, queued int) {
+	jobsQueued.WithLabelValues(algorithm).Set(float64(queued))
+}
+
+// ResultsDeleted records results deleted for the reason, "retention" or
+// "purge".
+func ResultsDeleted(reason string, count int) {
+	resultsDeleted.WithLabelValues(reason).Add(float64(count))
+}
+
+// ObserveDatabaseCall records the latency of a single database command.
+func ObserveDatabaseCall(command string, duration time.Duration) {
+	databaseDuration.WithLabelValues(command).Observe(duration.Seconds())
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,119 @@
+package metrics
+
+import (
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMiddleware(t *testing.T) {
+	t.Run("should count requests by route template and status", func(t *testing.T) {
+		//given
+		router := mux.NewRouter()
+		router.Use(Middleware)
+		router.HandleFunc("/v1/jobs/{id}/render", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+		})
+		counter := httpRequests.WithLabelValues("/v1/jobs/{id}/render", "GET", "404")
+		before := testutil.ToFloat64(counter)
+		r, err := http.NewRequest("GET", "/v1/jobs/2022-01-20T10:00:00Zalg1demo/render", nil)
+		assert.NoError(t, err)
+
+		//when
+		router.ServeHTTP(httptest.NewRecorder(), r)
+
+		//then
+		assert.Equal(t, before+1, testutil.ToFloat64(counter))
+	})
+}
+
+func TestObserveDispatch(t *testing.T) {
+	t.Run("should count failed dispatches", func(t *testing.T) {
+		//given
+		errorsCounter := dispatchErrors.WithLabelValues("alg1", "demo")
+		before := testutil.ToFloat64(errorsCounter)
+
+		//when
+		ObserveDispatch("alg1", "demo", time.Now(), false)
+		ObserveDispatch("alg1", "demo", time.Now(), true)
+
+		//then
+		assert.Equal(t, before+1, testutil.ToFloat64(errorsCounter))
+	})
+}
+
+func TestJobs(t *testing.T) {
+	t.Run("should track jobs in progress and their final status", func(t *testing.T) {
+		//given
+		gauge := jobsInProgress.WithLabelValues("alg1")
+		finished := jobs.WithLabelValues("alg1", "finished")
+		gaugeBefore, finishedBefore := testutil.ToFloat64(gauge), testutil.ToFloat64(finished)
+
+		//when
+		JobStarted("alg1")
+		JobStarted("alg1")
+		JobFinished("alg1", "finished")
+
+		//then
+		assert.Equal(t, gaugeBefore+1, testutil.ToFloat64(gauge))
+		assert.Equal(t, finishedBefore+1, testutil.ToFloat64(finished))
+	})
+	t.Run("should count resumed jobs as in progress without starting them again", func(t *testing.T) {
+		//given
+		gauge := jobsInProgress.WithLabelValues("alg2")
+		started := jobs.WithLabelValues("alg2", "in-progress")
+		gaugeBefore, startedBefore := testutil.ToFloat64(gauge), testutil.ToFloat64(started)
+
+		//when
+		JobResumed("alg2")
+
+		//then
+		assert.Equal(t, gaugeBefore+1, testutil.ToFloat64(gauge))
+		assert.Equal(t, startedBefore, testutil.ToFloat64(started))
+	})
+	t.Run("should report number of queued jobs", func(t *testing.T) {
+		//when
+		SetJobsQueued("alg1", 3)
+
+		//then
+		assert.Equal(t, 3.0, testutil.ToFloat64(jobsQueued.WithLabelValues("alg1")))
+	})
+}
+
+func TestResultsDeleted(t *testing.T) {
+	t.Run("should count deleted results by reason", func(t *testing.T) {
+		//given
+		counter := resultsDeleted.WithLabelValues("purge")
+		before := testutil.ToFloat64(counter)
+
+		//when
+		ResultsDeleted("purge", 3)
+
+		//then
+		assert.Equal(t, before+3, testutil.ToFloat64(counter))
+	})
+}
+
+func TestHandler(t *testing.T) {
+	t.Run("should expose metrics in Prometheus format", func(t *testing.T) {
+		//given
+		ObserveDatabaseCall("get", time.Millisecond)
+		r, err := http.NewRequest("GET", "/metrics", nil)
+		assert.NoError(t, err)
+		w := httptest.NewRecorder()
+
+		//when
+		Handler().ServeHTTP(w, r)
+
+		//then
+		assert.Equal(t, http.StatusOK, w.Code)
+		for _, name := range []string{"database_call_duration_seconds_count{command=\"get\"}", "jobs_in_progress", "http_requests_total"} {
+			assert.True(t, strings.Contains(w.Body.String(), name), name)
+		}
+	})
+}
@@ -0,0 +1,220 @@
+package queue
+
+import (
+	"backend/internal/metrics"
+	"github.com/pkg/errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrStopped is returned by Push after Stop.
+	ErrStopped = errors.New("queue is stopped")
+	// ErrTimeout is passed to Abandon of jobs which did not finish in time.
+	ErrTimeout = errors.New("job did not finish before timeout")
+	// ErrDuplicate is returned by Push for jobs whose id is queued or running
+	// already.
+	ErrDuplicate = errors.New("job is already queued or running")
+)
+
+// Job is a simulation waiting for a free slot of its algorithm.
+type Job struct {
+	ID        string
+	Algorithm string
+	// Priority orders queued jobs of an algorithm, higher first. Jobs with
+	// the same priority are started in the order they were pushed.
+	Priority int
+	// Run starts the job. Its slot stays taken until Done is called with the
+	// id of the job, Run returns an error or the timeout passes.
+	Run func() error
+	// Abandon is called with ErrTimeout when a started job does not finish in
+	// time.
+	Abandon func(reason error)
+}
+
+// Queue starts jobs as soon as their algorithm has a free slot. The number of
+// slots is set per algorithm, jobs of algorithms without a limit share the
+// default limit.
+type Queue struct {
+	mu       sync.Mutex
+	limit    int
+	limits   map[string]int
+	timeout  time.Duration
+	queued   map[string][]Job
+	running  map[string]map[string]*time.Timer
+	stopped  bool
+	starting sync.WaitGroup
+}
+
+// New creates a queue which runs up to limit jobs of each algorithm at once,
+// unless limits sets a different number for the algorithm. Started jobs free
+// their slot after timeout, when they are not done before.
+func New(limit int, limits map[string]int, timeout time.Duration) *Queue {
+	return &Queue{
+		limit:   limit,
+		limits:  limits,
+		timeout: timeout,
+		queued:  map[string][]Job{},
+		running: map[string]map[string]*time.Timer{},
+	}
+}
+
+// Push adds the job to the queue of its algorithm and returns its position,
+// counted from 1. Jobs started right away have position 0.
+func (q *Queue) Push(job Job) (int, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.stopped {
+		return 0, ErrStopped
+	}
+	if q.has(job.ID) {
+		return 0, ErrDuplicate
+	}
+
+	queued := q.queued[job.Algorithm]
+	i := sort.Search(len(queued), func(i int) bool { return queued[i].Priority < job.Priority })
+	queued = append(queued, Job{})
+	copy(queued[i+1:], queued[i:])
+	queued[i] = job
+	q.queued[job.Algorithm] = queued
+
+	q.fill(job.Algorithm)
+	return q.position(job.Algorithm, job.ID), nil
+}
+
+// Resume takes a slot for a job which was started before, e.g. by a previous
+// run of the server, so that it counts towards the limit of its algorithm
+// until it is done or the rest of its timeout passes. Jobs which are queued or
+// running already keep their slot and timeout.
+func (q *Queue) Resume(job Job, startedAt time.Time) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.stopped || q.has(job.ID) {
+		return
+	}
+	running := q.running[job.Algorithm]
+	if running == nil {
+		running = map[string]*time.Timer{}
+		q.running[job.Algorithm] = running
+	}
+	running[job.ID] = time.AfterFunc(q.timeout-time.Since(startedAt), func() { q.expire(job) })
+}
+
+// Done frees the slot of a started job. Ids of jobs which are not running are
+// ignored, so it is safe to call it for every finished job.
+func (q *Queue) Done(id string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for algorithm, running := range q.running {
+		if timer, ok := running[id]; ok {
+			timer.Stop()
+			delete(running, id)
+			q.fill(algorithm)
+			return
+		}
+	}
+}
+
+// Position returns the position of a queued job, counted from 1, or 0 if the
+// job is not queued.
+func (q *Queue) Position(id string) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for algorithm := range q.queued {
+		if position := q.position(algorithm, id); position > 0 {
+			return position
+		}
+	}
+	return 0
+}
+
+// Stop rejects new jobs, drops queued jobs and waits for jobs which are being
+// started. Jobs started before keep running, but their slots are no longer
+// tracked. Jobs are stored by the caller, which resumes them after restart.
+func (q *Queue) Stop() {
+	q.mu.Lock()
+	q.stopped = true
+	for algorithm := range q.queued {
+		delete(q.queued, algorithm)
+		metrics.SetJobsQueued(algorithm, 0)
+	}
+	for _, running := range q.running {
+		for id, timer := range running {
+			timer.Stop()
+			delete(running, id)
+		}
+	}
+	q.mu.Unlock()
+
+	q.starting.Wait()
+}
+
+// fill starts queued jobs of the algorithm while it has free slots. It has to
+// be called with the lock held.
+func (q *Queue) fill(algorithm string) {
+	limit, ok := q.limits[algorithm]
+	if !ok {
+		limit = q.limit
+	}
+	running := q.running[algorithm]
+	if running == nil {
+		running = map[string]*time.Timer{}
+		q.running[algorithm] = running
+	}
+	for !q.stopped && len(running) < limit && len(q.queued[algorithm]) > 0 {
+		job := q.queued[algorithm][0]
+		q.queued[algorithm] = q.queued[algorithm][1:]
+		running[job.ID] = time.AfterFunc(q.timeout, func() { q.expire(job) })
+
+		q.starting.Add(1)
+		go q.start(job)
+	}
+	metrics.SetJobsQueued(algorithm, len(q.queued[algorithm]))
+}
+
+func (q *Queue) start(job Job) {
+	defer q.starting.Done()
+	if err := job.Run(); err != nil {
+		q.Done(job.ID)
+	}
+}
+
+func (q *Queue) expire(job Job) {
+	q.mu.Lock()
+	running := q.running[job.Algorithm]
+	if _, ok := running[job.ID]; !ok {
+		q.mu.Unlock()
+		return
+	}
+	delete(running, job.ID)
+	q.fill(job.Algorithm)
+	q.mu.Unlock()
+
+	job.Abandon(ErrTimeout)
+}
+
+// has reports whether a job with the id is queued or running. It has to be
+// called with the lock held.
+func (q *Queue) has(id string) bool {
+	for _, running := range q.running {
+		if _, ok := running[id]; ok {
+			return true
+		}
+	}
+	for algorithm := range q.queued {
+		if q.position(algorithm, id) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (q *Queue) position(algorithm, id string) int {
+	for i, job := range q.queued[algorithm] {
+		if job.ID == id {
+			return i + 1
+		}
+	}
+	return 0
+}
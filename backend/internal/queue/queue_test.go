@@ -0,0 +1,181 @@
+package queue
+
+import (
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+// testJob returns a job which reports its id on started when it is run and
+// its abandon reason on abandoned.
+func testJob(id, algorithm string, priority int, started chan<- string, abandoned chan<- error) Job {
+	return Job{
+		ID:        id,
+		Algorithm: algorithm,
+		Priority:  priority,
+		Run: func() error {
+			started <- id
+			return nil
+		},
+		Abandon: func(reason error) { abandoned <- reason },
+	}
+}
+
+func receive(t *testing.T, started <-chan string) string {
+	select {
+	case id := <-started:
+		return id
+	case <-time.After(time.Second):
+		t.Fatal("job was not started")
+		return ""
+	}
+}
+
+func assertNotStarted(t *testing.T, started <-chan string) {
+	select {
+	case id := <-started:
+		t.Fatalf("job %s was started", id)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestQueue(t *testing.T) {
+	t.Run("should start jobs only when algorithm has a free slot", func(t *testing.T) {
+		//given
+		started := make(chan string, 10)
+		q := New(1, map[string]int{"alg2": 2}, time.Minute)
+
+		//when
+		first, _ := q.Push(testJob("1", "alg1", 0, started, nil))
+		second, _ := q.Push(testJob("2", "alg1", 0, started, nil))
+		other, _ := q.Push(testJob("3", "alg2", 0, started, nil))
+
+		//then
+		assert.Equal(t, 0, first)
+		assert.Equal(t, 1, second)
+		assert.Equal(t, 0, other)
+		assert.ElementsMatch(t, []string{"1", "3"}, []string{receive(t, started), receive(t, started)})
+		assertNotStarted(t, started)
+
+		//when
+		q.Done("1")
+
+		//then
+		assert.Equal(t, "2", receive(t, started))
+	})
+	t.Run("should start jobs with higher priority first", func(t *testing.T) {
+		//given
+		started := make(chan string, 10)
+		q := New(1, nil, time.Minute)
+		q.Push(testJob("running", "alg1", 0, started, nil))
+		receive(t, started)
+
+		//when
+		q.Push(testJob("low", "alg1", 0, started, nil))
+		q.Push(testJob("high", "alg1", 5, started, nil))
+		q.Push(testJob("low2", "alg1", 0, started, nil))
+
+		//then
+		assert.Equal(t, 1, q.Position("high"))
+		assert.Equal(t, 2, q.Position("low"))
+		assert.Equal(t, 3, q.Position("low2"))
+		assert.Equal(t, 0, q.Position("running"))
+		running := "running"
+		for _, id := range []string{"high", "low", "low2"} {
+			q.Done(running)
+			running = receive(t, started)
+			assert.Equal(t, id, running)
+		}
+	})
+	t.Run("should free slot of job which failed to start", func(t *testing.T) {
+		//given
+		started := make(chan string, 10)
+		q := New(1, nil, time.Minute)
+		failing := testJob("failing", "alg1", 0, started, nil)
+		failing.Run = func() error {
+			started <- "failing"
+			return errors.New("algorithm is down")
+		}
+
+		//when
+		q.Push(failing)
+		q.Push(testJob("next", "alg1", 0, started, nil))
+
+		//then
+		assert.Equal(t, "failing", receive(t, started))
+		assert.Equal(t, "next", receive(t, started))
+	})
+	t.Run("should abandon started job after timeout", func(t *testing.T) {
+		//given
+		started := make(chan string, 10)
+		abandoned := make(chan error, 10)
+		q := New(1, nil, 20*time.Millisecond)
+
+		//when
+		q.Push(testJob("slow", "alg1", 0, started, abandoned))
+		q.Push(testJob("next", "alg1", 0, started, abandoned))
+
+		//then
+		assert.Equal(t, "slow", receive(t, started))
+		assert.Equal(t, "next", receive(t, started))
+		assert.Equal(t, ErrTimeout, <-abandoned)
+	})
+	t.Run("should count resumed jobs towards limit until their timeout passes", func(t *testing.T) {
+		//given
+		started := make(chan string, 10)
+		abandoned := make(chan error, 10)
+		q := New(1, nil, 100*time.Millisecond)
+
+		//when
+		q.Resume(testJob("resumed", "alg1", 0, started, abandoned), time.Now().Add(-80*time.Millisecond))
+		position, _ := q.Push(testJob("next", "alg1", 0, started, abandoned))
+
+		//then
+		assert.Equal(t, 1, position)
+		assert.Equal(t, "next", receive(t, started))
+		assert.Equal(t, ErrTimeout, <-abandoned)
+	})
+	t.Run("should reject jobs with the id of a queued or running job", func(t *testing.T) {
+		//given
+		started := make(chan string, 10)
+		abandoned := make(chan error, 10)
+		q := New(1, nil, 50*time.Millisecond)
+		q.Push(testJob("running", "alg1", 0, started, abandoned))
+		q.Push(testJob("queued", "alg1", 0, started, abandoned))
+
+		//when
+		_, runningErr := q.Push(testJob("running", "alg1", 0, started, abandoned))
+		_, queuedErr := q.Push(testJob("queued", "alg2", 0, started, abandoned))
+		q.Resume(testJob("running", "alg1", 0, started, abandoned), time.Now().Add(-time.Hour))
+
+		//then
+		assert.Equal(t, ErrDuplicate, runningErr)
+		assert.Equal(t, ErrDuplicate, queuedErr)
+		assert.Equal(t, "running", receive(t, started))
+		assert.Equal(t, 1, q.Position("queued"))
+		assert.Equal(t, "queued", receive(t, started))
+		assert.Equal(t, ErrTimeout, <-abandoned)
+		assertNotStarted(t, started)
+	})
+	t.Run("should drop queued jobs and reject new ones when stopped", func(t *testing.T) {
+		//given
+		started := make(chan string, 10)
+		abandoned := make(chan error, 10)
+		q := New(1, nil, time.Minute)
+		q.Push(testJob("running", "alg1", 0, started, abandoned))
+		q.Push(testJob("queued", "alg1", 0, started, abandoned))
+		receive(t, started)
+
+		//when
+		q.Stop()
+		_, err := q.Push(testJob("late", "alg1", 0, started, abandoned))
+		q.Done("running")
+
+		//then
+		assert.Equal(t, ErrStopped, err)
+		assert.Equal(t, 0, q.Position("queued"))
+		assert.Len(t, abandoned, 0)
+		assertNotStarted(t, started)
+	})
+}
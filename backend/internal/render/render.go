@@ -0,0 +1,241 @@
+package render
+
+import (
+	"backend/internal/structure"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"github.com/pkg/errors"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+	"hash/fnv"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"strings"
+)
+
+const (
+	LineSolid  = "solid"
+	LineDashed = "dashed"
+	LineDotted = "dotted"
+
+	FormatPNG  = "png"
+	FormatJPEG = "jpeg"
+)
+
+type Options struct {
+	MinScore float64
+	Classes  map[string]bool
+	Line     string
+	Width    int
+	Masks    bool
+}
+
+var palette = []color.RGBA{
+	{R: 230, G: 25, B: 75, A: 255},
+	{R: 60, G: 180, B: 75, A: 255},
+	{R: 0, G: 130, B: 200, A: 255},
+	{R: 245, G: 130, B: 48, A: 255},
+	{R: 145, G: 30, B: 180, A: 255},
+	{R: 70, G: 240, B: 240, A: 255},
+	{R: 240, G: 50, B: 230, A: 255},
+	{R: 210, G: 245, B: 60, A: 255},
+	{R: 0, G: 128, B: 128, A: 255},
+	{R: 170, G: 110, B: 40, A: 255},
+}
+
+// DecodeImage decodes an image stored either as plain base64 or as a data URL,
+// the way the frontend uploads them.
+func DecodeImage(content string) (image.Image, string, error) {
+	raw, err := DecodeBase64(content)
+	if err != nil {
+		return nil, "", err
+	}
+	img, format, err := image.Decode(bytes.NewReader(raw))
+	if err != nil {
+		return nil, "", errors.New("failed to decode image: " + err.Error())
+	}
+	return img, format, nil
+}
+
+// DecodeBase64 returns the encoded file of an image stored either as plain
+// base64 or as a data URL.
+func DecodeBase64(content string) ([]byte, error) {
+	if i := strings.Index(content, "base64,"); i >= 0 && strings.HasPrefix(content, "data:") {
+		content = content[i+len("base64,"):]
+	}
+	raw, err := base64.StdEncoding.DecodeString(content)
+	if err != nil {
+		return nil, errors.New("image is not valid base64")
+	}
+	return raw, nil
+}
+
+func Encode(w io.Writer, img image.Image, format string) error {
+	switch format {
+	case FormatPNG:
+		return png.Encode(w, img)
+	case FormatJPEG:
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: 90})
+	}
+	return errors.New("unsupported format " + format)
+}
+
+// Draw returns a copy of src with detections drawn on top of it. Boxes are
+// expected in the [y1, x1, y2, x2] order returned by the detectors. Masks are
+// checked before they are drawn, since they come from the algorithms.
+func Draw(src image.Image, detections structure.Detections, opts Options) (*image.RGBA, error) {
+	bounds := src.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(dst, dst.Bounds(), src, bounds.Min, draw.Src)
+	if opts.Width < 1 {
+		opts.Width = 1
+	}
+
+	for i, name := range detections.Names {
+		if i >= len(detections.Scores) || i >= len(detections.BBox) || len(detections.BBox[i]) != 4 {
+			break
+		}
+		score := detections.Scores[i]
+		if score < opts.MinScore || (len(opts.Classes) > 0 && !opts.Classes[name]) {
+			continue
+		}
+		c := classColor(name)
+		if opts.Masks && i < len(detections.Masks) {
+			if err := drawMask(dst, detections.Masks[i], c); err != nil {
+				return nil, errors.Wrapf(err, "invalid mask of detection %d", i)
+			}
+		}
+		bbox := detections.BBox[i]
+		rect := image.Rect(int(bbox[1]), int(bbox[0]), int(bbox[3]), int(bbox[2]))
+		drawBox(dst, rect, c, opts.Line, opts.Width)
+		drawLabel(dst, fmt.Sprintf("%s %.3f", name, score), rect.Min, c)
+	}
+	return dst, nil
+}
+
+func classColor(name string) color.RGBA {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	return palette[h.Sum32()%uint32(len(palette))]
+}
+
+func drawBox(dst *image.RGBA, rect image.Rectangle, c color.RGBA, line string, width int) {
+	for w := 0; w < width; w++ {
+		r := rect.Inset(w)
+		if r.Empty() {
+			return
+		}
+		step := 0
+		for x := r.Min.X; x < r.Max.X; x++ {
+			if visible(line, step, width) {
+				dst.SetRGBA(x, r.Min.Y, c)
+				dst.SetRGBA(x, r.Max.Y-1, c)
+			}
+			step++
+		}
+		step = 0
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			if visible(line, step, width) {
+				dst.SetRGBA(r.Min.X, y, c)
+				dst.SetRGBA(r.Max.X-1, y, c)
+			}
+			step++
+		}
+	}
+}
+
+func visible(line string, step, width int) bool {
+	switch line {
+	case LineDashed:
+		return step%(10*width) < 6*width
+	case LineDotted:
+		return step%(3*width) < width
+	}
+	return true
+}
+
+func drawLabel(dst *image.RGBA, label string, at image.Point, c color.RGBA) {
+	face := basicfont.Face7x13
+	width := font.MeasureString(face, label).Ceil()
+	height := face.Metrics().Height.Ceil()
+	top := at.Y - height
+	if top < 0 {
+		top = at.Y
+	}
+	background := image.Rect(at.X, top, at.X+width+4, top+height)
+	draw.Draw(dst, background, image.NewUniform(c), image.Point{}, draw.Src)
+	drawer := font.Drawer{
+		Dst:  dst,
+		Src:  image.White,
+		Face: face,
+		Dot:  fixed.P(at.X+2, top+face.Metrics().Ascent.Ceil()),
+	}
+	drawer.DrawString(label)
+}
+
+func drawMask(dst *image.RGBA, mask structure.RLE, c color.RGBA) error {
+	if err := checkMask(mask, dst.Bounds()); err != nil {
+		return err
+	}
+	height := mask.Size[0]
+	pixel := 0
+	for i, count := range mask.Counts {
+		if i%2 == 1 {
+			for p := pixel; p < pixel+count; p++ {
+				x, y := p/height, p%height
+				if !(image.Point{X: x, Y: y}.In(dst.Bounds())) {
+					continue
+				}
+				blend(dst, x, y, c)
+			}
+		}
+		pixel += count
+	}
+	return nil
+}
+
+// checkMask makes sure that the mask covers the image exactly, so that
+// drawing it takes one pass over the image.
+func checkMask(mask structure.RLE, bounds image.Rectangle) error {
+	if len(mask.Size) != 2 {
+		return errors.New("size must have 2 values")
+	}
+	height, width := mask.Size[0], mask.Size[1]
+	if height <= 0 || width <= 0 {
+		return errors.New("size must be positive")
+	}
+	if height != bounds.Dy() || width != bounds.Dx() {
+		return fmt.Errorf("size %dx%d does not match image %dx%d", height, width, bounds.Dy(), bounds.Dx())
+	}
+	area, total := height*width, 0
+	for _, count := range mask.Counts {
+		if count < 0 {
+			return errors.New("counts must not be negative")
+		}
+		if total += count; total > area {
+			return fmt.Errorf("counts must sum to %d", area)
+		}
+	}
+	if total != area {
+		return fmt.Errorf("counts must sum to %d", area)
+	}
+	return nil
+}
+
+func blend(dst *image.RGBA, x, y int, c color.RGBA) {
+	const alpha = 0.4
+	o := dst.RGBAAt(x, y)
+	dst.SetRGBA(x, y, color.RGBA{
+		R: uint8(float64(o.R)*(1-alpha) + float64(c.R)*alpha),
+		G: uint8(float64(o.G)*(1-alpha) + float64(c.G)*alpha),
+		B: uint8(float64(o.B)*(1-alpha) + float64(c.B)*alpha),
+		A: o.A,
+	})
+}
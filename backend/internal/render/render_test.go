@@ -0,0 +1,159 @@
+package render
+
+import (
+	"backend/internal/structure"
+	"bytes"
+	"encoding/base64"
+	"github.com/stretchr/testify/assert"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+)
+
+func blankImage(width, height int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			img.SetRGBA(x, y, color.RGBA{A: 255})
+		}
+	}
+	return img
+}
+
+func TestDecodeImage(t *testing.T) {
+	var buf bytes.Buffer
+	assert.NoError(t, png.Encode(&buf, blankImage(4, 3)))
+	encoded := base64.StdEncoding.EncodeToString(buf.Bytes())
+	tests := []struct {
+		testName string
+		content  string
+		format   string
+		err      string
+	}{
+		{
+			testName: "should decode data url",
+			content:  "data:image/png;base64," + encoded,
+			format:   "png",
+		},
+		{
+			testName: "should decode plain base64",
+			content:  encoded,
+			format:   "png",
+		},
+		{
+			testName: "should return error when content is not base64",
+			content:  "data:image/png;base64,???",
+			err:      "image is not valid base64",
+		},
+		{
+			testName: "should return error when content is not an image",
+			content:  base64.StdEncoding.EncodeToString([]byte("text")),
+			err:      "failed to decode image: image: unknown format",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			//when
+			img, format, err := DecodeImage(tt.content)
+
+			//then
+			if tt.err != "" {
+				assert.EqualError(t, err, tt.err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.format, format)
+			assert.Equal(t, image.Rect(0, 0, 4, 3), img.Bounds())
+		})
+	}
+}
+
+func TestDraw(t *testing.T) {
+	black := color.RGBA{A: 255}
+	detections := structure.Detections{
+		Names:  []string{"person", "dog"},
+		Scores: []float64{0.9, 0.3},
+		BBox:   [][]float64{{20, 10, 60, 50}, {30, 30, 70, 70}},
+		Masks:  []structure.RLE{{Size: []int{80, 80}, Counts: []int{40*80 + 40, 1, 80*80 - 40*80 - 41}}},
+	}
+	t.Run("should draw solid boxes above score threshold", func(t *testing.T) {
+		//when
+		img, err := Draw(blankImage(80, 80), detections, Options{MinScore: 0.5, Line: LineSolid, Width: 1})
+
+		//then
+		assert.NoError(t, err)
+		c := classColor("person")
+		assert.Equal(t, c, img.RGBAAt(10, 40))
+		assert.Equal(t, c, img.RGBAAt(49, 40))
+		assert.Equal(t, c, img.RGBAAt(30, 59))
+		assert.Equal(t, black, img.RGBAAt(69, 50))
+	})
+	t.Run("should leave gaps in dashed boxes", func(t *testing.T) {
+		//when
+		img, err := Draw(blankImage(80, 80), detections, Options{Line: LineDashed, Width: 1, Classes: map[string]bool{"dog": true}})
+
+		//then
+		assert.NoError(t, err)
+		c := classColor("dog")
+		assert.Equal(t, c, img.RGBAAt(30, 69))
+		assert.Equal(t, black, img.RGBAAt(37, 69))
+		assert.Equal(t, black, img.RGBAAt(10, 59))
+	})
+	t.Run("should overlay masks only when requested", func(t *testing.T) {
+		//when
+		withMasks, err := Draw(blankImage(80, 80), detections, Options{Width: 1, Masks: true})
+		withoutMasks, err2 := Draw(blankImage(80, 80), detections, Options{Width: 1})
+
+		//then
+		assert.NoError(t, err)
+		assert.NoError(t, err2)
+		assert.NotEqual(t, black, withMasks.RGBAAt(40, 40))
+		assert.Equal(t, black, withoutMasks.RGBAAt(40, 40))
+	})
+	invalidMasks := []struct {
+		testName string
+		mask     structure.RLE
+		err      string
+	}{
+		{
+			testName: "should reject mask of zero size",
+			mask:     structure.RLE{Size: []int{0, 0}, Counts: []int{1, 1}},
+			err:      "invalid mask of detection 0: size must be positive",
+		},
+		{
+			testName: "should reject mask without width",
+			mask:     structure.RLE{Size: []int{80}, Counts: []int{80}},
+			err:      "invalid mask of detection 0: size must have 2 values",
+		},
+		{
+			testName: "should reject mask of other size than image",
+			mask:     structure.RLE{Size: []int{1 << 30, 1 << 30}, Counts: []int{1 << 60}},
+			err:      "invalid mask of detection 0: size 1073741824x1073741824 does not match image 80x80",
+		},
+		{
+			testName: "should reject negative counts",
+			mask:     structure.RLE{Size: []int{80, 80}, Counts: []int{10, -1}},
+			err:      "invalid mask of detection 0: counts must not be negative",
+		},
+		{
+			testName: "should reject counts not covering image",
+			mask:     structure.RLE{Size: []int{80, 80}, Counts: []int{10, 1 << 40}},
+			err:      "invalid mask of detection 0: counts must sum to 6400",
+		},
+	}
+	for _, tt := range invalidMasks {
+		t.Run(tt.testName, func(t *testing.T) {
+			//given
+			invalid := detections
+			invalid.Masks = []structure.RLE{tt.mask}
+
+			//when
+			img, err := Draw(blankImage(80, 80), invalid, Options{Width: 1, Masks: true})
+
+			//then
+			assert.Nil(t, img)
+			assert.EqualError(t, err, tt.err)
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package retention
+
+import (
+	"backend/internal/structure"
+	"encoding/json"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// resultKeyRegexp matches keys of simulation results, which are the time
+// stamp of the simulation followed by the algorithm id and operation type.
+// Keys of newer jobs end with the nanoseconds of the time stamp and a random
+// suffix, which keeps jobs submitted within the same second apart.
+var resultKeyRegexp = regexp.MustCompile(`^(\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}Z)(.+?)(-\d{9}[0-9a-f]{8})?$`)
+
+// Policy decides how long results of simulations are kept. Results of jobs
+// which did not finish yet are always kept, as the server still waits for
+// them.
+type Policy struct {
+	// MaxAge is how long results are kept after their simulation started,
+	// zero keeps results of any age.
+	MaxAge time.Duration
+	// MaxCount is how many of the newest results of each algorithm are kept,
+	// zero keeps any number.
+	MaxCount int
+	// FinishedOnly removes results of failed simulations.
+	FinishedOnly bool
+}
+
+// Result is a stored result of a simulation.
+type Result struct {
+	Key       string
+	Algorithm string
+	Operation string
+	Model     string
+	Status    string
+	TimeStamp time.Time
+}
+
+// Enabled reports whether the policy removes any results.
+func (p Policy) Enabled() bool {
+	return p.MaxAge > 0 || p.MaxCount > 0 || p.FinishedOnly
+}
+
+// Terminal reports whether a job with the status ended, so that its result
+// does not change anymore.
+func Terminal(status string) bool {
+	return status == "finished" || status == "error"
+}
+
+// ParseKey returns the time stamp of the result stored under key and the
+// algorithm id followed by the operation type. It returns false for keys of
+// other records.
+func ParseKey(key string) (time.Time, string, bool) {
+	match := resultKeyRegexp.FindStringSubmatch(key)
+	if match == nil {
+		return time.Time{}, "", false
+	}
+	timeStamp, err := time.Parse(time.RFC3339, match[1])
+	if err != nil {
+		return time.Time{}, "", false
+	}
+	return timeStamp, match[2], true
+}
+
+// ParseResult reads the result stored as value under key. It returns false
+// for keys of other records and values which are not results.
+func ParseResult(key, value string) (Result, bool) {
+	timeStamp, name, ok := ParseKey(key)
+	if !ok {
+		return Result{}, false
+	}
+	var results structure.Results
+	if err := json.Unmarshal([]byte(value), &results); err != nil {
+		return Result{}, false
+	}
+	return Result{
+		Key:       key,
+		Algorithm: results.Algorithm,
+		Operation: strings.TrimPrefix(name, results.Algorithm),
+		Model:     results.Model,
+		Status:    results.Status,
+		TimeStamp: timeStamp,
+	}, true
+}
+
+// Expiration returns how long value written to key is kept, or 0 when it is
+// kept until it is removed. Only the age of results can be enforced this way,
+// the other limits are applied by Expired.
+func (p Policy) Expiration(key, value string) time.Duration {
+	return p.expiration(key, value, time.Now())
+}
+
+func (p Policy) expiration(key, value string, now time.Time) time.Duration {
+	if p.MaxAge <= 0 {
+		return 0
+	}
+	result, ok := ParseResult(key, value)
+	if !ok || !Terminal(result.Status) {
+		return 0
+	}
+	// results which are already too old are removed right away
+	remaining := result.TimeStamp.Add(p.MaxAge).Sub(now)
+	if remaining < time.Second {
+		return time.Second
+	}
+	return remaining
+}
+
+// Expired returns keys of the results which the policy does not keep
+// anymore, sorted from the oldest one.
+func (p Policy) Expired(results []Result, now time.Time) []string {
+	sorted := append([]Result{}, results...)
+	sort.Slice(sorted, func(i, j int) bool {
+		if !sorted[i].TimeStamp.Equal(sorted[j].TimeStamp) {
+			return sorted[i].TimeStamp.After(sorted[j].TimeStamp)
+		}
+		return sorted[i].Key > sorted[j].Key
+	})
+
+	keys := []string{}
+	kept := map[string]int{}
+	for _, result := range sorted {
+		if !Terminal(result.Status) {
+			continue
+		}
+		switch {
+		case p.FinishedOnly && result.Status != "finished":
+		case p.MaxAge > 0 && result.TimeStamp.Before(now.Add(-p.MaxAge)):
+		case p.MaxCount > 0 && kept[result.Algorithm] >= p.MaxCount:
+		default:
+			kept[result.Algorithm]++
+			continue
+		}
+		keys = append(keys, result.Key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,143 @@
+package retention
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestParseResult(t *testing.T) {
+	tests := []struct {
+		testName string
+		key      string
+		value    string
+		result   Result
+		ok       bool
+	}{
+		{
+			testName: "should read result",
+			key:      "2022-01-20T10:00:00Zalg1segmentation",
+			value:    `{"algorithm":"alg1","model":"default","timeStamp":"2022-01-20T10:00:00Z","status":"finished"}`,
+			result: Result{
+				Key:       "2022-01-20T10:00:00Zalg1segmentation",
+				Algorithm: "alg1",
+				Operation: "segmentation",
+				Model:     "default",
+				Status:    "finished",
+				TimeStamp: time.Date(2022, 1, 20, 10, 0, 0, 0, time.UTC),
+			},
+			ok: true,
+		},
+		{
+			testName: "should read result of key with suffix",
+			key:      "2022-01-20T10:00:00Zalg1segmentation-651387237a1b2c3d4",
+			value:    `{"algorithm":"alg1","model":"default","timeStamp":"2022-01-20T10:00:00Z","status":"queued"}`,
+			result: Result{
+				Key:       "2022-01-20T10:00:00Zalg1segmentation-651387237a1b2c3d4",
+				Algorithm: "alg1",
+				Operation: "segmentation",
+				Model:     "default",
+				Status:    "queued",
+				TimeStamp: time.Date(2022, 1, 20, 10, 0, 0, 0, time.UTC),
+			},
+			ok: true,
+		},
+		{testName: "should skip other keys", key: "images", value: `{"ids":[]}`},
+		{testName: "should skip values which are not results", key: "2022-01-20T10:00:00Zalg1segmentation", value: "not json"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			//when
+			result, ok := ParseResult(tt.key, tt.value)
+
+			//then
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.result, result)
+		})
+	}
+}
+
+func TestPolicy_Expiration(t *testing.T) {
+	now := time.Date(2022, 1, 20, 12, 0, 0, 0, time.UTC)
+	key := "2022-01-20T10:00:00Zalg1segmentation"
+	finished := `{"algorithm":"alg1","status":"finished"}`
+	tests := []struct {
+		testName   string
+		policy     Policy
+		key        string
+		value      string
+		expiration time.Duration
+	}{
+		{testName: "should keep results without max age", policy: Policy{MaxCount: 10}, key: key, value: finished},
+		{testName: "should expire finished results", policy: Policy{MaxAge: 24 * time.Hour}, key: key, value: finished, expiration: 22 * time.Hour},
+		{testName: "should expire failed results", policy: Policy{MaxAge: 24 * time.Hour}, key: key, value: `{"algorithm":"alg1","status":"error"}`, expiration: 22 * time.Hour},
+		{testName: "should expire old results right away", policy: Policy{MaxAge: time.Hour}, key: key, value: finished, expiration: time.Second},
+		{testName: "should keep results of running jobs", policy: Policy{MaxAge: time.Hour}, key: key, value: `{"algorithm":"alg1","status":"in-progress"}`},
+		{testName: "should keep other keys", policy: Policy{MaxAge: time.Hour}, key: "pending_jobs", value: `{"ids":[]}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			//when
+			expiration := tt.policy.expiration(tt.key, tt.value, now)
+
+			//then
+			assert.Equal(t, tt.expiration, expiration)
+		})
+	}
+}
+
+func TestPolicy_Expired(t *testing.T) {
+	now := time.Date(2022, 1, 20, 12, 0, 0, 0, time.UTC)
+	result := func(hour int, algorithm, status string) Result {
+		timeStamp := time.Date(2022, 1, 20, hour, 0, 0, 0, time.UTC)
+		return Result{
+			Key:       timeStamp.Format(time.RFC3339) + algorithm + "demo",
+			Algorithm: algorithm,
+			Status:    status,
+			TimeStamp: timeStamp,
+		}
+	}
+	results := []Result{
+		result(8, "alg1", "finished"),
+		result(9, "alg1", "error"),
+		result(10, "alg1", "in-progress"),
+		result(11, "alg1", "finished"),
+		result(9, "alg2", "finished"),
+	}
+	tests := []struct {
+		testName string
+		policy   Policy
+		expired  []string
+	}{
+		{testName: "should keep all results without limits", policy: Policy{}, expired: []string{}},
+		{
+			testName: "should remove old results",
+			policy:   Policy{MaxAge: 150 * time.Minute},
+			expired:  []string{"2022-01-20T08:00:00Zalg1demo", "2022-01-20T09:00:00Zalg1demo", "2022-01-20T09:00:00Zalg2demo"},
+		},
+		{
+			testName: "should keep newest results of each algorithm",
+			policy:   Policy{MaxCount: 1},
+			expired:  []string{"2022-01-20T08:00:00Zalg1demo", "2022-01-20T09:00:00Zalg1demo"},
+		},
+		{
+			testName: "should remove failed results",
+			policy:   Policy{FinishedOnly: true},
+			expired:  []string{"2022-01-20T09:00:00Zalg1demo"},
+		},
+		{
+			testName: "should not count failed results which are removed",
+			policy:   Policy{MaxCount: 2, FinishedOnly: true},
+			expired:  []string{"2022-01-20T09:00:00Zalg1demo"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			//when
+			expired := tt.policy.Expired(results, now)
+
+			//then
+			assert.Equal(t, tt.expired, expired)
+		})
+	}
+}
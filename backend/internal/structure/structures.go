@@ -1,29 +1,289 @@
 package structure
 
+import "encoding/json"
+
 type Data struct {
 	ID      string `json:"id"`
 	Content string `json:"content"`
 }
 
 type Body struct {
-	ID    string `json:"id"`
-	Model string `json:"model"`
-	Image string `json:"image"`
+	ID       string `json:"id"`
+	Model    string `json:"model"`
+	Version  int    `json:"version,omitempty"`
+	Image    string `json:"image,omitempty"`
+	ImageID  string `json:"imageId,omitempty"`
+	Priority int    `json:"priority,omitempty"`
 }
 
+// Algorithm is the pre-registry layout of the `models` key, kept to read
+// data written by older versions.
 type Algorithm struct {
 	Models map[string][]string `json:"models"`
 }
 
+type Registry struct {
+	Algorithms map[string]*AlgorithmModels `json:"algorithms"`
+}
+
+type AlgorithmModels struct {
+	Default string             `json:"default"`
+	Models  map[string][]Model `json:"models"`
+}
+
+type Model struct {
+	Name        string   `json:"name"`
+	Version     int      `json:"version"`
+	File        string   `json:"file"`
+	SHA256      string   `json:"sha256"`
+	Size        int64    `json:"size"`
+	Uploader    string   `json:"uploader"`
+	UploadedAt  string   `json:"uploadedAt"`
+	Description string   `json:"description"`
+	Classes     []string `json:"classes"`
+	Status      string   `json:"status"`
+}
+
+// Images is the pre image id layout of the `images` key, which held the
+// contents of all images, kept to read data written by older versions.
 type Images struct {
 	Images []string `json:"images"`
 }
 
+// ImageIndex lists the images shown in the gallery. Contents are stored
+// separately under `image:{id}`.
+type ImageIndex struct {
+	IDs []string `json:"ids"`
+}
+
+// Image lists an image of the gallery, its content is served under URL.
+type Image struct {
+	ID  string `json:"id"`
+	URL string `json:"url,omitempty"`
+}
+
+type ImageList struct {
+	Images []Image `json:"images"`
+}
+
+// Results of a simulation. Image holds the image content of results recorded
+// before images had ids. Position is not stored, it is set for queued jobs
+// when they are read. Attempts counts how many times the job was sent to its
+// algorithm and StartedAt is the time of the last attempt.
 type Results struct {
+	Algorithm    string `json:"algorithm"`
+	Operation    string `json:"operation,omitempty"`
+	Model        string `json:"model"`
+	ModelVersion int    `json:"modelVersion,omitempty"`
+	ImageID      string `json:"imageId,omitempty"`
+	Image        string `json:"image,omitempty"`
+	Result       string `json:"result"`
+	TimeStamp    string `json:"timeStamp"`
+	Status       string `json:"status"`
+	Error        string `json:"error,omitempty"`
+	Priority     int    `json:"priority,omitempty"`
+	Position     int    `json:"position,omitempty"`
+	Attempts     int    `json:"attempts,omitempty"`
+	StartedAt    string `json:"startedAt,omitempty"`
+	RequestID    string `json:"requestId,omitempty"`
+	Lease        string `json:"lease,omitempty"`
+}
+
+// ResultSummary holds the fields of results which retention and purges
+// select results by. It is stored next to results of jobs which ended, so
+// that they are selected without reading whole results.
+type ResultSummary struct {
 	Algorithm string `json:"algorithm"`
 	Model     string `json:"model"`
-	Image     string `json:"image"`
-	Result    string `json:"result"`
-	TimeStamp string `json:"timeStamp"`
 	Status    string `json:"status"`
 }
+
+// JobIndex lists the ids of jobs which did not finish yet, in the order they
+// were submitted. Pending jobs were stored this way before every job got its
+// own key.
+type JobIndex struct {
+	IDs []string `json:"ids"`
+}
+
+// JobStatus is returned when a simulation is submitted. Position is counted
+// from 1 and omitted when the job started right away.
+type JobStatus struct {
+	ID       string `json:"id"`
+	Status   string `json:"status"`
+	Position int    `json:"position,omitempty"`
+}
+
+// Lease is a job given to a worker until ExpiresAt. Job has the format of
+// the body sent to algorithm containers, it is omitted in heartbeat responses.
+type Lease struct {
+	ID        string          `json:"id"`
+	JobID     string          `json:"jobId"`
+	Operation string          `json:"operation"`
+	ExpiresAt string          `json:"expiresAt"`
+	Job       json.RawMessage `json:"job,omitempty"`
+}
+
+// PurgeRequest selects results deleted by POST /v1/admin/purge. Empty fields
+// match any result, Before is an RFC3339 time. Results are only listed and
+// kept when DryRun is set.
+type PurgeRequest struct {
+	Algorithm string `json:"algorithm,omitempty"`
+	Operation string `json:"operation,omitempty"`
+	Status    string `json:"status,omitempty"`
+	Model     string `json:"model,omitempty"`
+	Before    string `json:"before,omitempty"`
+	DryRun    bool   `json:"dryRun,omitempty"`
+}
+
+// PurgeResult lists the ids of the results matched by a purge and how many
+// of them were deleted.
+type PurgeResult struct {
+	DryRun  bool     `json:"dryRun"`
+	IDs     []string `json:"ids"`
+	Deleted int      `json:"deleted"`
+}
+
+// RestoreCounts counts records of one kind read from a backup by what
+// happened to them.
+type RestoreCounts struct {
+	Added       int `json:"added"`
+	Skipped     int `json:"skipped"`
+	Overwritten int `json:"overwritten"`
+	Renamed     int `json:"renamed"`
+}
+
+// RestoreReport is returned by POST /v1/admin/restore. SchemaVersion is the
+// version of the database schema the backup was written with.
+type RestoreReport struct {
+	DryRun        bool          `json:"dryRun"`
+	Conflict      string        `json:"conflict"`
+	SchemaVersion int           `json:"schemaVersion"`
+	Images        RestoreCounts `json:"images"`
+	Models        RestoreCounts `json:"models"`
+	Results       RestoreCounts `json:"results"`
+}
+
+// AuditEntry records a mutating operation: who did it, from where and with
+// which request. Before and After summarize the changed record, e.g. the
+// status of a job or the default model of an algorithm.
+type AuditEntry struct {
+	ID        string            `json:"id"`
+	Time      string            `json:"time"`
+	Actor     string            `json:"actor"`
+	SourceIP  string            `json:"sourceIp,omitempty"`
+	RequestID string            `json:"requestId,omitempty"`
+	Action    string            `json:"action"`
+	Resource  string            `json:"resource"`
+	Before    map[string]string `json:"before,omitempty"`
+	After     map[string]string `json:"after,omitempty"`
+}
+
+// AuditLog is returned by GET /v1/admin/audit, newest entries first.
+type AuditLog struct {
+	Entries []AuditEntry `json:"entries"`
+}
+
+// Stats is returned by GET /v1/stats. Models are counted for every
+// algorithm, jobs for every requested window and storage for every kind of
+// record, with their sum under "total". Storage is left out when the database
+// does not report the size of values.
+type Stats struct {
+	GeneratedAt      string                  `json:"generatedAt"`
+	Images           int                     `json:"images"`
+	Models           map[string]ModelStats   `json:"models"`
+	Jobs             []JobStats              `json:"jobs"`
+	OldestInProgress *JobAge                 `json:"oldestInProgress,omitempty"`
+	Storage          map[string]StorageUsage `json:"storage,omitempty"`
+}
+
+// ModelStats counts the models of an algorithm, their versions and the
+// versions which can be used.
+type ModelStats struct {
+	Default  string `json:"default"`
+	Models   int    `json:"models"`
+	Versions int    `json:"versions"`
+	Ready    int    `json:"ready"`
+}
+
+// JobStats counts jobs submitted within Window before the stats were
+// generated, "all" counts all stored jobs. Callbacks counts results reported
+// by algorithms, CallbackErrors those of them which reported an error.
+type JobStats struct {
+	Window            string         `json:"window"`
+	Since             string         `json:"since,omitempty"`
+	Total             int            `json:"total"`
+	Statuses          map[string]int `json:"statuses"`
+	Groups            []JobGroup     `json:"groups"`
+	Callbacks         int            `json:"callbacks"`
+	CallbackErrors    int            `json:"callbackErrors"`
+	CallbackErrorRate float64        `json:"callbackErrorRate"`
+}
+
+// JobGroup counts jobs of an algorithm and operation with the status.
+type JobGroup struct {
+	Algorithm string `json:"algorithm"`
+	Operation string `json:"operation"`
+	Status    string `json:"status"`
+	Count     int    `json:"count"`
+}
+
+// JobAge is how long a job has been running.
+type JobAge struct {
+	ID         string `json:"id"`
+	StartedAt  string `json:"startedAt"`
+	AgeSeconds int64  `json:"ageSeconds"`
+}
+
+// StorageUsage counts stored keys and the size of their values. The size
+// does not include the overhead of the database.
+type StorageUsage struct {
+	Keys  int   `json:"keys"`
+	Bytes int64 `json:"bytes"`
+}
+
+// Error is the body of every error response. Code is derived from the status
+// and does not change, message is meant for users and details contain the
+// underlying error, if there is one.
+type Error struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	Details   string `json:"details,omitempty"`
+	RequestID string `json:"requestId,omitempty"`
+}
+
+type Detections struct {
+	Names  []string    `json:"names"`
+	Scores []float64   `json:"scores"`
+	BBox   [][]float64 `json:"bbox"`
+	Masks  []RLE       `json:"masks,omitempty"`
+}
+
+// RLE is a COCO uncompressed run-length encoded mask: counts alternate
+// between background and foreground pixels in column-major order.
+type RLE struct {
+	Size   []int `json:"size"`
+	Counts []int `json:"counts"`
+}
+
+type ModelUpload struct {
+	Algorithm   string   `json:"algorithm"`
+	Name        string   `json:"name"`
+	SHA256      string   `json:"sha256"`
+	Size        int64    `json:"size"`
+	Uploader    string   `json:"uploader"`
+	Description string   `json:"description"`
+	Classes     []string `json:"classes"`
+	Default     bool     `json:"default"`
+}
+
+type UploadSession struct {
+	ModelUpload
+	ID        string  `json:"id"`
+	Received  int64   `json:"received"`
+	Progress  float64 `json:"progress"`
+	Status    string  `json:"status"`
+	Version   int     `json:"version,omitempty"`
+	CreatedAt string  `json:"createdAt"`
+	// Error is the reason the last attempt to complete the upload failed.
+	Error string `json:"error,omitempty"`
+}
@@ -0,0 +1,105 @@
+package tracing
+
+import (
+	"context"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+const traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+func setupRecorder() *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	return recorder
+}
+
+func TestMiddleware(t *testing.T) {
+	tests := []struct {
+		testName    string
+		traceparent string
+		status      int
+		newTrace    bool
+	}{
+		{
+			testName:    "should continue trace sent by client",
+			traceparent: traceparent,
+			status:      http.StatusOK,
+		},
+		{
+			testName: "should start new trace when client does not send one",
+			status:   http.StatusNotFound,
+			newTrace: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			//given
+			recorder := setupRecorder()
+			var handlerSpan trace.SpanContext
+			router := mux.NewRouter()
+			router.Use(Middleware)
+			router.HandleFunc("/v1/jobs/{id}", func(w http.ResponseWriter, r *http.Request) {
+				handlerSpan = trace.SpanContextFromContext(r.Context())
+				w.WriteHeader(tt.status)
+			})
+			r := httptest.NewRequest("GET", "/v1/jobs/1", nil)
+			if tt.traceparent != "" {
+				r.Header.Set("traceparent", tt.traceparent)
+			}
+			w := httptest.NewRecorder()
+
+			//when
+			router.ServeHTTP(w, r)
+
+			//then
+			spans := recorder.Ended()
+			assert.Len(t, spans, 1)
+			assert.Equal(t, "GET /v1/jobs/{id}", spans[0].Name())
+			assert.Equal(t, trace.SpanKindServer, spans[0].SpanKind())
+			assert.Equal(t, spans[0].SpanContext(), handlerSpan)
+			if tt.newTrace {
+				assert.False(t, spans[0].Parent().IsValid())
+			} else {
+				assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", spans[0].SpanContext().TraceID().String())
+				assert.Equal(t, "00f067aa0ba902b7", spans[0].Parent().SpanID().String())
+			}
+		})
+	}
+}
+
+func TestInject(t *testing.T) {
+	//given
+	setupRecorder()
+	ctx, span := Tracer().Start(context.Background(), "test")
+	defer span.End()
+	header := http.Header{}
+
+	//when
+	Inject(ctx, header)
+
+	//then
+	expected := "00-" + span.SpanContext().TraceID().String() + "-" + span.SpanContext().SpanID().String() + "-01"
+	assert.Equal(t, expected, header.Get("traceparent"))
+}
+
+func TestSetup(t *testing.T) {
+	t.Run("should return error for unknown exporter", func(t *testing.T) {
+		_, err := Setup(context.Background(), "backend", "unknown")
+		assert.Error(t, err)
+	})
+	t.Run("should set up tracing without exporter", func(t *testing.T) {
+		shutdown, err := Setup(context.Background(), "backend", ExporterNone)
+		assert.NoError(t, err)
+		assert.NoError(t, shutdown(context.Background()))
+	})
+}
@@ -0,0 +1,248 @@
+package worker
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrStopped is returned by RunSimulation for jobs which no worker leased
+	// before the pool stopped.
+	ErrStopped = errors.New("worker pool is stopped")
+	// ErrUnknownLease is returned for leases which were completed or expired.
+	ErrUnknownLease = errors.New("lease does not exist or expired")
+	// ErrUploadNotSupported is returned by UploadModel, as the pool can not
+	// reach its workers.
+	ErrUploadNotSupported = errors.New("models of algorithms served by workers have to be deployed with the workers")
+)
+
+// Lease gives a job to a worker until ExpiresAt.
+type Lease struct {
+	ID        string
+	JobID     string
+	Operation string
+	Data      []byte
+	ExpiresAt time.Time
+}
+
+type task struct {
+	jobID     string
+	operation string
+	data      []byte
+	leased    chan struct{}
+	once      sync.Once
+}
+
+type lease struct {
+	Lease
+	task  *task
+	timer *time.Timer
+}
+
+// Pool hands out simulations of an algorithm to workers which poll for them,
+// instead of sending them to the algorithm container. Workers keep a job by
+// sending heartbeats, jobs of workers which stop sending them are offered to
+// other workers again.
+type Pool struct {
+	id      string
+	ttl     time.Duration
+	mu      sync.Mutex
+	offered []*task
+	leases  map[string]*lease
+	wake    chan struct{}
+	stop    chan struct{}
+	stopped bool
+}
+
+// NewPool creates a pool for the algorithm, whose leases expire after ttl
+// without a heartbeat.
+func NewPool(id string, ttl time.Duration) *Pool {
+	return &Pool{
+		id:     id,
+		ttl:    ttl,
+		leases: map[string]*lease{},
+		wake:   make(chan struct{}),
+		stop:   make(chan struct{}),
+	}
+}
+
+func (p *Pool) GetID() string {
+	return p.id
+}
+
+func (p *Pool) UploadModel(ctx context.Context, name string, model io.Reader) error {
+	return ErrUploadNotSupported
+}
+
+// RunSimulation offers the job to workers and returns once one of them
+// leased it, so that the job counts as started only when a worker has it.
+func (p *Pool) RunSimulation(ctx context.Context, opType string, data []byte) (int, error) {
+	var body struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(data, &body); err != nil {
+		return 0, errors.Wrap(err, "failed to read job id")
+	}
+	t := &task{jobID: body.ID, operation: opType, data: data, leased: make(chan struct{})}
+
+	p.mu.Lock()
+	if p.stopped {
+		p.mu.Unlock()
+		return 0, ErrStopped
+	}
+	p.offer(t, false)
+	p.mu.Unlock()
+
+	select {
+	case <-t.leased:
+		return http.StatusOK, nil
+	case <-p.stop:
+	case <-ctx.Done():
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	select {
+	case <-t.leased:
+		return http.StatusOK, nil
+	default:
+	}
+	p.withdraw(t)
+	if ctx.Err() != nil {
+		return 0, ctx.Err()
+	}
+	return 0, ErrStopped
+}
+
+// Lease waits until a job is offered or ctx is done. It returns false when
+// there was no job to lease.
+func (p *Pool) Lease(ctx context.Context) (Lease, bool) {
+	for {
+		p.mu.Lock()
+		if p.stopped {
+			p.mu.Unlock()
+			return Lease{}, false
+		}
+		if len(p.offered) > 0 {
+			t := p.offered[0]
+			p.offered = p.offered[1:]
+			l := p.lease(t)
+			p.mu.Unlock()
+			return l, true
+		}
+		wake := p.wake
+		p.mu.Unlock()
+
+		select {
+		case <-wake:
+		case <-p.stop:
+		case <-ctx.Done():
+			return Lease{}, false
+		}
+	}
+}
+
+// Heartbeat extends the lease by the ttl of the pool.
+func (p *Pool) Heartbeat(id string) (Lease, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	l, ok := p.leases[id]
+	if !ok {
+		return Lease{}, ErrUnknownLease
+	}
+	l.ExpiresAt = time.Now().Add(p.ttl)
+	l.timer.Reset(p.ttl)
+	return l.Lease, nil
+}
+
+// Complete ends the lease, after the worker reported the results of its job.
+func (p *Pool) Complete(id string) (Lease, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	l, ok := p.leases[id]
+	if !ok {
+		return Lease{}, ErrUnknownLease
+	}
+	l.timer.Stop()
+	delete(p.leases, id)
+	return l.Lease, nil
+}
+
+// Stop ends all leases and wakes waiting workers. Jobs which were not leased
+// yet are returned to their callers with ErrStopped.
+func (p *Pool) Stop() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.stopped {
+		return
+	}
+	p.stopped = true
+	close(p.stop)
+	for id, l := range p.leases {
+		l.timer.Stop()
+		delete(p.leases, id)
+	}
+}
+
+// offer adds the task to the offered jobs, first when it was leased before.
+// It has to be called with the lock held.
+func (p *Pool) offer(t *task, first bool) {
+	if first {
+		p.offered = append([]*task{t}, p.offered...)
+	} else {
+		p.offered = append(p.offered, t)
+	}
+	close(p.wake)
+	p.wake = make(chan struct{})
+}
+
+// withdraw removes a task which was not leased. It has to be called with the
+// lock held.
+func (p *Pool) withdraw(t *task) {
+	for i, offered := range p.offered {
+		if offered == t {
+			p.offered = append(p.offered[:i], p.offered[i+1:]...)
+			return
+		}
+	}
+}
+
+// lease gives the task to a worker. It has to be called with the lock held.
+func (p *Pool) lease(t *task) Lease {
+	id := make([]byte, 16)
+	rand.Read(id)
+	l := &lease{
+		Lease: Lease{
+			ID:        hex.EncodeToString(id),
+			JobID:     t.jobID,
+			Operation: t.operation,
+			Data:      t.data,
+			ExpiresAt: time.Now().Add(p.ttl),
+		},
+		task: t,
+	}
+	l.timer = time.AfterFunc(p.ttl, func() { p.expire(l.ID) })
+	p.leases[l.ID] = l
+	t.once.Do(func() { close(t.leased) })
+	return l.Lease
+}
+
+func (p *Pool) expire(id string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	l, ok := p.leases[id]
+	if !ok || time.Now().Before(l.ExpiresAt) {
+		return
+	}
+	delete(p.leases, id)
+	if !p.stopped {
+		p.offer(l.task, true)
+	}
+}
@@ -0,0 +1,146 @@
+package worker
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+	"time"
+)
+
+type runResult struct {
+	status int
+	err    error
+}
+
+// run starts a simulation of the job in the background and reports its
+// outcome on the returned channel.
+func run(ctx context.Context, p *Pool, jobID string) <-chan runResult {
+	done := make(chan runResult, 1)
+	go func() {
+		status, err := p.RunSimulation(ctx, "demo", []byte(`{"id":"`+jobID+`"}`))
+		done <- runResult{status, err}
+	}()
+	return done
+}
+
+func leaseJob(t *testing.T, p *Pool) Lease {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	l, ok := p.Lease(ctx)
+	if !ok {
+		t.Fatal("no job was leased")
+	}
+	return l
+}
+
+func receive(t *testing.T, done <-chan runResult) runResult {
+	select {
+	case result := <-done:
+		return result
+	case <-time.After(time.Second):
+		t.Fatal("simulation did not return")
+		return runResult{}
+	}
+}
+
+func TestPool(t *testing.T) {
+	t.Run("should start simulation when a worker leases it", func(t *testing.T) {
+		//given
+		p := NewPool("alg1", time.Minute)
+		done := run(context.Background(), p, "job1")
+
+		//when
+		l := leaseJob(t, p)
+
+		//then
+		assert.Equal(t, "job1", l.JobID)
+		assert.Equal(t, "demo", l.Operation)
+		assert.Equal(t, `{"id":"job1"}`, string(l.Data))
+		assert.Len(t, l.ID, 32)
+		assert.Equal(t, runResult{status: http.StatusOK}, receive(t, done))
+	})
+	t.Run("should wait for jobs until context is done", func(t *testing.T) {
+		//given
+		p := NewPool("alg1", time.Minute)
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+
+		//when
+		_, ok := p.Lease(ctx)
+
+		//then
+		assert.False(t, ok)
+	})
+	t.Run("should offer job to other workers when lease expires", func(t *testing.T) {
+		//given
+		p := NewPool("alg1", 20*time.Millisecond)
+		run(context.Background(), p, "job1")
+		first := leaseJob(t, p)
+
+		//when
+		second := leaseJob(t, p)
+
+		//then
+		assert.Equal(t, "job1", second.JobID)
+		assert.NotEqual(t, first.ID, second.ID)
+		_, err := p.Heartbeat(first.ID)
+		assert.Equal(t, ErrUnknownLease, err)
+	})
+	t.Run("should keep lease while worker sends heartbeats", func(t *testing.T) {
+		//given
+		p := NewPool("alg1", 50*time.Millisecond)
+		run(context.Background(), p, "job1")
+		l := leaseJob(t, p)
+
+		//when
+		for i := 0; i < 4; i++ {
+			time.Sleep(20 * time.Millisecond)
+			extended, err := p.Heartbeat(l.ID)
+			assert.NoError(t, err)
+			assert.True(t, extended.ExpiresAt.After(l.ExpiresAt))
+		}
+		completed, err := p.Complete(l.ID)
+
+		//then
+		assert.NoError(t, err)
+		assert.Equal(t, "job1", completed.JobID)
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		_, ok := p.Lease(ctx)
+		assert.False(t, ok)
+	})
+	t.Run("should return jobs which were not leased when stopped", func(t *testing.T) {
+		//given
+		p := NewPool("alg1", time.Minute)
+		done := run(context.Background(), p, "job1")
+		time.Sleep(10 * time.Millisecond)
+
+		//when
+		p.Stop()
+
+		//then
+		assert.Equal(t, runResult{err: ErrStopped}, receive(t, done))
+		_, ok := p.Lease(context.Background())
+		assert.False(t, ok)
+		_, err := p.RunSimulation(context.Background(), "demo", []byte(`{"id":"job2"}`))
+		assert.Equal(t, ErrStopped, err)
+	})
+	t.Run("should withdraw job when context is done", func(t *testing.T) {
+		//given
+		p := NewPool("alg1", time.Minute)
+		ctx, cancel := context.WithCancel(context.Background())
+		done := run(ctx, p, "job1")
+		time.Sleep(10 * time.Millisecond)
+
+		//when
+		cancel()
+
+		//then
+		assert.Equal(t, runResult{err: context.Canceled}, receive(t, done))
+		leaseCtx, cancelLease := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancelLease()
+		_, ok := p.Lease(leaseCtx)
+		assert.False(t, ok)
+	})
+}
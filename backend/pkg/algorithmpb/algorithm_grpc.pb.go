@@ -0,0 +1,289 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.2.0
+// - protoc             (unknown)
+// source: algorithm/v1/algorithm.proto
+
+package algorithmpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// AlgorithmClient is the client API for Algorithm service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type AlgorithmClient interface {
+	// RunSimulation starts a simulation and returns once the algorithm accepted
+	// it. Results are delivered by WatchResults.
+	RunSimulation(ctx context.Context, in *RunSimulationRequest, opts ...grpc.CallOption) (*RunSimulationResponse, error)
+	// UploadModel streams a model file. The first message carries the name of
+	// the file, the following ones its content.
+	UploadModel(ctx context.Context, opts ...grpc.CallOption) (Algorithm_UploadModelClient, error)
+	// GetStatus returns the state of a simulation started before.
+	GetStatus(ctx context.Context, in *GetStatusRequest, opts ...grpc.CallOption) (*SimulationStatus, error)
+	// WatchResults streams results of simulations as they finish. Results are
+	// kept by the algorithm until a watcher received them.
+	WatchResults(ctx context.Context, in *WatchResultsRequest, opts ...grpc.CallOption) (Algorithm_WatchResultsClient, error)
+}
+
+type algorithmClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewAlgorithmClient(cc grpc.ClientConnInterface) AlgorithmClient {
+	return &algorithmClient{cc}
+}
+
+func (c *algorithmClient) RunSimulation(ctx context.Context, in *RunSimulationRequest, opts ...grpc.CallOption) (*RunSimulationResponse, error) {
+	out := new(RunSimulationResponse)
+	err := c.cc.Invoke(ctx, "/algorithm.v1.Algorithm/RunSimulation", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *algorithmClient) UploadModel(ctx context.Context, opts ...grpc.CallOption) (Algorithm_UploadModelClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Algorithm_ServiceDesc.Streams[0], "/algorithm.v1.Algorithm/UploadModel", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &algorithmUploadModelClient{stream}
+	return x, nil
+}
+
+type Algorithm_UploadModelClient interface {
+	Send(*UploadModelRequest) error
+	CloseAndRecv() (*UploadModelResponse, error)
+	grpc.ClientStream
+}
+
+type algorithmUploadModelClient struct {
+	grpc.ClientStream
+}
+
+func (x *algorithmUploadModelClient) Send(m *UploadModelRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *algorithmUploadModelClient) CloseAndRecv() (*UploadModelResponse, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(UploadModelResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *algorithmClient) GetStatus(ctx context.Context, in *GetStatusRequest, opts ...grpc.CallOption) (*SimulationStatus, error) {
+	out := new(SimulationStatus)
+	err := c.cc.Invoke(ctx, "/algorithm.v1.Algorithm/GetStatus", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *algorithmClient) WatchResults(ctx context.Context, in *WatchResultsRequest, opts ...grpc.CallOption) (Algorithm_WatchResultsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Algorithm_ServiceDesc.Streams[1], "/algorithm.v1.Algorithm/WatchResults", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &algorithmWatchResultsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Algorithm_WatchResultsClient interface {
+	Recv() (*SimulationResult, error)
+	grpc.ClientStream
+}
+
+type algorithmWatchResultsClient struct {
+	grpc.ClientStream
+}
+
+func (x *algorithmWatchResultsClient) Recv() (*SimulationResult, error) {
+	m := new(SimulationResult)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// AlgorithmServer is the server API for Algorithm service.
+// All implementations must embed UnimplementedAlgorithmServer
+// for forward compatibility
+type AlgorithmServer interface {
+	// RunSimulation starts a simulation and returns once the algorithm accepted
+	// it. Results are delivered by WatchResults.
+	RunSimulation(context.Context, *RunSimulationRequest) (*RunSimulationResponse, error)
+	// UploadModel streams a model file. The first message carries the name of
+	// the file, the following ones its content.
+	UploadModel(Algorithm_UploadModelServer) error
+	// GetStatus returns the state of a simulation started before.
+	GetStatus(context.Context, *GetStatusRequest) (*SimulationStatus, error)
+	// WatchResults streams results of simulations as they finish. Results are
+	// kept by the algorithm until a watcher received them.
+	WatchResults(*WatchResultsRequest, Algorithm_WatchResultsServer) error
+	mustEmbedUnimplementedAlgorithmServer()
+}
+
+// UnimplementedAlgorithmServer must be embedded to have forward compatible implementations.
+type UnimplementedAlgorithmServer struct {
+}
+
+func (UnimplementedAlgorithmServer) RunSimulation(context.Context, *RunSimulationRequest) (*RunSimulationResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RunSimulation not implemented")
+}
+func (UnimplementedAlgorithmServer) UploadModel(Algorithm_UploadModelServer) error {
+	return status.Errorf(codes.Unimplemented, "method UploadModel not implemented")
+}
+func (UnimplementedAlgorithmServer) GetStatus(context.Context, *GetStatusRequest) (*SimulationStatus, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetStatus not implemented")
+}
+func (UnimplementedAlgorithmServer) WatchResults(*WatchResultsRequest, Algorithm_WatchResultsServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchResults not implemented")
+}
+func (UnimplementedAlgorithmServer) mustEmbedUnimplementedAlgorithmServer() {}
+
+// UnsafeAlgorithmServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to AlgorithmServer will
+// result in compilation errors.
+type UnsafeAlgorithmServer interface {
+	mustEmbedUnimplementedAlgorithmServer()
+}
+
+func RegisterAlgorithmServer(s grpc.ServiceRegistrar, srv AlgorithmServer) {
+	s.RegisterService(&Algorithm_ServiceDesc, srv)
+}
+
+func _Algorithm_RunSimulation_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RunSimulationRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AlgorithmServer).RunSimulation(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/algorithm.v1.Algorithm/RunSimulation",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AlgorithmServer).RunSimulation(ctx, req.(*RunSimulationRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Algorithm_UploadModel_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(AlgorithmServer).UploadModel(&algorithmUploadModelServer{stream})
+}
+
+type Algorithm_UploadModelServer interface {
+	SendAndClose(*UploadModelResponse) error
+	Recv() (*UploadModelRequest, error)
+	grpc.ServerStream
+}
+
+type algorithmUploadModelServer struct {
+	grpc.ServerStream
+}
+
+func (x *algorithmUploadModelServer) SendAndClose(m *UploadModelResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *algorithmUploadModelServer) Recv() (*UploadModelRequest, error) {
+	m := new(UploadModelRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _Algorithm_GetStatus_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetStatusRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AlgorithmServer).GetStatus(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/algorithm.v1.Algorithm/GetStatus",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AlgorithmServer).GetStatus(ctx, req.(*GetStatusRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Algorithm_WatchResults_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchResultsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(AlgorithmServer).WatchResults(m, &algorithmWatchResultsServer{stream})
+}
+
+type Algorithm_WatchResultsServer interface {
+	Send(*SimulationResult) error
+	grpc.ServerStream
+}
+
+type algorithmWatchResultsServer struct {
+	grpc.ServerStream
+}
+
+func (x *algorithmWatchResultsServer) Send(m *SimulationResult) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// Algorithm_ServiceDesc is the grpc.ServiceDesc for Algorithm service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Algorithm_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "algorithm.v1.Algorithm",
+	HandlerType: (*AlgorithmServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "RunSimulation",
+			Handler:    _Algorithm_RunSimulation_Handler,
+		},
+		{
+			MethodName: "GetStatus",
+			Handler:    _Algorithm_GetStatus_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "UploadModel",
+			Handler:       _Algorithm_UploadModel_Handler,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "WatchResults",
+			Handler:       _Algorithm_WatchResults_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "algorithm/v1/algorithm.proto",
+}
@@ -0,0 +1,7 @@
+// Package algorithmpb contains the gRPC contract of algorithm containers,
+// generated from proto/algorithm/v1/algorithm.proto. It is generated with
+// protoc 3.19.4, protoc-gen-go v1.27.1 and protoc-gen-go-grpc v1.2.0, which
+// record their versions in the headers of the generated files.
+package algorithmpb
+
+//go:generate protoc -I ../../proto --go_out=../.. --go_opt=module=backend --go-grpc_out=../.. --go-grpc_opt=module=backend algorithm/v1/algorithm.proto
@@ -0,0 +1,207 @@
+// Package algorithmserver is a reference implementation of the algorithm gRPC
+// contract. A detector implements Detector, the Server takes care of queueing
+// simulations, receiving models and keeping results until the backend
+// received them:
+//
+//	server := grpc.NewServer()
+//	algorithmpb.RegisterAlgorithmServer(server, algorithmserver.New(detector, 1, 16))
+//	server.Serve(listener)
+package algorithmserver
+
+import (
+	"backend/pkg/algorithmpb"
+	"context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io"
+	"sync"
+)
+
+// Detector finds objects on images.
+type Detector interface {
+	// LoadModel stores a model uploaded by the backend under its file name.
+	LoadModel(name string, model io.Reader) error
+	// Detect runs the operation, e.g. "demo", with the model on a JPEG or PNG
+	// encoded image.
+	Detect(ctx context.Context, operation, model string, image []byte) (*algorithmpb.Detections, error)
+}
+
+// Server runs simulations with a Detector.
+type Server struct {
+	algorithmpb.UnimplementedAlgorithmServer
+	detector Detector
+	jobs     chan *algorithmpb.RunSimulationRequest
+	ctx      context.Context
+	cancel   context.CancelFunc
+	workers  sync.WaitGroup
+	mu       sync.Mutex
+	statuses map[string]*algorithmpb.SimulationStatus
+	results  []*algorithmpb.SimulationResult
+	ready    chan struct{}
+}
+
+// New starts workers which run simulations. Up to queueSize accepted
+// simulations wait for a free worker, further ones are rejected with
+// codes.ResourceExhausted.
+func New(detector Detector, workers, queueSize int) *Server {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Server{
+		detector: detector,
+		jobs:     make(chan *algorithmpb.RunSimulationRequest, queueSize),
+		ctx:      ctx,
+		cancel:   cancel,
+		statuses: map[string]*algorithmpb.SimulationStatus{},
+		ready:    make(chan struct{}),
+	}
+	for i := 0; i < workers; i++ {
+		s.workers.Add(1)
+		go s.work()
+	}
+	return s
+}
+
+// Stop cancels running simulations and waits for the workers to exit.
+// Simulations sent afterwards are rejected with codes.Unavailable.
+func (s *Server) Stop() {
+	s.cancel()
+	s.mu.Lock()
+	close(s.jobs)
+	s.mu.Unlock()
+	s.workers.Wait()
+}
+
+// RunSimulation queues the simulation. Simulations which are already queued,
+// running or finished are not started again, as the backend resends jobs
+// whose outcome it does not know.
+func (s *Server) RunSimulation(ctx context.Context, req *algorithmpb.RunSimulationRequest) (*algorithmpb.RunSimulationResponse, error) {
+	if req.Id == "" {
+		return nil, status.Error(codes.InvalidArgument, "id is required")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ctx.Err() != nil {
+		return nil, status.Error(codes.Unavailable, "server is stopping")
+	}
+	if current, ok := s.statuses[req.Id]; ok && current.State != algorithmpb.State_STATE_FAILED {
+		return &algorithmpb.RunSimulationResponse{}, nil
+	}
+	select {
+	case s.jobs <- req:
+	default:
+		return nil, status.Error(codes.ResourceExhausted, "too many simulations are queued")
+	}
+	s.statuses[req.Id] = &algorithmpb.SimulationStatus{Id: req.Id, State: algorithmpb.State_STATE_QUEUED}
+	return &algorithmpb.RunSimulationResponse{}, nil
+}
+
+// UploadModel passes the streamed model to the detector as it arrives.
+func (s *Server) UploadModel(stream algorithmpb.Algorithm_UploadModelServer) error {
+	first, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	name := first.GetName()
+	if name == "" {
+		return status.Error(codes.InvalidArgument, "first message has to carry the name of the model")
+	}
+
+	body, pipe := io.Pipe()
+	loaded := make(chan error, 1)
+	go func() {
+		err := s.detector.LoadModel(name, body)
+		// unblocks the writer when the detector did not read the whole model
+		body.CloseWithError(io.ErrClosedPipe)
+		loaded <- err
+	}()
+
+	var size int64
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			pipe.Close()
+			break
+		}
+		if err != nil {
+			pipe.CloseWithError(err)
+			<-loaded
+			return err
+		}
+		if _, err = pipe.Write(req.GetChunk()); err != nil {
+			break
+		}
+		size += int64(len(req.GetChunk()))
+	}
+	if err = <-loaded; err != nil {
+		return status.Error(codes.Internal, "failed to load model: "+err.Error())
+	}
+	return stream.SendAndClose(&algorithmpb.UploadModelResponse{Size: size})
+}
+
+func (s *Server) GetStatus(ctx context.Context, req *algorithmpb.GetStatusRequest) (*algorithmpb.SimulationStatus, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	current, ok := s.statuses[req.Id]
+	if !ok {
+		return nil, status.Error(codes.NotFound, "simulation "+req.Id+" does not exist")
+	}
+	return &algorithmpb.SimulationStatus{Id: current.Id, State: current.State, Error: current.Error}, nil
+}
+
+// WatchResults sends results until the watcher disconnects. Each result is
+// sent to one watcher, results which could not be sent are kept for the next
+// one.
+func (s *Server) WatchResults(req *algorithmpb.WatchResultsRequest, stream algorithmpb.Algorithm_WatchResultsServer) error {
+	for {
+		s.mu.Lock()
+		if len(s.results) == 0 {
+			ready := s.ready
+			s.mu.Unlock()
+			select {
+			case <-ready:
+				continue
+			case <-stream.Context().Done():
+				return nil
+			case <-s.ctx.Done():
+				return status.Error(codes.Unavailable, "server is stopping")
+			}
+		}
+		result := s.results[0]
+		s.results = s.results[1:]
+		s.mu.Unlock()
+
+		if err := stream.Send(result); err != nil {
+			s.mu.Lock()
+			s.results = append([]*algorithmpb.SimulationResult{result}, s.results...)
+			s.mu.Unlock()
+			return err
+		}
+	}
+}
+
+func (s *Server) work() {
+	defer s.workers.Done()
+	for req := range s.jobs {
+		s.setState(req.Id, algorithmpb.State_STATE_RUNNING, "")
+		detections, err := s.detector.Detect(s.ctx, req.Operation, req.Model, req.Image)
+		result := &algorithmpb.SimulationResult{Id: req.Id}
+		if err != nil {
+			s.setState(req.Id, algorithmpb.State_STATE_FAILED, err.Error())
+			result.Outcome = &algorithmpb.SimulationResult_Error{Error: err.Error()}
+		} else {
+			s.setState(req.Id, algorithmpb.State_STATE_FINISHED, "")
+			result.Outcome = &algorithmpb.SimulationResult_Detections{Detections: detections}
+		}
+
+		s.mu.Lock()
+		s.results = append(s.results, result)
+		close(s.ready)
+		s.ready = make(chan struct{})
+		s.mu.Unlock()
+	}
+}
+
+func (s *Server) setState(id string, state algorithmpb.State, reason string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.statuses[id] = &algorithmpb.SimulationStatus{Id: id, State: state, Error: reason}
+}
@@ -0,0 +1,110 @@
+package algorithmserver
+
+import (
+	"backend/pkg/algorithmpb"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io"
+	"testing"
+	"time"
+)
+
+// blockingDetector finds nothing once a detection is released.
+type blockingDetector struct {
+	release chan struct{}
+}
+
+func (d *blockingDetector) LoadModel(name string, model io.Reader) error {
+	return nil
+}
+
+func (d *blockingDetector) Detect(ctx context.Context, operation, model string, image []byte) (*algorithmpb.Detections, error) {
+	select {
+	case <-d.release:
+		return &algorithmpb.Detections{}, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func waitForState(t *testing.T, s *Server, id string, state algorithmpb.State) {
+	deadline := time.Now().Add(time.Second)
+	for {
+		current, err := s.GetStatus(context.Background(), &algorithmpb.GetStatusRequest{Id: id})
+		if err == nil && current.State == state {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("simulation %s did not reach %s", id, state)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestServer(t *testing.T) {
+	run := func(s *Server, id string) error {
+		_, err := s.RunSimulation(context.Background(), &algorithmpb.RunSimulationRequest{Id: id, Operation: "demo"})
+		return err
+	}
+	t.Run("should report status of simulations", func(t *testing.T) {
+		//given
+		detector := &blockingDetector{release: make(chan struct{})}
+		s := New(detector, 1, 1)
+		defer s.Stop()
+
+		//when
+		assert.NoError(t, run(s, "job1"))
+
+		//then
+		waitForState(t, s, "job1", algorithmpb.State_STATE_RUNNING)
+		close(detector.release)
+		waitForState(t, s, "job1", algorithmpb.State_STATE_FINISHED)
+	})
+	t.Run("should return not found for unknown simulation", func(t *testing.T) {
+		//given
+		s := New(&blockingDetector{}, 1, 1)
+		defer s.Stop()
+
+		//when
+		_, err := s.GetStatus(context.Background(), &algorithmpb.GetStatusRequest{Id: "job1"})
+
+		//then
+		assert.Equal(t, codes.NotFound, status.Code(err))
+	})
+	t.Run("should reject simulations when queue is full", func(t *testing.T) {
+		//given
+		s := New(&blockingDetector{release: make(chan struct{})}, 1, 1)
+		defer s.Stop()
+		assert.NoError(t, run(s, "job1"))
+		waitForState(t, s, "job1", algorithmpb.State_STATE_RUNNING)
+		assert.NoError(t, run(s, "job2"))
+
+		//when
+		err := run(s, "job3")
+
+		//then
+		assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	})
+	t.Run("should accept resent simulation without running it again", func(t *testing.T) {
+		//given
+		s := New(&blockingDetector{release: make(chan struct{})}, 1, 1)
+		defer s.Stop()
+		assert.NoError(t, run(s, "job1"))
+		waitForState(t, s, "job1", algorithmpb.State_STATE_RUNNING)
+		assert.NoError(t, run(s, "job2"))
+
+		//when
+		err := run(s, "job2")
+
+		//then
+		assert.NoError(t, err)
+	})
+	t.Run("should reject simulations without id and after stop", func(t *testing.T) {
+		s := New(&blockingDetector{}, 1, 1)
+		assert.Equal(t, codes.InvalidArgument, status.Code(run(s, "")))
+		s.Stop()
+		assert.Equal(t, codes.Unavailable, status.Code(run(s, "job1")))
+	})
+}
@@ -8,6 +8,8 @@ export const getImages = async() => {
     return await response.json()
 }
 
+export const imageURL = (id) => `${address}/v1/images/${id}`
+
 export const uploadImage = async(data) => {
     return await fetch(`${address}/v1/images`, {
         method: 'PUT',
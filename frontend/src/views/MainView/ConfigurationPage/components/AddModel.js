@@ -27,7 +27,7 @@ const AddModel = () => {
     React.useEffect(() => {
         const fetchData = async () => {
             const m = await getModels(algorithmID);
-            setModels(m);
+            setModels(m.map(model => model.name));
         }
         fetchData()
             .catch(console.error);
@@ -19,6 +19,11 @@ const convertToBase64 = (image) => {
         }
     })
 };
+// images are listed by the SHA-256 checksum of their content
+const imageID = async (base64Image) => {
+    const digest = await crypto.subtle.digest('SHA-256', new TextEncoder().encode(base64Image));
+    return Array.from(new Uint8Array(digest)).map(b => b.toString(16).padStart(2, '0')).join('');
+};
 const validateExt = (extension) => {
     if (!extensions.includes(extension)) throw new Error("Unsupported extension!");
 };
@@ -48,7 +53,7 @@ const UploadImage = () => {
             validateExt(getExt(e.target.files[0].name));
             const base64Image = await convertToBase64(e.target.files[0]);
             if (images) {
-                checkIfExists(images, base64Image, "This image is already in the database!")
+                checkIfExists(images.map(i => i.id), await imageID(base64Image), "This image is already in the database!")
             }
             setContentImage(base64Image);
             setDisabledButton(false);
@@ -13,13 +13,16 @@ const DemoSection = () => {
     const handleClick = async () => {
         const data = {
             model: document.getElementById('choose-model').value,
-            image: document.getElementById('chosen-image').src,
+            imageId: document.getElementById('chosen-image').dataset.imageId,
             id: algorithmID
         }
         setDisabledButton(true);
         try {
             const resp = await runSimulation("demo", JSON.stringify(data))
-            if (resp.status === 202) setInfoModalActive("Simulation started!")
+            if (resp.status === 202) {
+                const job = await resp.json()
+                setInfoModalActive(job.status === "queued" ? (job.position ? `Simulation queued at position ${job.position}!` : "Simulation queued!") : "Simulation started!")
+            }
         } catch (err) {
             setErrorModalActive(err)
         }
@@ -1,5 +1,5 @@
 import React from "react";
-import {getImages} from "../../../../API";
+import {getImages, imageURL} from "../../../../API";
 
 export const setImagesModalActive = () => {
     const modal = document.getElementById("modal")
@@ -14,7 +14,9 @@ const ImagesModal = () => {
         modal.classList.remove('is-active')
     }
     const chooseImage = (e) => {
-        document.getElementById('chosen-image').src = e.target.src
+        const chosen = document.getElementById('chosen-image')
+        chosen.dataset.imageId = e.target.dataset.imageId
+        chosen.src = e.target.src
         unsetImagesModalActive()
     }
 
@@ -40,8 +42,8 @@ const ImagesModal = () => {
                     {images &&
                         images.map(i => {
                             return (
-                                <figure key={i} className="image is-inline-block">
-                                    <img src={i} alt={i} onClick={e => chooseImage(e)}/>
+                                <figure key={i.id} className="image is-inline-block">
+                                    <img src={imageURL(i.id)} alt={i.id} data-image-id={i.id} onClick={e => chooseImage(e)}/>
                                 </figure>)
                         })}
                 </section>
@@ -8,7 +8,7 @@ const ChooseModel = () => {
     React.useEffect(() => {
         const fetchData = async () => {
             const m = await getModels(algorithmID);
-            setModels(m);
+            setModels(m.map(model => model.name));
         }
         fetchData()
             .catch(console.error);
@@ -6,7 +6,9 @@ import {Header} from "../../components/Header/Header";
 import {Footer} from "../../components/Footer/Footer";
 import {DemoParameters, SimulationResults} from "./components/DemoResults";
 
-export const dict = { "in-progress" : "is-link" ,
+export const dict = { "queued" : "is-light" ,
+    "dispatching" : "is-link" ,
+    "in-progress" : "is-link" ,
     "finished" : "is-primary" ,
     "error" : "is-danger" ,
 };
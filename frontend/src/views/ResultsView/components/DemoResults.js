@@ -1,5 +1,8 @@
 import {dict} from "../ResultsView";
 import React from "react";
+import {imageURL} from "../../../API";
+
+const resultImage = (r) => r.imageId ? imageURL(r.imageId) : r.image
 
 const DemoParameters = (props) => {
     return (
@@ -19,7 +22,7 @@ const DemoParameters = (props) => {
                         </tr>
                         <tr>
                             <td>image</td>
-                            <td><img id={props.r.timeStamp} src={props.r.image} alt={props.r.timeStamp}/></td>
+                            <td><img id={props.r.timeStamp} src={resultImage(props.r)} crossOrigin="anonymous" alt={props.r.timeStamp}/></td>
                         </tr>
                         </thead>
                     </table>
@@ -55,11 +58,14 @@ const SimulationResults = (props) => {
                 const canvas = document.getElementById("canvas" + props.r.timeStamp)
                 const ctx = canvas.getContext('2d');
                 const image = new Image(document.getElementById(props.r.timeStamp).width, document.getElementById(props.r.timeStamp).height)
-                image.src = props.r.image
-                drawImageActualSize(canvas, ctx, image);
-                if (props.r.result !== '' && props.r.result !== `"error"`) {
-                    draw(ctx, JSON.parse(props.r.result))
+                image.crossOrigin = "anonymous"
+                image.onload = () => {
+                    drawImageActualSize(canvas, ctx, image);
+                    if (props.r.result !== '' && props.r.result !== `"error"`) {
+                        draw(ctx, JSON.parse(props.r.result))
+                    }
                 }
+                image.src = resultImage(props.r)
             }
             fetchData()
                 .catch(console.error);